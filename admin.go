@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"gorm.io/gorm"
+)
+
+// grantAdmin implements the grant-admin command, which makes the registered
+// user with the given email a platform administrator, allowed on the /admin
+// routes. It returns the exit code.
+func grantAdmin(db *gorm.DB, args []string, out io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintln(out, "usage: grant-admin <email>")
+		return 2
+	}
+
+	result := db.Model(&models.User{}).Where("LOWER(email) = ?", strings.ToLower(args[0])).Update("is_admin", true)
+	if result.Error != nil {
+		fmt.Fprintln(out, "error granting admin:", result.Error)
+		return 1
+	}
+	if result.RowsAffected < 1 {
+		fmt.Fprintf(out, "no user with email %q\n", args[0])
+		return 1
+	}
+
+	fmt.Fprintf(out, "%s is now an admin\n", args[0])
+	return 0
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/codelikesuraj/hng11-task-two/utils"
+	"gorm.io/gorm"
+)
+
+const defaultAuditCheckpointInterval = time.Hour
+
+// verifyAudit implements the verify-audit command, which re-walks the audit
+// log of the organisations whose IDs are given, or of all organisations with
+// events, printing one line each. It returns the exit code, 1 if a chain is
+// broken.
+func verifyAudit(db *gorm.DB, args []string, out io.Writer) int {
+	var orgIds []uint
+	for _, arg := range args {
+		orgId, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil || orgId < 1 {
+			fmt.Fprintf(out, "invalid organisation ID %q\n", arg)
+			return 2
+		}
+		orgIds = append(orgIds, uint(orgId))
+	}
+
+	if len(orgIds) == 0 {
+		err := db.Model(&models.AuditEvent{}).Distinct("organisation_id").Order("organisation_id").Pluck("organisation_id", &orgIds).Error
+		if err != nil {
+			fmt.Fprintln(out, "error listing organisations:", err)
+			return 2
+		}
+	}
+
+	code := 0
+	for _, orgId := range orgIds {
+		verification, err := utils.VerifyAuditChain(db, orgId)
+		if err != nil {
+			fmt.Fprintf(out, "organisation %d: error: %v\n", orgId, err)
+			return 2
+		}
+
+		if verification.Valid {
+			fmt.Fprintf(out, "organisation %d: ok, %d event(s), %d checkpoint(s)\n", orgId, verification.Events, verification.Checkpoints)
+			continue
+		}
+
+		code = 1
+		broken := verification.BrokenAt
+		fmt.Fprintf(out, "organisation %d: BROKEN at event %d", orgId, broken.Sequence)
+		if broken.EventID != "" {
+			fmt.Fprintf(out, " (ID %s)", broken.EventID)
+		}
+		if broken.CheckpointID != "" {
+			fmt.Fprintf(out, " (checkpoint %s)", broken.CheckpointID)
+		}
+		fmt.Fprintf(out, ": %s\n", broken.Reason)
+	}
+
+	return code
+}
+
+// scheduleAuditCheckpoints has the runner sign a checkpoint of every changed
+// audit log each AUDIT_CHECKPOINT_INTERVAL.
+func scheduleAuditCheckpoints(db *gorm.DB, runner *utils.JobRunner) {
+	interval := defaultAuditCheckpointInterval
+	if v := os.Getenv("AUDIT_CHECKPOINT_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Second {
+			log.Fatal("invalid AUDIT_CHECKPOINT_INTERVAL:", v)
+		}
+		interval = d
+	}
+
+	runner.Handle(models.JobCheckpointAuditLogs, utils.CheckpointAuditLogsJob(db))
+	if err := runner.Schedule("@every "+interval.String(), models.JobCheckpointAuditLogs, nil); err != nil {
+		log.Fatal("error scheduling audit checkpoints:", err)
+	}
+}
@@ -0,0 +1,190 @@
+package client
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// how long before it expires an access token is replaced
+const tokenExpiryMargin = time.Minute
+
+// TokenSource supplies the tokens requests are authenticated with.
+type TokenSource interface {
+	// Token returns the token to send, a new one if the last has expired.
+	Token(ctx context.Context) (string, error)
+	// Expire discards a token the API rejected, reporting whether Token
+	// gets a new one.
+	Expire(token string) bool
+}
+
+// StaticToken is a TokenSource of a single token.
+type StaticToken string
+
+func (t StaticToken) Token(context.Context) (string, error) {
+	return string(t), nil
+}
+
+func (t StaticToken) Expire(string) bool {
+	return false
+}
+
+// passwordTokenSource logs in for access tokens, reusing each until it
+// expires.
+type passwordTokenSource struct {
+	client *Client
+
+	mu     sync.Mutex
+	params LoginParams
+	token  string
+	expiry time.Time
+}
+
+func (s *passwordTokenSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && (s.expiry.IsZero() || time.Until(s.expiry) > tokenExpiryMargin) {
+		return s.token, nil
+	}
+
+	session, err := s.client.Login(ctx, s.params)
+	if err != nil {
+		return "", err
+	}
+	s.set(session.AccessToken)
+	return s.token, nil
+}
+
+func (s *passwordTokenSource) Expire(token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// another request may have replaced it already
+	if s.token == token {
+		s.token = ""
+	}
+	return true
+}
+
+// set replaces the token, taking its expiry from its exp claim. The claims
+// are not verified, the token is only sent back to the API that issued it.
+func (s *passwordTokenSource) set(token string) {
+	s.token = token
+	s.expiry = time.Time{}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if json.Unmarshal(payload, &claims) == nil && claims.Exp > 0 {
+		s.expiry = time.Unix(claims.Exp, 0)
+	}
+}
+
+type RegisterParams struct {
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Email     string `json:"email"`
+	Password  string `json:"password"`
+	Phone     string `json:"phone"`
+	// InvitationToken joins the organisation of an invitation
+	InvitationToken string `json:"invitationToken,omitempty"`
+}
+
+type LoginParams struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type ChangePasswordParams struct {
+	Email       string `json:"email"`
+	Password    string `json:"password"`
+	NewPassword string `json:"newPassword"`
+}
+
+// Session is a logged in user and the access token to act as them with.
+type Session struct {
+	AccessToken string `json:"accessToken"`
+	User        User   `json:"user"`
+}
+
+type Registration struct {
+	Session
+	// JoinableOrganisations are the organisations the user may join through
+	// the domain of their email once it is verified
+	JoinableOrganisations []Organisation `json:"joinableOrganisations"`
+}
+
+type EmailVerification struct {
+	User User `json:"user"`
+	// JoinedOrganisations are the organisations joined automatically through
+	// the domain of the email
+	JoinedOrganisations []Organisation `json:"joinedOrganisations"`
+}
+
+func (c *Client) Register(ctx context.Context, params RegisterParams) (*Registration, error) {
+	var registration Registration
+	if err := c.do(ctx, http.MethodPost, "/v1/auth/register", nil, params, &registration, false); err != nil {
+		return nil, err
+	}
+	return &registration, nil
+}
+
+func (c *Client) Login(ctx context.Context, params LoginParams) (*Session, error) {
+	var session Session
+	if err := c.do(ctx, http.MethodPost, "/v1/auth/login", nil, params, &session, false); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// ChangePassword changes the password of the user, logging out their other
+// sessions. A client authenticated WithCredentials of the user goes on with
+// the new password.
+func (c *Client) ChangePassword(ctx context.Context, params ChangePasswordParams) (*Session, error) {
+	var session Session
+	if err := c.do(ctx, http.MethodPost, "/v1/auth/change-password", nil, params, &session, false); err != nil {
+		return nil, err
+	}
+
+	if s, ok := c.tokens.(*passwordTokenSource); ok {
+		s.mu.Lock()
+		if strings.EqualFold(s.params.Email, params.Email) {
+			s.params.Password = params.NewPassword
+			s.set(session.AccessToken)
+		}
+		s.mu.Unlock()
+	}
+
+	return &session, nil
+}
+
+// SendEmailVerification emails the user a link to verify their email, unless
+// it is already verified.
+func (c *Client) SendEmailVerification(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/v1/auth/verify-email", nil, nil, nil, true)
+}
+
+// VerifyEmail verifies an email with the token of the link emailed by
+// SendEmailVerification.
+func (c *Client) VerifyEmail(ctx context.Context, token string) (*EmailVerification, error) {
+	var verification EmailVerification
+	query := url.Values{"token": {token}}
+	if err := c.do(ctx, http.MethodGet, "/v1/auth/verify-email", query, nil, &verification, false); err != nil {
+		return nil, err
+	}
+	return &verification, nil
+}
@@ -0,0 +1,213 @@
+// Package client calls the API from Go.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRetries = 2
+	defaultBackoff = 200 * time.Millisecond
+)
+
+// Client calls the API on behalf of the caller of its TokenSource, if it has
+// one. It is safe for concurrent use.
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	tokens     TokenSource
+	retries    int
+	backoff    time.Duration
+}
+
+type Option func(*Client)
+
+// WithHTTPClient sends the requests with hc instead of http.DefaultClient.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithTokenSource authenticates the requests with the tokens of ts.
+func WithTokenSource(ts TokenSource) Option {
+	return func(c *Client) {
+		c.tokens = ts
+	}
+}
+
+// WithToken authenticates the requests with a token that does not expire
+// while it is used, such as a personal access token or an API key.
+func WithToken(token string) Option {
+	return WithTokenSource(StaticToken(token))
+}
+
+// WithCredentials authenticates the requests by logging in with the email
+// and password, logging in again whenever the access token expires.
+func WithCredentials(email, password string) Option {
+	return func(c *Client) {
+		c.tokens = &passwordTokenSource{client: c, params: LoginParams{Email: email, Password: password}}
+	}
+}
+
+// WithRetries changes how many times idempotent requests are retried after a
+// network error or a response saying the API is unavailable, and how long to
+// wait before the first retry, doubled before every other one.
+func WithRetries(retries int, backoff time.Duration) Option {
+	return func(c *Client) {
+		c.retries = retries
+		c.backoff = backoff
+	}
+}
+
+// New returns a client of the API at baseURL, such as https://api.example.com.
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		retries:    defaultRetries,
+		backoff:    defaultBackoff,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// envelope is how successful responses are wrapped.
+type envelope struct {
+	Status  string          `json:"status"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// do sends a request with body encoded as JSON, decoding the data of the
+// response into out if it is not nil. Authenticated requests rejected for
+// their token are sent once more with a new one, if the TokenSource has one.
+// The API does not handle requests it rejects, so even those that are not
+// idempotent are sent again.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}, authenticated bool) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	u := c.baseURL + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var token string
+	if authenticated {
+		if c.tokens == nil {
+			return errors.New("client: no token source to authenticate with")
+		}
+		var err error
+		if token, err = c.tokens.Token(ctx); err != nil {
+			return err
+		}
+	}
+
+	res, err := c.send(ctx, method, u, payload, token)
+	if err != nil {
+		return err
+	}
+
+	if authenticated && res.StatusCode == http.StatusUnauthorized && c.tokens.Expire(token) {
+		res.Body.Close()
+		if token, err = c.tokens.Token(ctx); err != nil {
+			return err
+		}
+		if res, err = c.send(ctx, method, u, payload, token); err != nil {
+			return err
+		}
+	}
+	defer res.Body.Close()
+
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return parseError(res.StatusCode, data)
+	}
+
+	if out == nil {
+		return nil
+	}
+	var e envelope
+	if err := json.Unmarshal(data, &e); err != nil {
+		return err
+	}
+	return json.Unmarshal(e.Data, out)
+}
+
+// send sends a request, retrying it while it is idempotent and fails in a
+// way that may not last.
+func (c *Client) send(ctx context.Context, method, u string, payload []byte, token string) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		if payload != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		res, err := c.httpClient.Do(req)
+		if attempt >= c.retries || !idempotent(method) || !retryable(res, err) || ctx.Err() != nil {
+			return res, err
+		}
+
+		wait := c.backoff << attempt
+		if res != nil {
+			if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil {
+				wait = time.Duration(seconds) * time.Second
+			}
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func retryable(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch res.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
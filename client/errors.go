@@ -0,0 +1,87 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Error is an error response of the API. Use errors.Is with ErrUnauthorized,
+// ErrForbidden, ErrNotFound and ErrConflict to check what kind it is.
+type Error struct {
+	StatusCode int
+	// Status is the reason phrase of StatusCode
+	Status  string
+	Message string
+	// LoginURL is where to log in instead when logging in with a password is
+	// refused because the organisation of the user enforces single sign-on
+	LoginURL string
+}
+
+var (
+	ErrUnauthorized = &Error{StatusCode: http.StatusUnauthorized}
+	ErrForbidden    = &Error{StatusCode: http.StatusForbidden}
+	ErrNotFound     = &Error{StatusCode: http.StatusNotFound}
+	ErrConflict     = &Error{StatusCode: http.StatusConflict}
+)
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("client: %d %s", e.StatusCode, e.Status)
+	}
+	return fmt.Sprintf("client: %d %s: %s", e.StatusCode, e.Status, e.Message)
+}
+
+// Is reports whether target is an Error with the same status code.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.StatusCode == e.StatusCode
+}
+
+// FieldError is why a field of a request is invalid.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is the response to a request with invalid fields.
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	fields := []string{}
+	for _, err := range e.Errors {
+		fields = append(fields, err.Field+": "+err.Message)
+	}
+	return "client: invalid request: " + strings.Join(fields, ", ")
+}
+
+// parseError maps an error response to an Error or a ValidationError,
+// whichever envelope it is in. Errors of the authentication middleware only
+// have an error message.
+func parseError(statusCode int, body []byte) error {
+	var res struct {
+		Message  string       `json:"message"`
+		Error    string       `json:"error"`
+		LoginURL string       `json:"loginUrl"`
+		Errors   []FieldError `json:"errors"`
+	}
+	json.Unmarshal(body, &res)
+
+	if statusCode == http.StatusUnprocessableEntity && len(res.Errors) > 0 {
+		return &ValidationError{Errors: res.Errors}
+	}
+
+	err := &Error{
+		StatusCode: statusCode,
+		Status:     http.StatusText(statusCode),
+		Message:    res.Message,
+		LoginURL:   res.LoginURL,
+	}
+	if err.Message == "" {
+		err.Message = res.Error
+	}
+	return err
+}
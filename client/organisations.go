@@ -0,0 +1,122 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+type Organisation struct {
+	ID          string `json:"orgId"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Pagination describes a page of a list. Pass NextCursor or PrevCursor as
+// the Cursor of the next request to get the page after or before it.
+type Pagination struct {
+	Total      int    `json:"total"`
+	Limit      int    `json:"limit"`
+	Sort       string `json:"sort"`
+	NextCursor string `json:"nextCursor"`
+	PrevCursor string `json:"prevCursor"`
+}
+
+type ListOrganisationsParams struct {
+	// Query only lists the organisations whose name contains it
+	Query string
+	// Prefix only lists the organisations whose name starts with it
+	Prefix string
+	Limit  int
+	Cursor string
+	// Sort is name or createdAt, prefixed with - for descending order
+	Sort string
+}
+
+type OrganisationPage struct {
+	Organisations []Organisation `json:"organisations"`
+	Pagination    Pagination     `json:"pagination"`
+}
+
+type CreateOrganisationParams struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type JoinableOrganisations struct {
+	Organisations []Organisation `json:"organisations"`
+	// EmailVerified is whether the caller verified their email, which they
+	// need to before joining any of them
+	EmailVerified bool `json:"emailVerified"`
+}
+
+// ListOrganisations lists a page of the caller's organisations.
+func (c *Client) ListOrganisations(ctx context.Context, params ListOrganisationsParams) (*OrganisationPage, error) {
+	query := url.Values{}
+	if params.Query != "" {
+		query.Set("q", params.Query)
+	}
+	if params.Prefix != "" {
+		query.Set("prefix", params.Prefix)
+	}
+	if params.Limit > 0 {
+		query.Set("limit", strconv.Itoa(params.Limit))
+	}
+	if params.Cursor != "" {
+		query.Set("cursor", params.Cursor)
+	}
+	if params.Sort != "" {
+		query.Set("sort", params.Sort)
+	}
+
+	var page OrganisationPage
+	if err := c.do(ctx, http.MethodGet, "/v1/api/organisations", query, nil, &page, true); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+func (c *Client) GetOrganisation(ctx context.Context, orgId string) (*Organisation, error) {
+	var data struct {
+		Organisation Organisation `json:"organisations"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/v1/api/organisations/"+url.PathEscape(orgId), nil, nil, &data, true); err != nil {
+		return nil, err
+	}
+	return &data.Organisation, nil
+}
+
+func (c *Client) CreateOrganisation(ctx context.Context, params CreateOrganisationParams) (*Organisation, error) {
+	var org Organisation
+	if err := c.do(ctx, http.MethodPost, "/v1/api/organisations", nil, params, &org, true); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// AddUserToOrganisation adds a user to one of the caller's organisations.
+func (c *Client) AddUserToOrganisation(ctx context.Context, orgId, userId string) error {
+	body := map[string]string{"userId": userId}
+	return c.do(ctx, http.MethodPost, "/v1/api/organisations/"+url.PathEscape(orgId)+"/users", nil, body, nil, true)
+}
+
+// ListJoinableOrganisations lists the organisations the caller may join
+// through the domain of their email.
+func (c *Client) ListJoinableOrganisations(ctx context.Context) (*JoinableOrganisations, error) {
+	var joinable JoinableOrganisations
+	if err := c.do(ctx, http.MethodGet, "/v1/api/organisations/joinable", nil, nil, &joinable, true); err != nil {
+		return nil, err
+	}
+	return &joinable, nil
+}
+
+// JoinOrganisation joins one of the organisations of
+// ListJoinableOrganisations.
+func (c *Client) JoinOrganisation(ctx context.Context, orgId string) (*Organisation, error) {
+	var org Organisation
+	if err := c.do(ctx, http.MethodPost, "/v1/api/organisations/"+url.PathEscape(orgId)+"/join", nil, nil, &org, true); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
@@ -0,0 +1,24 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+type User struct {
+	ID        string `json:"userId"`
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+}
+
+// GetUser gets the caller, or a member of one of their organisations.
+func (c *Client) GetUser(ctx context.Context, userId string) (*User, error) {
+	var user User
+	if err := c.do(ctx, http.MethodGet, "/v1/api/users/"+url.PathEscape(userId), nil, nil, &user, true); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,220 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/codelikesuraj/hng11-task-two/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// AdminController serves the /admin routes, which are limited to platform
+// administrators by middlewares.RequireAdmin.
+type AdminController struct {
+	DB *gorm.DB
+}
+
+func NewAdminController(db *gorm.DB) *AdminController {
+	return &AdminController{DB: db}
+}
+
+// adminUserSorts are the sort keys of the admin user listing.
+var adminUserSorts = map[string]string{
+	"email":     "LOWER(users.email)",
+	"createdAt": "users.created_at",
+}
+
+// GetUsers lists users a page at a time, optionally filtered by a search term
+// matched against their name and email.
+func (ac *AdminController) GetUsers(c *gin.Context) {
+	page, errors := utils.ParsePagination(c, "users", adminUserSorts, "createdAt")
+	if errors != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": errors,
+		})
+		return
+	}
+
+	query := ac.DB.Model(&models.User{})
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		like := "%" + utils.EscapeLike(strings.ToLower(q)) + "%"
+		query = query.Where(`LOWER(first_name) LIKE ? ESCAPE '\' OR LOWER(last_name) LIKE ? ESCAPE '\' OR LOWER(email) LIKE ? ESCAPE '\'`, like, like, like)
+	}
+
+	users, pageInfo, err := utils.Paginate[models.User](query, page)
+	if err == utils.ErrStaleCursor {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":     http.StatusText(http.StatusBadRequest),
+			"message":    err.Error(),
+			"statusCode": http.StatusBadRequest,
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": fmt.Sprintf("found %d user(s)", len(users)),
+		"data": gin.H{
+			"users":      models.AdminUsersResponse(users),
+			"pagination": pageInfo,
+		},
+	})
+}
+
+func (ac *AdminController) GetUser(c *gin.Context) {
+	user, ok := ac.findUser(c)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "user found",
+		"data":    models.AdminUserResponse(user),
+	})
+}
+
+func (ac *AdminController) GetUserOrganisations(c *gin.Context) {
+	user, ok := ac.findUser(c)
+	if !ok {
+		return
+	}
+
+	var orgs []models.Organisation
+	if err := ac.DB.Model(&user).Association("Organisations").Find(&orgs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": fmt.Sprintf("found %d organisation(s)", len(orgs)),
+		"data": gin.H{
+			"organisations": models.OrganisationsResponse(orgs),
+		},
+	})
+}
+
+// DisableUser blocks the user from logging in and signs out their sessions.
+func (ac *AdminController) DisableUser(c *gin.Context) {
+	user, ok := ac.findUser(c)
+	if !ok {
+		return
+	}
+
+	if principal, _ := currentPrincipal(c); principal.User != nil && principal.User.ID == user.ID {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":     http.StatusText(http.StatusBadRequest),
+			"message":    "admins cannot disable their own account",
+			"statusCode": http.StatusBadRequest,
+		})
+		return
+	}
+
+	ac.updateUser(c, &user, map[string]interface{}{
+		"disabled_at":     time.Now(),
+		"session_version": gorm.Expr("session_version + 1"),
+	}, "User disabled successfully")
+}
+
+func (ac *AdminController) EnableUser(c *gin.Context) {
+	user, ok := ac.findUser(c)
+	if !ok {
+		return
+	}
+
+	ac.updateUser(c, &user, map[string]interface{}{
+		"disabled_at": nil,
+	}, "User enabled successfully")
+}
+
+// ForcePasswordReset signs the user out, revoking their personal access
+// tokens and OAuth tokens too as the account may be compromised, and
+// requires them to choose a new password through /v1/auth/change-password
+// before they can log in again.
+func (ac *AdminController) ForcePasswordReset(c *gin.Context) {
+	user, ok := ac.findUser(c)
+	if !ok {
+		return
+	}
+
+	ac.updateUser(c, &user, map[string]interface{}{
+		"password_reset_required": true,
+		"session_version":         gorm.Expr("session_version + 1"),
+	}, "Password reset required for user", revokeUserTokens)
+}
+
+// revokeUserTokens revokes the personal access tokens of the user and the
+// OAuth tokens issued on their behalf.
+func revokeUserTokens(tx *gorm.DB, user models.User) error {
+	if err := tx.Where("user_id = ?", user.ID).Delete(&models.PersonalAccessToken{}).Error; err != nil {
+		return err
+	}
+	return tx.Model(&models.OAuthToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", user.ID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// updateUser applies values to the user, along with the changes of then,
+// if any, in the same transaction.
+func (ac *AdminController) updateUser(c *gin.Context, user *models.User, values map[string]interface{}, message string, then ...func(tx *gorm.DB, user models.User) error) {
+	err := ac.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(values).Error; err != nil {
+			return err
+		}
+		for _, change := range then {
+			if err := change(tx, *user); err != nil {
+				return err
+			}
+		}
+		return tx.First(user, user.ID).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": message,
+		"data":    models.AdminUserResponse(*user),
+	})
+}
+
+func (ac *AdminController) findUser(c *gin.Context) (models.User, bool) {
+	var user models.User
+
+	userId, _ := strconv.Atoi(c.Param("id"))
+	result := ac.DB.Limit(1).Find(&user, userId)
+	if userId < 1 || result.RowsAffected < 1 || result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":     http.StatusText(http.StatusNotFound),
+			"message":    "user not found",
+			"statusCode": http.StatusNotFound,
+		})
+		return user, false
+	}
+
+	return user, true
+}
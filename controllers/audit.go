@@ -0,0 +1,52 @@
+package controllers
+
+import (
+	"github.com/codelikesuraj/hng11-task-two/middlewares"
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// recordAudit appends event to the audit log of its organisation. Unless the
+// event names its actor, the caller of c is the actor, and the IP and user
+// agent are those of the request. Recording in the transaction of the
+// mutation rolls the mutation back if the event cannot be recorded. Some
+// actions are published through the outbox as well, see publishAuditEvent.
+func recordAudit(tx *gorm.DB, c *gin.Context, event models.AuditEvent) error {
+	if c != nil {
+		if event.ActorType == "" {
+			event.ActorType, event.ActorID = auditActor(c)
+		}
+		if event.IP == "" {
+			event.IP = c.ClientIP()
+		}
+		if event.UserAgent == "" {
+			event.UserAgent = c.Request.UserAgent()
+		}
+	}
+	if event.ActorType == "" {
+		event.ActorType = models.AuditActorAnonymous
+	}
+
+	if err := tx.Create(&event).Error; err != nil {
+		return err
+	}
+
+	return publishAuditEvent(tx, event)
+}
+
+func auditActor(c *gin.Context) (string, *uint) {
+	if principal, ok := middlewares.GetPrincipal(c); ok {
+		if principal.IsServiceAccount() {
+			return models.AuditActorServiceAccount, &principal.ServiceAccount.ID
+		}
+		return models.AuditActorUser, &principal.User.ID
+	}
+
+	if v, ok := c.Get(middlewares.SCIMTokenKey); ok {
+		tokenId := v.(uint)
+		return models.AuditActorSCIMToken, &tokenId
+	}
+
+	return models.AuditActorAnonymous, nil
+}
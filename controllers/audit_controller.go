@@ -0,0 +1,265 @@
+package controllers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/codelikesuraj/hng11-task-two/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+const auditExportBatchSize = 500
+
+var auditEventSorts = map[string]string{
+	"createdAt": "audit_events.created_at",
+}
+
+var auditCSVHeader = []string{"eventId", "orgId", "sequence", "actorType", "actorId", "action", "targetType", "targetId", "metadata", "ip", "userAgent", "createdAt", "prevHash", "hash"}
+
+type AuditController struct {
+	DB *gorm.DB
+}
+
+func NewAuditController(db *gorm.DB) *AuditController {
+	return &AuditController{DB: db}
+}
+
+// GetAll lists the audit log of the :orgId organisation a page at a time,
+// newest first by default. Only admins of the organisation may read it.
+func (ac *AuditController) GetAll(c *gin.Context) {
+	org, ok := findAuthorizedOrganisation(c, ac.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	query, ok := ac.filteredEvents(c, org)
+	if !ok {
+		return
+	}
+
+	page, errors := utils.ParsePagination(c, "audit_events", auditEventSorts, "-createdAt")
+	if errors != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": errors,
+		})
+		return
+	}
+
+	events, pageInfo, err := utils.Paginate[models.AuditEvent](query, page)
+	if err == utils.ErrStaleCursor {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":     http.StatusText(http.StatusBadRequest),
+			"message":    err.Error(),
+			"statusCode": http.StatusBadRequest,
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": fmt.Sprintf("found %d audit event(s)", len(events)),
+		"data": gin.H{
+			"events":     models.AuditEventsResponse(events),
+			"pagination": pageInfo,
+		},
+	})
+}
+
+// Export downloads the audit log of the :orgId organisation, oldest first, as
+// CSV or, with format=jsonl, as one JSON event per line. It takes the filters
+// of GetAll and streams the events in batches, however many there are.
+func (ac *AuditController) Export(c *gin.Context) {
+	org, ok := findAuthorizedOrganisation(c, ac.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "jsonl" {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": []models.InputError{
+				{
+					Field:   "format",
+					Message: "must be one of [csv jsonl]",
+				},
+			},
+		})
+		return
+	}
+
+	query, ok := ac.filteredEvents(c, org)
+	if !ok {
+		return
+	}
+
+	filename := fmt.Sprintf("audit-log-%d.%s", org.ID, format)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	var write func(models.AuditEvent) error
+	var flush func() error
+
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv; charset=utf-8")
+		w := csv.NewWriter(c.Writer)
+		if err := w.Write(auditCSVHeader); err != nil {
+			return
+		}
+		write = func(event models.AuditEvent) error {
+			return w.Write(auditCSVRecord(event))
+		}
+		flush = func() error {
+			w.Flush()
+			return w.Error()
+		}
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+		enc := json.NewEncoder(c.Writer)
+		write = func(event models.AuditEvent) error {
+			return enc.Encode(models.AuditEventResponse(event))
+		}
+		flush = func() error { return nil }
+	}
+
+	c.Status(http.StatusOK)
+
+	// once streaming started, errors can only cut the download short
+	var events []models.AuditEvent
+	query.Order("audit_events.id").FindInBatches(&events, auditExportBatchSize, func(tx *gorm.DB, batch int) error {
+		for _, event := range events {
+			if err := write(event); err != nil {
+				return err
+			}
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	})
+}
+
+// Verify re-walks the hash chain of the audit log of the :orgId organisation
+// and reports the first broken link, if any.
+func (ac *AuditController) Verify(c *gin.Context) {
+	org, ok := findAuthorizedOrganisation(c, ac.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	verification, err := utils.VerifyAuditChain(ac.DB, org.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	message := "Audit log is intact"
+	if !verification.Valid {
+		message = fmt.Sprintf("Audit log is broken at event %d: %s", verification.BrokenAt.Sequence, verification.BrokenAt.Reason)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": message,
+		"data":    verification,
+	})
+}
+
+// filteredEvents returns the query for the events of org matching the filters
+// of the query string, responding with the invalid ones.
+func (ac *AuditController) filteredEvents(c *gin.Context, org models.Organisation) (*gorm.DB, bool) {
+	var params models.AuditEventFilterParams
+	validate := validator.New(validator.WithRequiredStructEnabled())
+
+	c.ShouldBindQuery(&params)
+
+	if err := validate.Struct(params); err != nil {
+		ve := err.(validator.ValidationErrors)
+		errors := make([]models.InputError, len(ve))
+		for i, fe := range ve {
+			errors[i] = models.InputError{
+				Field:   utils.GetJSONTagValue(params, fe.Field()),
+				Message: utils.GetValidationMessage(fe),
+			}
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": errors,
+		})
+		return nil, false
+	}
+
+	query := ac.DB.Model(&models.AuditEvent{}).Where("audit_events.organisation_id = ?", org.ID)
+
+	if params.Action != "" {
+		query = query.Where("audit_events.action = ?", params.Action)
+	}
+	if params.ActorID != "" {
+		actorId, _ := strconv.ParseUint(params.ActorID, 10, 64)
+		query = query.Where("audit_events.actor_id = ?", actorId)
+	}
+	if params.TargetType != "" {
+		query = query.Where("audit_events.target_type = ?", params.TargetType)
+	}
+	if params.TargetID != "" {
+		query = query.Where("audit_events.target_id = ?", params.TargetID)
+	}
+	// both were validated as RFC 3339
+	if params.Since != "" {
+		since, _ := time.Parse(time.RFC3339, params.Since)
+		query = query.Where("audit_events.created_at >= ?", since)
+	}
+	if params.Until != "" {
+		until, _ := time.Parse(time.RFC3339, params.Until)
+		query = query.Where("audit_events.created_at < ?", until)
+	}
+
+	return query, true
+}
+
+func auditCSVRecord(event models.AuditEvent) []string {
+	actorId := ""
+	if event.ActorID != nil {
+		actorId = fmt.Sprintf("%d", *event.ActorID)
+	}
+
+	metadata := "{}"
+	if len(event.Metadata) > 0 {
+		b, _ := json.Marshal(event.Metadata)
+		metadata = string(b)
+	}
+
+	return []string{
+		fmt.Sprintf("%d", event.ID),
+		fmt.Sprintf("%d", event.OrganisationID),
+		fmt.Sprintf("%d", event.Sequence),
+		event.ActorType,
+		actorId,
+		event.Action,
+		event.TargetType,
+		event.TargetID,
+		metadata,
+		event.IP,
+		event.UserAgent,
+		event.CreatedAt.Format(time.RFC3339),
+		event.PrevHash,
+		event.Hash,
+	}
+}
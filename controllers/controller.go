@@ -2,8 +2,13 @@ package controllers
 
 import (
 	"net/http"
+	"slices"
+	"strconv"
 
+	"github.com/codelikesuraj/hng11-task-two/middlewares"
+	"github.com/codelikesuraj/hng11-task-two/models"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
 func Home(c *gin.Context) {
@@ -11,3 +16,96 @@ func Home(c *gin.Context) {
 		"message": "this is the default page",
 	})
 }
+
+// currentPrincipal returns the caller authenticated by middlewares.Auth,
+// writing an unauthorized response if there is none.
+func currentPrincipal(c *gin.Context) (middlewares.Principal, bool) {
+	principal, ok := middlewares.GetPrincipal(c)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":     http.StatusText(http.StatusUnauthorized),
+			"message":    http.StatusText(http.StatusUnauthorized),
+			"statusCode": http.StatusUnauthorized,
+		})
+	}
+	return principal, ok
+}
+
+func isMember(db *gorm.DB, userId, orgId uint) bool {
+	return db.Model(&models.User{Model: gorm.Model{ID: userId}}).Where("id = ?", orgId).Association("Organisations").Count() > 0
+}
+
+// organisationRole returns the role of the caller in an organisation. A
+// service account holds its own role, the creator of the organisation is an
+// admin, other users hold the highest role granted by their effective teams,
+// or member. ok is false for callers that are not members.
+func organisationRole(db *gorm.DB, principal middlewares.Principal, org models.Organisation) (role string, ok bool) {
+	if principal.IsServiceAccount() {
+		return principal.ServiceAccount.Role, principal.ServiceAccount.OrganisationID == org.ID
+	}
+
+	if !isMember(db, principal.User.ID, org.ID) {
+		return "", false
+	}
+	if org.CreatedByID == principal.User.ID {
+		return models.RoleAdmin, true
+	}
+
+	// without its teams a user is only a member
+	teams, _ := effectiveTeams(db, org.ID, principal.User.ID)
+	for _, team := range teams {
+		if team.Role == models.RoleAdmin {
+			return models.RoleAdmin, true
+		}
+	}
+
+	return models.RoleMember, true
+}
+
+func roleGrants(role, scope string) bool {
+	return slices.Contains(models.ScopesForRole(role), scope)
+}
+
+// findAuthorizedOrganisation loads the :orgId organisation, responding with
+// not found unless the caller is one of its members and with forbidden
+// unless their role in it grants the scope.
+func findAuthorizedOrganisation(c *gin.Context, db *gorm.DB, scope string) (models.Organisation, bool) {
+	var org models.Organisation
+
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return org, false
+	}
+
+	orgId, _ := strconv.Atoi(c.Param("orgId"))
+	result := db.Limit(1).Find(&org, orgId)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return org, false
+	}
+
+	role, ok := organisationRole(db, principal, org)
+	if orgId < 1 || result.RowsAffected < 1 || !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":     http.StatusText(http.StatusNotFound),
+			"message":    "organisation not found",
+			"statusCode": http.StatusNotFound,
+		})
+		return org, false
+	}
+
+	if !roleGrants(role, scope) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"status":     http.StatusText(http.StatusForbidden),
+			"message":    "user cannot manage organisation",
+			"statusCode": http.StatusForbidden,
+		})
+		return org, false
+	}
+
+	return org, true
+}
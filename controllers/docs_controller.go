@@ -0,0 +1,32 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/codelikesuraj/hng11-task-two/docs"
+	"github.com/gin-gonic/gin"
+)
+
+const docsPage = `<!DOCTYPE html>
+<html>
+  <head>
+    <title>API documentation</title>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+  </head>
+  <body>
+    <redoc spec-url="/openapi.json"></redoc>
+    <script src="https://cdn.redoc.ly/redoc/v2.1.5/bundles/redoc.standalone.js"></script>
+  </body>
+</html>
+`
+
+// OpenAPI serves the OpenAPI document of the API.
+func OpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, "application/json", docs.OpenAPI)
+}
+
+// Docs serves a Redoc page rendering the OpenAPI document.
+func Docs(c *gin.Context) {
+	c.Data(http.StatusOK, "text/html; charset=utf-8", []byte(docsPage))
+}
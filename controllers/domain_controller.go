@@ -0,0 +1,509 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/codelikesuraj/hng11-task-two/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+var errDomainVerifiedElsewhere = errors.New("domain has already been verified by another organisation")
+
+// DomainController lets organisations claim the email domains of their
+// users. Resolver looks up the DNS records proving a claim, Mailer sends the
+// verification emails of the email method.
+type DomainController struct {
+	DB       *gorm.DB
+	Resolver utils.TXTResolver
+	Mailer   utils.Mailer
+}
+
+func NewDomainController(db *gorm.DB, resolver utils.TXTResolver, mailer utils.Mailer) *DomainController {
+	return &DomainController{DB: db, Resolver: resolver, Mailer: mailer}
+}
+
+func (dc *DomainController) GetAll(c *gin.Context) {
+	org, ok := findAuthorizedOrganisation(c, dc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	var domains []models.OrganisationDomain
+	if err := dc.DB.Where("organisation_id = ?", org.ID).Order("id").Find(&domains).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": fmt.Sprintf("found %d domain(s)", len(domains)),
+		"data": gin.H{
+			"domains": models.OrganisationDomainsResponse(domains),
+		},
+	})
+}
+
+// Create claims a domain for the organisation. With the dns method the
+// response holds the TXT record to publish before calling Verify, with the
+// email method a verification link is sent to the given admin mailbox.
+func (dc *DomainController) Create(c *gin.Context) {
+	var params models.OrganisationDomainCreateParams
+	validate := validator.New(validator.WithRequiredStructEnabled())
+
+	if err := c.ShouldBind(&params); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	params.Domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(params.Domain)), ".")
+
+	if err := validate.Struct(params); err != nil {
+		ve := err.(validator.ValidationErrors)
+		errors := make([]models.InputError, len(ve))
+		for i, fe := range ve {
+			errors[i] = models.InputError{
+				Field:   utils.GetJSONTagValue(params, fe.Field()),
+				Message: utils.GetValidationMessage(fe),
+			}
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": errors,
+		})
+		return
+	}
+
+	if params.Method == models.DomainVerificationEmail && !utils.IsDomainAdminEmail(params.Email, params.Domain) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": []models.InputError{{
+				Field:   "email",
+				Message: "must be one of the " + strings.Join(utils.DomainAdminMailboxes, ", ") + " mailboxes of the domain",
+			}},
+		})
+		return
+	}
+
+	org, ok := findAuthorizedOrganisation(c, dc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	var existing []models.OrganisationDomain
+	if err := dc.DB.Where("domain = ?", params.Domain).Find(&existing).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+	for _, claim := range existing {
+		message := "domain has already been claimed by this organisation"
+		if claim.OrganisationID != org.ID {
+			if !claim.IsVerified() {
+				continue
+			}
+			message = errDomainVerifiedElsewhere.Error()
+		}
+		c.JSON(http.StatusConflict, gin.H{
+			"status":     http.StatusText(http.StatusConflict),
+			"message":    message,
+			"statusCode": http.StatusConflict,
+		})
+		return
+	}
+
+	token, err := utils.GenerateToken("")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	domain := models.OrganisationDomain{
+		OrganisationID:     org.ID,
+		Domain:             params.Domain,
+		VerificationMethod: params.Method,
+		VerificationToken:  token,
+		VerificationEmail:  strings.ToLower(params.Email),
+		AutoJoin:           params.AutoJoin,
+	}
+	err = dc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&domain).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, models.AuditEvent{
+			OrganisationID: org.ID,
+			Action:         models.AuditDomainCreated,
+			TargetType:     models.AuditTargetDomain,
+			TargetID:       fmt.Sprintf("%d", domain.ID),
+			Metadata:       domainAuditMetadata(domain),
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	if domain.VerificationMethod == models.DomainVerificationEmail {
+		if err := dc.sendVerificationEmail(&domain); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status":     http.StatusText(http.StatusInternalServerError),
+				"message":    "domain claimed but the verification email could not be sent",
+				"statusCode": http.StatusInternalServerError,
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"message": "Domain claimed successfully",
+		"data":    models.OrganisationDomainResponse(domain),
+	})
+}
+
+func (dc *DomainController) Update(c *gin.Context) {
+	var params models.OrganisationDomainUpdateParams
+	validate := validator.New(validator.WithRequiredStructEnabled())
+
+	if err := c.ShouldBind(&params); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	if err := validate.Struct(params); err != nil {
+		ve := err.(validator.ValidationErrors)
+		errors := make([]models.InputError, len(ve))
+		for i, fe := range ve {
+			errors[i] = models.InputError{
+				Field:   utils.GetJSONTagValue(params, fe.Field()),
+				Message: utils.GetValidationMessage(fe),
+			}
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": errors,
+		})
+		return
+	}
+
+	domain, ok := dc.findDomain(c)
+	if !ok {
+		return
+	}
+
+	domain.AutoJoin = *params.AutoJoin
+	err := dc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&domain).Update("auto_join", domain.AutoJoin).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, models.AuditEvent{
+			OrganisationID: domain.OrganisationID,
+			Action:         models.AuditDomainUpdated,
+			TargetType:     models.AuditTargetDomain,
+			TargetID:       fmt.Sprintf("%d", domain.ID),
+			Metadata:       domainAuditMetadata(domain),
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Domain updated successfully",
+		"data":    models.OrganisationDomainResponse(domain),
+	})
+}
+
+// Verify checks the TXT record of a domain claimed with the dns method, or
+// sends a new verification email for the email method.
+func (dc *DomainController) Verify(c *gin.Context) {
+	domain, ok := dc.findDomain(c)
+	if !ok {
+		return
+	}
+
+	if domain.IsVerified() {
+		c.JSON(http.StatusOK, gin.H{
+			"status":  "success",
+			"message": "Domain is already verified",
+			"data":    models.OrganisationDomainResponse(domain),
+		})
+		return
+	}
+
+	if domain.VerificationMethod == models.DomainVerificationEmail {
+		if err := dc.sendVerificationEmail(&domain); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status":     http.StatusText(http.StatusInternalServerError),
+				"message":    "verification email could not be sent",
+				"statusCode": http.StatusInternalServerError,
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status":  "success",
+			"message": "Verification email sent to " + domain.VerificationEmail,
+			"data":    models.OrganisationDomainResponse(domain),
+		})
+		return
+	}
+
+	found, err := utils.HasTXTRecord(c.Request.Context(), dc.Resolver, domain.Domain, domain.VerificationRecord())
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"status":     http.StatusText(http.StatusBadGateway),
+			"message":    "DNS lookup failed",
+			"statusCode": http.StatusBadGateway,
+		})
+		return
+	}
+	if !found {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":     http.StatusText(http.StatusBadRequest),
+			"message":    "verification record not found, it may take a while for new DNS records to be visible",
+			"statusCode": http.StatusBadRequest,
+		})
+		return
+	}
+
+	dc.markVerified(c, domain)
+}
+
+// VerifyEmail verifies the domain whose verification link was followed.
+func (dc *DomainController) VerifyEmail(c *gin.Context) {
+	var domain models.OrganisationDomain
+	result := dc.DB.Where("email_token_hash = ?", utils.HashToken(c.Query("token"))).Limit(1).Find(&domain)
+	if c.Query("token") == "" || result.Error != nil || result.RowsAffected < 1 ||
+		domain.EmailTokenExpiresAt == nil || time.Now().After(*domain.EmailTokenExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":     http.StatusText(http.StatusBadRequest),
+			"message":    "invalid or expired token",
+			"statusCode": http.StatusBadRequest,
+		})
+		return
+	}
+
+	dc.markVerified(c, domain)
+}
+
+func (dc *DomainController) Delete(c *gin.Context) {
+	domain, ok := dc.findDomain(c)
+	if !ok {
+		return
+	}
+
+	// deleted claims are not kept, so the domain can be claimed again
+	err := dc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Delete(&domain).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, models.AuditEvent{
+			OrganisationID: domain.OrganisationID,
+			Action:         models.AuditDomainDeleted,
+			TargetType:     models.AuditTargetDomain,
+			TargetID:       fmt.Sprintf("%d", domain.ID),
+			Metadata:       map[string]interface{}{"domain": domain.Domain},
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Domain deleted successfully",
+	})
+}
+
+// markVerified marks the domain as verified by its organisation, unless
+// another organisation verified it first.
+func (dc *DomainController) markVerified(c *gin.Context, domain models.OrganisationDomain) {
+	err := dc.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		domain.VerifiedAt = &now
+		domain.EmailTokenHash = ""
+		domain.EmailTokenExpiresAt = nil
+		if err := tx.Save(&domain).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, models.AuditEvent{
+			OrganisationID: domain.OrganisationID,
+			Action:         models.AuditDomainVerified,
+			TargetType:     models.AuditTargetDomain,
+			TargetID:       fmt.Sprintf("%d", domain.ID),
+			Metadata:       domainAuditMetadata(domain),
+		})
+	})
+	// the unique index on verified domains rejects the save when another
+	// organisation verified the domain first, even at the same time
+	if err != nil && dc.verifiedElsewhere(domain) {
+		err = errDomainVerifiedElsewhere
+	}
+	if errors.Is(err, errDomainVerifiedElsewhere) {
+		c.JSON(http.StatusConflict, gin.H{
+			"status":     http.StatusText(http.StatusConflict),
+			"message":    err.Error(),
+			"statusCode": http.StatusConflict,
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Domain verified successfully",
+		"data":    models.OrganisationDomainResponse(domain),
+	})
+}
+
+// verifiedElsewhere reports whether another organisation verified the domain.
+func (dc *DomainController) verifiedElsewhere(domain models.OrganisationDomain) bool {
+	var count int64
+	dc.DB.Model(&models.OrganisationDomain{}).
+		Where("domain = ? AND organisation_id <> ? AND verified_at IS NOT NULL", domain.Domain, domain.OrganisationID).
+		Count(&count)
+	return count > 0
+}
+
+// sendVerificationEmail emails a new verification link to the admin mailbox
+// of the domain, replacing any link sent before.
+func (dc *DomainController) sendVerificationEmail(domain *models.OrganisationDomain) error {
+	token, err := utils.GenerateToken(models.DomainVerificationTokenPrefix)
+	if err != nil {
+		return err
+	}
+
+	expiresAt := time.Now().Add(models.DomainVerificationEmailTTL)
+	domain.EmailTokenHash = utils.HashToken(token)
+	domain.EmailTokenExpiresAt = &expiresAt
+	if err := dc.DB.Save(domain).Error; err != nil {
+		return err
+	}
+
+	var org models.Organisation
+	dc.DB.Limit(1).Find(&org, domain.OrganisationID)
+
+	body := fmt.Sprintf("%s has claimed the domain %s.\n\nIf they should be trusted with the accounts of its users, confirm the claim by opening the link below within 24 hours:\n\n%s/v1/domains/verify?token=%s\n\nOtherwise you can ignore this email.",
+		org.Name, domain.Domain, utils.GetIssuer(), token)
+	return dc.Mailer.Send(domain.VerificationEmail, "Verify your domain "+domain.Domain, body)
+}
+
+func (dc *DomainController) findDomain(c *gin.Context) (models.OrganisationDomain, bool) {
+	var domain models.OrganisationDomain
+
+	org, ok := findAuthorizedOrganisation(c, dc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return domain, false
+	}
+
+	domainId, _ := strconv.Atoi(c.Param("domainId"))
+	result := dc.DB.Where("organisation_id = ?", org.ID).Limit(1).Find(&domain, domainId)
+	if domainId < 1 || result.RowsAffected < 1 || result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":     http.StatusText(http.StatusNotFound),
+			"message":    "domain not found",
+			"statusCode": http.StatusNotFound,
+		})
+		return domain, false
+	}
+
+	return domain, true
+}
+
+// domainOrganisations returns the organisations that verified the domain of
+// the user's email and that the user is not a member of yet, only those that
+// let users join automatically if autoJoinOnly is set.
+func domainOrganisations(db *gorm.DB, user models.User, autoJoinOnly bool) ([]models.Organisation, error) {
+	var orgs []models.Organisation
+
+	query := db.
+		Joins("JOIN organisation_domains ON organisation_domains.organisation_id = organisations.id AND organisation_domains.deleted_at IS NULL").
+		Where("organisation_domains.domain = ? AND organisation_domains.verified_at IS NOT NULL", utils.GetEmailDomain(user.Email)).
+		Where("organisations.id NOT IN (?)", db.Table("users_organisations").Select("organisation_id").Where("user_id = ?", user.ID))
+	if autoJoinOnly {
+		query = query.Where("organisation_domains.auto_join = ?", true)
+	}
+
+	err := query.Order("organisations.id").Find(&orgs).Error
+	return orgs, err
+}
+
+// joinDomainOrganisations adds a user with a verified email to the
+// organisations of its domain that let users join automatically. An email
+// anyone could have typed in does not prove the user belongs there.
+func joinDomainOrganisations(tx *gorm.DB, user models.User) ([]models.Organisation, error) {
+	if !user.IsEmailVerified() {
+		return nil, nil
+	}
+
+	orgs, err := domainOrganisations(tx, user, true)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, org := range orgs {
+		if err := tx.Model(&org).Association("Users").Append(&user); err != nil {
+			return nil, err
+		}
+	}
+
+	return orgs, nil
+}
+
+func domainAuditMetadata(domain models.OrganisationDomain) map[string]interface{} {
+	return map[string]interface{}{
+		"domain":   domain.Domain,
+		"method":   domain.VerificationMethod,
+		"autoJoin": domain.AutoJoin,
+	}
+}
@@ -0,0 +1,172 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/codelikesuraj/hng11-task-two/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	eventStreamBuffer      = 256
+	eventStreamReplayBatch = 100
+	// how long clients wait before reconnecting, in milliseconds
+	eventStreamRetry = 3000
+	// how often a comment is sent on event streams to keep proxies from
+	// closing them while idle
+	eventStreamHeartbeat = 15 * time.Second
+)
+
+// EventController streams outbox events to the users they concern as
+// Server-Sent Events.
+type EventController struct {
+	DB  *gorm.DB
+	Bus *utils.EventBus
+}
+
+func NewEventController(db *gorm.DB, bus *utils.EventBus) *EventController {
+	return &EventController{DB: db, Bus: bus}
+}
+
+// the part of the payload of outbox events telling who they concern
+type eventStreamPayload struct {
+	Data struct {
+		Target *struct {
+			Type string `json:"type"`
+			ID   string `json:"id"`
+		} `json:"target"`
+	} `json:"data"`
+}
+
+// Stream sends the user the events of the organisations they are a member of
+// as they are published, and those about themselves such as being removed
+// from one, each with the outbox event ID as its ID and its payload as data.
+// With a Last-Event-ID header, or lastEventId query for clients that cannot
+// send one, the events published since that one are sent first. A client
+// falling too far behind is disconnected, to resume from the last event it
+// got.
+func (ec *EventController) Stream(c *gin.Context) {
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	lastEventId := c.GetHeader("Last-Event-ID")
+	if lastEventId == "" {
+		lastEventId = c.Query("lastEventId")
+	}
+
+	// subscribe before replaying so nothing published in between is missed
+	subscription := ec.Bus.SubscribeBuffered(eventStreamBuffer)
+	defer subscription.Close()
+
+	var replay []models.OutboxEvent
+	if lastEventId != "" {
+		var err error
+		if replay, err = ec.eventsSince(lastEventId, principal.User.ID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status":     http.StatusText(http.StatusInternalServerError),
+				"message":    http.StatusText(http.StatusInternalServerError),
+				"statusCode": http.StatusInternalServerError,
+			})
+			return
+		}
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// stop nginx buffering the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	fmt.Fprintf(c.Writer, "retry: %d\n\n", eventStreamRetry)
+	sent := map[string]bool{}
+	for _, event := range replay {
+		writeStreamEvent(c, event)
+		sent[event.EventID] = true
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(eventStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": heartbeat\n\n")
+			c.Writer.Flush()
+		case event, ok := <-subscription.Events():
+			if !ok {
+				return
+			}
+			if sent[event.EventID] || !ec.concerns(event, principal.User.ID) {
+				continue
+			}
+			sent[event.EventID] = true
+			writeStreamEvent(c, event)
+			c.Writer.Flush()
+		}
+	}
+}
+
+// eventsSince loads the published events after the one with the ID that
+// concern the user, none if there is no such event.
+func (ec *EventController) eventsSince(eventId string, userId uint) ([]models.OutboxEvent, error) {
+	var last models.OutboxEvent
+	result := ec.DB.Where("event_id = ?", eventId).Limit(1).Find(&last)
+	if result.Error != nil || result.RowsAffected < 1 {
+		return nil, result.Error
+	}
+
+	events := []models.OutboxEvent{}
+	after := last.ID
+	for {
+		var batch []models.OutboxEvent
+		err := ec.DB.Where("id > ? AND published_at IS NOT NULL", after).
+			Order("id").
+			Limit(eventStreamReplayBatch).
+			Find(&batch).Error
+		if err != nil {
+			return nil, err
+		}
+
+		for _, event := range batch {
+			if ec.concerns(event, userId) {
+				events = append(events, event)
+			}
+		}
+
+		if len(batch) < eventStreamReplayBatch {
+			return events, nil
+		}
+		after = batch[len(batch)-1].ID
+	}
+}
+
+// concerns reports whether the event is about the user or an organisation
+// they are a member of.
+func (ec *EventController) concerns(event models.OutboxEvent, userId uint) bool {
+	var payload eventStreamPayload
+	if err := json.Unmarshal([]byte(event.Payload), &payload); err != nil {
+		return false
+	}
+
+	target := payload.Data.Target
+	if target != nil && target.Type == models.AuditTargetUser && target.ID == fmt.Sprintf("%d", userId) {
+		return true
+	}
+
+	return isMember(ec.DB, userId, event.OrganisationID)
+}
+
+func writeStreamEvent(c *gin.Context, event models.OutboxEvent) {
+	fmt.Fprintf(c.Writer, "id: %s\nevent: %s\ndata: %s\n\n", event.EventID, event.Event, event.Payload)
+}
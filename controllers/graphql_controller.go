@@ -0,0 +1,132 @@
+package controllers
+
+import (
+	"context"
+	_ "embed"
+	"net/http"
+
+	"github.com/codelikesuraj/hng11-task-two/middlewares"
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/gin-gonic/gin"
+	graphql "github.com/graph-gophers/graphql-go"
+	"gorm.io/gorm"
+)
+
+// queries nesting deeper than this are rejected, each level of users and
+// organisations being another batch of joins
+const graphqlMaxDepth = 8
+
+//go:embed schema.graphql
+var graphqlSchemaSDL string
+
+var graphqlSchema = graphql.MustParseSchema(graphqlSchemaSDL, &graphqlResolver{}, graphql.MaxDepth(graphqlMaxDepth))
+
+// GraphQLController serves the GraphQL API over users and organisations.
+type GraphQLController struct {
+	DB *gorm.DB
+}
+
+func NewGraphQLController(db *gorm.DB) *GraphQLController {
+	return &GraphQLController{DB: db}
+}
+
+type graphqlParams struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// graphqlRequest is what the resolvers of a request share, through its
+// context.
+type graphqlRequest struct {
+	db        *gorm.DB
+	c         *gin.Context
+	principal middlewares.Principal
+	loaders   *graphqlLoaders
+}
+
+type graphqlRequestKey struct{}
+
+func graphqlRequestFrom(ctx context.Context) *graphqlRequest {
+	return ctx.Value(graphqlRequestKey{}).(*graphqlRequest)
+}
+
+// Serve executes a GraphQL query or mutation for the caller. Errors of
+// resolvers are reported in the errors of the response, with the status and
+// statusCode the REST routes would respond with in their extensions.
+func (gc *GraphQLController) Serve(c *gin.Context) {
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	var params graphqlParams
+	if err := c.ShouldBindJSON(&params); err != nil || params.Query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":     http.StatusText(http.StatusBadRequest),
+			"message":    "a query is required",
+			"statusCode": http.StatusBadRequest,
+		})
+		return
+	}
+
+	ctx := context.WithValue(c.Request.Context(), graphqlRequestKey{}, &graphqlRequest{
+		db:        gc.DB,
+		c:         c,
+		principal: principal,
+		loaders:   newGraphQLLoaders(gc.DB),
+	})
+
+	c.JSON(http.StatusOK, graphqlSchema.Exec(ctx, params.Query, params.OperationName, params.Variables))
+}
+
+// graphqlError is the error of a resolver, with the status code and message
+// the REST route would respond with.
+type graphqlError struct {
+	statusCode int
+	message    string
+	errors     []models.InputError
+}
+
+func (e *graphqlError) Error() string {
+	return e.message
+}
+
+func (e *graphqlError) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{
+		"status":     http.StatusText(e.statusCode),
+		"statusCode": e.statusCode,
+	}
+	if e.errors != nil {
+		extensions["errors"] = e.errors
+	}
+	return extensions
+}
+
+func newGraphQLError(statusCode int, message string) *graphqlError {
+	return &graphqlError{statusCode: statusCode, message: message}
+}
+
+func graphqlInternalError() *graphqlError {
+	return newGraphQLError(http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+}
+
+func graphqlValidationError(errors []models.InputError) *graphqlError {
+	return &graphqlError{statusCode: http.StatusUnprocessableEntity, message: "validation failed", errors: errors}
+}
+
+// requireScope mirrors middlewares.RequireScope.
+func (r *graphqlRequest) requireScope(scope string) error {
+	if !r.principal.HasScope(scope) {
+		return newGraphQLError(http.StatusForbidden, "token is missing the "+scope+" scope")
+	}
+	return nil
+}
+
+// requireSession mirrors middlewares.RequireSession.
+func (r *graphqlRequest) requireSession() error {
+	if !r.principal.IsSession() {
+		return newGraphQLError(http.StatusForbidden, "tokens and API keys cannot access this route")
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/graph-gophers/dataloader"
+	"gorm.io/gorm"
+)
+
+// how long loaders wait for the resolvers of a level of a query to ask for
+// the rows they need before loading them all at once
+const graphqlBatchWait = 5 * time.Millisecond
+
+// graphqlLoaders batch the membership lookups of the resolvers of a request
+// into one query over users_organisations per level of the query, rather
+// than one per user or organisation. They cache what they load for the rest
+// of the request.
+type graphqlLoaders struct {
+	// user ID to the organisations they are a member of
+	organisations *dataloader.Loader
+	// organisation ID to its members
+	members *dataloader.Loader
+}
+
+func newGraphQLLoaders(db *gorm.DB) *graphqlLoaders {
+	return &graphqlLoaders{
+		organisations: dataloader.NewBatchedLoader(loadUserOrganisations(db), dataloader.WithWait(graphqlBatchWait)),
+		members:       dataloader.NewBatchedLoader(loadOrganisationMembers(db), dataloader.WithWait(graphqlBatchWait)),
+	}
+}
+
+// userOrganisations loads the organisations of each of the users, in the
+// same batch.
+func (l *graphqlLoaders) userOrganisations(ctx context.Context, userIds ...uint) ([][]models.Organisation, error) {
+	keys := make(dataloader.Keys, len(userIds))
+	for i, userId := range userIds {
+		keys[i] = loaderKey(userId)
+	}
+
+	values, errs := l.organisations.LoadMany(ctx, keys)()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	orgs := make([][]models.Organisation, len(values))
+	for i, value := range values {
+		orgs[i] = value.([]models.Organisation)
+	}
+	return orgs, nil
+}
+
+func (l *graphqlLoaders) organisationMembers(ctx context.Context, orgId uint) ([]models.User, error) {
+	users, err := l.members.Load(ctx, loaderKey(orgId))()
+	if err != nil {
+		return nil, err
+	}
+	return users.([]models.User), nil
+}
+
+type organisationOfMember struct {
+	models.Organisation
+	MemberID uint
+}
+
+func loadUserOrganisations(db *gorm.DB) dataloader.BatchFunc {
+	return func(ctx context.Context, keys dataloader.Keys) []*dataloader.Result {
+		var rows []organisationOfMember
+		err := db.WithContext(ctx).Model(&models.Organisation{}).
+			Select("organisations.*, users_organisations.user_id AS member_id").
+			Joins("JOIN users_organisations ON users_organisations.organisation_id = organisations.id").
+			Where("users_organisations.user_id IN ?", loaderIDs(keys)).
+			Order("organisations.id").
+			Find(&rows).Error
+
+		byUser := map[string][]models.Organisation{}
+		for _, row := range rows {
+			key := loaderKey(row.MemberID).String()
+			byUser[key] = append(byUser[key], row.Organisation)
+		}
+
+		results := make([]*dataloader.Result, len(keys))
+		for i, key := range keys {
+			orgs := byUser[key.String()]
+			if orgs == nil {
+				orgs = []models.Organisation{}
+			}
+			results[i] = &dataloader.Result{Data: orgs, Error: err}
+		}
+		return results
+	}
+}
+
+type memberOfOrganisation struct {
+	models.User
+	MemberOfID uint
+}
+
+func loadOrganisationMembers(db *gorm.DB) dataloader.BatchFunc {
+	return func(ctx context.Context, keys dataloader.Keys) []*dataloader.Result {
+		var rows []memberOfOrganisation
+		err := db.WithContext(ctx).Model(&models.User{}).
+			Select("users.*, users_organisations.organisation_id AS member_of_id").
+			Joins("JOIN users_organisations ON users_organisations.user_id = users.id").
+			Where("users_organisations.organisation_id IN ?", loaderIDs(keys)).
+			Order("users.id").
+			Find(&rows).Error
+
+		byOrg := map[string][]models.User{}
+		for _, row := range rows {
+			key := loaderKey(row.MemberOfID).String()
+			byOrg[key] = append(byOrg[key], row.User)
+		}
+
+		results := make([]*dataloader.Result, len(keys))
+		for i, key := range keys {
+			users := byOrg[key.String()]
+			if users == nil {
+				users = []models.User{}
+			}
+			results[i] = &dataloader.Result{Data: users, Error: err}
+		}
+		return results
+	}
+}
+
+func loaderKey(id uint) dataloader.Key {
+	return dataloader.StringKey(fmt.Sprintf("%d", id))
+}
+
+func loaderIDs(keys dataloader.Keys) []uint {
+	ids := make([]uint, len(keys))
+	for i, key := range keys {
+		id, _ := strconv.ParseUint(key.String(), 10, 64)
+		ids[i] = uint(id)
+	}
+	return ids
+}
+
+// forgetMembership drops what the loaders cached about the user and the
+// organisation after the user joined it.
+func (l *graphqlLoaders) forgetMembership(ctx context.Context, userId, orgId uint) {
+	l.organisations.Clear(ctx, loaderKey(userId))
+	l.members.Clear(ctx, loaderKey(orgId))
+}
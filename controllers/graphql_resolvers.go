@@ -0,0 +1,410 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/codelikesuraj/hng11-task-two/utils"
+	"github.com/go-playground/validator/v10"
+	graphql "github.com/graph-gophers/graphql-go"
+)
+
+// graphqlResolver resolves the queries and mutations of schema.graphql, each
+// mirroring a REST handler and its authorization checks.
+type graphqlResolver struct{}
+
+func (*graphqlResolver) Me(ctx context.Context) (*userResolver, error) {
+	r := graphqlRequestFrom(ctx)
+	if err := r.requireScope(models.ScopeUsersRead); err != nil {
+		return nil, err
+	}
+
+	if r.principal.IsServiceAccount() {
+		return nil, nil
+	}
+	return &userResolver{user: *r.principal.User}, nil
+}
+
+// User mirrors UserController.GetUserById.
+func (*graphqlResolver) User(ctx context.Context, args struct{ UserID graphql.ID }) (*userResolver, error) {
+	r := graphqlRequestFrom(ctx)
+	if err := r.requireScope(models.ScopeUsersRead); err != nil {
+		return nil, err
+	}
+
+	userId := graphqlIDValue(args.UserID)
+	if userId < 1 {
+		return nil, nil
+	}
+
+	var user models.User
+	result := r.db.Limit(1).Find(&user, userId)
+	if result.Error != nil {
+		return nil, graphqlInternalError()
+	}
+	if result.RowsAffected < 1 {
+		return nil, nil
+	}
+
+	// service accounts can only see members of their organisation
+	if r.principal.IsServiceAccount() && !isMember(r.db, user.ID, r.principal.ServiceAccount.OrganisationID) {
+		return nil, nil
+	}
+
+	return &userResolver{user: user}, nil
+}
+
+// Organisation mirrors OrganisationController.GetOrganisationById.
+func (*graphqlResolver) Organisation(ctx context.Context, args struct{ OrgID graphql.ID }) (*organisationResolver, error) {
+	r := graphqlRequestFrom(ctx)
+	if err := r.requireScope(models.ScopeOrgsRead); err != nil {
+		return nil, err
+	}
+
+	orgId := graphqlIDValue(args.OrgID)
+	if orgId < 1 {
+		return nil, nil
+	}
+
+	var orgs []models.Organisation
+	if err := organisationsQuery(r.db, r.principal, "", "").Where("organisations.id = ?", orgId).Find(&orgs).Error; err != nil {
+		return nil, graphqlInternalError()
+	}
+	if len(orgs) < 1 {
+		return nil, nil
+	}
+
+	return &organisationResolver{org: orgs[0]}, nil
+}
+
+// Organisations mirrors OrganisationController.GetAll.
+func (*graphqlResolver) Organisations(ctx context.Context, args struct {
+	Q      *string
+	Prefix *string
+	Limit  *int32
+	Cursor *string
+	Sort   *string
+}) (*organisationPageResolver, error) {
+	r := graphqlRequestFrom(ctx)
+	if err := r.requireScope(models.ScopeOrgsRead); err != nil {
+		return nil, err
+	}
+
+	var limit int
+	if args.Limit != nil {
+		limit = int(*args.Limit)
+	}
+	page, errors := utils.NewPagination(limit, stringValue(args.Sort), stringValue(args.Cursor), "organisations", organisationSorts, "createdAt")
+	if errors != nil {
+		return nil, graphqlValidationError(errors)
+	}
+
+	query := organisationsQuery(r.db, r.principal, stringValue(args.Q), stringValue(args.Prefix))
+	orgs, pageInfo, err := utils.Paginate[models.Organisation](query, page)
+	if err == utils.ErrStaleCursor {
+		return nil, newGraphQLError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return nil, graphqlInternalError()
+	}
+
+	return &organisationPageResolver{orgs: orgs, pageInfo: pageInfo}, nil
+}
+
+// JoinableOrganisations mirrors OrganisationController.GetJoinable.
+func (*graphqlResolver) JoinableOrganisations(ctx context.Context) ([]*organisationResolver, error) {
+	r := graphqlRequestFrom(ctx)
+	if err := r.requireSession(); err != nil {
+		return nil, err
+	}
+
+	orgs, err := domainOrganisations(r.db, *r.principal.User, false)
+	if err != nil {
+		return nil, graphqlInternalError()
+	}
+
+	return organisationResolvers(orgs), nil
+}
+
+// CreateOrganisation mirrors OrganisationController.Create.
+func (*graphqlResolver) CreateOrganisation(ctx context.Context, args struct {
+	Name        string
+	Description *string
+}) (*organisationResolver, error) {
+	r := graphqlRequestFrom(ctx)
+	if err := r.requireScope(models.ScopeOrgsWrite); err != nil {
+		return nil, err
+	}
+
+	params := models.OrganisationCreateParams{Name: args.Name, Description: stringValue(args.Description)}
+	validate := validator.New(validator.WithRequiredStructEnabled())
+	if err := validate.Struct(params); err != nil {
+		ve := err.(validator.ValidationErrors)
+		errors := make([]models.InputError, len(ve))
+		for i, fe := range ve {
+			errors[i] = models.InputError{
+				Field:   utils.GetJSONTagValue(params, fe.Field()),
+				Message: utils.GetValidationMessage(fe),
+			}
+		}
+		return nil, graphqlValidationError(errors)
+	}
+
+	if r.principal.IsServiceAccount() {
+		return nil, newGraphQLError(http.StatusForbidden, "service accounts cannot create organisations")
+	}
+
+	org, err := createOrganisation(r.db, r.c, *r.principal.User, params)
+	if err != nil {
+		return nil, graphqlInternalError()
+	}
+
+	return &organisationResolver{org: org}, nil
+}
+
+// AddUserToOrganisation mirrors OrganisationController.AddUser.
+func (*graphqlResolver) AddUserToOrganisation(ctx context.Context, args struct {
+	OrgID  graphql.ID
+	UserID graphql.ID
+}) (*membershipResolver, error) {
+	r := graphqlRequestFrom(ctx)
+	if err := r.requireScope(models.ScopeOrgsWrite); err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	result := r.db.Limit(1).Find(&user, graphqlIDValue(args.UserID))
+	if result.RowsAffected < 1 || result.Error != nil {
+		return nil, newGraphQLError(http.StatusNotFound, "user not found")
+	}
+
+	var org models.Organisation
+	result = r.db.Limit(1).Find(&org, graphqlIDValue(args.OrgID))
+	if result.RowsAffected < 1 || result.Error != nil {
+		return nil, newGraphQLError(http.StatusNotFound, "organisation not found")
+	}
+
+	if _, ok := organisationRole(r.db, r.principal, org); !ok {
+		return nil, newGraphQLError(http.StatusUnauthorized, "user cannot access organisation")
+	}
+
+	if err := addOrganisationMember(r.db, r.c, org, user, nil); err != nil {
+		return nil, newGraphQLError(http.StatusInternalServerError, "error adding user to organisation")
+	}
+	r.loaders.forgetMembership(ctx, user.ID, org.ID)
+
+	return &membershipResolver{user: user, org: org}, nil
+}
+
+// JoinOrganisation mirrors OrganisationController.Join.
+func (*graphqlResolver) JoinOrganisation(ctx context.Context, args struct{ OrgID graphql.ID }) (*membershipResolver, error) {
+	r := graphqlRequestFrom(ctx)
+	if err := r.requireSession(); err != nil {
+		return nil, err
+	}
+
+	orgs, err := domainOrganisations(r.db, *r.principal.User, false)
+	if err != nil {
+		return nil, graphqlInternalError()
+	}
+
+	var org models.Organisation
+	for _, joinable := range orgs {
+		if joinable.ID == graphqlIDValue(args.OrgID) {
+			org = joinable
+		}
+	}
+	if org.ID == 0 {
+		return nil, newGraphQLError(http.StatusNotFound, "organisation not found")
+	}
+
+	if !r.principal.User.IsEmailVerified() {
+		return nil, newGraphQLError(http.StatusForbidden, "Verify your email to join this organisation")
+	}
+
+	err = addOrganisationMember(r.db, r.c, org, *r.principal.User, map[string]interface{}{"via": "domain"})
+	if err != nil {
+		return nil, newGraphQLError(http.StatusInternalServerError, "error adding user to organisation")
+	}
+	r.loaders.forgetMembership(ctx, r.principal.User.ID, org.ID)
+
+	return &membershipResolver{user: *r.principal.User, org: org}, nil
+}
+
+type userResolver struct {
+	user models.User
+}
+
+func (u *userResolver) UserID() graphql.ID {
+	return graphql.ID(fmt.Sprintf("%d", u.user.ID))
+}
+
+func (u *userResolver) FirstName() string {
+	return u.user.FirstName
+}
+
+func (u *userResolver) LastName() string {
+	return u.user.LastName
+}
+
+func (u *userResolver) Email() string {
+	return u.user.Email
+}
+
+func (u *userResolver) Phone() string {
+	return u.user.Phone
+}
+
+// Organisations lists the organisations of the user that the caller can
+// see: those they are a member of as well, or the one of a service account.
+func (u *userResolver) Organisations(ctx context.Context) ([]*organisationResolver, error) {
+	r := graphqlRequestFrom(ctx)
+	if err := r.requireScope(models.ScopeOrgsRead); err != nil {
+		return nil, err
+	}
+
+	visible := map[uint]bool{}
+	var orgs []models.Organisation
+	if r.principal.IsServiceAccount() {
+		loaded, err := r.loaders.userOrganisations(ctx, u.user.ID)
+		if err != nil {
+			return nil, graphqlInternalError()
+		}
+		orgs = loaded[0]
+		visible[r.principal.ServiceAccount.OrganisationID] = true
+	} else {
+		loaded, err := r.loaders.userOrganisations(ctx, u.user.ID, r.principal.User.ID)
+		if err != nil {
+			return nil, graphqlInternalError()
+		}
+		orgs = loaded[0]
+		for _, org := range loaded[1] {
+			visible[org.ID] = true
+		}
+	}
+
+	resolvers := []*organisationResolver{}
+	for _, org := range orgs {
+		if visible[org.ID] {
+			resolvers = append(resolvers, &organisationResolver{org: org})
+		}
+	}
+	return resolvers, nil
+}
+
+// organisationResolver resolves an organisation the caller is known to be
+// able to see.
+type organisationResolver struct {
+	org models.Organisation
+}
+
+func organisationResolvers(orgs []models.Organisation) []*organisationResolver {
+	resolvers := make([]*organisationResolver, len(orgs))
+	for i, org := range orgs {
+		resolvers[i] = &organisationResolver{org: org}
+	}
+	return resolvers
+}
+
+func (o *organisationResolver) OrgID() graphql.ID {
+	return graphql.ID(fmt.Sprintf("%d", o.org.ID))
+}
+
+func (o *organisationResolver) Name() string {
+	return o.org.Name
+}
+
+func (o *organisationResolver) Description() string {
+	return o.org.Description
+}
+
+func (o *organisationResolver) Members(ctx context.Context) ([]*userResolver, error) {
+	r := graphqlRequestFrom(ctx)
+	if err := r.requireScope(models.ScopeUsersRead); err != nil {
+		return nil, err
+	}
+
+	users, err := r.loaders.organisationMembers(ctx, o.org.ID)
+	if err != nil {
+		return nil, graphqlInternalError()
+	}
+
+	resolvers := make([]*userResolver, len(users))
+	for i, user := range users {
+		resolvers[i] = &userResolver{user: user}
+	}
+	return resolvers, nil
+}
+
+type membershipResolver struct {
+	user models.User
+	org  models.Organisation
+}
+
+func (m *membershipResolver) User() *userResolver {
+	return &userResolver{user: m.user}
+}
+
+func (m *membershipResolver) Organisation() *organisationResolver {
+	return &organisationResolver{org: m.org}
+}
+
+type organisationPageResolver struct {
+	orgs     []models.Organisation
+	pageInfo utils.PageInfo
+}
+
+func (p *organisationPageResolver) Organisations() []*organisationResolver {
+	return organisationResolvers(p.orgs)
+}
+
+func (p *organisationPageResolver) Pagination() *paginationResolver {
+	return &paginationResolver{info: p.pageInfo}
+}
+
+type paginationResolver struct {
+	info utils.PageInfo
+}
+
+func (p *paginationResolver) Total() int32 {
+	return int32(p.info.Total)
+}
+
+func (p *paginationResolver) Limit() int32 {
+	return int32(p.info.Limit)
+}
+
+func (p *paginationResolver) Sort() string {
+	return p.info.Sort
+}
+
+func (p *paginationResolver) NextCursor() *string {
+	if p.info.NextCursor == "" {
+		return nil
+	}
+	return &p.info.NextCursor
+}
+
+func (p *paginationResolver) PrevCursor() *string {
+	if p.info.PrevCursor == "" {
+		return nil
+	}
+	return &p.info.PrevCursor
+}
+
+// graphqlIDValue is the row ID an ID argument stands for, 0 if it is not
+// one.
+func graphqlIDValue(id graphql.ID) uint {
+	value, _ := strconv.ParseUint(string(id), 10, 64)
+	return uint(value)
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
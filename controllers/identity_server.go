@@ -0,0 +1,208 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/codelikesuraj/hng11-task-two/identitypb"
+	"github.com/codelikesuraj/hng11-task-two/middlewares"
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/codelikesuraj/hng11-task-two/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// IdentityServer serves the gRPC API internal services verify tokens and
+// look up memberships through, to callers authenticated by
+// middlewares.GRPCAuth and with the same checks as the REST routes.
+type IdentityServer struct {
+	identitypb.UnimplementedIdentityServer
+	DB *gorm.DB
+}
+
+func NewIdentityServer(db *gorm.DB) *IdentityServer {
+	return &IdentityServer{DB: db}
+}
+
+// VerifyToken reports an invalid token as inactive rather than as an error,
+// the call itself having succeeded.
+func (s *IdentityServer) VerifyToken(ctx context.Context, req *identitypb.VerifyTokenRequest) (*identitypb.VerifyTokenResponse, error) {
+	principal, err := grpcPrincipal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !principal.IsServiceAccount() {
+		return nil, status.Error(codes.PermissionDenied, "only service accounts can verify tokens")
+	}
+
+	verified, err := middlewares.Authenticate(s.DB.WithContext(ctx), req.GetToken())
+	if err != nil {
+		return &identitypb.VerifyTokenResponse{Error: err.Error()}, nil
+	}
+
+	resp := &identitypb.VerifyTokenResponse{
+		Active:  true,
+		Scopes:  verified.Scopes,
+		Session: verified.IsSession(),
+	}
+	if verified.IsServiceAccount() {
+		resp.Principal = &identitypb.VerifyTokenResponse_ServiceAccount{ServiceAccount: &identitypb.ServiceAccount{
+			ServiceAccountId: fmt.Sprintf("%d", verified.ServiceAccount.ID),
+			OrgId:            fmt.Sprintf("%d", verified.ServiceAccount.OrganisationID),
+			Name:             verified.ServiceAccount.Name,
+			Role:             verified.ServiceAccount.Role,
+		}}
+	} else {
+		resp.Principal = &identitypb.VerifyTokenResponse_User{User: userMessage(*verified.User)}
+	}
+
+	return resp, nil
+}
+
+// GetUser mirrors UserController.GetUserById.
+func (s *IdentityServer) GetUser(ctx context.Context, req *identitypb.GetUserRequest) (*identitypb.User, error) {
+	principal, err := grpcPrincipalWithScope(ctx, models.ScopeUsersRead)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.findVisibleUser(ctx, principal, req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	return userMessage(user), nil
+}
+
+// ListUserOrganisations lists the organisations of the user that the caller
+// can see, as OrganisationController.GetAll lists the caller's own.
+func (s *IdentityServer) ListUserOrganisations(ctx context.Context, req *identitypb.ListUserOrganisationsRequest) (*identitypb.ListUserOrganisationsResponse, error) {
+	principal, err := grpcPrincipalWithScope(ctx, models.ScopeOrgsRead)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.findVisibleUser(ctx, principal, req.GetUserId())
+	if err != nil {
+		return nil, err
+	}
+
+	page, errors := utils.NewPagination(int(req.GetPageSize()), "", req.GetPageToken(), "organisations", organisationSorts, "createdAt")
+	if errors != nil {
+		return nil, status.Error(codes.InvalidArgument, errors[0].Field+": "+errors[0].Message)
+	}
+
+	db := s.DB.WithContext(ctx)
+	query := organisationsQuery(db, principal, "", "").
+		Where("organisations.id IN (?)", db.Table("users_organisations").Select("organisation_id").Where("user_id = ?", user.ID))
+	orgs, pageInfo, err := utils.Paginate[models.Organisation](query, page)
+	if err == utils.ErrStaleCursor {
+		return nil, status.Error(codes.InvalidArgument, "page_token: "+err.Error())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+
+	resp := &identitypb.ListUserOrganisationsResponse{
+		Organisations: make([]*identitypb.Organisation, len(orgs)),
+		NextPageToken: pageInfo.NextCursor,
+		Total:         pageInfo.Total,
+	}
+	for i, org := range orgs {
+		resp.Organisations[i] = &identitypb.Organisation{
+			OrgId:       fmt.Sprintf("%d", org.ID),
+			Name:        org.Name,
+			Description: org.Description,
+		}
+	}
+
+	return resp, nil
+}
+
+// CheckMembership reports the role of the user in one of the caller's
+// organisations, as organisationRole works it out for the REST routes.
+func (s *IdentityServer) CheckMembership(ctx context.Context, req *identitypb.CheckMembershipRequest) (*identitypb.CheckMembershipResponse, error) {
+	principal, err := grpcPrincipalWithScope(ctx, models.ScopeOrgsRead)
+	if err != nil {
+		return nil, err
+	}
+
+	db := s.DB.WithContext(ctx)
+	orgId, _ := strconv.Atoi(req.GetOrgId())
+	var orgs []models.Organisation
+	if err := organisationsQuery(db, principal, "", "").Where("organisations.id = ?", orgId).Find(&orgs).Error; err != nil {
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	if len(orgs) < 1 {
+		return nil, status.Error(codes.NotFound, "organisation not found")
+	}
+
+	userId, _ := strconv.Atoi(req.GetUserId())
+	var user models.User
+	result := db.Limit(1).Find(&user, userId)
+	if result.Error != nil {
+		return nil, status.Error(codes.Internal, "internal error")
+	}
+	if userId < 1 || result.RowsAffected < 1 {
+		return nil, status.Error(codes.NotFound, "user not found")
+	}
+
+	role, ok := organisationRole(db, middlewares.Principal{User: &user}, orgs[0])
+	return &identitypb.CheckMembershipResponse{Member: ok, Role: role}, nil
+}
+
+// findVisibleUser mirrors UserController.GetUserById: users can see anyone,
+// service accounts only the members of their organisation.
+func (s *IdentityServer) findVisibleUser(ctx context.Context, principal middlewares.Principal, id string) (models.User, error) {
+	var user models.User
+
+	userId, _ := strconv.Atoi(id)
+	if userId < 1 {
+		return user, status.Error(codes.NotFound, "user not found")
+	}
+
+	db := s.DB.WithContext(ctx)
+	result := db.Limit(1).Find(&user, userId)
+	if result.Error != nil {
+		return user, status.Error(codes.Internal, "internal error")
+	}
+	if result.RowsAffected < 1 {
+		return user, status.Error(codes.NotFound, "user not found")
+	}
+
+	if principal.IsServiceAccount() && !isMember(db, user.ID, principal.ServiceAccount.OrganisationID) {
+		return user, status.Error(codes.NotFound, "user not found")
+	}
+
+	return user, nil
+}
+
+func grpcPrincipal(ctx context.Context) (middlewares.Principal, error) {
+	principal, ok := middlewares.PrincipalFromContext(ctx)
+	if !ok {
+		return principal, status.Error(codes.Unauthenticated, "unauthenticated")
+	}
+	return principal, nil
+}
+
+// grpcPrincipalWithScope is grpcPrincipal for methods behind a scope,
+// checking it as middlewares.RequireScope does.
+func grpcPrincipalWithScope(ctx context.Context, scope string) (middlewares.Principal, error) {
+	principal, err := grpcPrincipal(ctx)
+	if err == nil && !principal.HasScope(scope) {
+		err = status.Error(codes.PermissionDenied, "token is missing the "+scope+" scope")
+	}
+	return principal, err
+}
+
+func userMessage(user models.User) *identitypb.User {
+	return &identitypb.User{
+		UserId:    fmt.Sprintf("%d", user.ID),
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Email:     user.Email,
+		Phone:     user.Phone,
+	}
+}
@@ -0,0 +1,265 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/codelikesuraj/hng11-task-two/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+// InvitationController manages the invitations of an organisation. Mailer
+// sends invitations addressed to an email.
+type InvitationController struct {
+	DB     *gorm.DB
+	Mailer utils.Mailer
+}
+
+func NewInvitationController(db *gorm.DB, mailer utils.Mailer) *InvitationController {
+	return &InvitationController{DB: db, Mailer: mailer}
+}
+
+func (ic *InvitationController) GetAll(c *gin.Context) {
+	org, ok := findAuthorizedOrganisation(c, ic.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	var invitations []models.Invitation
+	if err := ic.DB.Where("organisation_id = ?", org.ID).Order("id").Find(&invitations).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": fmt.Sprintf("found %d invitation(s)", len(invitations)),
+		"data": gin.H{
+			"invitations": models.InvitationsResponse(invitations),
+		},
+	})
+}
+
+func (ic *InvitationController) Create(c *gin.Context) {
+	var params models.InvitationCreateParams
+	validate := validator.New(validator.WithRequiredStructEnabled())
+
+	if err := c.ShouldBind(&params); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	if err := validate.Struct(params); err != nil {
+		ve := err.(validator.ValidationErrors)
+		errors := make([]models.InputError, len(ve))
+		for i, fe := range ve {
+			errors[i] = models.InputError{
+				Field:   utils.GetJSONTagValue(params, fe.Field()),
+				Message: utils.GetValidationMessage(fe),
+			}
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": errors,
+		})
+		return
+	}
+
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	org, ok := findAuthorizedOrganisation(c, ic.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	plainToken, err := utils.GenerateToken(models.InvitationTokenPrefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	invitation := models.Invitation{
+		OrganisationID: org.ID,
+		Email:          params.Email,
+		TokenHash:      utils.HashToken(plainToken),
+		InvitedByID:    principal.User.ID,
+		ExpiresAt:      time.Now().Add(models.InvitationTTL),
+	}
+	err = ic.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&invitation).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, models.AuditEvent{
+			OrganisationID: org.ID,
+			Action:         models.AuditInvitationCreated,
+			TargetType:     models.AuditTargetInvitation,
+			TargetID:       fmt.Sprintf("%d", invitation.ID),
+			Metadata:       map[string]interface{}{"email": invitation.Email},
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	if invitation.Email != "" {
+		body := fmt.Sprintf("%s %s invited you to join %s.\n\nRegister with the invitation token below, or accept it from your account if you already have one, within 7 days:\n\n%s\n\nIf you were not expecting this invitation, you can ignore this email.",
+			principal.User.FirstName, principal.User.LastName, org.Name, plainToken)
+		if err := ic.Mailer.Send(invitation.Email, "You are invited to join "+org.Name, body); err != nil {
+			log.Println("error sending invitation:", err)
+		}
+	}
+
+	data := models.InvitationResponse(invitation)
+	data["token"] = plainToken
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"message": "Invitation created successfully",
+		"data":    data,
+	})
+}
+
+func (ic *InvitationController) Delete(c *gin.Context) {
+	org, ok := findAuthorizedOrganisation(c, ic.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	invitationId, _ := strconv.Atoi(c.Param("invitationId"))
+
+	var result *gorm.DB
+	err := ic.DB.Transaction(func(tx *gorm.DB) error {
+		result = tx.Where("organisation_id = ?", org.ID).Delete(&models.Invitation{}, invitationId)
+		if result.Error != nil || result.RowsAffected < 1 {
+			return result.Error
+		}
+
+		return recordAudit(tx, c, models.AuditEvent{
+			OrganisationID: org.ID,
+			Action:         models.AuditInvitationRevoked,
+			TargetType:     models.AuditTargetInvitation,
+			TargetID:       fmt.Sprintf("%d", invitationId),
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+	if invitationId < 1 || result.RowsAffected < 1 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":     http.StatusText(http.StatusNotFound),
+			"message":    "invitation not found",
+			"statusCode": http.StatusNotFound,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Invitation revoked successfully",
+	})
+}
+
+// Accept adds the signed in user to the organisation of an invitation.
+func (ic *InvitationController) Accept(c *gin.Context) {
+	var params models.InvitationAcceptParams
+	validate := validator.New(validator.WithRequiredStructEnabled())
+
+	if err := c.ShouldBind(&params); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	if err := validate.Struct(params); err != nil {
+		ve := err.(validator.ValidationErrors)
+		errors := make([]models.InputError, len(ve))
+		for i, fe := range ve {
+			errors[i] = models.InputError{
+				Field:   utils.GetJSONTagValue(params, fe.Field()),
+				Message: utils.GetValidationMessage(fe),
+			}
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": errors,
+		})
+		return
+	}
+
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	var org models.Organisation
+	err := ic.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		org, err = acceptInvitation(tx, params.Token, *principal.User)
+		if err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, models.AuditEvent{
+			OrganisationID: org.ID,
+			Action:         models.AuditInvitationAccepted,
+			TargetType:     models.AuditTargetUser,
+			TargetID:       fmt.Sprintf("%d", principal.User.ID),
+		})
+	})
+	if errors.Is(err, errInvalidInvitation) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":     http.StatusText(http.StatusNotFound),
+			"message":    err.Error(),
+			"statusCode": http.StatusNotFound,
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    "error adding user to organisation",
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Joined organisation successfully",
+		"data":    models.OrganisationResponse(org),
+	})
+}
@@ -0,0 +1,265 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/codelikesuraj/hng11-task-two/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var notificationSorts = map[string]string{
+	"createdAt": "notifications.created_at",
+}
+
+// NotificationController serves users their own notifications and
+// notification preferences.
+type NotificationController struct {
+	DB *gorm.DB
+}
+
+func NewNotificationController(db *gorm.DB) *NotificationController {
+	return &NotificationController{DB: db}
+}
+
+// GetAll lists the notifications of the user a page at a time, newest first
+// by default, only the unread ones with unread=true, with how many are
+// unread overall.
+func (nc *NotificationController) GetAll(c *gin.Context) {
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	page, errors := utils.ParsePagination(c, "notifications", notificationSorts, "-createdAt")
+	if errors != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": errors,
+		})
+		return
+	}
+
+	query := nc.DB.Model(&models.Notification{}).Where("notifications.user_id = ?", principal.User.ID)
+	if c.Query("unread") == "true" {
+		query = query.Where("notifications.read_at IS NULL")
+	}
+
+	notifications, pageInfo, err := utils.Paginate[models.Notification](query, page)
+	if err == utils.ErrStaleCursor {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":     http.StatusText(http.StatusBadRequest),
+			"message":    err.Error(),
+			"statusCode": http.StatusBadRequest,
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	var unread int64
+	if err := nc.DB.Model(&models.Notification{}).Where("user_id = ? AND read_at IS NULL", principal.User.ID).Count(&unread).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": fmt.Sprintf("found %d notification(s)", len(notifications)),
+		"data": gin.H{
+			"notifications": models.NotificationsResponse(notifications),
+			"unreadCount":   unread,
+			"pagination":    pageInfo,
+		},
+	})
+}
+
+func (nc *NotificationController) MarkRead(c *gin.Context) {
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	var notification models.Notification
+	notificationId, _ := strconv.Atoi(c.Param("notificationId"))
+	result := nc.DB.Where("user_id = ?", principal.User.ID).Limit(1).Find(&notification, notificationId)
+	if notificationId < 1 || result.RowsAffected < 1 || result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":     http.StatusText(http.StatusNotFound),
+			"message":    "notification not found",
+			"statusCode": http.StatusNotFound,
+		})
+		return
+	}
+
+	if notification.ReadAt == nil {
+		now := time.Now()
+		notification.ReadAt = &now
+		if err := nc.DB.Model(&notification).Update("read_at", now).Error; err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status":     http.StatusText(http.StatusInternalServerError),
+				"message":    http.StatusText(http.StatusInternalServerError),
+				"statusCode": http.StatusInternalServerError,
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Notification marked as read",
+		"data":    models.NotificationResponse(notification),
+	})
+}
+
+func (nc *NotificationController) MarkAllRead(c *gin.Context) {
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	result := nc.DB.Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", principal.User.ID).
+		Update("read_at", time.Now())
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": fmt.Sprintf("marked %d notification(s) as read", result.RowsAffected),
+	})
+}
+
+// GetPreferences lists the preferences of the user for every event, the
+// defaults for those they did not change.
+func (nc *NotificationController) GetPreferences(c *gin.Context) {
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	preferences, err := nc.preferences(principal.User.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": fmt.Sprintf("found %d preference(s)", len(preferences)),
+		"data": gin.H{
+			"preferences": models.NotificationPreferencesResponse(preferences),
+		},
+	})
+}
+
+// UpdatePreference changes the channels the user is notified of the :event
+// on.
+func (nc *NotificationController) UpdatePreference(c *gin.Context) {
+	var params models.NotificationPreferenceParams
+
+	if err := c.ShouldBind(&params); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	event := c.Param("event")
+	if !models.IsNotificationEvent(event) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":     http.StatusText(http.StatusNotFound),
+			"message":    "event not found",
+			"statusCode": http.StatusNotFound,
+		})
+		return
+	}
+
+	preference := models.DefaultNotificationPreference(principal.User.ID, event)
+	err := nc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND event = ?", principal.User.ID, event).Limit(1).Find(&preference).Error; err != nil {
+			return err
+		}
+
+		if params.InApp != nil {
+			preference.InApp = *params.InApp
+		}
+		if params.Email != nil {
+			preference.Email = *params.Email
+		}
+
+		if preference.ID != 0 {
+			return tx.Save(&preference).Error
+		}
+		// a concurrent request may have saved one since
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "event"}},
+			DoUpdates: clause.AssignmentColumns([]string{"in_app", "email", "updated_at"}),
+		}).Create(&preference).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Preference updated successfully",
+		"data":    models.NotificationPreferenceResponse(preference),
+	})
+}
+
+func (nc *NotificationController) preferences(userId uint) ([]models.NotificationPreference, error) {
+	var saved []models.NotificationPreference
+	if err := nc.DB.Where("user_id = ?", userId).Find(&saved).Error; err != nil {
+		return nil, err
+	}
+
+	preferences := []models.NotificationPreference{}
+	for _, event := range models.NotificationEvents {
+		preference := models.DefaultNotificationPreference(userId, event)
+		for _, p := range saved {
+			if p.Event == event {
+				preference = p
+			}
+		}
+		preferences = append(preferences, preference)
+	}
+
+	return preferences, nil
+}
@@ -0,0 +1,822 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/codelikesuraj/hng11-task-two/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/golang-jwt/jwt/v4"
+	"gorm.io/gorm"
+)
+
+// OAuthController lets this service act as an OAuth2 authorization server
+// and OpenID provider for client apps registered by organisations.
+type OAuthController struct {
+	DB *gorm.DB
+}
+
+func NewOAuthController(db *gorm.DB) *OAuthController {
+	return &OAuthController{DB: db}
+}
+
+// authorizationRequest is a validated request to the authorization endpoint.
+type authorizationRequest struct {
+	Client              models.OAuthClient
+	RedirectURI         string
+	Scopes              []string
+	State               string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+func (oc *OAuthController) Discovery(c *gin.Context) {
+	issuer := utils.GetIssuer()
+
+	c.JSON(http.StatusOK, gin.H{
+		"issuer":                                issuer,
+		"authorization_endpoint":                issuer + "/oauth/authorize",
+		"token_endpoint":                        issuer + "/oauth/token",
+		"userinfo_endpoint":                     issuer + "/oauth/userinfo",
+		"jwks_uri":                              issuer + "/oauth/jwks",
+		"introspection_endpoint":                issuer + "/oauth/introspect",
+		"revocation_endpoint":                   issuer + "/oauth/revoke",
+		"response_types_supported":              []string{"code"},
+		"grant_types_supported":                 []string{"authorization_code", "refresh_token", "client_credentials"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"scopes_supported":                      models.OAuthScopes,
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post", "none"},
+		"code_challenge_methods_supported":      []string{"S256"},
+		"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "nonce", "email", "email_verified", "name", "given_name", "family_name"},
+	})
+}
+
+func (oc *OAuthController) JWKS(c *gin.Context) {
+	jwks, err := utils.GetOAuthJWKS()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, jwks)
+}
+
+// Authorize validates an authorization request for the logged in user. If the
+// user already consented to the requested scopes, it returns the redirect
+// carrying the authorization code, otherwise what the consent screen needs.
+func (oc *OAuthController) Authorize(c *gin.Context) {
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	req, ok := oc.parseAuthorizationRequest(c)
+	if !ok {
+		return
+	}
+
+	var consent models.OAuthConsent
+	result := oc.DB.Where("user_id = ? AND client_id = ?", principal.User.ID, req.Client.ID).Limit(1).Find(&consent)
+	if result.Error != nil || result.RowsAffected < 1 || !consent.Covers(req.Scopes) {
+		c.JSON(http.StatusOK, gin.H{
+			"status":  "success",
+			"message": "consent required",
+			"data": gin.H{
+				"consentRequired": true,
+				"client":          gin.H{"clientId": req.Client.ClientID, "name": req.Client.Name},
+				"scopes":          req.Scopes,
+			},
+		})
+		return
+	}
+
+	oc.redirectWithCode(c, req, principal.User.ID)
+}
+
+// Consent records the user's answer to the consent screen, then redirects
+// back to the client with an authorization code or an access_denied error.
+func (oc *OAuthController) Consent(c *gin.Context) {
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	req, ok := oc.parseAuthorizationRequest(c)
+	if !ok {
+		return
+	}
+
+	if approve, _ := strconv.ParseBool(c.Request.FormValue("approve")); !approve {
+		oc.redirectWithError(c, req, "access_denied", "the user denied the request")
+		return
+	}
+
+	var consent models.OAuthConsent
+	err := oc.DB.Where(models.OAuthConsent{UserID: principal.User.ID, ClientID: req.Client.ID}).FirstOrInit(&consent).Error
+	if err == nil {
+		scopes := strings.Fields(consent.Scopes)
+		for _, scope := range req.Scopes {
+			if !slices.Contains(scopes, scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+		consent.Scopes = strings.Join(scopes, " ")
+		err = oc.DB.Save(&consent).Error
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	oc.redirectWithCode(c, req, principal.User.ID)
+}
+
+func (oc *OAuthController) Token(c *gin.Context) {
+	c.Header("Cache-Control", "no-store")
+
+	client, ok := oc.authenticateClient(c)
+	if !ok {
+		return
+	}
+
+	switch c.PostForm("grant_type") {
+	case "authorization_code":
+		oc.exchangeAuthorizationCode(c, client)
+	case "refresh_token":
+		oc.exchangeRefreshToken(c, client)
+	case "client_credentials":
+		oc.exchangeClientCredentials(c, client)
+	default:
+		oauthError(c, http.StatusBadRequest, "unsupported_grant_type", "")
+	}
+}
+
+func (oc *OAuthController) exchangeAuthorizationCode(c *gin.Context, client models.OAuthClient) {
+	var code models.OAuthAuthorizationCode
+	result := oc.DB.Where("code_hash = ?", utils.HashToken(c.PostForm("code"))).Limit(1).Find(&code)
+	if result.Error != nil || result.RowsAffected < 1 || code.ClientID != client.ID || code.UsedAt != nil || time.Now().After(code.ExpiresAt) {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "invalid or expired authorization code")
+		return
+	}
+
+	if code.RedirectURI != c.PostForm("redirect_uri") {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "redirect_uri does not match the authorization request")
+		return
+	}
+
+	if code.CodeChallenge != "" && !utils.VerifyCodeChallenge(code.CodeChallenge, code.CodeChallengeMethod, c.PostForm("code_verifier")) {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "invalid code_verifier")
+		return
+	}
+
+	// codes are single use, claiming it fails if a concurrent request did first
+	result = oc.DB.Model(&code).Where("used_at IS NULL").Update("used_at", time.Now())
+	if result.Error != nil || result.RowsAffected < 1 {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "invalid or expired authorization code")
+		return
+	}
+
+	var user models.User
+	result = oc.DB.Limit(1).Find(&user, code.UserID)
+	if result.Error != nil || result.RowsAffected < 1 || user.IsDisabled() {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "user not found")
+		return
+	}
+
+	oc.issueTokens(c, client, &user, strings.Fields(code.Scopes), code.Nonce)
+}
+
+func (oc *OAuthController) exchangeRefreshToken(c *gin.Context, client models.OAuthClient) {
+	var token models.OAuthToken
+	result := oc.DB.Where("token_hash = ? AND type = ?", utils.HashToken(c.PostForm("refresh_token")), models.OAuthTokenTypeRefresh).Limit(1).Find(&token)
+	if result.Error != nil || result.RowsAffected < 1 || token.ClientID != client.ID || !token.IsActive() || token.UserID == nil {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "invalid or expired refresh token")
+		return
+	}
+
+	var user models.User
+	result = oc.DB.Limit(1).Find(&user, *token.UserID)
+	if result.Error != nil || result.RowsAffected < 1 || user.IsDisabled() {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "user not found")
+		return
+	}
+
+	// refresh tokens are rotated on every use
+	result = oc.DB.Model(&token).Where("revoked_at IS NULL").Update("revoked_at", time.Now())
+	if result.Error != nil || result.RowsAffected < 1 {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "invalid or expired refresh token")
+		return
+	}
+
+	oc.issueTokens(c, client, &user, token.ScopeList(), "")
+}
+
+func (oc *OAuthController) exchangeClientCredentials(c *gin.Context, client models.OAuthClient) {
+	if client.IsPublic() {
+		oauthError(c, http.StatusUnauthorized, "unauthorized_client", "public clients cannot use the client_credentials grant")
+		return
+	}
+
+	scopes := strings.Fields(c.PostForm("scope"))
+	if len(scopes) == 0 {
+		for _, scope := range client.ScopeList() {
+			if isAPIScope(scope) {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	for _, scope := range scopes {
+		if !isAPIScope(scope) || !slices.Contains(client.ScopeList(), scope) {
+			oauthError(c, http.StatusBadRequest, "invalid_scope", scope+" cannot be requested by this client")
+			return
+		}
+	}
+
+	oc.issueTokens(c, client, nil, scopes, "")
+}
+
+// issueTokens responds with an access token, plus a refresh token when the
+// offline_access scope was granted and an ID token for the openid scope.
+func (oc *OAuthController) issueTokens(c *gin.Context, client models.OAuthClient, user *models.User, scopes []string, nonce string) {
+	var userId *uint
+	if user != nil {
+		userId = &user.ID
+	}
+
+	response := gin.H{
+		"token_type": "Bearer",
+		"expires_in": int(models.OAuthAccessTokenTTL.Seconds()),
+		"scope":      strings.Join(scopes, " "),
+	}
+
+	err := oc.DB.Transaction(func(tx *gorm.DB) error {
+		accessToken, err := createOAuthToken(tx, client, userId, models.OAuthTokenTypeAccess, scopes)
+		if err != nil {
+			return err
+		}
+		response["access_token"] = accessToken
+
+		if user != nil && slices.Contains(scopes, "offline_access") {
+			refreshToken, err := createOAuthToken(tx, client, userId, models.OAuthTokenTypeRefresh, scopes)
+			if err != nil {
+				return err
+			}
+			response["refresh_token"] = refreshToken
+		}
+
+		if user != nil && slices.Contains(scopes, "openid") {
+			claims := oidcUserClaims(*user, scopes)
+			claims["iss"] = utils.GetIssuer()
+			claims["aud"] = client.ClientID
+			claims["iat"] = time.Now().Unix()
+			claims["exp"] = time.Now().Add(models.OAuthAccessTokenTTL).Unix()
+			if nonce != "" {
+				claims["nonce"] = nonce
+			}
+
+			idToken, err := utils.SignIDToken(claims)
+			if err != nil {
+				return err
+			}
+			response["id_token"] = idToken
+		}
+
+		return nil
+	})
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", "")
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Introspect implements RFC 7662. Clients can only introspect tokens issued
+// to clients of their own organisation.
+func (oc *OAuthController) Introspect(c *gin.Context) {
+	client, ok := oc.authenticateClient(c)
+	if !ok {
+		return
+	}
+
+	var token models.OAuthToken
+	result := oc.DB.Where("token_hash = ?", utils.HashToken(c.PostForm("token"))).Preload("Client").Limit(1).Find(&token)
+	if result.Error != nil || result.RowsAffected < 1 || !token.IsActive() || token.Client.OrganisationID != client.OrganisationID {
+		c.JSON(http.StatusOK, gin.H{"active": false})
+		return
+	}
+
+	response := gin.H{
+		"active":     true,
+		"scope":      token.Scopes,
+		"client_id":  token.Client.ClientID,
+		"exp":        token.ExpiresAt.Unix(),
+		"iat":        token.CreatedAt.Unix(),
+		"iss":        utils.GetIssuer(),
+		"token_type": "Bearer",
+		"sub":        token.Client.ClientID,
+	}
+	if token.Type == models.OAuthTokenTypeRefresh {
+		response["token_type"] = models.OAuthTokenTypeRefresh
+	}
+	if token.UserID != nil {
+		var user models.User
+		if oc.DB.Limit(1).Find(&user, *token.UserID).RowsAffected < 1 || user.IsDisabled() {
+			c.JSON(http.StatusOK, gin.H{"active": false})
+			return
+		}
+		response["sub"] = fmt.Sprintf("%d", user.ID)
+		response["username"] = user.Email
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// Revoke implements RFC 7009. Unknown tokens and tokens of other clients are
+// ignored, as the RFC requires.
+func (oc *OAuthController) Revoke(c *gin.Context) {
+	client, ok := oc.authenticateClient(c)
+	if !ok {
+		return
+	}
+
+	oc.DB.Model(&models.OAuthToken{}).
+		Where("token_hash = ? AND client_id = ? AND revoked_at IS NULL", utils.HashToken(c.PostForm("token")), client.ID).
+		Update("revoked_at", time.Now())
+
+	c.Status(http.StatusOK)
+}
+
+func (oc *OAuthController) UserInfo(c *gin.Context) {
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	if principal.User == nil || !slices.Contains(principal.Scopes, "openid") {
+		c.Header("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+		oauthError(c, http.StatusForbidden, "insufficient_scope", "the openid scope is required")
+		return
+	}
+
+	c.JSON(http.StatusOK, oidcUserClaims(*principal.User, principal.Scopes))
+}
+
+func (oc *OAuthController) GetClients(c *gin.Context) {
+	org, ok := findAuthorizedOrganisation(c, oc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	var clients []models.OAuthClient
+	if err := oc.DB.Where("organisation_id = ?", org.ID).Order("id").Find(&clients).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": fmt.Sprintf("found %d client(s)", len(clients)),
+		"data": gin.H{
+			"clients": models.OAuthClientsResponse(clients),
+		},
+	})
+}
+
+func (oc *OAuthController) CreateClient(c *gin.Context) {
+	var params models.OAuthClientCreateParams
+	validate := validator.New(validator.WithRequiredStructEnabled())
+
+	if err := c.ShouldBind(&params); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	if err := validate.Struct(params); err != nil {
+		ve := err.(validator.ValidationErrors)
+		errors := make([]models.InputError, len(ve))
+		for i, fe := range ve {
+			errors[i] = models.InputError{
+				Field:   utils.GetJSONTagValue(params, fe.Field()),
+				Message: utils.GetValidationMessage(fe),
+			}
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": errors,
+		})
+		return
+	}
+
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	org, ok := findAuthorizedOrganisation(c, oc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	clientId, err := utils.GenerateToken(models.OAuthClientIDPrefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	client := models.OAuthClient{
+		OrganisationID: org.ID,
+		Name:           params.Name,
+		ClientID:       clientId,
+		RedirectURIs:   strings.Join(params.RedirectURIs, " "),
+		Scopes:         strings.Join(params.Scopes, " "),
+		CreatedByID:    principal.User.ID,
+	}
+
+	var secret string
+	if !params.Public {
+		secret, err = utils.GenerateToken(models.OAuthClientSecretPrefix)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status":     http.StatusText(http.StatusInternalServerError),
+				"message":    err.Error(),
+				"statusCode": http.StatusInternalServerError,
+			})
+			return
+		}
+		client.SecretHash = utils.HashToken(secret)
+	}
+
+	err = oc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&client).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, models.AuditEvent{
+			OrganisationID: org.ID,
+			Action:         models.AuditOAuthClientCreated,
+			TargetType:     models.AuditTargetOAuthClient,
+			TargetID:       fmt.Sprintf("%d", client.ID),
+			Metadata: map[string]interface{}{
+				"name":     client.Name,
+				"clientId": client.ClientID,
+				"scopes":   client.Scopes,
+			},
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	data := models.OAuthClientResponse(client)
+	if secret != "" {
+		data["clientSecret"] = secret
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"message": "Client created successfully",
+		"data":    data,
+	})
+}
+
+func (oc *OAuthController) DeleteClient(c *gin.Context) {
+	org, ok := findAuthorizedOrganisation(c, oc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	var client models.OAuthClient
+	clientId, _ := strconv.Atoi(c.Param("clientId"))
+	result := oc.DB.Where("organisation_id = ?", org.ID).Limit(1).Find(&client, clientId)
+	if clientId < 1 || result.RowsAffected < 1 || result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":     http.StatusText(http.StatusNotFound),
+			"message":    "client not found",
+			"statusCode": http.StatusNotFound,
+		})
+		return
+	}
+
+	err := oc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.OAuthToken{}).Where("client_id = ? AND revoked_at IS NULL", client.ID).Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(&client).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, models.AuditEvent{
+			OrganisationID: org.ID,
+			Action:         models.AuditOAuthClientDeleted,
+			TargetType:     models.AuditTargetOAuthClient,
+			TargetID:       fmt.Sprintf("%d", client.ID),
+			Metadata: map[string]interface{}{
+				"name":     client.Name,
+				"clientId": client.ClientID,
+			},
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Client deleted successfully",
+	})
+}
+
+func (oc *OAuthController) GetConsents(c *gin.Context) {
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	var consents []models.OAuthConsent
+	if err := oc.DB.Where("user_id = ?", principal.User.ID).Preload("Client").Order("id").Find(&consents).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": fmt.Sprintf("found %d consent(s)", len(consents)),
+		"data": gin.H{
+			"consents": models.OAuthConsentsResponse(consents),
+		},
+	})
+}
+
+// RevokeConsent withdraws a client's access, revoking the tokens it holds for
+// the user.
+func (oc *OAuthController) RevokeConsent(c *gin.Context) {
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	var consent models.OAuthConsent
+	consentId, _ := strconv.Atoi(c.Param("consentId"))
+	result := oc.DB.Where("user_id = ?", principal.User.ID).Limit(1).Find(&consent, consentId)
+	if consentId < 1 || result.RowsAffected < 1 || result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":     http.StatusText(http.StatusNotFound),
+			"message":    "consent not found",
+			"statusCode": http.StatusNotFound,
+		})
+		return
+	}
+
+	err := oc.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.OAuthToken{}).
+			Where("client_id = ? AND user_id = ? AND revoked_at IS NULL", consent.ClientID, principal.User.ID).
+			Update("revoked_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+		return tx.Unscoped().Delete(&consent).Error
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Consent revoked successfully",
+	})
+}
+
+// parseAuthorizationRequest validates the authorization request parameters.
+// Until the client and redirect URI are known to be valid, errors are
+// returned to the caller rather than the redirect URI (RFC 6749 4.1.2.1).
+func (oc *OAuthController) parseAuthorizationRequest(c *gin.Context) (authorizationRequest, bool) {
+	var req authorizationRequest
+
+	result := oc.DB.Where("client_id = ?", c.Request.FormValue("client_id")).Limit(1).Find(&req.Client)
+	if result.Error != nil || result.RowsAffected < 1 {
+		oauthError(c, http.StatusBadRequest, "invalid_client", "unknown client_id")
+		return req, false
+	}
+
+	req.RedirectURI = c.Request.FormValue("redirect_uri")
+	if !slices.Contains(req.Client.RedirectURIList(), req.RedirectURI) {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "redirect_uri is not registered for this client")
+		return req, false
+	}
+
+	req.State = c.Request.FormValue("state")
+	req.Nonce = c.Request.FormValue("nonce")
+	req.CodeChallenge = c.Request.FormValue("code_challenge")
+	req.CodeChallengeMethod = c.Request.FormValue("code_challenge_method")
+	req.Scopes = strings.Fields(c.Request.FormValue("scope"))
+
+	if c.Request.FormValue("response_type") != "code" {
+		oc.redirectWithError(c, req, "unsupported_response_type", "only the code response type is supported")
+		return req, false
+	}
+
+	for _, scope := range req.Scopes {
+		if !slices.Contains(req.Client.ScopeList(), scope) {
+			oc.redirectWithError(c, req, "invalid_scope", scope+" cannot be requested by this client")
+			return req, false
+		}
+	}
+
+	// the plain method, the default, does not protect a code intercepted
+	// along with its challenge
+	if req.CodeChallenge != "" && req.CodeChallengeMethod != "S256" {
+		oc.redirectWithError(c, req, "invalid_request", "unsupported code_challenge_method, use S256")
+		return req, false
+	}
+	if req.Client.IsPublic() && req.CodeChallenge == "" {
+		oc.redirectWithError(c, req, "invalid_request", "public clients must use PKCE")
+		return req, false
+	}
+
+	return req, true
+}
+
+func (oc *OAuthController) redirectWithCode(c *gin.Context, req authorizationRequest, userId uint) {
+	code, err := utils.GenerateToken("")
+	if err == nil {
+		err = oc.DB.Create(&models.OAuthAuthorizationCode{
+			CodeHash:            utils.HashToken(code),
+			ClientID:            req.Client.ID,
+			UserID:              userId,
+			RedirectURI:         req.RedirectURI,
+			Scopes:              strings.Join(req.Scopes, " "),
+			Nonce:               req.Nonce,
+			CodeChallenge:       req.CodeChallenge,
+			CodeChallengeMethod: req.CodeChallengeMethod,
+			ExpiresAt:           time.Now().Add(models.OAuthAuthorizationCodeTTL),
+		}).Error
+	}
+	if err != nil {
+		oc.redirectWithError(c, req, "server_error", "")
+		return
+	}
+
+	oc.redirect(c, req, url.Values{"code": {code}})
+}
+
+func (oc *OAuthController) redirectWithError(c *gin.Context, req authorizationRequest, code, description string) {
+	params := url.Values{"error": {code}}
+	if description != "" {
+		params.Set("error_description", description)
+	}
+	oc.redirect(c, req, params)
+}
+
+// redirect returns where the user agent should be sent next. The frontend
+// follows it, as the authorization endpoint is called with a bearer token
+// rather than cookies.
+func (oc *OAuthController) redirect(c *gin.Context, req authorizationRequest, params url.Values) {
+	redirectURI, _ := url.Parse(req.RedirectURI)
+	query := redirectURI.Query()
+	for k, v := range params {
+		query[k] = v
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	redirectURI.RawQuery = query.Encode()
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "redirect to client",
+		"data": gin.H{
+			"redirectTo": redirectURI.String(),
+		},
+	})
+}
+
+// authenticateClient reads client credentials from HTTP basic auth or the
+// form body. Public clients only send their client_id.
+func (oc *OAuthController) authenticateClient(c *gin.Context) (models.OAuthClient, bool) {
+	var client models.OAuthClient
+
+	clientId, secret, ok := c.Request.BasicAuth()
+	if !ok {
+		clientId, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+
+	result := oc.DB.Where("client_id = ?", clientId).Limit(1).Find(&client)
+	if result.Error != nil || result.RowsAffected < 1 {
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "")
+		return client, false
+	}
+
+	if !client.IsPublic() && subtle.ConstantTimeCompare([]byte(utils.HashToken(secret)), []byte(client.SecretHash)) != 1 {
+		oauthError(c, http.StatusUnauthorized, "invalid_client", "")
+		return client, false
+	}
+
+	return client, true
+}
+
+func createOAuthToken(db *gorm.DB, client models.OAuthClient, userId *uint, tokenType string, scopes []string) (string, error) {
+	prefix, ttl := models.OAuthAccessTokenPrefix, models.OAuthAccessTokenTTL
+	if tokenType == models.OAuthTokenTypeRefresh {
+		prefix, ttl = models.OAuthRefreshTokenPrefix, models.OAuthRefreshTokenTTL
+	}
+
+	plainToken, err := utils.GenerateToken(prefix)
+	if err != nil {
+		return "", err
+	}
+
+	err = db.Create(&models.OAuthToken{
+		TokenHash: utils.HashToken(plainToken),
+		Type:      tokenType,
+		ClientID:  client.ID,
+		UserID:    userId,
+		Scopes:    strings.Join(scopes, " "),
+		ExpiresAt: time.Now().Add(ttl),
+	}).Error
+
+	return plainToken, err
+}
+
+// oidcUserClaims returns the standard claims about user released by scopes.
+func oidcUserClaims(user models.User, scopes []string) jwt.MapClaims {
+	claims := jwt.MapClaims{
+		"sub": fmt.Sprintf("%d", user.ID),
+	}
+
+	if slices.Contains(scopes, "email") {
+		claims["email"] = user.Email
+		// most emails are never verified, relying parties must not trust
+		// those as an identity
+		claims["email_verified"] = user.IsEmailVerified()
+	}
+	if slices.Contains(scopes, "profile") {
+		claims["name"] = strings.TrimSpace(user.FirstName + " " + user.LastName)
+		claims["given_name"] = user.FirstName
+		claims["family_name"] = user.LastName
+	}
+
+	return claims
+}
+
+// isAPIScope reports whether scope grants access to this API, rather than
+// to information about the user.
+func isAPIScope(scope string) bool {
+	return scope == models.ScopeOrgsRead || scope == models.ScopeOrgsWrite || scope == models.ScopeUsersRead
+}
+
+func oauthError(c *gin.Context, status int, code, description string) {
+	body := gin.H{"error": code}
+	if description != "" {
+		body["error_description"] = description
+	}
+	c.JSON(status, body)
+}
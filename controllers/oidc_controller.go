@@ -0,0 +1,265 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/codelikesuraj/hng11-task-two/utils"
+	"github.com/gin-gonic/gin"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+const oidcLoginStateTTL = 10 * time.Minute
+
+// OIDCController signs users in with a social login provider through the
+// authorization code + PKCE flow. Users signing in for the first time are
+// registered through Registration.
+type OIDCController struct {
+	DB           *gorm.DB
+	Providers    map[string]utils.SocialProvider
+	Registration *RegistrationPipeline
+}
+
+func NewOIDCController(db *gorm.DB, providers map[string]utils.SocialProvider, registration *RegistrationPipeline) *OIDCController {
+	return &OIDCController{DB: db, Providers: providers, Registration: registration}
+}
+
+// Login redirects the user to the provider's consent page. The
+// invitationToken query parameter is used if the user has to be registered.
+func (oc *OIDCController) Login(c *gin.Context) {
+	provider, ok := oc.Providers[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":     http.StatusText(http.StatusNotFound),
+			"message":    "unknown provider",
+			"statusCode": http.StatusNotFound,
+		})
+		return
+	}
+
+	state, err := utils.GenerateToken("")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+	nonce, err := utils.GenerateToken("")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	loginState := models.OIDCLoginState{
+		State:           state,
+		Provider:        c.Param("provider"),
+		Nonce:           nonce,
+		CodeVerifier:    oauth2.GenerateVerifier(),
+		InvitationToken: c.Query("invitationToken"),
+		ExpiresAt:       time.Now().Add(oidcLoginStateTTL),
+	}
+
+	authURL, err := provider.AuthCodeURL(c.Request.Context(), loginState.State, loginState.Nonce, loginState.CodeVerifier)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"status":     http.StatusText(http.StatusBadGateway),
+			"message":    "provider unavailable",
+			"statusCode": http.StatusBadGateway,
+		})
+		return
+	}
+
+	if err := oc.DB.Create(&loginState).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback completes the flow, creating or linking the user by their verified
+// email, and returns an access token like LoginUser, or where to log in
+// instead when an organisation of the user enforces SSO.
+func (oc *OIDCController) Callback(c *gin.Context) {
+	provider, ok := oc.Providers[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":     http.StatusText(http.StatusNotFound),
+			"message":    "unknown provider",
+			"statusCode": http.StatusNotFound,
+		})
+		return
+	}
+
+	// the state can only be used once
+	var loginState models.OIDCLoginState
+	result := oc.DB.Where("state = ? AND provider = ?", c.Query("state"), c.Param("provider")).Limit(1).Find(&loginState)
+	if result.Error != nil || result.RowsAffected < 1 || time.Now().After(loginState.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":     http.StatusText(http.StatusBadRequest),
+			"message":    "invalid or expired state",
+			"statusCode": http.StatusBadRequest,
+		})
+		return
+	}
+	oc.DB.Unscoped().Delete(&loginState)
+
+	if c.Query("error") != "" || c.Query("code") == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":     "Bad request",
+			"message":    "Authentication failed",
+			"statusCode": http.StatusUnauthorized,
+		})
+		return
+	}
+
+	identity, err := provider.Exchange(c.Request.Context(), c.Query("code"), loginState.Nonce, loginState.CodeVerifier)
+	if err != nil || identity.Subject == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":     "Bad request",
+			"message":    "Authentication failed",
+			"statusCode": http.StatusUnauthorized,
+		})
+		return
+	}
+
+	// only a verified email is trusted to link to an existing account
+	if !identity.EmailVerified || identity.Email == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":     "Bad request",
+			"message":    "Email is not verified",
+			"statusCode": http.StatusUnauthorized,
+		})
+		return
+	}
+
+	user, err := oc.findOrCreateUser(c, loginState.Provider, identity, loginState.InvitationToken)
+	var registrationErr *RegistrationError
+	if errors.As(err, &registrationErr) {
+		c.JSON(http.StatusForbidden, gin.H{
+			"status":     http.StatusText(http.StatusForbidden),
+			"message":    registrationErr.Message,
+			"statusCode": http.StatusForbidden,
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	if user.IsDisabled() {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":     "Bad request",
+			"message":    "Account is disabled",
+			"statusCode": http.StatusUnauthorized,
+		})
+		return
+	}
+
+	if loginURL, ok := ssoLoginURL(oc.DB, user); ok {
+		c.JSON(http.StatusForbidden, gin.H{
+			"status":     http.StatusText(http.StatusForbidden),
+			"message":    "Your organisation requires you to log in with SSO",
+			"statusCode": http.StatusForbidden,
+			"loginUrl":   loginURL,
+		})
+		return
+	}
+
+	token, err := utils.GenerateJWT(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Login successful",
+		"data": gin.H{
+			"accessToken": token,
+			"user":        models.UserResponse(user),
+		},
+	})
+}
+
+// findOrCreateUser returns the user linked to the identity, linking an
+// existing user with the same email or registering a new one on first login.
+func (oc *OIDCController) findOrCreateUser(c *gin.Context, provider string, identity utils.SocialIdentity, invitationToken string) (models.User, error) {
+	var user models.User
+
+	err := oc.DB.Transaction(func(tx *gorm.DB) error {
+		var link models.UserIdentity
+		result := tx.Where("provider = ? AND subject = ?", provider, identity.Subject).Preload("User").Limit(1).Find(&link)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			if link.User.ID == 0 {
+				return errors.New("linked user no longer exists")
+			}
+			user = link.User
+			return nil
+		}
+
+		result = tx.Where("LOWER(email) = ?", strings.ToLower(identity.Email)).Limit(1).Find(&user)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected < 1 {
+			firstName := identity.FirstName
+			if firstName == "" {
+				firstName, _, _ = strings.Cut(identity.Email, "@")
+			}
+
+			// the provider verified the email, see Callback
+			now := time.Now()
+			registration, err := oc.Registration.Register(tx, Registration{
+				User: models.User{
+					FirstName:       firstName,
+					LastName:        identity.LastName,
+					Email:           identity.Email,
+					EmailVerifiedAt: &now,
+				},
+				InvitationToken: invitationToken,
+				IP:              c.ClientIP(),
+				UserAgent:       c.Request.UserAgent(),
+			})
+			if err != nil {
+				return err
+			}
+			user = registration.User
+		}
+
+		return tx.Create(&models.UserIdentity{
+			UserID:   user.ID,
+			Provider: provider,
+			Subject:  identity.Subject,
+			Email:    identity.Email,
+		}).Error
+	})
+
+	return user, err
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"github.com/codelikesuraj/hng11-task-two/middlewares"
 	"github.com/codelikesuraj/hng11-task-two/models"
 	"github.com/codelikesuraj/hng11-task-two/utils"
 	"github.com/gin-gonic/gin"
@@ -49,107 +51,162 @@ func (oc *OrganisationController) Create(c *gin.Context) {
 		return
 	}
 
-	tokenString, _ := utils.GetJWTFromRequest(c)
-	userFromJWT, err := utils.GetUserFromJWT(tokenString)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"status":     http.StatusText(http.StatusUnauthorized),
-			"message":    http.StatusText(http.StatusUnauthorized),
-			"statusCode": http.StatusUnauthorized,
-		})
+	principal, ok := currentPrincipal(c)
+	if !ok {
 		return
 	}
 
-	userJWTId, _ := strconv.ParseUint(userFromJWT["userId"].(string), 10, 64)
-	var user models.User
+	if principal.IsServiceAccount() {
+		c.JSON(http.StatusForbidden, gin.H{
+			"status":     http.StatusText(http.StatusForbidden),
+			"message":    "service accounts cannot create organisations",
+			"statusCode": http.StatusForbidden,
+		})
+		return
+	}
 
-	if result := oc.DB.Limit(1).First(&user, userJWTId); result.Error != nil || result.RowsAffected < 1 {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"status":     http.StatusText(http.StatusBadRequest),
-			"message":    "invalid user",
-			"statusCode": http.StatusBadRequest,
+	newOrg, err := createOrganisation(oc.DB, c, *principal.User, org)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
 		})
 		return
 	}
 
-	newOrg := models.Organisation{
-		Name:        org.Name,
-		Description: org.Description,
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  http.StatusText(http.StatusCreated),
+		"message": "Organisation created successfully",
+		"data":    models.OrganisationResponse(newOrg),
+	})
+}
+
+// createOrganisation creates an organisation with the user as its creator
+// and first member.
+func createOrganisation(db *gorm.DB, c *gin.Context, user models.User, params models.OrganisationCreateParams) (models.Organisation, error) {
+	org := models.Organisation{
+		Name:        params.Name,
+		Description: params.Description,
 		CreatedByID: user.ID,
 	}
 
-	err = oc.DB.Transaction(func(tx *gorm.DB) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
 		// create organisation
-		if err := tx.Create(&newOrg).Error; err != nil {
+		if err := tx.Create(&org).Error; err != nil {
 			return err
 		}
 
 		// add user to organisation
-		if err := tx.Model(&newOrg).Association("Users").Append(&user); err != nil {
+		if err := tx.Model(&org).Association("Users").Append(&user); err != nil {
 			return err
 		}
 
-		return nil
-	})
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status":     http.StatusText(http.StatusInternalServerError),
-			"message":    http.StatusText(http.StatusInternalServerError),
-			"statusCode": http.StatusInternalServerError,
+		return recordAudit(tx, c, models.AuditEvent{
+			OrganisationID: org.ID,
+			Action:         models.AuditOrganisationCreated,
+			TargetType:     models.AuditTargetOrganisation,
+			TargetID:       fmt.Sprintf("%d", org.ID),
+			Metadata:       map[string]interface{}{"name": org.Name},
 		})
-		return
-	}
+	})
 
-	c.JSON(http.StatusCreated, gin.H{
-		"status":  http.StatusText(http.StatusCreated),
-		"message": "Organisation created successfully",
-		"data":    models.OrganisationResponse(newOrg),
+	return org, err
+}
+
+// addOrganisationMember adds the user to the organisation, recording the
+// metadata with the audit event.
+func addOrganisationMember(db *gorm.DB, c *gin.Context, org models.Organisation, user models.User, metadata map[string]interface{}) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&org).Association("Users").Append(&user); err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, models.AuditEvent{
+			OrganisationID: org.ID,
+			Action:         models.AuditMemberAdded,
+			TargetType:     models.AuditTargetUser,
+			TargetID:       fmt.Sprintf("%d", user.ID),
+			Metadata:       metadata,
+		})
 	})
 }
 
+// organisationSorts are the sort keys of organisation listings.
+var organisationSorts = map[string]string{
+	"name":      "LOWER(organisations.name)",
+	"createdAt": "organisations.created_at",
+}
+
+// GetAll lists the caller's organisations a page at a time, optionally only
+// those whose name contains q or starts with prefix.
 func (oc *OrganisationController) GetAll(c *gin.Context) {
-	tokenString, _ := utils.GetJWTFromRequest(c)
-	userFromJWT, err := utils.GetUserFromJWT(tokenString)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"status":     http.StatusText(http.StatusUnauthorized),
-			"message":    http.StatusText(http.StatusUnauthorized),
-			"statusCode": http.StatusUnauthorized,
-		})
+	principal, ok := currentPrincipal(c)
+	if !ok {
 		return
 	}
 
-	// userJWTId, _ := strconv.ParseUint(userJWT["userId"].(string), 10, 64)
-	// if uint(userJWTId) != user.ID {
-	// 	c.JSON(http.StatusUnauthorized, gin.H{
-	// 		"status":     http.StatusText(http.StatusUnauthorized),
-	// 		"message":    http.StatusText(http.StatusUnauthorized),
-	// 		"statusCode": http.StatusUnauthorized,
-	// 	})
-	// 	return
-	// }
-
-	var user models.User
+	page, errors := utils.ParsePagination(c, "organisations", organisationSorts, "createdAt")
+	if errors != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": errors,
+		})
+		return
+	}
 
-	result := oc.DB.Where("id = ?", userFromJWT["userId"]).Preload("Organisations").Limit(1).First(&user)
-	if result.RowsAffected < 1 || result.Error != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"status":     http.StatusText(http.StatusNotFound),
-			"message":    "user not found",
-			"statusCode": http.StatusNotFound,
+	query := organisationsQuery(oc.DB, principal, c.Query("q"), c.Query("prefix"))
+	orgs, pageInfo, err := utils.Paginate[models.Organisation](query, page)
+	if err == utils.ErrStaleCursor {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":     http.StatusText(http.StatusBadRequest),
+			"message":    err.Error(),
+			"statusCode": http.StatusBadRequest,
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
 		})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "success",
-		"message": fmt.Sprintf("found %d organisation(s)", len(user.Organisations)),
+		"message": fmt.Sprintf("found %d organisation(s)", len(orgs)),
 		"data": gin.H{
-			"organisations": models.OrganisationsResponse(user.Organisations),
+			"organisations": models.OrganisationsResponse(orgs),
+			"pagination":    pageInfo,
 		},
 	})
 }
 
+// organisationsQuery selects the caller's organisations, optionally only
+// those whose name contains q or starts with prefix.
+func organisationsQuery(db *gorm.DB, principal middlewares.Principal, q, prefix string) *gorm.DB {
+	query := db.Model(&models.Organisation{})
+
+	// service accounts only see the organisation they belong to
+	if principal.IsServiceAccount() {
+		query = query.Where("organisations.id = ?", principal.ServiceAccount.OrganisationID)
+	} else {
+		query = query.
+			Joins("JOIN users_organisations ON users_organisations.organisation_id = organisations.id").
+			Where("users_organisations.user_id = ?", principal.User.ID)
+	}
+
+	if q := strings.TrimSpace(q); q != "" {
+		query = query.Where(`LOWER(organisations.name) LIKE ? ESCAPE '\'`, "%"+utils.EscapeLike(strings.ToLower(q))+"%")
+	}
+	if prefix != "" {
+		query = query.Where(`LOWER(organisations.name) LIKE ? ESCAPE '\'`, utils.EscapeLike(strings.ToLower(prefix))+"%")
+	}
+
+	return query
+}
+
 func (oc *OrganisationController) GetOrganisationById(c *gin.Context) {
 	orgId, _ := strconv.Atoi(c.Param("orgId"))
 	if orgId < 1 {
@@ -161,31 +218,19 @@ func (oc *OrganisationController) GetOrganisationById(c *gin.Context) {
 		return
 	}
 
-	tokenString, _ := utils.GetJWTFromRequest(c)
-	userFromJWT, err := utils.GetUserFromJWT(tokenString)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"status":     http.StatusText(http.StatusUnauthorized),
-			"message":    http.StatusText(http.StatusUnauthorized),
-			"statusCode": http.StatusUnauthorized,
-		})
+	principal, ok := currentPrincipal(c)
+	if !ok {
 		return
 	}
 
-	var user models.User
 	var orgs []models.Organisation
+	var err error
 
-	result := oc.DB.Where("id = ?", userFromJWT["userId"]).Preload("Organisations").Limit(1).First(&user)
-	if result.RowsAffected < 1 || result.Error != nil {
-		c.JSON(http.StatusNotFound, gin.H{
-			"status":     http.StatusText(http.StatusNotFound),
-			"message":    "user not found",
-			"statusCode": http.StatusNotFound,
-		})
-		return
+	if principal.IsServiceAccount() {
+		err = oc.DB.Where("id = ?", principal.ServiceAccount.OrganisationID).Where("id = ?", orgId).Find(&orgs).Error
+	} else {
+		err = oc.DB.Model(principal.User).Where("id = ?", orgId).Association("Organisations").Find(&orgs)
 	}
-
-	err = oc.DB.Model(&user).Where("id = ?", orgId).Association("Organisations").Find(&orgs)
 	switch {
 	case errors.Is(err, gorm.ErrRecordNotFound) || len(orgs) < 1:
 		c.JSON(http.StatusNotFound, gin.H{
@@ -273,42 +318,112 @@ func (oc *OrganisationController) AddUser(c *gin.Context) {
 		return
 	}
 
-	// get authUser
-	tokenString, _ := utils.GetJWTFromRequest(c)
-	userFromJWT, err := utils.GetUserFromJWT(tokenString)
-	if err != nil {
+	// check if auth user or service account has access to organisation
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	// any member can add users, whatever their role
+	if _, ok := organisationRole(oc.DB, principal, org); !ok {
 		c.JSON(http.StatusUnauthorized, gin.H{
 			"status":     http.StatusText(http.StatusUnauthorized),
-			"message":    http.StatusText(http.StatusUnauthorized),
+			"message":    "user cannot access organisation",
 			"statusCode": http.StatusUnauthorized,
 		})
 		return
 	}
 
-	var authUser models.User
+	// add user to org
+	if err := addOrganisationMember(oc.DB, c, org, newUser, nil); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    "error adding user to organisation",
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
 
-	result = oc.DB.Limit(1).Find(&authUser, userFromJWT["userId"])
-	if result.RowsAffected < 1 || result.Error != nil {
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "User added to organisation successfully",
+	})
+}
+
+// GetJoinable lists the organisations that verified the domain of the user's
+// email and that the user can join.
+func (oc *OrganisationController) GetJoinable(c *gin.Context) {
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	orgs, err := domainOrganisations(oc.DB, *principal.User, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": fmt.Sprintf("found %d organisation(s)", len(orgs)),
+		"data": gin.H{
+			"organisations": models.OrganisationsResponse(orgs),
+			"emailVerified": principal.User.IsEmailVerified(),
+		},
+	})
+}
+
+// Join adds the user to an organisation that verified the domain of their
+// email, which they have to have verified as well.
+func (oc *OrganisationController) Join(c *gin.Context) {
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	orgId, _ := strconv.Atoi(c.Param("orgId"))
+
+	orgs, err := domainOrganisations(oc.DB, *principal.User, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	var org models.Organisation
+	for _, joinable := range orgs {
+		if joinable.ID == uint(orgId) {
+			org = joinable
+		}
+	}
+	if org.ID == 0 {
 		c.JSON(http.StatusNotFound, gin.H{
 			"status":     http.StatusText(http.StatusNotFound),
-			"message":    "user not found",
+			"message":    "organisation not found",
 			"statusCode": http.StatusNotFound,
 		})
 		return
 	}
 
-	// check if auth user has access to organisation
-	if oc.DB.Model(&authUser).Where("id = ?", org.ID).Association("Organisations").Count() < 1 {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"status":     http.StatusText(http.StatusUnauthorized),
-			"message":    "user cannot access organisation",
-			"statusCode": http.StatusUnauthorized,
+	if !principal.User.IsEmailVerified() {
+		c.JSON(http.StatusForbidden, gin.H{
+			"status":     http.StatusText(http.StatusForbidden),
+			"message":    "Verify your email to join this organisation",
+			"statusCode": http.StatusForbidden,
 		})
 		return
 	}
 
-	// add user to org
-	if err := oc.DB.Model(&org).Association("Users").Append(&newUser); err != nil {
+	err = addOrganisationMember(oc.DB, c, org, *principal.User, map[string]interface{}{"via": "domain"})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":     http.StatusText(http.StatusInternalServerError),
 			"message":    "error adding user to organisation",
@@ -319,6 +434,7 @@ func (oc *OrganisationController) AddUser(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":  "success",
-		"message": "User added to organisation successfully",
+		"message": "Joined organisation successfully",
+		"data":    models.OrganisationResponse(org),
 	})
 }
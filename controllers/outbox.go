@@ -0,0 +1,83 @@
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/codelikesuraj/hng11-task-two/utils"
+	"gorm.io/gorm"
+)
+
+// publishEvent writes the event to the outbox, from which the relay publishes
+// it to webhooks and the other sinks. Written in the transaction of the
+// change, events are only published for changes that happened.
+func publishEvent(tx *gorm.DB, orgId uint, event string, data map[string]interface{}) error {
+	eventId, err := utils.GenerateToken(models.OutboxEventPrefix)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	payload, err := json.Marshal(map[string]interface{}{
+		"id":        eventId,
+		"event":     event,
+		"orgId":     fmt.Sprintf("%d", orgId),
+		"createdAt": now.Format(time.RFC3339),
+		"data":      data,
+	})
+	if err != nil {
+		return err
+	}
+
+	return tx.Create(&models.OutboxEvent{
+		EventID:        eventId,
+		OrganisationID: orgId,
+		Event:          event,
+		Payload:        string(payload),
+		NextAttemptAt:  &now,
+	}).Error
+}
+
+// publishAuditEvent publishes the audit event if its action is one of
+// models.WebhookEvents, with the user it is about, if any.
+func publishAuditEvent(tx *gorm.DB, event models.AuditEvent) error {
+	if !slices.Contains(models.WebhookEvents, event.Action) {
+		return nil
+	}
+
+	data := map[string]interface{}{
+		"actor": map[string]interface{}{
+			"type": event.ActorType,
+			"id":   auditActorID(event),
+		},
+		"target": map[string]interface{}{
+			"type": event.TargetType,
+			"id":   event.TargetID,
+		},
+		"metadata": event.Metadata,
+	}
+
+	if event.TargetType == models.AuditTargetUser {
+		var user models.User
+		result := tx.Limit(1).Find(&user, event.TargetID)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			data["user"] = models.UserResponse(user)
+		}
+	}
+
+	return publishEvent(tx, event.OrganisationID, event.Action, data)
+}
+
+func auditActorID(event models.AuditEvent) *string {
+	if event.ActorID == nil {
+		return nil
+	}
+	id := fmt.Sprintf("%d", *event.ActorID)
+	return &id
+}
@@ -0,0 +1,237 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/codelikesuraj/hng11-task-two/utils"
+	"gorm.io/gorm"
+)
+
+// The organisation a new user gets when they were not invited to one.
+const (
+	// RegistrationOrganisationPersonal creates "<FirstName>'s Organisation".
+	RegistrationOrganisationPersonal = "personal"
+	// RegistrationOrganisationInvitation turns users away without an
+	// invitation.
+	RegistrationOrganisationInvitation = "invitation"
+	// RegistrationOrganisationNone leaves users without an organisation.
+	RegistrationOrganisationNone = "none"
+)
+
+var errInvalidInvitation = errors.New("invalid or expired invitation")
+
+// Registration is the user being registered, handed from hook to hook.
+type Registration struct {
+	User            models.User
+	InvitationToken string
+	// IP and UserAgent are those of the request registering the user, for
+	// the audit log.
+	IP        string
+	UserAgent string
+	// Organisations lists the organisations the user joined so far.
+	Organisations []models.Organisation
+}
+
+// RegistrationHook is a step of registering a user. Hooks run in order inside
+// the transaction that created the user, an error rolls the whole
+// registration back. A *RegistrationError is reported to the user as an
+// invalid field, any other error as a server error.
+type RegistrationHook func(tx *gorm.DB, registration *Registration) error
+
+type RegistrationError struct {
+	Field   string
+	Message string
+}
+
+func (e *RegistrationError) Error() string {
+	return e.Field + ": " + e.Message
+}
+
+// RegistrationPipeline registers users, whether they signed up with a
+// password or through a social login provider.
+type RegistrationPipeline struct {
+	Hooks []RegistrationHook
+}
+
+// NewRegistrationPipeline returns the pipeline giving new users the default
+// organisation of mode, one of the RegistrationOrganisation constants or
+// empty for RegistrationOrganisationPersonal, followed by hooks. Invited users
+// join the organisation that invited them whatever the mode, and users with a
+// verified email join the organisations of their domain that allow it.
+func NewRegistrationPipeline(mode string, hooks ...RegistrationHook) (*RegistrationPipeline, error) {
+	pipeline := &RegistrationPipeline{
+		Hooks: []RegistrationHook{JoinInvitationOrganisation},
+	}
+
+	switch mode {
+	case "", RegistrationOrganisationPersonal:
+		pipeline.Hooks = append(pipeline.Hooks, CreatePersonalOrganisation)
+	case RegistrationOrganisationInvitation:
+		pipeline.Hooks = append(pipeline.Hooks, RequireInvitation)
+	case RegistrationOrganisationNone:
+	default:
+		return nil, fmt.Errorf("unknown default organisation %q", mode)
+	}
+
+	pipeline.Hooks = append(pipeline.Hooks, JoinDomainOrganisations)
+	pipeline.Hooks = append(pipeline.Hooks, hooks...)
+
+	return pipeline, nil
+}
+
+// Register creates registration.User and runs the hooks, in one transaction,
+// then notifies the organisations the user joined.
+func (p *RegistrationPipeline) Register(db *gorm.DB, registration Registration) (Registration, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&registration.User).Error; err != nil {
+			return err
+		}
+
+		for _, hook := range p.Hooks {
+			if err := hook(tx, &registration); err != nil {
+				return err
+			}
+		}
+
+		for _, org := range registration.Organisations {
+			err := publishEvent(tx, org.ID, models.WebhookUserRegistered, map[string]interface{}{
+				"user": models.UserResponse(registration.User),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return registration, err
+}
+
+// JoinInvitationOrganisation adds the user to the organisation whose
+// invitation they registered with.
+func JoinInvitationOrganisation(tx *gorm.DB, registration *Registration) error {
+	if registration.InvitationToken == "" {
+		return nil
+	}
+
+	org, err := acceptInvitation(tx, registration.InvitationToken, registration.User)
+	if errors.Is(err, errInvalidInvitation) {
+		return &RegistrationError{Field: "invitationToken", Message: err.Error()}
+	}
+	if err != nil {
+		return err
+	}
+
+	registration.Organisations = append(registration.Organisations, org)
+	return registration.audit(tx, models.AuditEvent{
+		OrganisationID: org.ID,
+		Action:         models.AuditInvitationAccepted,
+		TargetType:     models.AuditTargetUser,
+		TargetID:       fmt.Sprintf("%d", registration.User.ID),
+	})
+}
+
+// CreatePersonalOrganisation creates an organisation for a user who did not
+// join one yet.
+func CreatePersonalOrganisation(tx *gorm.DB, registration *Registration) error {
+	if len(registration.Organisations) > 0 {
+		return nil
+	}
+
+	org := models.Organisation{
+		Name:        fmt.Sprintf("%s's Organisation", registration.User.FirstName),
+		CreatedByID: registration.User.ID,
+	}
+	if err := tx.Create(&org).Error; err != nil {
+		return err
+	}
+
+	if err := tx.Model(&org).Association("Users").Append(&registration.User); err != nil {
+		return err
+	}
+
+	registration.Organisations = append(registration.Organisations, org)
+	return registration.audit(tx, models.AuditEvent{
+		OrganisationID: org.ID,
+		Action:         models.AuditOrganisationCreated,
+		TargetType:     models.AuditTargetOrganisation,
+		TargetID:       fmt.Sprintf("%d", org.ID),
+		Metadata:       map[string]interface{}{"name": org.Name},
+	})
+}
+
+// RequireInvitation fails the registration of a user who did not join an
+// organisation yet.
+func RequireInvitation(tx *gorm.DB, registration *Registration) error {
+	if len(registration.Organisations) > 0 {
+		return nil
+	}
+
+	return &RegistrationError{Field: "invitationToken", Message: "an invitation is required to register"}
+}
+
+// JoinDomainOrganisations adds a user with a verified email to the
+// organisations of its domain that let users join automatically.
+func JoinDomainOrganisations(tx *gorm.DB, registration *Registration) error {
+	orgs, err := joinDomainOrganisations(tx, registration.User)
+	if err != nil {
+		return err
+	}
+
+	for _, org := range orgs {
+		err := registration.audit(tx, models.AuditEvent{
+			OrganisationID: org.ID,
+			Action:         models.AuditMemberAdded,
+			TargetType:     models.AuditTargetUser,
+			TargetID:       fmt.Sprintf("%d", registration.User.ID),
+			Metadata:       map[string]interface{}{"via": "domain"},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	registration.Organisations = append(registration.Organisations, orgs...)
+	return nil
+}
+
+// audit records an event of the user being registered.
+func (r *Registration) audit(tx *gorm.DB, event models.AuditEvent) error {
+	event.ActorType = models.AuditActorUser
+	event.ActorID = &r.User.ID
+	event.IP = r.IP
+	event.UserAgent = r.UserAgent
+	return recordAudit(tx, nil, event)
+}
+
+// acceptInvitation adds the user to the organisation of the invitation with
+// the token, which cannot be used again.
+func acceptInvitation(tx *gorm.DB, token string, user models.User) (models.Organisation, error) {
+	var invitation models.Invitation
+	result := tx.Where("token_hash = ?", utils.HashToken(token)).Preload("Organisation").Limit(1).Find(&invitation)
+	if result.Error != nil {
+		return invitation.Organisation, result.Error
+	}
+	if result.RowsAffected < 1 || invitation.IsAccepted() || invitation.HasExpired() || !invitation.IsFor(user.Email) || invitation.Organisation.ID == 0 {
+		return invitation.Organisation, errInvalidInvitation
+	}
+
+	// only one of concurrent requests gets to accept it
+	result = tx.Model(&invitation).Where("accepted_at IS NULL").Updates(map[string]interface{}{
+		"accepted_at":    time.Now(),
+		"accepted_by_id": user.ID,
+	})
+	if result.Error != nil {
+		return invitation.Organisation, result.Error
+	}
+	if result.RowsAffected < 1 {
+		return invitation.Organisation, errInvalidInvitation
+	}
+
+	err := tx.Model(&invitation.Organisation).Association("Users").Append(&user)
+	return invitation.Organisation, err
+}
@@ -0,0 +1,513 @@
+package controllers
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/codelikesuraj/hng11-task-two/utils"
+	"github.com/crewjam/saml"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+var errSAMLAccountExists = errors.New("an account with this email already exists")
+
+// SAMLController signs organisation members in through the organisation's
+// own SAML identity provider, provisioning them on first login.
+type SAMLController struct {
+	DB *gorm.DB
+}
+
+func NewSAMLController(db *gorm.DB) *SAMLController {
+	return &SAMLController{DB: db}
+}
+
+func (sc *SAMLController) GetConfig(c *gin.Context) {
+	org, ok := findAuthorizedOrganisation(c, sc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	config, ok := sc.findConfig(c, org.ID)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "SAML configuration found",
+		"data":    samlConfigData(config),
+	})
+}
+
+// UpdateConfig sets the organisation's IdP from its uploaded metadata XML.
+func (sc *SAMLController) UpdateConfig(c *gin.Context) {
+	var params models.SAMLConfigParams
+	validate := validator.New(validator.WithRequiredStructEnabled())
+
+	if err := c.ShouldBind(&params); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	if err := validate.Struct(params); err != nil {
+		ve := err.(validator.ValidationErrors)
+		errors := make([]models.InputError, len(ve))
+		for i, fe := range ve {
+			errors[i] = models.InputError{
+				Field:   utils.GetJSONTagValue(params, fe.Field()),
+				Message: utils.GetValidationMessage(fe),
+			}
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": errors,
+		})
+		return
+	}
+
+	descriptor, err := utils.ParseSAMLIDPMetadata(params.IDPMetadata)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": []models.InputError{{
+				Field:   "idpMetadata",
+				Message: err.Error(),
+			}},
+		})
+		return
+	}
+
+	org, ok := findAuthorizedOrganisation(c, sc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	var config models.SAMLConfig
+	err = sc.DB.Where(models.SAMLConfig{OrganisationID: org.ID}).FirstOrInit(&config).Error
+	if err == nil {
+		config.IDPEntityID = descriptor.EntityID
+		config.IDPMetadata = params.IDPMetadata
+		config.SSOEnforced = params.SSOEnforced
+		err = sc.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Save(&config).Error; err != nil {
+				return err
+			}
+
+			return recordAudit(tx, c, models.AuditEvent{
+				OrganisationID: org.ID,
+				Action:         models.AuditSAMLConfigUpdated,
+				TargetType:     models.AuditTargetSAMLConfig,
+				TargetID:       fmt.Sprintf("%d", config.ID),
+				Metadata: map[string]interface{}{
+					"idpEntityId": config.IDPEntityID,
+					"ssoEnforced": config.SSOEnforced,
+				},
+			})
+		})
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "SAML configuration saved successfully",
+		"data":    samlConfigData(config),
+	})
+}
+
+func (sc *SAMLController) DeleteConfig(c *gin.Context) {
+	org, ok := findAuthorizedOrganisation(c, sc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	config, ok := sc.findConfig(c, org.ID)
+	if !ok {
+		return
+	}
+
+	err := sc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Delete(&config).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, models.AuditEvent{
+			OrganisationID: org.ID,
+			Action:         models.AuditSAMLConfigDeleted,
+			TargetType:     models.AuditTargetSAMLConfig,
+			TargetID:       fmt.Sprintf("%d", config.ID),
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "SAML configuration deleted successfully",
+	})
+}
+
+// Metadata serves our service provider metadata for the organisation's IdP
+// to import.
+func (sc *SAMLController) Metadata(c *gin.Context) {
+	sp, _, ok := sc.serviceProvider(c)
+	if !ok {
+		return
+	}
+
+	metadata, err := xml.MarshalIndent(sp.Metadata(), "", "  ")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/samlmetadata+xml", metadata)
+}
+
+// Login redirects the user to the organisation's IdP.
+func (sc *SAMLController) Login(c *gin.Context) {
+	sp, config, ok := sc.serviceProvider(c)
+	if !ok {
+		return
+	}
+
+	authnRequest, err := sp.MakeAuthenticationRequest(sp.GetSSOBindingLocation(saml.HTTPRedirectBinding), saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"status":     http.StatusText(http.StatusBadGateway),
+			"message":    "IdP does not support the redirect binding",
+			"statusCode": http.StatusBadGateway,
+		})
+		return
+	}
+
+	relayState, err := utils.GenerateToken("")
+	if err == nil {
+		err = sc.DB.Create(&models.SAMLRequest{
+			RelayState:     relayState,
+			RequestID:      authnRequest.ID,
+			OrganisationID: config.OrganisationID,
+			ExpiresAt:      time.Now().Add(models.SAMLRequestTTL),
+		}).Error
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	redirectURL, err := authnRequest.Redirect(relayState, sp)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.Redirect(http.StatusFound, redirectURL.String())
+}
+
+// ACS is the assertion consumer service the IdP posts its response to. It
+// returns an access token like LoginUser.
+func (sc *SAMLController) ACS(c *gin.Context) {
+	sp, config, ok := sc.serviceProvider(c)
+	if !ok {
+		return
+	}
+
+	if err := c.Request.ParseForm(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":     http.StatusText(http.StatusBadRequest),
+			"message":    "invalid SAML response",
+			"statusCode": http.StatusBadRequest,
+		})
+		return
+	}
+
+	// the relay state ties the response to our request and can only be used once
+	var request models.SAMLRequest
+	result := sc.DB.Where("relay_state = ? AND organisation_id = ?", c.Request.PostForm.Get("RelayState"), config.OrganisationID).Limit(1).Find(&request)
+	if result.Error != nil || result.RowsAffected < 1 || time.Now().After(request.ExpiresAt) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":     http.StatusText(http.StatusBadRequest),
+			"message":    "invalid or expired relay state",
+			"statusCode": http.StatusBadRequest,
+		})
+		return
+	}
+	sc.DB.Unscoped().Delete(&request)
+
+	assertion, err := sp.ParseResponse(c.Request, []string{request.RequestID})
+	if err != nil || assertion.Subject == nil || assertion.Subject.NameID == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":     "Bad request",
+			"message":    "Authentication failed",
+			"statusCode": http.StatusUnauthorized,
+		})
+		return
+	}
+
+	user, err := sc.findOrProvisionUser(c, config.OrganisationID, assertion)
+	if errors.Is(err, errSAMLAccountExists) {
+		c.JSON(http.StatusConflict, gin.H{
+			"status":     http.StatusText(http.StatusConflict),
+			"message":    "An account with this email already exists, log in with your password",
+			"statusCode": http.StatusConflict,
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":     "Bad request",
+			"message":    err.Error(),
+			"statusCode": http.StatusUnauthorized,
+		})
+		return
+	}
+
+	if user.IsDisabled() {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":     "Bad request",
+			"message":    "Account is disabled",
+			"statusCode": http.StatusUnauthorized,
+		})
+		return
+	}
+
+	token, err := utils.GenerateJWT(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Login successful",
+		"data": gin.H{
+			"accessToken": token,
+			"user":        models.UserResponse(user),
+		},
+	})
+}
+
+// findOrProvisionUser returns the user the assertion is about, creating them
+// on first login, and makes sure they are a member of the organisation. An
+// account is only created or taken over by the IdP if its email is on a
+// domain the organisation verified, as the IdP could otherwise assert any
+// email.
+func (sc *SAMLController) findOrProvisionUser(c *gin.Context, orgId uint, assertion *saml.Assertion) (models.User, error) {
+	var user models.User
+
+	provider := fmt.Sprintf("saml:%d", orgId)
+	nameID := assertion.Subject.NameID
+	// transient name IDs change on every login, so they cannot be linked
+	subject := nameID.Value
+	if nameID.Format == string(saml.TransientNameIDFormat) {
+		subject = ""
+	}
+
+	email := utils.GetSAMLAttribute(assertion, "email")
+	if email == "" && strings.Contains(nameID.Value, "@") {
+		email = nameID.Value
+	}
+	if email == "" {
+		return user, errors.New("IdP did not send an email address")
+	}
+
+	err := sc.DB.Transaction(func(tx *gorm.DB) error {
+		var link models.UserIdentity
+		if subject != "" {
+			result := tx.Where("provider = ? AND subject = ?", provider, subject).Preload("User").Limit(1).Find(&link)
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected > 0 {
+				if link.User.ID == 0 {
+					return errors.New("linked user no longer exists")
+				}
+				user = link.User
+			}
+		}
+
+		if user.ID == 0 {
+			result := tx.Where("LOWER(email) = ?", strings.ToLower(email)).Limit(1).Find(&user)
+			if result.Error != nil {
+				return result.Error
+			}
+
+			// a new account would hold the email, so it is only created on
+			// those domains too
+			if !isVerifiedDomain(tx, orgId, email) {
+				if result.RowsAffected > 0 {
+					return errSAMLAccountExists
+				}
+				return errors.New("email is not on a domain the organisation verified")
+			}
+
+			if result.RowsAffected < 1 {
+				firstName := utils.GetSAMLAttribute(assertion, "firstName")
+				if firstName == "" {
+					firstName, _, _ = strings.Cut(email, "@")
+				}
+
+				user = models.User{
+					FirstName: firstName,
+					LastName:  utils.GetSAMLAttribute(assertion, "lastName"),
+					Email:     email,
+				}
+				if err := tx.Create(&user).Error; err != nil {
+					return err
+				}
+			}
+
+			if subject != "" {
+				err := tx.Create(&models.UserIdentity{
+					UserID:   user.ID,
+					Provider: provider,
+					Subject:  subject,
+					Email:    email,
+				}).Error
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if isMember(tx, user.ID, orgId) {
+			return nil
+		}
+		if err := tx.Model(&models.Organisation{Model: gorm.Model{ID: orgId}}).Association("Users").Append(&user); err != nil {
+			return err
+		}
+
+		// the IdP vouched for the user signing in
+		return recordAudit(tx, c, models.AuditEvent{
+			OrganisationID: orgId,
+			ActorType:      models.AuditActorUser,
+			ActorID:        &user.ID,
+			Action:         models.AuditMemberAdded,
+			TargetType:     models.AuditTargetUser,
+			TargetID:       fmt.Sprintf("%d", user.ID),
+			Metadata:       map[string]interface{}{"via": "saml"},
+		})
+	})
+
+	return user, err
+}
+
+// serviceProvider builds the service provider for the :orgId organisation,
+// responding with not found if it has not configured SAML.
+func (sc *SAMLController) serviceProvider(c *gin.Context) (*saml.ServiceProvider, models.SAMLConfig, bool) {
+	orgId, _ := strconv.Atoi(c.Param("orgId"))
+
+	config, ok := sc.findConfig(c, uint(orgId))
+	if !ok {
+		return nil, config, false
+	}
+
+	sp, err := utils.NewSAMLServiceProvider(config.OrganisationID, config.IDPMetadata)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return nil, config, false
+	}
+
+	return sp, config, true
+}
+
+func (sc *SAMLController) findConfig(c *gin.Context, orgId uint) (models.SAMLConfig, bool) {
+	var config models.SAMLConfig
+
+	result := sc.DB.Where("organisation_id = ?", orgId).Limit(1).Find(&config)
+	if orgId < 1 || result.RowsAffected < 1 || result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":     http.StatusText(http.StatusNotFound),
+			"message":    "SAML is not configured for this organisation",
+			"statusCode": http.StatusNotFound,
+		})
+		return config, false
+	}
+
+	return config, true
+}
+
+// samlConfigData adds the service provider details the IdP has to be set up
+// with to the config.
+func samlConfigData(config models.SAMLConfig) map[string]interface{} {
+	baseURL := utils.GetSAMLServiceProviderURL(config.OrganisationID)
+
+	data := models.SAMLConfigResponse(config)
+	data["spEntityId"] = baseURL + "/metadata"
+	data["metadataUrl"] = baseURL + "/metadata"
+	data["acsUrl"] = baseURL + "/acs"
+	data["loginUrl"] = baseURL + "/login"
+	return data
+}
+
+// ssoLoginURL returns where the user has to log in instead if one of their
+// organisations enforces SSO for their verified email domain.
+func ssoLoginURL(db *gorm.DB, user models.User) (string, bool) {
+	_, domain, _ := strings.Cut(strings.ToLower(user.Email), "@")
+
+	var config models.SAMLConfig
+	result := db.
+		Joins("JOIN organisation_domains ON organisation_domains.organisation_id = saml_configs.organisation_id AND organisation_domains.deleted_at IS NULL").
+		Joins("JOIN users_organisations ON users_organisations.organisation_id = saml_configs.organisation_id").
+		Where("saml_configs.sso_enforced = ? AND organisation_domains.domain = ? AND organisation_domains.verified_at IS NOT NULL AND users_organisations.user_id = ?", true, domain, user.ID).
+		Limit(1).Find(&config)
+	if result.Error != nil || result.RowsAffected < 1 {
+		return "", false
+	}
+
+	return utils.GetSAMLServiceProviderURL(config.OrganisationID) + "/login", true
+}
+
+// isVerifiedDomain reports whether the organisation verified the domain of
+// email.
+func isVerifiedDomain(db *gorm.DB, orgId uint, email string) bool {
+	_, domain, _ := strings.Cut(strings.ToLower(email), "@")
+
+	var count int64
+	db.Model(&models.OrganisationDomain{}).
+		Where("organisation_id = ? AND domain = ? AND verified_at IS NOT NULL", orgId, domain).
+		Count(&count)
+	return count > 0
+}
@@ -0,0 +1,1212 @@
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/middlewares"
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/codelikesuraj/hng11-task-two/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+const (
+	scimDefaultCount = 100
+	scimMaxCount     = 200
+)
+
+// scimUserAttributes are the User attributes that can be filtered on, see
+// scimUsersQuery for the tables they come from.
+var scimUserAttributes = map[string]utils.SCIMAttribute{
+	"id":                 {Column: "users.id", Type: "integer"},
+	"externalid":         {Column: "scim_users.external_id"},
+	"username":           {Column: "COALESCE(NULLIF(scim_users.user_name, ''), users.email)"},
+	"name.givenname":     {Column: "users.first_name"},
+	"name.familyname":    {Column: "users.last_name"},
+	"emails":             {Column: "users.email"},
+	"emails.value":       {Column: "users.email"},
+	"phonenumbers":       {Column: "users.phone"},
+	"phonenumbers.value": {Column: "users.phone"},
+	"active":             {Column: "(users_organisations.user_id IS NOT NULL)", Type: "boolean"},
+	"meta.created":       {Column: "users.created_at", Type: "dateTime"},
+	"meta.lastmodified":  {Column: "users.updated_at", Type: "dateTime"},
+}
+
+var scimGroupAttributes = map[string]utils.SCIMAttribute{
+	"id":                {Column: "scim_groups.id", Type: "integer"},
+	"displayname":       {Column: "scim_groups.display_name"},
+	"externalid":        {Column: "scim_groups.external_id"},
+	"meta.created":      {Column: "scim_groups.created_at", Type: "dateTime"},
+	"meta.lastmodified": {Column: "scim_groups.updated_at", Type: "dateTime"},
+}
+
+// SCIMController serves the SCIM 2.0 API (RFC 7644) an organisation's identity
+// provider provisions its users and groups through, and manages the tokens
+// the identity provider authenticates with.
+//
+// The Users of an organisation are its members, which are active, and the
+// users its identity provider deactivated, which keep their SCIMUser but are
+// no longer members.
+type SCIMController struct {
+	DB *gorm.DB
+}
+
+func NewSCIMController(db *gorm.DB) *SCIMController {
+	return &SCIMController{DB: db}
+}
+
+// scimUserRecord is a user as seen by an organisation's identity provider.
+// Link is the zero SCIMUser for members who joined outside of SCIM.
+type scimUserRecord struct {
+	User   models.User
+	Link   models.SCIMUser
+	Active bool
+	Groups []models.SCIMMultiValue
+}
+
+func (sc *SCIMController) GetTokens(c *gin.Context) {
+	org, ok := findAuthorizedOrganisation(c, sc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	var tokens []models.SCIMToken
+	if err := sc.DB.Where("organisation_id = ?", org.ID).Order("id").Find(&tokens).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": fmt.Sprintf("found %d SCIM token(s)", len(tokens)),
+		"data": gin.H{
+			"tokens": models.SCIMTokensResponse(tokens),
+		},
+	})
+}
+
+func (sc *SCIMController) CreateToken(c *gin.Context) {
+	var params models.SCIMTokenCreateParams
+	validate := validator.New(validator.WithRequiredStructEnabled())
+
+	if err := c.ShouldBind(&params); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	if err := validate.Struct(params); err != nil {
+		ve := err.(validator.ValidationErrors)
+		errors := make([]models.InputError, len(ve))
+		for i, fe := range ve {
+			errors[i] = models.InputError{
+				Field:   utils.GetJSONTagValue(params, fe.Field()),
+				Message: utils.GetValidationMessage(fe),
+			}
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": errors,
+		})
+		return
+	}
+
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	org, ok := findAuthorizedOrganisation(c, sc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	plainToken, err := utils.GenerateToken(models.SCIMTokenPrefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	token := models.SCIMToken{
+		OrganisationID: org.ID,
+		Name:           params.Name,
+		TokenHash:      utils.HashToken(plainToken),
+		CreatedByID:    principal.User.ID,
+	}
+	err = sc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&token).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, models.AuditEvent{
+			OrganisationID: org.ID,
+			Action:         models.AuditSCIMTokenCreated,
+			TargetType:     models.AuditTargetSCIMToken,
+			TargetID:       fmt.Sprintf("%d", token.ID),
+			Metadata:       map[string]interface{}{"name": token.Name},
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	data := models.SCIMTokenResponse(token)
+	data["token"] = plainToken
+	data["baseUrl"] = utils.GetSCIMBaseURL(org.ID)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"message": "SCIM token created successfully",
+		"data":    data,
+	})
+}
+
+func (sc *SCIMController) DeleteToken(c *gin.Context) {
+	org, ok := findAuthorizedOrganisation(c, sc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	tokenId, _ := strconv.Atoi(c.Param("tokenId"))
+
+	var result *gorm.DB
+	err := sc.DB.Transaction(func(tx *gorm.DB) error {
+		result = tx.Where("organisation_id = ?", org.ID).Delete(&models.SCIMToken{}, tokenId)
+		if result.Error != nil || result.RowsAffected < 1 {
+			return result.Error
+		}
+
+		return recordAudit(tx, c, models.AuditEvent{
+			OrganisationID: org.ID,
+			Action:         models.AuditSCIMTokenDeleted,
+			TargetType:     models.AuditTargetSCIMToken,
+			TargetID:       fmt.Sprintf("%d", tokenId),
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+	if tokenId < 1 || result.RowsAffected < 1 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":     http.StatusText(http.StatusNotFound),
+			"message":    "token not found",
+			"statusCode": http.StatusNotFound,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "SCIM token revoked successfully",
+	})
+}
+
+func (sc *SCIMController) ServiceProviderConfig(c *gin.Context) {
+	baseURL := utils.GetSCIMBaseURL(scimOrganisationID(c))
+
+	scimJSON(c, http.StatusOK, gin.H{
+		"schemas":        []string{models.SCIMSchemaServiceProviderConfig},
+		"patch":          gin.H{"supported": true},
+		"bulk":           gin.H{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         gin.H{"supported": true, "maxResults": scimMaxCount},
+		"changePassword": gin.H{"supported": true},
+		"sort":           gin.H{"supported": false},
+		"etag":           gin.H{"supported": false},
+		"authenticationSchemes": []gin.H{{
+			"type":        "oauthbearertoken",
+			"name":        "OAuth Bearer Token",
+			"description": "Authentication with a SCIM token of the organisation",
+			"primary":     true,
+		}},
+		"meta": gin.H{
+			"resourceType": "ServiceProviderConfig",
+			"location":     baseURL + "/ServiceProviderConfig",
+		},
+	})
+}
+
+func (sc *SCIMController) ResourceTypes(c *gin.Context) {
+	baseURL := utils.GetSCIMBaseURL(scimOrganisationID(c))
+
+	resources := []interface{}{}
+	for _, resourceType := range []struct{ name, schema string }{
+		{"User", models.SCIMSchemaUser},
+		{"Group", models.SCIMSchemaGroup},
+	} {
+		resources = append(resources, gin.H{
+			"schemas":  []string{models.SCIMSchemaResourceType},
+			"id":       resourceType.name,
+			"name":     resourceType.name,
+			"endpoint": "/" + resourceType.name + "s",
+			"schema":   resourceType.schema,
+			"meta": gin.H{
+				"resourceType": "ResourceType",
+				"location":     baseURL + "/ResourceTypes/" + resourceType.name,
+			},
+		})
+	}
+
+	scimJSON(c, http.StatusOK, models.SCIMListResponse{
+		Schemas:      []string{models.SCIMSchemaListResponse},
+		TotalResults: int64(len(resources)),
+		StartIndex:   1,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+func (sc *SCIMController) GetUsers(c *gin.Context) {
+	orgId := scimOrganisationID(c)
+
+	query, ok := applySCIMFilter(c, scimUsersQuery(sc.DB, orgId), scimUserAttributes)
+	if !ok {
+		return
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		scimRequestError(c, err)
+		return
+	}
+
+	startIndex, count := scimPagination(c)
+
+	resources := []interface{}{}
+	if count > 0 {
+		var users []models.User
+		if err := query.Select("users.*").Order("users.id").Offset(startIndex - 1).Limit(count).Find(&users).Error; err != nil {
+			scimRequestError(c, err)
+			return
+		}
+
+		records, err := sc.loadUserRecords(orgId, users)
+		if err != nil {
+			scimRequestError(c, err)
+			return
+		}
+		for _, record := range records {
+			resources = append(resources, scimUserResource(orgId, record))
+		}
+	}
+
+	scimJSON(c, http.StatusOK, models.SCIMListResponse{
+		Schemas:      []string{models.SCIMSchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+func (sc *SCIMController) GetUser(c *gin.Context) {
+	orgId := scimOrganisationID(c)
+
+	record, ok := sc.findUserRecord(c, orgId)
+	if !ok {
+		return
+	}
+
+	scimJSON(c, http.StatusOK, scimUserResource(orgId, record))
+}
+
+// CreateUser provisions a user. Existing accounts are added to the
+// organisation as they are, their profile only becomes managed by the
+// identity provider if their email is on a domain the organisation verified.
+// New accounts are only created on those domains, as they are managed and
+// hold their email.
+func (sc *SCIMController) CreateUser(c *gin.Context) {
+	orgId := scimOrganisationID(c)
+
+	var resource models.SCIMUserResource
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	email, err := scimUserEmail(resource)
+	if err != nil {
+		scimRequestError(c, err)
+		return
+	}
+
+	var record scimUserRecord
+	err = sc.DB.Transaction(func(tx *gorm.DB) error {
+		if scimUserNameTaken(tx, orgId, resource.UserName, 0) {
+			return &utils.SCIMError{Type: "uniqueness", Detail: "userName is already in use"}
+		}
+
+		var user models.User
+		result := tx.Where("LOWER(email) = ?", strings.ToLower(email)).Limit(1).Find(&user)
+		if result.Error != nil {
+			return result.Error
+		}
+
+		var managed bool
+		if result.RowsAffected > 0 {
+			var count int64
+			if err := scimUsersQuery(tx, orgId).Where("users.id = ?", user.ID).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return &utils.SCIMError{Type: "uniqueness", Detail: "a user with this email already exists"}
+			}
+
+			managed = isVerifiedDomain(tx, orgId, email)
+		} else {
+			if !isVerifiedDomain(tx, orgId, email) {
+				return &utils.SCIMError{Type: "invalidValue", Detail: "users can only be created on a domain the organisation verified"}
+			}
+
+			managed = true
+			user = models.User{Email: email}
+			if err := applySCIMProfile(&user, resource); err != nil {
+				return err
+			}
+			if err := tx.Create(&user).Error; err != nil {
+				return err
+			}
+		}
+
+		record = scimUserRecord{
+			User: user,
+			Link: models.SCIMUser{
+				OrganisationID: orgId,
+				UserID:         user.ID,
+				ExternalID:     resource.ExternalID,
+				UserName:       resource.UserName,
+				Managed:        managed,
+			},
+		}
+		if err := tx.Create(&record.Link).Error; err != nil {
+			return err
+		}
+
+		if resource.Active != nil && !*resource.Active {
+			return nil
+		}
+		record.Active = true
+		if err := tx.Model(&models.Organisation{Model: gorm.Model{ID: orgId}}).Association("Users").Append(&user); err != nil {
+			return err
+		}
+
+		return recordSCIMUserAudit(tx, c, orgId, models.AuditMemberAdded, record)
+	})
+	if err != nil {
+		scimRequestError(c, err)
+		return
+	}
+
+	userResource := scimUserResource(orgId, record)
+	c.Header("Location", userResource.Meta.Location)
+	scimJSON(c, http.StatusCreated, userResource)
+}
+
+func (sc *SCIMController) ReplaceUser(c *gin.Context) {
+	orgId := scimOrganisationID(c)
+
+	var resource models.SCIMUserResource
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	record, ok := sc.findUserRecord(c, orgId)
+	if !ok {
+		return
+	}
+
+	sc.updateUser(c, orgId, record, resource)
+}
+
+func (sc *SCIMController) PatchUser(c *gin.Context) {
+	orgId := scimOrganisationID(c)
+
+	var patch models.SCIMPatchRequest
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	record, ok := sc.findUserRecord(c, orgId)
+	if !ok {
+		return
+	}
+
+	var resource models.SCIMUserResource
+	err := patchSCIMResource(scimUserResource(orgId, record), patch, &resource, func(values map[string]interface{}) {
+		// Azure AD sends active as a string
+		key := utils.FindSCIMKey(values, "active")
+		if active, ok := values[key].(string); ok {
+			values[key] = strings.EqualFold(active, "true")
+		}
+	})
+	if err != nil {
+		scimRequestError(c, err)
+		return
+	}
+
+	sc.updateUser(c, orgId, record, resource)
+}
+
+// DeleteUser removes the user from the organisation and forgets what the
+// identity provider knew about them. Their account itself is kept, they may
+// be a member of other organisations.
+func (sc *SCIMController) DeleteUser(c *gin.Context) {
+	orgId := scimOrganisationID(c)
+
+	record, ok := sc.findUserRecord(c, orgId)
+	if !ok {
+		return
+	}
+
+	err := sc.DB.Transaction(func(tx *gorm.DB) error {
+		if record.Active {
+			if err := removeSCIMMembership(tx, orgId, record.User.ID); err != nil {
+				return err
+			}
+		}
+		if record.Link.ID != 0 {
+			if err := tx.Unscoped().Delete(&record.Link).Error; err != nil {
+				return err
+			}
+		}
+
+		return recordSCIMUserAudit(tx, c, orgId, models.AuditMemberRemoved, record)
+	})
+	if err != nil {
+		scimRequestError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (sc *SCIMController) GetGroups(c *gin.Context) {
+	orgId := scimOrganisationID(c)
+
+	query, ok := applySCIMFilter(c, sc.DB.Model(&models.SCIMGroup{}).Where("scim_groups.organisation_id = ?", orgId), scimGroupAttributes)
+	if !ok {
+		return
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		scimRequestError(c, err)
+		return
+	}
+
+	startIndex, count := scimPagination(c)
+	withMembers := scimIncludesMembers(c)
+
+	resources := []interface{}{}
+	if count > 0 {
+		if withMembers {
+			query = query.Preload("Members", scimOrderMembers)
+		}
+
+		var groups []models.SCIMGroup
+		if err := query.Order("scim_groups.id").Offset(startIndex - 1).Limit(count).Find(&groups).Error; err != nil {
+			scimRequestError(c, err)
+			return
+		}
+		for _, group := range groups {
+			resources = append(resources, scimGroupResource(orgId, group, withMembers))
+		}
+	}
+
+	scimJSON(c, http.StatusOK, models.SCIMListResponse{
+		Schemas:      []string{models.SCIMSchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+func (sc *SCIMController) GetGroup(c *gin.Context) {
+	orgId := scimOrganisationID(c)
+
+	group, ok := sc.findGroup(c, orgId)
+	if !ok {
+		return
+	}
+
+	scimJSON(c, http.StatusOK, scimGroupResource(orgId, group, scimIncludesMembers(c)))
+}
+
+func (sc *SCIMController) CreateGroup(c *gin.Context) {
+	orgId := scimOrganisationID(c)
+
+	var resource models.SCIMGroupResource
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	group := models.SCIMGroup{OrganisationID: orgId}
+	err := sc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := applySCIMGroup(tx, &group, resource); err != nil {
+			return err
+		}
+		return recordSCIMGroupAudit(tx, c, models.AuditSCIMGroupCreated, group)
+	})
+	if err != nil {
+		scimRequestError(c, err)
+		return
+	}
+
+	groupResource := scimGroupResource(orgId, group, true)
+	c.Header("Location", groupResource.Meta.Location)
+	scimJSON(c, http.StatusCreated, groupResource)
+}
+
+func (sc *SCIMController) ReplaceGroup(c *gin.Context) {
+	orgId := scimOrganisationID(c)
+
+	var resource models.SCIMGroupResource
+	if err := c.ShouldBindJSON(&resource); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	group, ok := sc.findGroup(c, orgId)
+	if !ok {
+		return
+	}
+
+	err := sc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := applySCIMGroup(tx, &group, resource); err != nil {
+			return err
+		}
+		return recordSCIMGroupAudit(tx, c, models.AuditSCIMGroupUpdated, group)
+	})
+	if err != nil {
+		scimRequestError(c, err)
+		return
+	}
+
+	scimJSON(c, http.StatusOK, scimGroupResource(orgId, group, true))
+}
+
+func (sc *SCIMController) PatchGroup(c *gin.Context) {
+	orgId := scimOrganisationID(c)
+
+	var patch models.SCIMPatchRequest
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	group, ok := sc.findGroup(c, orgId)
+	if !ok {
+		return
+	}
+
+	var resource models.SCIMGroupResource
+	err := patchSCIMResource(scimGroupResource(orgId, group, true), patch, &resource, nil)
+	if err == nil {
+		err = sc.DB.Transaction(func(tx *gorm.DB) error {
+			if err := applySCIMGroup(tx, &group, resource); err != nil {
+				return err
+			}
+			return recordSCIMGroupAudit(tx, c, models.AuditSCIMGroupUpdated, group)
+		})
+	}
+	if err != nil {
+		scimRequestError(c, err)
+		return
+	}
+
+	scimJSON(c, http.StatusOK, scimGroupResource(orgId, group, true))
+}
+
+func (sc *SCIMController) DeleteGroup(c *gin.Context) {
+	group, ok := sc.findGroup(c, scimOrganisationID(c))
+	if !ok {
+		return
+	}
+
+	err := sc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&group).Association("Members").Clear(); err != nil {
+			return err
+		}
+		if err := tx.Delete(&group).Error; err != nil {
+			return err
+		}
+		return recordSCIMGroupAudit(tx, c, models.AuditSCIMGroupDeleted, group)
+	})
+	if err != nil {
+		scimRequestError(c, err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// updateUser applies a replaced or patched User resource and responds with
+// the result.
+func (sc *SCIMController) updateUser(c *gin.Context, orgId uint, record scimUserRecord, resource models.SCIMUserResource) {
+	email, err := scimUserEmail(resource)
+	if err != nil {
+		scimRequestError(c, err)
+		return
+	}
+
+	err = sc.DB.Transaction(func(tx *gorm.DB) error {
+		if scimUserNameTaken(tx, orgId, resource.UserName, record.User.ID) {
+			return &utils.SCIMError{Type: "uniqueness", Detail: "userName is already in use"}
+		}
+
+		if record.Link.ID == 0 {
+			record.Link = models.SCIMUser{
+				OrganisationID: orgId,
+				UserID:         record.User.ID,
+				Managed:        isVerifiedDomain(tx, orgId, record.User.Email),
+			}
+		}
+
+		user := record.User
+		if !strings.EqualFold(email, user.Email) {
+			user.Email = email
+		}
+		if err := applySCIMProfile(&user, resource); err != nil {
+			return err
+		}
+
+		profileChanged := user.Email != record.User.Email ||
+			user.FirstName != record.User.FirstName ||
+			user.LastName != record.User.LastName ||
+			user.Phone != record.User.Phone ||
+			user.Password != record.User.Password
+		if profileChanged {
+			if !record.Link.Managed {
+				return &utils.SCIMError{Type: "mutability", Detail: "the profile of a user the identity provider did not create cannot be changed"}
+			}
+
+			if user.Email != record.User.Email {
+				if !isVerifiedDomain(tx, orgId, user.Email) {
+					return &utils.SCIMError{Type: "invalidValue", Detail: "emails can only be changed to a domain the organisation verified"}
+				}
+
+				var count int64
+				tx.Model(&models.User{}).Where("LOWER(email) = ? AND id <> ?", strings.ToLower(user.Email), user.ID).Count(&count)
+				if count > 0 {
+					return &utils.SCIMError{Type: "uniqueness", Detail: "a user with this email already exists"}
+				}
+			}
+
+			if err := tx.Model(&record.User).Select("FirstName", "LastName", "Email", "Phone", "Password").Updates(user).Error; err != nil {
+				return err
+			}
+			if err := tx.First(&record.User, user.ID).Error; err != nil {
+				return err
+			}
+		}
+
+		record.Link.ExternalID = resource.ExternalID
+		record.Link.UserName = resource.UserName
+		if err := tx.Save(&record.Link).Error; err != nil {
+			return err
+		}
+
+		if resource.Active == nil || *resource.Active == record.Active {
+			return recordSCIMUserAudit(tx, c, orgId, models.AuditMemberUpdated, record)
+		}
+
+		record.Active = *resource.Active
+		if !record.Active {
+			record.Groups = nil
+			if err := removeSCIMMembership(tx, orgId, record.User.ID); err != nil {
+				return err
+			}
+			return recordSCIMUserAudit(tx, c, orgId, models.AuditMemberRemoved, record)
+		}
+		if err := tx.Model(&models.Organisation{Model: gorm.Model{ID: orgId}}).Association("Users").Append(&record.User); err != nil {
+			return err
+		}
+		return recordSCIMUserAudit(tx, c, orgId, models.AuditMemberAdded, record)
+	})
+	if err != nil {
+		scimRequestError(c, err)
+		return
+	}
+
+	scimJSON(c, http.StatusOK, scimUserResource(orgId, record))
+}
+
+func recordSCIMUserAudit(tx *gorm.DB, c *gin.Context, orgId uint, action string, record scimUserRecord) error {
+	return recordAudit(tx, c, models.AuditEvent{
+		OrganisationID: orgId,
+		Action:         action,
+		TargetType:     models.AuditTargetUser,
+		TargetID:       fmt.Sprintf("%d", record.User.ID),
+		Metadata: map[string]interface{}{
+			"via":      "scim",
+			"userName": record.Link.UserName,
+		},
+	})
+}
+
+func recordSCIMGroupAudit(tx *gorm.DB, c *gin.Context, action string, group models.SCIMGroup) error {
+	return recordAudit(tx, c, models.AuditEvent{
+		OrganisationID: group.OrganisationID,
+		Action:         action,
+		TargetType:     models.AuditTargetSCIMGroup,
+		TargetID:       fmt.Sprintf("%d", group.ID),
+		Metadata:       map[string]interface{}{"displayName": group.DisplayName},
+	})
+}
+
+// loadUserRecords adds what the organisation knows about each of users.
+func (sc *SCIMController) loadUserRecords(orgId uint, users []models.User) ([]scimUserRecord, error) {
+	ids := make([]uint, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+
+	var links []models.SCIMUser
+	if err := sc.DB.Where("organisation_id = ? AND user_id IN ?", orgId, ids).Find(&links).Error; err != nil {
+		return nil, err
+	}
+
+	var memberIds []uint
+	if err := sc.DB.Table("users_organisations").Where("organisation_id = ? AND user_id IN ?", orgId, ids).Pluck("user_id", &memberIds).Error; err != nil {
+		return nil, err
+	}
+
+	var groupMemberships []struct {
+		UserID      uint
+		GroupID     uint
+		DisplayName string
+	}
+	err := sc.DB.Table("scim_group_members").
+		Select("scim_group_members.user_id, scim_groups.id AS group_id, scim_groups.display_name").
+		Joins("JOIN scim_groups ON scim_groups.id = scim_group_members.scim_group_id AND scim_groups.deleted_at IS NULL").
+		Where("scim_groups.organisation_id = ? AND scim_group_members.user_id IN ?", orgId, ids).
+		Order("scim_groups.id").
+		Scan(&groupMemberships).Error
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]scimUserRecord, len(users))
+	for i, user := range users {
+		records[i].User = user
+		for _, link := range links {
+			if link.UserID == user.ID {
+				records[i].Link = link
+			}
+		}
+		for _, id := range memberIds {
+			records[i].Active = records[i].Active || id == user.ID
+		}
+		for _, membership := range groupMemberships {
+			if membership.UserID == user.ID {
+				records[i].Groups = append(records[i].Groups, models.SCIMMultiValue{
+					Value:   fmt.Sprintf("%d", membership.GroupID),
+					Display: membership.DisplayName,
+				})
+			}
+		}
+	}
+
+	return records, nil
+}
+
+func (sc *SCIMController) findUserRecord(c *gin.Context, orgId uint) (scimUserRecord, bool) {
+	var users []models.User
+
+	userId, _ := strconv.Atoi(c.Param("id"))
+	result := scimUsersQuery(sc.DB, orgId).Where("users.id = ?", userId).Select("users.*").Limit(1).Find(&users)
+	if result.Error != nil {
+		scimRequestError(c, result.Error)
+		return scimUserRecord{}, false
+	}
+	if result.RowsAffected < 1 {
+		scimError(c, http.StatusNotFound, "", "user not found")
+		return scimUserRecord{}, false
+	}
+
+	records, err := sc.loadUserRecords(orgId, users)
+	if err != nil {
+		scimRequestError(c, err)
+		return scimUserRecord{}, false
+	}
+
+	return records[0], true
+}
+
+func (sc *SCIMController) findGroup(c *gin.Context, orgId uint) (models.SCIMGroup, bool) {
+	var group models.SCIMGroup
+
+	groupId, _ := strconv.Atoi(c.Param("id"))
+	result := sc.DB.Where("organisation_id = ?", orgId).Preload("Members", scimOrderMembers).Limit(1).Find(&group, groupId)
+	if result.Error != nil {
+		scimRequestError(c, result.Error)
+		return group, false
+	}
+	if groupId < 1 || result.RowsAffected < 1 {
+		scimError(c, http.StatusNotFound, "", "group not found")
+		return group, false
+	}
+
+	return group, true
+}
+
+// scimUsersQuery selects the users of an organisation's SCIM resource set,
+// joined with their membership and SCIMUser to filter on.
+func scimUsersQuery(db *gorm.DB, orgId uint) *gorm.DB {
+	return db.Model(&models.User{}).
+		Joins("LEFT JOIN users_organisations ON users_organisations.user_id = users.id AND users_organisations.organisation_id = ?", orgId).
+		Joins("LEFT JOIN scim_users ON scim_users.user_id = users.id AND scim_users.organisation_id = ? AND scim_users.deleted_at IS NULL", orgId).
+		Where("(users_organisations.user_id IS NOT NULL OR scim_users.id IS NOT NULL)")
+}
+
+// scimUserNameTaken reports whether another user of the organisation has the
+// userName, which is unique within an organisation.
+func scimUserNameTaken(db *gorm.DB, orgId uint, userName string, exceptUserId uint) bool {
+	var count int64
+	scimUsersQuery(db, orgId).
+		Where("LOWER(COALESCE(NULLIF(scim_users.user_name, ''), users.email)) = ? AND users.id <> ?", strings.ToLower(userName), exceptUserId).
+		Count(&count)
+	return count > 0
+}
+
+// removeSCIMMembership deactivates a user, removing them from the
+// organisation, its groups and its teams.
+func removeSCIMMembership(tx *gorm.DB, orgId, userId uint) error {
+	err := tx.Exec("DELETE FROM scim_group_members WHERE user_id = ? AND scim_group_id IN (SELECT id FROM scim_groups WHERE organisation_id = ?)", userId, orgId).Error
+	if err != nil {
+		return err
+	}
+
+	err = tx.Exec("DELETE FROM team_members WHERE user_id = ? AND team_id IN (SELECT id FROM teams WHERE organisation_id = ?)", userId, orgId).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Model(&models.Organisation{Model: gorm.Model{ID: orgId}}).Association("Users").Delete(&models.User{Model: gorm.Model{ID: userId}})
+}
+
+// applySCIMGroup sets the group's attributes and members from a Group
+// resource and saves it. Members have to be active users of the organisation.
+func applySCIMGroup(tx *gorm.DB, group *models.SCIMGroup, resource models.SCIMGroupResource) error {
+	if resource.DisplayName == "" {
+		return &utils.SCIMError{Type: "invalidValue", Detail: "displayName is required"}
+	}
+
+	var count int64
+	tx.Model(&models.SCIMGroup{}).
+		Where("organisation_id = ? AND LOWER(display_name) = ? AND id <> ?", group.OrganisationID, strings.ToLower(resource.DisplayName), group.ID).
+		Count(&count)
+	if count > 0 {
+		return &utils.SCIMError{Type: "uniqueness", Detail: "displayName is already in use"}
+	}
+
+	ids := []uint{}
+	for _, member := range resource.Members {
+		id, err := strconv.Atoi(member.Value)
+		if err != nil || id < 1 {
+			return &utils.SCIMError{Type: "invalidValue", Detail: fmt.Sprintf("member %q is not a user of this organisation", member.Value)}
+		}
+		ids = append(ids, uint(id))
+	}
+
+	var members []models.User
+	if len(ids) > 0 {
+		err := tx.Joins("JOIN users_organisations ON users_organisations.user_id = users.id AND users_organisations.organisation_id = ?", group.OrganisationID).
+			Where("users.id IN ?", ids).
+			Order("users.id").
+			Find(&members).Error
+		if err != nil {
+			return err
+		}
+	}
+	for _, id := range ids {
+		found := false
+		for _, member := range members {
+			found = found || member.ID == id
+		}
+		if !found {
+			return &utils.SCIMError{Type: "invalidValue", Detail: fmt.Sprintf("member %q is not a user of this organisation", strconv.Itoa(int(id)))}
+		}
+	}
+
+	group.DisplayName = resource.DisplayName
+	group.ExternalID = resource.ExternalID
+
+	if group.ID == 0 {
+		group.Members = members
+		return tx.Create(group).Error
+	}
+
+	if err := tx.Model(group).Select("DisplayName", "ExternalID").Updates(group).Error; err != nil {
+		return err
+	}
+
+	group.Members = members
+	if len(members) == 0 {
+		return tx.Model(group).Association("Members").Clear()
+	}
+	return tx.Model(group).Association("Members").Replace(members)
+}
+
+// applySCIMProfile copies the profile attributes of a User resource onto
+// user, leaving those the resource does not set.
+func applySCIMProfile(user *models.User, resource models.SCIMUserResource) error {
+	if resource.Name != nil && resource.Name.GivenName != "" {
+		user.FirstName = resource.Name.GivenName
+	}
+	if resource.Name != nil && resource.Name.FamilyName != "" {
+		user.LastName = resource.Name.FamilyName
+	}
+	if user.FirstName == "" {
+		user.FirstName, _, _ = strings.Cut(user.Email, "@")
+	}
+
+	if len(resource.PhoneNumbers) > 0 {
+		user.Phone = resource.PhoneNumbers[0].Value
+	}
+
+	if resource.Password != "" && !utils.PasswordIsValid(user.Password, resource.Password) {
+		hash, err := utils.HashPassword(resource.Password)
+		if err != nil {
+			return err
+		}
+		user.Password = hash
+	}
+
+	return nil
+}
+
+// scimUserEmail returns the email of a User resource. Identity providers that
+// only send a userName send the email as the userName.
+func scimUserEmail(resource models.SCIMUserResource) (string, error) {
+	if resource.UserName == "" {
+		return "", &utils.SCIMError{Type: "invalidValue", Detail: "userName is required"}
+	}
+
+	email := resource.PrimaryEmail()
+	if email == "" {
+		email = resource.UserName
+	}
+
+	if validator.New().Var(email, "required,email") != nil {
+		return "", &utils.SCIMError{Type: "invalidValue", Detail: "a valid email address is required, as an email or as the userName"}
+	}
+
+	return email, nil
+}
+
+func scimUserResource(orgId uint, record scimUserRecord) models.SCIMUserResource {
+	user := record.User
+
+	userName := record.Link.UserName
+	if userName == "" {
+		userName = user.Email
+	}
+
+	resource := models.SCIMUserResource{
+		Schemas:    []string{models.SCIMSchemaUser},
+		ID:         fmt.Sprintf("%d", user.ID),
+		ExternalID: record.Link.ExternalID,
+		UserName:   userName,
+		Name: &models.SCIMName{
+			Formatted:  strings.TrimSpace(user.FirstName + " " + user.LastName),
+			GivenName:  user.FirstName,
+			FamilyName: user.LastName,
+		},
+		DisplayName: strings.TrimSpace(user.FirstName + " " + user.LastName),
+		Emails:      []models.SCIMMultiValue{{Value: user.Email, Type: "work", Primary: true}},
+		Active:      &record.Active,
+		Groups:      record.Groups,
+		Meta:        scimMeta(orgId, "User", user.ID, user.CreatedAt, user.UpdatedAt),
+	}
+	if user.Phone != "" {
+		resource.PhoneNumbers = []models.SCIMMultiValue{{Value: user.Phone, Type: "work"}}
+	}
+
+	return resource
+}
+
+func scimGroupResource(orgId uint, group models.SCIMGroup, withMembers bool) models.SCIMGroupResource {
+	resource := models.SCIMGroupResource{
+		Schemas:     []string{models.SCIMSchemaGroup},
+		ID:          fmt.Sprintf("%d", group.ID),
+		ExternalID:  group.ExternalID,
+		DisplayName: group.DisplayName,
+		Meta:        scimMeta(orgId, "Group", group.ID, group.CreatedAt, group.UpdatedAt),
+	}
+
+	if withMembers {
+		for _, member := range group.Members {
+			resource.Members = append(resource.Members, models.SCIMMultiValue{
+				Value:   fmt.Sprintf("%d", member.ID),
+				Display: strings.TrimSpace(member.FirstName + " " + member.LastName),
+			})
+		}
+	}
+
+	return resource
+}
+
+func scimMeta(orgId uint, resourceType string, id uint, created, lastModified time.Time) *models.SCIMMeta {
+	return &models.SCIMMeta{
+		ResourceType: resourceType,
+		Created:      created.UTC().Format(time.RFC3339),
+		LastModified: lastModified.UTC().Format(time.RFC3339),
+		Location:     fmt.Sprintf("%s/%ss/%d", utils.GetSCIMBaseURL(orgId), resourceType, id),
+	}
+}
+
+// patchSCIMResource applies a PATCH request to the JSON representation of
+// resource and decodes the result into patched. fix adjusts the patched
+// values before they are decoded.
+func patchSCIMResource(resource interface{}, patch models.SCIMPatchRequest, patched interface{}, fix func(map[string]interface{})) error {
+	body, err := json.Marshal(resource)
+	if err != nil {
+		return err
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal(body, &values); err != nil {
+		return err
+	}
+
+	if err := utils.ApplySCIMPatch(values, patch.Operations); err != nil {
+		return err
+	}
+	if fix != nil {
+		fix(values)
+	}
+
+	if body, err = json.Marshal(values); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, patched); err != nil {
+		return &utils.SCIMError{Type: "invalidValue", Detail: err.Error()}
+	}
+
+	return nil
+}
+
+// applySCIMFilter adds the filter query parameter to query.
+func applySCIMFilter(c *gin.Context, query *gorm.DB, attributes map[string]utils.SCIMAttribute) (*gorm.DB, bool) {
+	if c.Query("filter") != "" {
+		filter, err := utils.ParseSCIMFilter(c.Query("filter"))
+		if err != nil {
+			scimRequestError(c, err)
+			return nil, false
+		}
+
+		sql, args, err := filter.SQL(attributes)
+		if err != nil {
+			scimRequestError(c, err)
+			return nil, false
+		}
+		query = query.Where(sql, args...)
+	}
+
+	// the query is used for both the count and the page
+	return query.Session(&gorm.Session{}), true
+}
+
+// scimPagination returns the 1-based startIndex and the count of results
+// requested (RFC 7644 3.4.2.4).
+func scimPagination(c *gin.Context) (int, int) {
+	startIndex, _ := strconv.Atoi(c.Query("startIndex"))
+	if startIndex < 1 {
+		startIndex = 1
+	}
+
+	count := scimDefaultCount
+	if c.Query("count") != "" {
+		count, _ = strconv.Atoi(c.Query("count"))
+	}
+	count = max(0, min(count, scimMaxCount))
+
+	return startIndex, count
+}
+
+// scimIncludesMembers reports whether group members were not excluded from
+// the response, as Azure AD does when it only checks a group exists.
+func scimIncludesMembers(c *gin.Context) bool {
+	for _, attr := range strings.Split(c.Query("excludedAttributes"), ",") {
+		if strings.EqualFold(strings.TrimSpace(attr), "members") {
+			return false
+		}
+	}
+	return true
+}
+
+func scimOrderMembers(db *gorm.DB) *gorm.DB {
+	return db.Order("users.id")
+}
+
+func scimOrganisationID(c *gin.Context) uint {
+	orgId, _ := c.Get(middlewares.SCIMOrganisationKey)
+	id, _ := orgId.(uint)
+	return id
+}
+
+// scimJSON responds with the SCIM media type (RFC 7644 3.1).
+func scimJSON(c *gin.Context, status int, body interface{}) {
+	c.Header("Content-Type", "application/scim+json")
+	c.JSON(status, body)
+}
+
+func scimError(c *gin.Context, status int, scimType, detail string) {
+	body := gin.H{
+		"schemas": []string{models.SCIMSchemaError},
+		"status":  strconv.Itoa(status),
+		"detail":  detail,
+	}
+	if scimType != "" {
+		body["scimType"] = scimType
+	}
+
+	scimJSON(c, status, body)
+}
+
+// scimRequestError responds with err, a bad request or a conflict if it is a
+// utils.SCIMError and an internal error otherwise.
+func scimRequestError(c *gin.Context, err error) {
+	var scimErr *utils.SCIMError
+	if !errors.As(err, &scimErr) {
+		scimError(c, http.StatusInternalServerError, "", http.StatusText(http.StatusInternalServerError))
+		return
+	}
+
+	status := http.StatusBadRequest
+	if scimErr.Type == "uniqueness" {
+		status = http.StatusConflict
+	}
+	scimError(c, status, scimErr.Type, scimErr.Detail)
+}
@@ -0,0 +1,119 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/codelikesuraj/hng11-task-two/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+const defaultSearchLimit = 10
+
+type SearchController struct {
+	DB *gorm.DB
+}
+
+func NewSearchController(db *gorm.DB) *SearchController {
+	return &SearchController{DB: db}
+}
+
+// Search finds the organisations the caller is a member of, and the users
+// sharing one of them, by partial name or email, the most relevant first.
+// Users are only searched with the users:read scope.
+func (sc *SearchController) Search(c *gin.Context) {
+	var params models.SearchParams
+	validate := validator.New(validator.WithRequiredStructEnabled())
+
+	if err := c.ShouldBindQuery(&params); err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": []models.InputError{
+				{
+					Field:   "limit",
+					Message: "must be a number",
+				},
+			},
+		})
+		return
+	}
+
+	if err := validate.Struct(params); err != nil {
+		ve := err.(validator.ValidationErrors)
+		errors := make([]models.InputError, len(ve))
+		for i, fe := range ve {
+			errors[i] = models.InputError{
+				Field:   utils.GetJSONTagValue(params, fe.Field()),
+				Message: utils.GetValidationMessage(fe),
+			}
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": errors,
+		})
+		return
+	}
+	if params.Limit == 0 {
+		params.Limit = defaultSearchLimit
+	}
+
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	// the organisations GetOrganisationById would return
+	visibleOrgs := sc.DB.Table("users_organisations").Select("organisation_id")
+	if principal.IsServiceAccount() {
+		visibleOrgs = visibleOrgs.Where("organisation_id = ?", principal.ServiceAccount.OrganisationID)
+	} else {
+		visibleOrgs = visibleOrgs.Where("user_id = ?", principal.User.ID)
+	}
+
+	var orgs []models.Organisation
+	err := sc.DB.Model(&models.Organisation{}).
+		Scopes(utils.SearchScope(utils.OrganisationSearchDocument, params.Q)).
+		Where("id IN (?)", visibleOrgs).
+		Limit(params.Limit).
+		Find(&orgs).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	users := []map[string]string{}
+	if principal.HasScope(models.ScopeUsersRead) {
+		var found []models.User
+		err := sc.DB.Model(&models.User{}).
+			Scopes(utils.SearchScope(utils.UserSearchDocument, params.Q)).
+			Where("id IN (?)", sc.DB.Table("users_organisations").Select("user_id").Where("organisation_id IN (?)", visibleOrgs)).
+			Limit(params.Limit).
+			Find(&found).Error
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status":     http.StatusText(http.StatusInternalServerError),
+				"message":    http.StatusText(http.StatusInternalServerError),
+				"statusCode": http.StatusInternalServerError,
+			})
+			return
+		}
+
+		for _, user := range found {
+			users = append(users, models.UserResponse(user))
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": fmt.Sprintf("found %d organisation(s) and %d user(s)", len(orgs), len(users)),
+		"data": gin.H{
+			"organisations": models.OrganisationsResponse(orgs),
+			"users":         users,
+		},
+	})
+}
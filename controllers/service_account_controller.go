@@ -0,0 +1,396 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/codelikesuraj/hng11-task-two/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+type ServiceAccountController struct {
+	DB *gorm.DB
+}
+
+func NewServiceAccountController(db *gorm.DB) *ServiceAccountController {
+	return &ServiceAccountController{DB: db}
+}
+
+func (sc *ServiceAccountController) GetAll(c *gin.Context) {
+	org, ok := findAuthorizedOrganisation(c, sc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	var accounts []models.ServiceAccount
+	if err := sc.DB.Where("organisation_id = ?", org.ID).Preload("APIKeys").Order("id").Find(&accounts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": fmt.Sprintf("found %d service account(s)", len(accounts)),
+		"data": gin.H{
+			"serviceAccounts": models.ServiceAccountsResponse(accounts),
+		},
+	})
+}
+
+func (sc *ServiceAccountController) Create(c *gin.Context) {
+	var params models.ServiceAccountCreateParams
+	validate := validator.New(validator.WithRequiredStructEnabled())
+
+	if err := c.ShouldBind(&params); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	if err := validate.Struct(params); err != nil {
+		ve := err.(validator.ValidationErrors)
+		errors := make([]models.InputError, len(ve))
+		for i, fe := range ve {
+			errors[i] = models.InputError{
+				Field:   utils.GetJSONTagValue(params, fe.Field()),
+				Message: utils.GetValidationMessage(fe),
+			}
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": errors,
+		})
+		return
+	}
+
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	org, ok := findAuthorizedOrganisation(c, sc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	account := models.ServiceAccount{
+		OrganisationID: org.ID,
+		Name:           params.Name,
+		Role:           params.Role,
+		CreatedByID:    principal.User.ID,
+	}
+	err := sc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&account).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, models.AuditEvent{
+			OrganisationID: org.ID,
+			Action:         models.AuditServiceAccountCreated,
+			TargetType:     models.AuditTargetServiceAccount,
+			TargetID:       fmt.Sprintf("%d", account.ID),
+			Metadata: map[string]interface{}{
+				"name": account.Name,
+				"role": account.Role,
+			},
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"message": "Service account created successfully",
+		"data":    models.ServiceAccountResponse(account),
+	})
+}
+
+func (sc *ServiceAccountController) Delete(c *gin.Context) {
+	account, ok := sc.findServiceAccount(c)
+	if !ok {
+		return
+	}
+
+	// deleting the account also invalidates its keys, see middlewares.Auth
+	err := sc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&account).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, models.AuditEvent{
+			OrganisationID: account.OrganisationID,
+			Action:         models.AuditServiceAccountDeleted,
+			TargetType:     models.AuditTargetServiceAccount,
+			TargetID:       fmt.Sprintf("%d", account.ID),
+			Metadata:       map[string]interface{}{"name": account.Name},
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Service account deleted successfully",
+	})
+}
+
+func (sc *ServiceAccountController) CreateKey(c *gin.Context) {
+	var params models.APIKeyCreateParams
+	validate := validator.New(validator.WithRequiredStructEnabled())
+
+	// the request body is optional
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBind(&params); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status":     http.StatusText(http.StatusInternalServerError),
+				"message":    err.Error(),
+				"statusCode": http.StatusInternalServerError,
+			})
+			return
+		}
+	}
+
+	if err := validate.Struct(params); err != nil {
+		ve := err.(validator.ValidationErrors)
+		errors := make([]models.InputError, len(ve))
+		for i, fe := range ve {
+			errors[i] = models.InputError{
+				Field:   utils.GetJSONTagValue(params, fe.Field()),
+				Message: utils.GetValidationMessage(fe),
+			}
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": errors,
+		})
+		return
+	}
+
+	account, ok := sc.findServiceAccount(c)
+	if !ok {
+		return
+	}
+
+	var expiresAt *time.Time
+	if params.ExpiresInDays > 0 {
+		t := time.Now().AddDate(0, 0, params.ExpiresInDays)
+		expiresAt = &t
+	}
+
+	var key models.APIKey
+	var plainKey string
+
+	err := sc.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		key, plainKey, err = sc.issueKey(tx, account, expiresAt)
+		if err != nil {
+			return err
+		}
+
+		return recordAPIKeyAudit(tx, c, models.AuditAPIKeyCreated, account, key)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	data := models.APIKeyResponse(key)
+	data["key"] = plainKey
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"message": "API key created successfully",
+		"data":    data,
+	})
+}
+
+// RotateKey issues a replacement for a key, with the same expiry, and revokes
+// the old one.
+func (sc *ServiceAccountController) RotateKey(c *gin.Context) {
+	key, ok := sc.findKey(c)
+	if !ok {
+		return
+	}
+
+	if !key.IsActive() {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":     http.StatusText(http.StatusBadRequest),
+			"message":    "API key is revoked or expired",
+			"statusCode": http.StatusBadRequest,
+		})
+		return
+	}
+
+	var newKey models.APIKey
+	var plainKey string
+
+	err := sc.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if err = tx.Model(&key).Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		newKey, plainKey, err = sc.issueKey(tx, key.ServiceAccount, key.ExpiresAt)
+		if err != nil {
+			return err
+		}
+
+		return recordAPIKeyAudit(tx, c, models.AuditAPIKeyRotated, key.ServiceAccount, newKey, key)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	data := models.APIKeyResponse(newKey)
+	data["key"] = plainKey
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"message": "API key rotated successfully",
+		"data":    data,
+	})
+}
+
+func (sc *ServiceAccountController) RevokeKey(c *gin.Context) {
+	key, ok := sc.findKey(c)
+	if !ok {
+		return
+	}
+
+	if key.RevokedAt == nil {
+		err := sc.DB.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&key).Update("revoked_at", time.Now()).Error; err != nil {
+				return err
+			}
+
+			return recordAPIKeyAudit(tx, c, models.AuditAPIKeyRevoked, key.ServiceAccount, key)
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"status":     http.StatusText(http.StatusInternalServerError),
+				"message":    http.StatusText(http.StatusInternalServerError),
+				"statusCode": http.StatusInternalServerError,
+			})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "API key revoked successfully",
+	})
+}
+
+func (sc *ServiceAccountController) issueKey(db *gorm.DB, account models.ServiceAccount, expiresAt *time.Time) (models.APIKey, string, error) {
+	plainKey, err := utils.GenerateToken(models.APIKeyPrefix)
+	if err != nil {
+		return models.APIKey{}, "", err
+	}
+
+	key := models.APIKey{
+		ServiceAccountID: account.ID,
+		KeyHash:          utils.HashToken(plainKey),
+		ExpiresAt:        expiresAt,
+	}
+	if err := db.Create(&key).Error; err != nil {
+		return models.APIKey{}, "", err
+	}
+
+	return key, plainKey, nil
+}
+
+// recordAPIKeyAudit records an action on the key of a service account. The
+// key a rotation replaced is recorded as well.
+func recordAPIKeyAudit(tx *gorm.DB, c *gin.Context, action string, account models.ServiceAccount, key models.APIKey, replaced ...models.APIKey) error {
+	metadata := map[string]interface{}{"serviceAccountId": fmt.Sprintf("%d", account.ID)}
+	for _, old := range replaced {
+		metadata["replacedKeyId"] = fmt.Sprintf("%d", old.ID)
+	}
+
+	return recordAudit(tx, c, models.AuditEvent{
+		OrganisationID: account.OrganisationID,
+		Action:         action,
+		TargetType:     models.AuditTargetAPIKey,
+		TargetID:       fmt.Sprintf("%d", key.ID),
+		Metadata:       metadata,
+	})
+}
+
+// findServiceAccount loads the :accountId service account of the :orgId
+// organisation, which the caller has to administer, writing the error
+// response if not.
+func (sc *ServiceAccountController) findServiceAccount(c *gin.Context) (models.ServiceAccount, bool) {
+	var account models.ServiceAccount
+
+	org, ok := findAuthorizedOrganisation(c, sc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return account, false
+	}
+
+	accountId, _ := strconv.Atoi(c.Param("accountId"))
+	result := sc.DB.Where("organisation_id = ?", org.ID).Limit(1).Find(&account, accountId)
+	if accountId < 1 || result.RowsAffected < 1 || result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":     http.StatusText(http.StatusNotFound),
+			"message":    "service account not found",
+			"statusCode": http.StatusNotFound,
+		})
+		return account, false
+	}
+
+	return account, true
+}
+
+func (sc *ServiceAccountController) findKey(c *gin.Context) (models.APIKey, bool) {
+	var key models.APIKey
+
+	account, ok := sc.findServiceAccount(c)
+	if !ok {
+		return key, false
+	}
+
+	keyId, _ := strconv.Atoi(c.Param("keyId"))
+	result := sc.DB.Where("service_account_id = ?", account.ID).Limit(1).Find(&key, keyId)
+	if keyId < 1 || result.RowsAffected < 1 || result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":     http.StatusText(http.StatusNotFound),
+			"message":    "API key not found",
+			"statusCode": http.StatusNotFound,
+		})
+		return key, false
+	}
+
+	key.ServiceAccount = account
+	return key, true
+}
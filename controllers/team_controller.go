@@ -0,0 +1,614 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/codelikesuraj/hng11-task-two/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+// TeamController manages the teams of an organisation. Any member can see
+// them, only admins can change them.
+type TeamController struct {
+	DB *gorm.DB
+}
+
+func NewTeamController(db *gorm.DB) *TeamController {
+	return &TeamController{DB: db}
+}
+
+func (tc *TeamController) GetAll(c *gin.Context) {
+	org, ok := findAuthorizedOrganisation(c, tc.DB, models.ScopeOrgsRead)
+	if !ok {
+		return
+	}
+
+	var teams []models.Team
+	if err := tc.DB.Where("organisation_id = ?", org.ID).Order("id").Find(&teams).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": fmt.Sprintf("found %d team(s)", len(teams)),
+		"data": gin.H{
+			"teams": models.TeamsResponse(teams),
+		},
+	})
+}
+
+func (tc *TeamController) Get(c *gin.Context) {
+	org, ok := findAuthorizedOrganisation(c, tc.DB, models.ScopeOrgsRead)
+	if !ok {
+		return
+	}
+
+	team, ok := tc.findTeam(c, org)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "found team",
+		"data":    models.TeamResponse(team),
+	})
+}
+
+func (tc *TeamController) Create(c *gin.Context) {
+	var params models.TeamCreateParams
+	validate := validator.New(validator.WithRequiredStructEnabled())
+
+	if err := c.ShouldBind(&params); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	if err := validate.Struct(params); err != nil {
+		ve := err.(validator.ValidationErrors)
+		errors := make([]models.InputError, len(ve))
+		for i, fe := range ve {
+			errors[i] = models.InputError{
+				Field:   utils.GetJSONTagValue(params, fe.Field()),
+				Message: utils.GetValidationMessage(fe),
+			}
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": errors,
+		})
+		return
+	}
+
+	org, ok := findAuthorizedOrganisation(c, tc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	team := models.Team{
+		OrganisationID: org.ID,
+		Name:           params.Name,
+		Description:    params.Description,
+		Role:           params.Role,
+	}
+	if team.Role == "" {
+		team.Role = models.RoleMember
+	}
+
+	if !tc.setParent(c, &team, params.ParentID) {
+		return
+	}
+
+	err := tc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&team).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, models.AuditEvent{
+			OrganisationID: org.ID,
+			Action:         models.AuditTeamCreated,
+			TargetType:     models.AuditTargetTeam,
+			TargetID:       fmt.Sprintf("%d", team.ID),
+			Metadata:       teamAuditMetadata(team),
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"message": "Team created successfully",
+		"data":    models.TeamResponse(team),
+	})
+}
+
+func (tc *TeamController) Update(c *gin.Context) {
+	var params models.TeamUpdateParams
+	validate := validator.New(validator.WithRequiredStructEnabled())
+
+	if err := c.ShouldBind(&params); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	if err := validate.Struct(params); err != nil {
+		ve := err.(validator.ValidationErrors)
+		errors := make([]models.InputError, len(ve))
+		for i, fe := range ve {
+			errors[i] = models.InputError{
+				Field:   utils.GetJSONTagValue(params, fe.Field()),
+				Message: utils.GetValidationMessage(fe),
+			}
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": errors,
+		})
+		return
+	}
+
+	org, ok := findAuthorizedOrganisation(c, tc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	team, ok := tc.findTeam(c, org)
+	if !ok {
+		return
+	}
+
+	if params.Name != nil {
+		team.Name = *params.Name
+	}
+	if params.Description != nil {
+		team.Description = *params.Description
+	}
+	if params.Role != nil {
+		team.Role = *params.Role
+	}
+	if params.ParentID != nil && !tc.setParent(c, &team, *params.ParentID) {
+		return
+	}
+
+	// Save would leave parent_id alone when it is cleared
+	err := tc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&team).Select("name", "description", "role", "parent_id").Updates(&team).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, models.AuditEvent{
+			OrganisationID: org.ID,
+			Action:         models.AuditTeamUpdated,
+			TargetType:     models.AuditTargetTeam,
+			TargetID:       fmt.Sprintf("%d", team.ID),
+			Metadata:       teamAuditMetadata(team),
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Team updated successfully",
+		"data":    models.TeamResponse(team),
+	})
+}
+
+func (tc *TeamController) Delete(c *gin.Context) {
+	org, ok := findAuthorizedOrganisation(c, tc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	team, ok := tc.findTeam(c, org)
+	if !ok {
+		return
+	}
+
+	var children int64
+	if err := tc.DB.Model(&models.Team{}).Where("parent_id = ?", team.ID).Count(&children).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+	if children > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"status":     http.StatusText(http.StatusConflict),
+			"message":    "team has sub-teams, move or delete them first",
+			"statusCode": http.StatusConflict,
+		})
+		return
+	}
+
+	err := tc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&team).Association("Users").Clear(); err != nil {
+			return err
+		}
+		if err := tx.Delete(&team).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, models.AuditEvent{
+			OrganisationID: org.ID,
+			Action:         models.AuditTeamDeleted,
+			TargetType:     models.AuditTargetTeam,
+			TargetID:       fmt.Sprintf("%d", team.ID),
+			Metadata:       map[string]interface{}{"name": team.Name},
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Team deleted successfully",
+	})
+}
+
+// GetMembers lists the direct members of a team.
+func (tc *TeamController) GetMembers(c *gin.Context) {
+	org, ok := findAuthorizedOrganisation(c, tc.DB, models.ScopeOrgsRead)
+	if !ok {
+		return
+	}
+
+	team, ok := tc.findTeam(c, org)
+	if !ok {
+		return
+	}
+
+	var users []models.User
+	if err := tc.DB.Model(&team).Order("users.id").Association("Users").Find(&users); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	members := []map[string]string{}
+	for _, user := range users {
+		members = append(members, models.UserResponse(user))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": fmt.Sprintf("found %d member(s)", len(members)),
+		"data": gin.H{
+			"members": members,
+		},
+	})
+}
+
+// AddMember adds a member of the organisation to a team.
+func (tc *TeamController) AddMember(c *gin.Context) {
+	var params models.OrganisationUserParams
+	validate := validator.New(validator.WithRequiredStructEnabled())
+
+	if err := c.ShouldBind(&params); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	if err := validate.Struct(params); err != nil {
+		ve := err.(validator.ValidationErrors)
+		errors := make([]models.InputError, len(ve))
+		for i, fe := range ve {
+			errors[i] = models.InputError{
+				Field:   utils.GetJSONTagValue(params, fe.Field()),
+				Message: utils.GetValidationMessage(fe),
+			}
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": errors,
+		})
+		return
+	}
+
+	org, ok := findAuthorizedOrganisation(c, tc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	team, ok := tc.findTeam(c, org)
+	if !ok {
+		return
+	}
+
+	// teams are made of members of the organisation only
+	userId, _ := strconv.Atoi(params.UserID)
+	var user models.User
+	result := tc.DB.Limit(1).Find(&user, userId)
+	if userId < 1 || result.Error != nil || result.RowsAffected < 1 || !isMember(tc.DB, user.ID, org.ID) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": []models.InputError{
+				{
+					Field:   "userId",
+					Message: "must be a member of the organisation",
+				},
+			},
+		})
+		return
+	}
+
+	err := tc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&team).Association("Users").Append(&user); err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, models.AuditEvent{
+			OrganisationID: org.ID,
+			Action:         models.AuditTeamMemberAdded,
+			TargetType:     models.AuditTargetTeam,
+			TargetID:       fmt.Sprintf("%d", team.ID),
+			Metadata:       map[string]interface{}{"userId": fmt.Sprintf("%d", user.ID)},
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    "error adding user to team",
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "User added to team successfully",
+	})
+}
+
+func (tc *TeamController) RemoveMember(c *gin.Context) {
+	org, ok := findAuthorizedOrganisation(c, tc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	team, ok := tc.findTeam(c, org)
+	if !ok {
+		return
+	}
+
+	userId, _ := strconv.Atoi(c.Param("userId"))
+
+	var result *gorm.DB
+	err := tc.DB.Transaction(func(tx *gorm.DB) error {
+		result = tx.Exec("DELETE FROM team_members WHERE team_id = ? AND user_id = ?", team.ID, userId)
+		if result.Error != nil || result.RowsAffected < 1 {
+			return result.Error
+		}
+
+		return recordAudit(tx, c, models.AuditEvent{
+			OrganisationID: org.ID,
+			Action:         models.AuditTeamMemberRemoved,
+			TargetType:     models.AuditTargetTeam,
+			TargetID:       fmt.Sprintf("%d", team.ID),
+			Metadata:       map[string]interface{}{"userId": fmt.Sprintf("%d", userId)},
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+	if result.RowsAffected < 1 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":     http.StatusText(http.StatusNotFound),
+			"message":    "member not found",
+			"statusCode": http.StatusNotFound,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "User removed from team successfully",
+	})
+}
+
+// GetUserTeams lists the effective teams of a member of the organisation,
+// see effectiveTeams.
+func (tc *TeamController) GetUserTeams(c *gin.Context) {
+	org, ok := findAuthorizedOrganisation(c, tc.DB, models.ScopeOrgsRead)
+	if !ok {
+		return
+	}
+
+	userId, _ := strconv.Atoi(c.Param("userId"))
+	if userId < 1 || !isMember(tc.DB, uint(userId), org.ID) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":     http.StatusText(http.StatusNotFound),
+			"message":    "user not found",
+			"statusCode": http.StatusNotFound,
+		})
+		return
+	}
+
+	teams, err := effectiveTeams(tc.DB, org.ID, uint(userId))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": fmt.Sprintf("found %d team(s)", len(teams)),
+		"data": gin.H{
+			"teams": models.TeamsResponse(teams),
+		},
+	})
+}
+
+func (tc *TeamController) findTeam(c *gin.Context, org models.Organisation) (models.Team, bool) {
+	var team models.Team
+
+	teamId, _ := strconv.Atoi(c.Param("teamId"))
+	result := tc.DB.Where("organisation_id = ?", org.ID).Limit(1).Find(&team, teamId)
+	if teamId < 1 || result.Error != nil || result.RowsAffected < 1 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":     http.StatusText(http.StatusNotFound),
+			"message":    "team not found",
+			"statusCode": http.StatusNotFound,
+		})
+		return team, false
+	}
+
+	return team, true
+}
+
+// setParent nests the team under the team of the organisation with the ID
+// parentId, or moves it to the top of the tree if parentId is empty. A team
+// cannot be nested under itself or one of its sub-teams.
+func (tc *TeamController) setParent(c *gin.Context, team *models.Team, parentId string) bool {
+	if parentId == "" {
+		team.ParentID = nil
+		return true
+	}
+
+	invalid := func(message string) bool {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": []models.InputError{
+				{
+					Field:   "parentId",
+					Message: message,
+				},
+			},
+		})
+		return false
+	}
+
+	var teams []models.Team
+	if err := tc.DB.Where("organisation_id = ?", team.OrganisationID).Find(&teams).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return false
+	}
+	byId := map[uint]models.Team{}
+	for _, t := range teams {
+		byId[t.ID] = t
+	}
+
+	id, _ := strconv.Atoi(parentId)
+	parent, ok := byId[uint(id)]
+	if id < 1 || !ok {
+		return invalid("team not found")
+	}
+
+	// the tree has no cycles, so walking up from the new parent ends
+	for ancestor := &parent; ancestor != nil; {
+		if team.ID != 0 && ancestor.ID == team.ID {
+			return invalid("cannot nest a team under itself or its sub-teams")
+		}
+		if ancestor.ParentID == nil {
+			break
+		}
+		next := byId[*ancestor.ParentID]
+		ancestor = &next
+	}
+
+	team.ParentID = &parent.ID
+	return true
+}
+
+// effectiveTeams returns the teams of an organisation a user is a member of,
+// directly or as a member of a team nested under them.
+func effectiveTeams(db *gorm.DB, orgId, userId uint) ([]models.Team, error) {
+	var teams []models.Team
+	if err := db.Where("organisation_id = ?", orgId).Order("id").Find(&teams).Error; err != nil {
+		return nil, err
+	}
+
+	var direct []uint
+	err := db.Table("team_members").
+		Where("user_id = ? AND team_id IN (?)", userId, db.Model(&models.Team{}).Select("id").Where("organisation_id = ?", orgId)).
+		Pluck("team_id", &direct).Error
+	if err != nil {
+		return nil, err
+	}
+
+	parents := map[uint]*uint{}
+	for _, team := range teams {
+		parents[team.ID] = team.ParentID
+	}
+
+	effective := map[uint]bool{}
+	for _, id := range direct {
+		for next := &id; next != nil && !effective[*next]; next = parents[*next] {
+			effective[*next] = true
+		}
+	}
+
+	res := []models.Team{}
+	for _, team := range teams {
+		if effective[team.ID] {
+			res = append(res, team)
+		}
+	}
+
+	return res, nil
+}
+
+// teamAuditMetadata records the fields of a team that grant roles.
+func teamAuditMetadata(team models.Team) map[string]interface{} {
+	metadata := map[string]interface{}{
+		"name": team.Name,
+		"role": team.Role,
+	}
+	if team.ParentID != nil {
+		metadata["parentId"] = fmt.Sprintf("%d", *team.ParentID)
+	}
+	return metadata
+}
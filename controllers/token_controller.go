@@ -0,0 +1,158 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/codelikesuraj/hng11-task-two/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+type TokenController struct {
+	DB *gorm.DB
+}
+
+func NewTokenController(db *gorm.DB) *TokenController {
+	return &TokenController{DB: db}
+}
+
+func (tc *TokenController) Create(c *gin.Context) {
+	var params models.PersonalAccessTokenCreateParams
+	validate := validator.New(validator.WithRequiredStructEnabled())
+
+	if err := c.ShouldBind(&params); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	if err := validate.Struct(params); err != nil {
+		ve := err.(validator.ValidationErrors)
+		errors := make([]models.InputError, len(ve))
+		for i, fe := range ve {
+			errors[i] = models.InputError{
+				Field:   utils.GetJSONTagValue(params, fe.Field()),
+				Message: utils.GetValidationMessage(fe),
+			}
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": errors,
+		})
+		return
+	}
+
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	plainToken, err := utils.GenerateToken(models.PersonalAccessTokenPrefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	token := models.PersonalAccessToken{
+		UserID:    principal.User.ID,
+		Name:      params.Name,
+		TokenHash: utils.HashToken(plainToken),
+		Scopes:    strings.Join(params.Scopes, " "),
+		ExpiresAt: time.Now().AddDate(0, 0, params.ExpiresInDays),
+	}
+	if err := tc.DB.Create(&token).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	data := models.PersonalAccessTokenResponse(token)
+	data["token"] = plainToken
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"message": "Token created successfully",
+		"data":    data,
+	})
+}
+
+func (tc *TokenController) GetAll(c *gin.Context) {
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	var tokens []models.PersonalAccessToken
+
+	if err := tc.DB.Where("user_id = ?", principal.User.ID).Order("id").Find(&tokens).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": fmt.Sprintf("found %d token(s)", len(tokens)),
+		"data": gin.H{
+			"tokens": models.PersonalAccessTokensResponse(tokens),
+		},
+	})
+}
+
+func (tc *TokenController) Delete(c *gin.Context) {
+	tokenId, _ := strconv.Atoi(c.Param("tokenId"))
+	if tokenId < 1 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":     http.StatusText(http.StatusNotFound),
+			"message":    "token not found",
+			"statusCode": http.StatusNotFound,
+		})
+		return
+	}
+
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	result := tc.DB.Where("user_id = ?", principal.User.ID).Delete(&models.PersonalAccessToken{}, tokenId)
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+	if result.RowsAffected < 1 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":     http.StatusText(http.StatusNotFound),
+			"message":    "token not found",
+			"statusCode": http.StatusNotFound,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Token revoked successfully",
+	})
+}
@@ -1,9 +1,12 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/codelikesuraj/hng11-task-two/models"
 	"github.com/codelikesuraj/hng11-task-two/utils"
@@ -12,12 +15,16 @@ import (
 	"gorm.io/gorm"
 )
 
+// UserController registers and authenticates users. Mailer sends the links
+// users verify their email with.
 type UserController struct {
-	DB *gorm.DB
+	DB           *gorm.DB
+	Mailer       utils.Mailer
+	Registration *RegistrationPipeline
 }
 
-func NewUserController(db *gorm.DB) *UserController {
-	return &UserController{DB: db}
+func NewUserController(db *gorm.DB, mailer utils.Mailer, registration *RegistrationPipeline) *UserController {
+	return &UserController{DB: db, Mailer: mailer, Registration: registration}
 }
 
 func (uc *UserController) RegisterUser(c *gin.Context) {
@@ -86,7 +93,24 @@ func (uc *UserController) RegisterUser(c *gin.Context) {
 		return
 	}
 
-	newUser, err = registerUserWithOrg(uc.DB, newUser)
+	registration, err := uc.Registration.Register(uc.DB, Registration{
+		User:            newUser,
+		InvitationToken: user.InvitationToken,
+		IP:              c.ClientIP(),
+		UserAgent:       c.Request.UserAgent(),
+	})
+	var registrationErr *RegistrationError
+	if errors.As(err, &registrationErr) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": []models.InputError{
+				{
+					Field:   registrationErr.Field,
+					Message: registrationErr.Message,
+				},
+			},
+		})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status":     http.StatusText(http.StatusInternalServerError),
@@ -95,6 +119,24 @@ func (uc *UserController) RegisterUser(c *gin.Context) {
 		})
 		return
 	}
+	newUser = registration.User
+
+	// organisations that verified the user's email domain are offered to
+	// them, they can join once they verified their email
+	joinable, err := domainOrganisations(uc.DB, newUser, false)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+	if len(joinable) > 0 {
+		if err := uc.sendEmailVerification(newUser); err != nil {
+			log.Println("error sending email verification:", err)
+		}
+	}
 
 	token, err := utils.GenerateJWT(newUser)
 	if err != nil {
@@ -106,16 +148,145 @@ func (uc *UserController) RegisterUser(c *gin.Context) {
 		return
 	}
 
+	data := gin.H{
+		"accessToken": token,
+		"user":        models.UserResponse(newUser),
+	}
+	if len(joinable) > 0 {
+		data["joinableOrganisations"] = models.OrganisationsResponse(joinable)
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
 		"status":  "success",
 		"message": "Registration successful",
+		"data":    data,
+	})
+}
+
+// SendEmailVerification emails the authenticated user a link to verify their
+// email with.
+func (uc *UserController) SendEmailVerification(c *gin.Context) {
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	if principal.User.IsEmailVerified() {
+		c.JSON(http.StatusOK, gin.H{
+			"status":  "success",
+			"message": "Email is already verified",
+		})
+		return
+	}
+
+	if err := uc.sendEmailVerification(*principal.User); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    "verification email could not be sent",
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Verification email sent",
+	})
+}
+
+// VerifyEmail verifies the email of the user whose verification link was
+// followed, and adds them to the organisations of their email domain that let
+// users join automatically.
+func (uc *UserController) VerifyEmail(c *gin.Context) {
+	var verification models.EmailVerification
+	result := uc.DB.Where("token_hash = ?", utils.HashToken(c.Query("token"))).Preload("User").Limit(1).Find(&verification)
+	if c.Query("token") == "" || result.Error != nil || result.RowsAffected < 1 ||
+		time.Now().After(verification.ExpiresAt) || verification.User.ID == 0 || verification.User.Email != verification.Email {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":     http.StatusText(http.StatusBadRequest),
+			"message":    "invalid or expired token",
+			"statusCode": http.StatusBadRequest,
+		})
+		return
+	}
+
+	user := verification.User
+	var joined []models.Organisation
+	err := uc.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+		if err := tx.Model(&user).Update("email_verified_at", now).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(&models.EmailVerification{}).Error; err != nil {
+			return err
+		}
+
+		var err error
+		joined, err = joinDomainOrganisations(tx, user)
+		if err != nil {
+			return err
+		}
+
+		// the link is anonymous, but only the user could have followed it
+		for _, org := range joined {
+			err := recordAudit(tx, c, models.AuditEvent{
+				OrganisationID: org.ID,
+				ActorType:      models.AuditActorUser,
+				ActorID:        &user.ID,
+				Action:         models.AuditMemberAdded,
+				TargetType:     models.AuditTargetUser,
+				TargetID:       fmt.Sprintf("%d", user.ID),
+				Metadata:       map[string]interface{}{"via": "domain"},
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Email verified successfully",
 		"data": gin.H{
-			"accessToken": token,
-			"user":        models.UserResponse(newUser),
+			"user":                models.UserResponse(user),
+			"joinedOrganisations": models.OrganisationsResponse(joined),
 		},
 	})
 }
 
+func (uc *UserController) sendEmailVerification(user models.User) error {
+	token, err := utils.GenerateToken(models.EmailVerificationTokenPrefix)
+	if err != nil {
+		return err
+	}
+
+	err = uc.DB.Create(&models.EmailVerification{
+		UserID:    user.ID,
+		Email:     user.Email,
+		TokenHash: utils.HashToken(token),
+		ExpiresAt: time.Now().Add(models.EmailVerificationTTL),
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nPlease verify your email by opening the link below within 24 hours:\n\n%s/v1/auth/verify-email?token=%s\n\nIf you did not create an account, you can ignore this email.",
+		user.FirstName, utils.GetIssuer(), token)
+	return uc.Mailer.Send(user.Email, "Verify your email", body)
+}
+
 func (uc *UserController) LoginUser(c *gin.Context) {
 	var userParam models.UserLoginParams
 	validate := validator.New(validator.WithRequiredStructEnabled())
@@ -164,6 +335,34 @@ func (uc *UserController) LoginUser(c *gin.Context) {
 		return
 	}
 
+	if user.IsDisabled() {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":     "Bad request",
+			"message":    "Account is disabled",
+			"statusCode": http.StatusUnauthorized,
+		})
+		return
+	}
+
+	if loginURL, ok := ssoLoginURL(uc.DB, user); ok {
+		c.JSON(http.StatusForbidden, gin.H{
+			"status":     http.StatusText(http.StatusForbidden),
+			"message":    "Your organisation requires you to log in with SSO",
+			"statusCode": http.StatusForbidden,
+			"loginUrl":   loginURL,
+		})
+		return
+	}
+
+	if user.PasswordResetRequired {
+		c.JSON(http.StatusForbidden, gin.H{
+			"status":     http.StatusText(http.StatusForbidden),
+			"message":    "Password reset required",
+			"statusCode": http.StatusForbidden,
+		})
+		return
+	}
+
 	token, err := utils.GenerateJWT(user)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -184,6 +383,112 @@ func (uc *UserController) LoginUser(c *gin.Context) {
 	})
 }
 
+// ChangePassword lets a user replace their password, including when an admin
+// has forced a reset, and signs out their other sessions.
+func (uc *UserController) ChangePassword(c *gin.Context) {
+	var userParam models.UserChangePasswordParams
+	validate := validator.New(validator.WithRequiredStructEnabled())
+
+	if err := c.ShouldBind(&userParam); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	if err := validate.Struct(userParam); err != nil {
+		ve := err.(validator.ValidationErrors)
+		errors := make([]models.InputError, len(ve))
+		for i, fe := range ve {
+			errors[i] = models.InputError{
+				Field:   utils.GetJSONTagValue(userParam, fe.Field()),
+				Message: utils.GetValidationMessage(fe),
+			}
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": errors,
+		})
+		return
+	}
+
+	var user models.User
+	result := uc.DB.Where("email = ?", userParam.Email).Limit(1).Find(&user)
+	if err := result.Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	if result.RowsAffected < 1 || !utils.PasswordIsValid(user.Password, userParam.Password) || user.IsDisabled() {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"status":     "Bad request",
+			"message":    "Authentication failed",
+			"statusCode": http.StatusUnauthorized,
+		})
+		return
+	}
+
+	if loginURL, ok := ssoLoginURL(uc.DB, user); ok {
+		c.JSON(http.StatusForbidden, gin.H{
+			"status":     http.StatusText(http.StatusForbidden),
+			"message":    "Your organisation requires you to log in with SSO",
+			"statusCode": http.StatusForbidden,
+			"loginUrl":   loginURL,
+		})
+		return
+	}
+
+	passwordHash, err := utils.HashPassword(userParam.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	// bumping the session version signs out every other session
+	user.SessionVersion++
+	err = uc.DB.Model(&user).Updates(map[string]interface{}{
+		"password":                passwordHash,
+		"password_reset_required": false,
+		"session_version":         user.SessionVersion,
+	}).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	token, err := utils.GenerateJWT(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Password changed successfully",
+		"data": gin.H{
+			"accessToken": token,
+			"user":        models.UserResponse(user),
+		},
+	})
+}
+
 func (uc *UserController) GetUserById(c *gin.Context) {
 	userId, _ := strconv.Atoi(c.Param("id"))
 	if userId < 1 {
@@ -198,29 +503,23 @@ func (uc *UserController) GetUserById(c *gin.Context) {
 	var user models.User
 	user.ID = uint(userId)
 
-	tokenString, _ := utils.GetJWTFromRequest(c)
-	_, err := utils.GetUserFromJWT(tokenString)
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"status":     http.StatusText(http.StatusUnauthorized),
-			"message":    http.StatusText(http.StatusUnauthorized),
-			"statusCode": http.StatusUnauthorized,
+	result := uc.DB.Limit(1).Find(&user)
+	if result.RowsAffected < 1 || result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":     http.StatusText(http.StatusNotFound),
+			"message":    "user not found",
+			"statusCode": http.StatusNotFound,
 		})
 		return
 	}
 
-	// userJWTId, _ := strconv.ParseUint(userJWT["userId"].(string), 10, 64)
-	// if uint(userJWTId) != user.ID {
-	// 	c.JSON(http.StatusUnauthorized, gin.H{
-	// 		"status":     http.StatusText(http.StatusUnauthorized),
-	// 		"message":    http.StatusText(http.StatusUnauthorized),
-	// 		"statusCode": http.StatusUnauthorized,
-	// 	})
-	// 	return
-	// }
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
 
-	result := uc.DB.Limit(1).Find(&user)
-	if result.RowsAffected < 1 || result.Error != nil {
+	// service accounts can only see members of their organisation
+	if principal.IsServiceAccount() && !isMember(uc.DB, user.ID, principal.ServiceAccount.OrganisationID) {
 		c.JSON(http.StatusNotFound, gin.H{
 			"status":     http.StatusText(http.StatusNotFound),
 			"message":    "user not found",
@@ -235,30 +534,3 @@ func (uc *UserController) GetUserById(c *gin.Context) {
 		"data":    models.UserResponse(user),
 	})
 }
-
-func registerUserWithOrg(db *gorm.DB, user models.User) (models.User, error) {
-	err := db.Transaction(func(tx *gorm.DB) error {
-		// create user
-		if err := tx.Create(&user).Error; err != nil {
-			return err
-		}
-
-		// create organisation
-		org := models.Organisation{
-			Name:        fmt.Sprintf("%s's Organisation", user.FirstName),
-			CreatedByID: user.ID,
-		}
-		if err := tx.Create(&org).Error; err != nil {
-			return err
-		}
-
-		// add user to organisation
-		if err := tx.Model(&org).Association("Users").Append(&user); err != nil {
-			return err
-		}
-
-		return nil
-	})
-
-	return user, err
-}
@@ -0,0 +1,461 @@
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/codelikesuraj/hng11-task-two/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"gorm.io/gorm"
+)
+
+var webhookDeliverySorts = map[string]string{
+	"createdAt": "webhook_deliveries.created_at",
+}
+
+// WebhookController manages the webhooks of organisations, which only their
+// admins may see or change.
+type WebhookController struct {
+	DB *gorm.DB
+}
+
+func NewWebhookController(db *gorm.DB) *WebhookController {
+	return &WebhookController{DB: db}
+}
+
+func (wc *WebhookController) GetAll(c *gin.Context) {
+	org, ok := findAuthorizedOrganisation(c, wc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	var webhooks []models.Webhook
+	if err := wc.DB.Where("organisation_id = ?", org.ID).Order("id").Find(&webhooks).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": fmt.Sprintf("found %d webhook(s)", len(webhooks)),
+		"data": gin.H{
+			"webhooks": models.WebhooksResponse(webhooks),
+		},
+	})
+}
+
+func (wc *WebhookController) Get(c *gin.Context) {
+	org, ok := findAuthorizedOrganisation(c, wc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	webhook, ok := wc.findWebhook(c, org)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Webhook found",
+		"data":    models.WebhookResponse(webhook),
+	})
+}
+
+func (wc *WebhookController) Create(c *gin.Context) {
+	var params models.WebhookCreateParams
+	validate := validator.New(validator.WithRequiredStructEnabled())
+
+	if err := c.ShouldBind(&params); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	if err := validate.Struct(params); err != nil {
+		ve := err.(validator.ValidationErrors)
+		errors := make([]models.InputError, len(ve))
+		for i, fe := range ve {
+			errors[i] = models.InputError{
+				Field:   utils.GetJSONTagValue(params, fe.Field()),
+				Message: utils.GetValidationMessage(fe),
+			}
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": errors,
+		})
+		return
+	}
+
+	principal, ok := currentPrincipal(c)
+	if !ok {
+		return
+	}
+
+	org, ok := findAuthorizedOrganisation(c, wc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	secret, err := utils.GenerateToken(models.WebhookSecretPrefix)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	webhook := models.Webhook{
+		OrganisationID: org.ID,
+		URL:            params.URL,
+		Description:    params.Description,
+		Events:         strings.Join(params.Events, " "),
+		Secret:         secret,
+		Active:         true,
+	}
+	if principal.User != nil {
+		webhook.CreatedByID = &principal.User.ID
+	}
+
+	err = wc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&webhook).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, models.AuditEvent{
+			OrganisationID: org.ID,
+			Action:         models.AuditWebhookCreated,
+			TargetType:     models.AuditTargetWebhook,
+			TargetID:       fmt.Sprintf("%d", webhook.ID),
+			Metadata:       webhookAuditMetadata(webhook),
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	// the secret is only ever returned here, receivers check signatures with it
+	data := models.WebhookResponse(webhook)
+	data["secret"] = secret
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"message": "Webhook created successfully",
+		"data":    data,
+	})
+}
+
+func (wc *WebhookController) Update(c *gin.Context) {
+	var params models.WebhookUpdateParams
+	validate := validator.New(validator.WithRequiredStructEnabled())
+
+	if err := c.ShouldBind(&params); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    err.Error(),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	if err := validate.Struct(params); err != nil {
+		ve := err.(validator.ValidationErrors)
+		errors := make([]models.InputError, len(ve))
+		for i, fe := range ve {
+			errors[i] = models.InputError{
+				Field:   utils.GetJSONTagValue(params, fe.Field()),
+				Message: utils.GetValidationMessage(fe),
+			}
+		}
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": errors,
+		})
+		return
+	}
+
+	org, ok := findAuthorizedOrganisation(c, wc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	webhook, ok := wc.findWebhook(c, org)
+	if !ok {
+		return
+	}
+
+	if params.URL != nil {
+		webhook.URL = *params.URL
+	}
+	if params.Description != nil {
+		webhook.Description = *params.Description
+	}
+	if params.Events != nil {
+		webhook.Events = strings.Join(*params.Events, " ")
+	}
+	if params.Active != nil {
+		webhook.Active = *params.Active
+	}
+
+	err := wc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&webhook).Select("url", "description", "events", "active").Updates(&webhook).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, models.AuditEvent{
+			OrganisationID: org.ID,
+			Action:         models.AuditWebhookUpdated,
+			TargetType:     models.AuditTargetWebhook,
+			TargetID:       fmt.Sprintf("%d", webhook.ID),
+			Metadata:       webhookAuditMetadata(webhook),
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Webhook updated successfully",
+		"data":    models.WebhookResponse(webhook),
+	})
+}
+
+// Delete removes a webhook. Its pending deliveries fail, its delivery log is
+// kept.
+func (wc *WebhookController) Delete(c *gin.Context) {
+	org, ok := findAuthorizedOrganisation(c, wc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	webhook, ok := wc.findWebhook(c, org)
+	if !ok {
+		return
+	}
+
+	err := wc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(&webhook).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, models.AuditEvent{
+			OrganisationID: org.ID,
+			Action:         models.AuditWebhookDeleted,
+			TargetType:     models.AuditTargetWebhook,
+			TargetID:       fmt.Sprintf("%d", webhook.ID),
+			Metadata:       map[string]interface{}{"url": webhook.URL},
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Webhook deleted successfully",
+	})
+}
+
+// GetDeliveries lists the delivery log of a webhook a page at a time, newest
+// first by default, optionally only the deliveries with a status.
+func (wc *WebhookController) GetDeliveries(c *gin.Context) {
+	org, ok := findAuthorizedOrganisation(c, wc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	webhook, ok := wc.findWebhook(c, org)
+	if !ok {
+		return
+	}
+
+	page, errors := utils.ParsePagination(c, "webhook_deliveries", webhookDeliverySorts, "-createdAt")
+	if errors != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"errors": errors,
+		})
+		return
+	}
+
+	query := wc.DB.Model(&models.WebhookDelivery{}).Where("webhook_deliveries.webhook_id = ?", webhook.ID)
+	if status := c.Query("status"); status != "" {
+		query = query.Where("webhook_deliveries.status = ?", status)
+	}
+
+	deliveries, pageInfo, err := utils.Paginate[models.WebhookDelivery](query, page)
+	if err == utils.ErrStaleCursor {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"status":     http.StatusText(http.StatusBadRequest),
+			"message":    err.Error(),
+			"statusCode": http.StatusBadRequest,
+		})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": fmt.Sprintf("found %d deliveries", len(deliveries)),
+		"data": gin.H{
+			"deliveries": models.WebhookDeliveriesResponse(deliveries),
+			"pagination": pageInfo,
+		},
+	})
+}
+
+func (wc *WebhookController) GetDelivery(c *gin.Context) {
+	org, ok := findAuthorizedOrganisation(c, wc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	delivery, ok := wc.findDelivery(c, org)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "success",
+		"message": "Delivery found",
+		"data":    models.WebhookDeliveryResponse(delivery),
+	})
+}
+
+// Replay sends the event of a delivery again, as a new delivery due right
+// away, whatever became of the original.
+func (wc *WebhookController) Replay(c *gin.Context) {
+	org, ok := findAuthorizedOrganisation(c, wc.DB, models.ScopeOrgsWrite)
+	if !ok {
+		return
+	}
+
+	delivery, ok := wc.findDelivery(c, org)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	replay := models.WebhookDelivery{
+		WebhookID:     delivery.WebhookID,
+		EventID:       delivery.EventID,
+		Event:         delivery.Event,
+		Payload:       delivery.Payload,
+		Status:        models.WebhookDeliveryPending,
+		NextAttemptAt: &now,
+		ReplayOfID:    &delivery.ID,
+	}
+
+	err := wc.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&replay).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, models.AuditEvent{
+			OrganisationID: org.ID,
+			Action:         models.AuditWebhookDeliveryReplayed,
+			TargetType:     models.AuditTargetWebhook,
+			TargetID:       fmt.Sprintf("%d", delivery.WebhookID),
+			Metadata: map[string]interface{}{
+				"deliveryId": fmt.Sprintf("%d", delivery.ID),
+				"eventId":    delivery.EventID,
+			},
+		})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status":     http.StatusText(http.StatusInternalServerError),
+			"message":    http.StatusText(http.StatusInternalServerError),
+			"statusCode": http.StatusInternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "success",
+		"message": "Delivery queued for replay",
+		"data":    models.WebhookDeliveryResponse(replay),
+	})
+}
+
+func (wc *WebhookController) findWebhook(c *gin.Context, org models.Organisation) (models.Webhook, bool) {
+	var webhook models.Webhook
+
+	webhookId, _ := strconv.Atoi(c.Param("webhookId"))
+	result := wc.DB.Where("organisation_id = ?", org.ID).Limit(1).Find(&webhook, webhookId)
+	if webhookId < 1 || result.RowsAffected < 1 || result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":     http.StatusText(http.StatusNotFound),
+			"message":    "webhook not found",
+			"statusCode": http.StatusNotFound,
+		})
+		return webhook, false
+	}
+
+	return webhook, true
+}
+
+func (wc *WebhookController) findDelivery(c *gin.Context, org models.Organisation) (models.WebhookDelivery, bool) {
+	var delivery models.WebhookDelivery
+
+	webhook, ok := wc.findWebhook(c, org)
+	if !ok {
+		return delivery, false
+	}
+
+	deliveryId, _ := strconv.Atoi(c.Param("deliveryId"))
+	result := wc.DB.Where("webhook_id = ?", webhook.ID).Limit(1).Find(&delivery, deliveryId)
+	if deliveryId < 1 || result.RowsAffected < 1 || result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{
+			"status":     http.StatusText(http.StatusNotFound),
+			"message":    "delivery not found",
+			"statusCode": http.StatusNotFound,
+		})
+		return delivery, false
+	}
+
+	return delivery, true
+}
+
+func webhookAuditMetadata(webhook models.Webhook) map[string]interface{} {
+	return map[string]interface{}{
+		"url":    webhook.URL,
+		"events": webhook.EventList(),
+		"active": webhook.Active,
+	}
+}
@@ -0,0 +1,9 @@
+// Package docs holds the OpenAPI document describing the API.
+package docs
+
+import _ "embed"
+
+// OpenAPI is the OpenAPI 3.1 document of every route of the API, in JSON.
+//
+//go:embed openapi.json
+var OpenAPI []byte
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/codelikesuraj/hng11-task-two/controllers"
+	"github.com/codelikesuraj/hng11-task-two/identitypb"
+	"github.com/codelikesuraj/hng11-task-two/middlewares"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	reflectionv1alphapb "google.golang.org/grpc/reflection/grpc_reflection_v1alpha"
+	"gorm.io/gorm"
+)
+
+const defaultGRPCPort = "9090"
+
+// serveGRPC serves the gRPC API on GRPC_PORT until ctx is done, alongside
+// the HTTP API. wg is done once the calls in flight have finished.
+func serveGRPC(ctx context.Context, wg *sync.WaitGroup, db *gorm.DB) {
+	port := os.Getenv("GRPC_PORT")
+	if port == "" {
+		port = defaultGRPCPort
+	}
+
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Fatal("error listening for gRPC:", err)
+	}
+
+	server, healthServer := newGRPCServer(db)
+	go func() {
+		if err := server.Serve(lis); err != nil {
+			log.Println("error serving gRPC:", err)
+		}
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		<-ctx.Done()
+		// tell load balancers to stop sending calls before draining them
+		healthServer.Shutdown()
+		server.GracefulStop()
+	}()
+}
+
+// newGRPCServer returns the gRPC server with the Identity service, health
+// checking and reflection registered, the last two being served without
+// authentication.
+func newGRPCServer(db *gorm.DB) (*grpc.Server, *health.Server) {
+	public := []string{
+		healthpb.Health_ServiceDesc.ServiceName,
+		reflectionpb.ServerReflection_ServiceDesc.ServiceName,
+		reflectionv1alphapb.ServerReflection_ServiceDesc.ServiceName,
+	}
+	server := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(middlewares.GRPCAuth(db, public...)),
+		grpc.ChainStreamInterceptor(middlewares.GRPCStreamAuth(db, public...)),
+	)
+
+	identitypb.RegisterIdentityServer(server, controllers.NewIdentityServer(db))
+
+	healthServer := health.NewServer()
+	healthServer.SetServingStatus(identitypb.Identity_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(server, healthServer)
+
+	reflection.Register(server)
+
+	return server, healthServer
+}
@@ -0,0 +1,5 @@
+// Package identitypb holds the protobuf definitions of the gRPC API and the
+// Go code generated from them.
+package identitypb
+
+//go:generate protoc -I .. --go_out=.. --go_opt=paths=source_relative --go-grpc_out=.. --go-grpc_opt=paths=source_relative ../identitypb/identity.proto
@@ -1,17 +1,26 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
+	"net"
+	"net/http"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/codelikesuraj/hng11-task-two/controllers"
-	"github.com/codelikesuraj/hng11-task-two/middlewares"
 	"github.com/codelikesuraj/hng11-task-two/models"
 	"github.com/codelikesuraj/hng11-task-two/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 )
 
+const defaultShutdownTimeout = 5 * time.Second
+
 func init() {
 	godotenv.Load()
 }
@@ -22,21 +31,97 @@ func main() {
 	if err != nil {
 		log.Fatal("error connecting to database:", err)
 	}
-	db.AutoMigrate(&models.Organisation{}, models.User{})
+	db.AutoMigrate(&models.Organisation{}, models.User{}, models.PersonalAccessToken{}, models.ServiceAccount{}, models.APIKey{}, models.UserIdentity{}, models.OIDCLoginState{}, models.OAuthClient{}, models.OAuthAuthorizationCode{}, models.OAuthConsent{}, models.OAuthToken{}, models.OrganisationDomain{}, models.SAMLConfig{}, models.SAMLRequest{}, models.SCIMToken{}, models.SCIMUser{}, models.SCIMGroup{}, models.EmailVerification{}, models.Invitation{}, models.Team{}, models.AuditEvent{}, models.AuditCheckpoint{}, models.Webhook{}, models.WebhookDelivery{}, models.OutboxEvent{}, models.Job{}, models.Notification{}, models.NotificationPreference{})
+	if err := utils.CreateSearchIndexes(db); err != nil {
+		log.Fatal("error creating search indexes:", err)
+	}
+	if err := utils.CreateAuditTriggers(db); err != nil {
+		log.Fatal("error creating audit triggers:", err)
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "verify-audit" {
+		os.Exit(verifyAudit(db, os.Args[2:], os.Stdout))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "requeue-jobs" {
+		os.Exit(requeueJobs(db, os.Args[2:], os.Stdout))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "grant-admin" {
+		os.Exit(grantAdmin(db, os.Args[2:], os.Stdout))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	// the background work and the gRPC server, waited for on shutdown
+	var wg sync.WaitGroup
+	bus := utils.NewEventBus()
+	if len(os.Args) > 1 && os.Args[1] == "worker" {
+		startWorker(ctx, &wg, db, utils.NewMailer(), bus)
+		<-ctx.Done()
+		stop()
+		wg.Wait()
+		return
+	}
+	if os.Getenv("RUN_WORKER") != "false" {
+		startWorker(ctx, &wg, db, utils.NewMailer(), bus)
+	}
+	listenForEvents(ctx, db, bus)
+
+	socialProviders, err := utils.NewSocialProviders(utils.GetOIDCProviderConfigs())
+	if err != nil {
+		log.Fatal("error configuring social login:", err)
+	}
+
+	// emails are sent by the worker
+	mailer := utils.NewQueuedMailer(db)
 
-	OrganisationController := controllers.NewOrganisationController(db)
-	UserController := controllers.NewUserController(db)
+	registration, err := controllers.NewRegistrationPipeline(os.Getenv("REGISTRATION_ORGANISATION"))
+	if err != nil {
+		log.Fatal("error configuring registration:", err)
+	}
 
 	router := gin.Default()
-	router.GET("/", controllers.Home)
-	router.Group("/auth").
-		POST("/register", UserController.RegisterUser).
-		POST("/login", UserController.LoginUser)
-	router.Group("/api", middlewares.Auth()).
-		GET("/users/:id", UserController.GetUserById).
-		GET("/organisations/:orgId", OrganisationController.GetOrganisationById).
-		GET("/organisations", OrganisationController.GetAll).
-		POST("/organisations", OrganisationController.Create).
-		POST("/organisations/:orgId/users", OrganisationController.AddUser)
-	router.Run(":" + os.Getenv("PORT"))
+	registerRoutes(router, routeDependencies{
+		DB:              db,
+		Mailer:          mailer,
+		Registration:    registration,
+		SocialProviders: socialProviders,
+		Resolver:        net.DefaultResolver,
+		Bus:             bus,
+	})
+
+	serveGRPC(ctx, &wg, db)
+
+	server := &http.Server{Addr: ":" + os.Getenv("PORT"), Handler: router}
+	go func() {
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("error serving HTTP:", err)
+		}
+	}()
+
+	<-ctx.Done()
+	// a second signal kills the process
+	stop()
+	shutdown(server)
+	wg.Wait()
+}
+
+// shutdown stops server from accepting requests and waits up to
+// SHUTDOWN_TIMEOUT for those in flight, closing the connections left after
+// it, such as those of event streams.
+func shutdown(server *http.Server) {
+	timeout := defaultShutdownTimeout
+	if v := os.Getenv("SHUTDOWN_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatal("invalid SHUTDOWN_TIMEOUT:", v)
+		}
+		timeout = d
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Println("error shutting down HTTP:", err)
+		server.Close()
+	}
 }
@@ -1,24 +1,60 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	crand "crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"io"
 	"log"
+	"math/big"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/codelikesuraj/hng11-task-two/client"
 	"github.com/codelikesuraj/hng11-task-two/controllers"
+	"github.com/codelikesuraj/hng11-task-two/docs"
+	"github.com/codelikesuraj/hng11-task-two/identitypb"
 	"github.com/codelikesuraj/hng11-task-two/middlewares"
 	"github.com/codelikesuraj/hng11-task-two/models"
 	"github.com/codelikesuraj/hng11-task-two/utils"
+	"github.com/crewjam/saml"
 	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
 	"github.com/joho/godotenv"
+	"github.com/oauth2-proxy/mockoidc"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
 	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
 type RegisterSuccessResponse struct {
@@ -44,6 +80,16 @@ const (
 var (
 	db     *gorm.DB
 	router *gin.Engine
+
+	// socialProviders is filled in by tests that run a mock provider
+	socialProviders = map[string]utils.SocialProvider{}
+
+	mailer   = &testMailer{}
+	resolver = testResolver{}
+	bus      = utils.NewEventBus()
+
+	// registration is the default pipeline, tests may swap its hooks
+	registration, _ = controllers.NewRegistrationPipeline(controllers.RegistrationOrganisationPersonal)
 )
 
 func RandStringBytes(n int) string {
@@ -78,30 +124,29 @@ func init() {
 	if err != nil {
 		log.Fatal("error connecting to database:", err)
 	}
-	db.AutoMigrate(&models.Organisation{}, models.User{})
+	db.AutoMigrate(&models.Organisation{}, models.User{}, models.PersonalAccessToken{}, models.ServiceAccount{}, models.APIKey{}, models.UserIdentity{}, models.OIDCLoginState{}, models.OAuthClient{}, models.OAuthAuthorizationCode{}, models.OAuthConsent{}, models.OAuthToken{}, models.OrganisationDomain{}, models.SAMLConfig{}, models.SAMLRequest{}, models.SCIMToken{}, models.SCIMUser{}, models.SCIMGroup{}, models.EmailVerification{}, models.Invitation{}, models.Team{}, models.AuditEvent{}, models.AuditCheckpoint{}, models.Webhook{}, models.WebhookDelivery{}, models.OutboxEvent{}, models.Job{}, models.Notification{}, models.NotificationPreference{})
+	if err := utils.CreateSearchIndexes(db); err != nil {
+		log.Fatal("error creating search indexes:", err)
+	}
+	if err := utils.CreateAuditTriggers(db); err != nil {
+		log.Fatal("error creating audit triggers:", err)
+	}
 
 	router = setupRouter()
 }
 
 func setupRouter() *gin.Engine {
-	userController := controllers.UserController{DB: db}
-	organisationController := controllers.OrganisationController{DB: db}
-
 	router := gin.New()
-	router.GET("/", controllers.Home)
-	authRoutes := router.Group("/auth")
-	{
-		authRoutes.POST("/register", userController.RegisterUser)
-		authRoutes.POST("/login", userController.LoginUser)
-	}
-	apiRoutes := router.Group("/api", middlewares.Auth())
-	{
-		apiRoutes.GET("/users/:id", userController.GetUserById)
-		apiRoutes.GET("/organisations/:orgId", organisationController.GetOrganisationById)
-		apiRoutes.GET("/organisations", organisationController.GetAll)
-		apiRoutes.POST("/organisations", organisationController.Create)
-		apiRoutes.POST("/organisations/:orgId/users", organisationController.AddUser)
-	}
+	// every response of the tests is checked against the OpenAPI document
+	router.Use(validateResponses)
+	registerRoutes(router, routeDependencies{
+		DB:              db,
+		Mailer:          mailer,
+		Registration:    registration,
+		SocialProviders: socialProviders,
+		Resolver:        resolver,
+		Bus:             bus,
+	})
 	return router
 }
 
@@ -292,3 +337,5087 @@ func TestAPIRoutes(t *testing.T) {
 		}
 	})
 }
+
+func TestPersonalAccessTokens(t *testing.T) {
+	var resp RegisterSuccessResponse
+
+	registerParamsJSON, _ := json.Marshal(map[string]string{
+		"firstName": GenerateRandomString(10),
+		"lastName":  GenerateRandomString(10),
+		"email":     GenerateRandomEmail(),
+		"phone":     GenerateRandomNumber(),
+		"password":  GenerateRandomString(8),
+	})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/auth/register", bytes.NewBuffer(registerParamsJSON))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	err := json.NewDecoder(w.Body).Decode(&resp)
+	require.Nil(t, err, err)
+
+	testToken := resp.Data.AccessToken
+
+	t.Run("test user cannot create token on validation error", func(t *testing.T) {
+		tokenParamsJSON, _ := json.Marshal(map[string]interface{}{
+			"name":          "ci",
+			"scopes":        []string{"orgs:delete"},
+			"expiresInDays": 30,
+		})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/api/tokens", bytes.NewBuffer(tokenParamsJSON))
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code, w.Body.String())
+	})
+
+	var tokenResp struct {
+		Data struct {
+			TokenID string   `json:"tokenId"`
+			Token   string   `json:"token"`
+			Scopes  []string `json:"scopes"`
+		} `json:"data"`
+	}
+
+	tokenParamsJSON, _ := json.Marshal(map[string]interface{}{
+		"name":          "ci",
+		"scopes":        []string{"orgs:read"},
+		"expiresInDays": 30,
+	})
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/api/tokens", bytes.NewBuffer(tokenParamsJSON))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	err = json.NewDecoder(w.Body).Decode(&tokenResp)
+	require.Nil(t, err, err)
+	require.NotEmpty(t, tokenResp.Data.Token)
+
+	pat := tokenResp.Data.Token
+
+	t.Run("test token is only shown once", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/tokens", nil)
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.NotContains(t, w.Body.String(), pat)
+	})
+
+	t.Run("test token can access routes within its scopes", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/api/organisations", nil)
+		req.Header.Set("Authorization", "Bearer "+pat)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	})
+
+	t.Run("test token cannot access routes outside its scopes", func(t *testing.T) {
+		testCases := []struct {
+			method, url string
+		}{
+			{"GET", "/api/users/1"},
+			{"POST", "/api/organisations"},
+			{"GET", "/api/tokens"},
+			{"POST", "/api/tokens"},
+		}
+
+		for _, testCase := range testCases {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(testCase.method, testCase.url, nil)
+			req.Header.Set("Authorization", "Bearer "+pat)
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusForbidden, w.Code, fmt.Sprintln(w.Body.String(), ":", testCase.url))
+		}
+	})
+
+	t.Run("test revoked token cannot access routes", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("DELETE", "/api/tokens/"+tokenResp.Data.TokenID, nil)
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/api/organisations", nil)
+		req.Header.Set("Authorization", "Bearer "+pat)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, w.Body.String())
+	})
+}
+
+func TestServiceAccounts(t *testing.T) {
+	var resp RegisterSuccessResponse
+	var orgsResp struct {
+		Data struct {
+			Organisations []struct {
+				OrgID string `json:"orgId"`
+			} `json:"organisations"`
+		} `json:"data"`
+	}
+
+	registerParamsJSON, _ := json.Marshal(map[string]string{
+		"firstName": GenerateRandomString(10),
+		"lastName":  GenerateRandomString(10),
+		"email":     GenerateRandomEmail(),
+		"phone":     GenerateRandomNumber(),
+		"password":  GenerateRandomString(8),
+	})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/auth/register", bytes.NewBuffer(registerParamsJSON))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	err := json.NewDecoder(w.Body).Decode(&resp)
+	require.Nil(t, err, err)
+
+	testToken := resp.Data.AccessToken
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/api/organisations", nil)
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	err = json.NewDecoder(w.Body).Decode(&orgsResp)
+	require.Nil(t, err, err)
+	require.Len(t, orgsResp.Data.Organisations, 1)
+
+	orgURL := "/api/organisations/" + orgsResp.Data.Organisations[0].OrgID
+
+	var accountResp struct {
+		Data struct {
+			ServiceAccountID string `json:"serviceAccountId"`
+		} `json:"data"`
+	}
+
+	accountParamsJSON, _ := json.Marshal(map[string]string{
+		"name": "billing",
+		"role": "member",
+	})
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", orgURL+"/service-accounts", bytes.NewBuffer(accountParamsJSON))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	err = json.NewDecoder(w.Body).Decode(&accountResp)
+	require.Nil(t, err, err)
+
+	keysURL := orgURL + "/service-accounts/" + accountResp.Data.ServiceAccountID + "/keys"
+
+	var keyResp struct {
+		Data struct {
+			KeyID string `json:"keyId"`
+			Key   string `json:"key"`
+		} `json:"data"`
+	}
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", keysURL, nil)
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	err = json.NewDecoder(w.Body).Decode(&keyResp)
+	require.Nil(t, err, err)
+	require.NotEmpty(t, keyResp.Data.Key)
+
+	apiKey := keyResp.Data.Key
+
+	t.Run("test service account is limited to its organisation", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", orgURL, nil)
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/api/organisations/"+GenerateRandomNumber(), nil)
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/api/users/"+resp.Data.User.UserID, nil)
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	})
+
+	t.Run("test service account is limited by its role", func(t *testing.T) {
+		testCases := []struct {
+			method, url string
+		}{
+			{"POST", "/api/organisations"},
+			{"POST", orgURL + "/users"},
+			{"GET", "/api/tokens"},
+			{"GET", orgURL + "/service-accounts"},
+		}
+
+		for _, testCase := range testCases {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(testCase.method, testCase.url, nil)
+			req.Header.Set("Authorization", "Bearer "+apiKey)
+			router.ServeHTTP(w, req)
+			assert.Equal(t, http.StatusForbidden, w.Code, fmt.Sprintln(w.Body.String(), ":", testCase.url))
+		}
+	})
+
+	t.Run("test members cannot manage service accounts", func(t *testing.T) {
+		member, _ := RegisterRandomUser(t)
+		w := DoRequest("POST", orgURL+"/users", testToken, map[string]string{"userId": member.Data.User.UserID})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = DoRequest("POST", orgURL+"/service-accounts", member.Data.AccessToken, map[string]string{"name": "escalate", "role": "admin"})
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+
+		testCases := []struct {
+			method, url string
+		}{
+			{"GET", orgURL + "/service-accounts"},
+			{"POST", keysURL},
+			{"POST", keysURL + "/" + keyResp.Data.KeyID + "/rotate"},
+			{"DELETE", keysURL + "/" + keyResp.Data.KeyID},
+			{"DELETE", orgURL + "/service-accounts/" + accountResp.Data.ServiceAccountID},
+		}
+		for _, testCase := range testCases {
+			w := DoRequest(testCase.method, testCase.url, member.Data.AccessToken, nil)
+			assert.Equal(t, http.StatusForbidden, w.Code, fmt.Sprintln(w.Body.String(), ":", testCase.url))
+		}
+	})
+
+	t.Run("test rotated key replaces the old key", func(t *testing.T) {
+		var rotateResp struct {
+			Data struct {
+				Key string `json:"key"`
+			} `json:"data"`
+		}
+
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", keysURL+"/"+keyResp.Data.KeyID+"/rotate", nil)
+		req.Header.Set("Authorization", "Bearer "+testToken)
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+		err := json.NewDecoder(w.Body).Decode(&rotateResp)
+		require.Nil(t, err, err)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/api/organisations", nil)
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, w.Body.String())
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/api/organisations", nil)
+		req.Header.Set("Authorization", "Bearer "+rotateResp.Data.Key)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), "found 1 organisation(s)")
+	})
+}
+
+func TestJWT(t *testing.T) {
+	user := models.User{Model: gorm.Model{ID: 42}, FirstName: "John", Email: "john@example.com"}
+
+	t.Run("test token contains the user", func(t *testing.T) {
+		token, err := utils.GenerateJWT(user)
+		require.Nil(t, err, err)
+
+		claims, err := utils.ParseJWT(token)
+		require.Nil(t, err, err)
+		assert.InDelta(t, time.Now().Add(time.Hour).Unix(), claims["exp"], 5)
+
+		userId, err := utils.GetUserIDFromClaims(claims)
+		require.Nil(t, err, err)
+		assert.Equal(t, user.ID, userId)
+	})
+
+	t.Run("test expired token is rejected", func(t *testing.T) {
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"id":  user.ID,
+			"exp": time.Now().Add(-time.Minute).Unix(),
+		}).SignedString([]byte(os.Getenv("JWT_SECRET")))
+
+		_, err := utils.ParseJWT(token)
+		assert.EqualError(t, err, "expired token")
+	})
+
+	t.Run("test malformed claims are rejected", func(t *testing.T) {
+		token, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"id":  "42",
+			"exp": time.Now().Add(time.Minute).Unix(),
+		}).SignedString([]byte(os.Getenv("JWT_SECRET")))
+
+		claims, err := utils.ParseJWT(token)
+		require.Nil(t, err, err)
+
+		_, err = utils.GetUserIDFromClaims(claims)
+		assert.EqualError(t, err, "invalid token")
+	})
+
+	t.Run("test token of deleted user cannot access routes", func(t *testing.T) {
+		var resp RegisterSuccessResponse
+
+		registerParamsJSON, _ := json.Marshal(map[string]string{
+			"firstName": GenerateRandomString(10),
+			"lastName":  GenerateRandomString(10),
+			"email":     GenerateRandomEmail(),
+			"phone":     GenerateRandomNumber(),
+			"password":  GenerateRandomString(8),
+		})
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/auth/register", bytes.NewBuffer(registerParamsJSON))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+		err := json.NewDecoder(w.Body).Decode(&resp)
+		require.Nil(t, err, err)
+
+		userId, _ := strconv.Atoi(resp.Data.User.UserID)
+		require.Nil(t, db.Delete(&models.User{}, userId).Error)
+
+		w = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/api/organisations", nil)
+		req.Header.Set("Authorization", "Bearer "+resp.Data.AccessToken)
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, w.Body.String())
+	})
+}
+
+func RegisterRandomUser(t *testing.T) (RegisterSuccessResponse, map[string]string) {
+	var resp RegisterSuccessResponse
+
+	params := map[string]string{
+		"firstName": GenerateRandomString(10),
+		"lastName":  GenerateRandomString(10),
+		"email":     GenerateRandomEmail(),
+		"phone":     GenerateRandomNumber(),
+		"password":  GenerateRandomString(8),
+	}
+	registerParamsJSON, _ := json.Marshal(params)
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/auth/register", bytes.NewBuffer(registerParamsJSON))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	err := json.NewDecoder(w.Body).Decode(&resp)
+	require.Nil(t, err, err)
+
+	return resp, params
+}
+
+func DoRequest(method, url, token string, body interface{}) *httptest.ResponseRecorder {
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, url, &buf)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminRoutes(t *testing.T) {
+	var out bytes.Buffer
+	admin, adminParams := RegisterRandomUser(t)
+	require.Equal(t, 0, grantAdmin(db, []string{strings.ToUpper(adminParams["email"])}, &out), out.String())
+	adminToken := admin.Data.AccessToken
+
+	user, userParams := RegisterRandomUser(t)
+	userURL := "/admin/users/" + user.Data.User.UserID
+	login := map[string]string{"email": userParams["email"], "password": userParams["password"]}
+
+	t.Run("test grant-admin command", func(t *testing.T) {
+		var out bytes.Buffer
+		assert.Equal(t, 2, grantAdmin(db, nil, &out))
+		assert.Equal(t, 1, grantAdmin(db, []string{GenerateRandomEmail()}, &out))
+
+		var granted models.User
+		require.Nil(t, db.First(&granted, admin.Data.User.UserID).Error)
+		assert.True(t, granted.IsAdmin)
+	})
+
+	t.Run("test non admin cannot access admin routes", func(t *testing.T) {
+		w := DoRequest("GET", "/admin/users", user.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+	})
+
+	t.Run("test admin can search users", func(t *testing.T) {
+		w := DoRequest("GET", "/admin/users?q="+userParams["email"], adminToken, nil)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), `"total":1`)
+		assert.Contains(t, w.Body.String(), userParams["email"])
+
+		// wildcards in the search term match themselves
+		w = DoRequest("GET", "/admin/users?q=%25", adminToken, nil)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), `"total":0`)
+
+		w = DoRequest("GET", "/admin/users?limit=1&sort=-createdAt", adminToken, nil)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), `"nextCursor"`)
+
+		w = DoRequest("GET", "/admin/users?sort=password", adminToken, nil)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code, w.Body.String())
+
+		w = DoRequest("GET", userURL+"/organisations", adminToken, nil)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), userParams["firstName"]+"'s Organisation")
+	})
+
+	t.Run("test disabled user cannot login or access routes", func(t *testing.T) {
+		w := DoRequest("POST", userURL+"/disable", adminToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = DoRequest("GET", "/api/organisations", user.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, w.Body.String())
+
+		w = DoRequest("POST", "/auth/login", "", login)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, w.Body.String())
+
+		w = DoRequest("POST", userURL+"/enable", adminToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		// sessions from before the account was disabled stay signed out
+		w = DoRequest("GET", "/api/organisations", user.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, w.Body.String())
+
+		w = DoRequest("POST", "/auth/login", "", login)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	})
+
+	t.Run("test forced reset revokes tokens", func(t *testing.T) {
+		var session RegisterSuccessResponse
+		var tokenResp struct {
+			Data struct {
+				Token string `json:"token"`
+			} `json:"data"`
+		}
+		var orgsResp struct {
+			Data struct {
+				Organisations []struct {
+					OrgID string `json:"orgId"`
+				} `json:"organisations"`
+			} `json:"data"`
+		}
+
+		w := DoRequest("POST", "/auth/login", "", login)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&session))
+
+		w = DoRequest("POST", "/api/tokens", session.Data.AccessToken, map[string]interface{}{"name": "ci", "scopes": []string{"orgs:read"}, "expiresInDays": 30})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&tokenResp))
+
+		w = DoRequest("GET", "/api/organisations", tokenResp.Data.Token, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&orgsResp))
+		orgId, _ := strconv.Atoi(orgsResp.Data.Organisations[0].OrgID)
+
+		userId, _ := strconv.Atoi(user.Data.User.UserID)
+		uid := uint(userId)
+		client := models.OAuthClient{OrganisationID: uint(orgId), Name: "app", ClientID: GenerateRandomString(16)}
+		require.Nil(t, db.Create(&client).Error)
+		oauthToken := models.OAuthToken{TokenHash: utils.HashToken(GenerateRandomString(32)), Type: "access", ClientID: client.ID, UserID: &uid, ExpiresAt: time.Now().Add(time.Hour)}
+		require.Nil(t, db.Create(&oauthToken).Error)
+
+		w = DoRequest("POST", userURL+"/force-password-reset", adminToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = DoRequest("GET", "/api/organisations", tokenResp.Data.Token, nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, w.Body.String())
+
+		require.Nil(t, db.First(&oauthToken, oauthToken.ID).Error)
+		assert.NotNil(t, oauthToken.RevokedAt)
+	})
+
+	t.Run("test user must change password after forced reset", func(t *testing.T) {
+		w := DoRequest("POST", userURL+"/force-password-reset", adminToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = DoRequest("POST", "/auth/login", "", login)
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+
+		newPassword := GenerateRandomString(8)
+		w = DoRequest("POST", "/auth/change-password", "", map[string]string{
+			"email":       login["email"],
+			"password":    login["password"],
+			"newPassword": newPassword,
+		})
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = DoRequest("POST", "/auth/login", "", map[string]string{"email": login["email"], "password": newPassword})
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	})
+
+	t.Run("test admin cannot disable themselves", func(t *testing.T) {
+		w := DoRequest("POST", "/admin/users/"+admin.Data.User.UserID+"/disable", adminToken, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+	})
+}
+
+func TestOIDCLogin(t *testing.T) {
+	m, err := mockoidc.Run()
+	require.Nil(t, err, err)
+	defer m.Shutdown()
+
+	cfg := m.Config()
+	providers, err := utils.NewSocialProviders([]utils.OIDCProviderConfig{{
+		Name:         "mock",
+		Type:         "oidc",
+		Issuer:       cfg.Issuer,
+		ClientID:     cfg.ClientID,
+		ClientSecret: cfg.ClientSecret,
+		RedirectURL:  "http://localhost/auth/oidc/mock/callback",
+	}})
+	require.Nil(t, err, err)
+	socialProviders["mock"] = providers["mock"]
+	defer delete(socialProviders, "mock")
+
+	// loginAs walks through the provider's consent redirect and returns the
+	// callback URL it sends the browser back to
+	loginAs := func(t *testing.T, user *mockoidc.MockUser, invitationToken string) string {
+		m.QueueUser(user)
+
+		w := DoRequest("GET", "/auth/oidc/mock/login?invitationToken="+url.QueryEscape(invitationToken), "", nil)
+		require.Equal(t, http.StatusFound, w.Code, w.Body.String())
+
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		}}
+		resp, err := client.Get(w.Header().Get("Location"))
+		require.Nil(t, err, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusFound, resp.StatusCode)
+
+		callback, err := url.Parse(resp.Header.Get("Location"))
+		require.Nil(t, err, err)
+		return callback.RequestURI()
+	}
+
+	t.Run("test first login registers user with default organisation", func(t *testing.T) {
+		var resp RegisterSuccessResponse
+		username := GenerateRandomString(8)
+
+		w := DoRequest("GET", loginAs(t, &mockoidc.MockUser{
+			Subject:           GenerateRandomNumber(),
+			Email:             GenerateRandomEmail(),
+			EmailVerified:     true,
+			PreferredUsername: username,
+		}, ""), "", nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		err := json.NewDecoder(w.Body).Decode(&resp)
+		require.Nil(t, err, err)
+		require.NotEmpty(t, resp.Data.AccessToken)
+
+		w = DoRequest("GET", "/api/organisations", resp.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), username+"'s Organisation")
+	})
+
+	t.Run("test login links existing user by verified email", func(t *testing.T) {
+		var resp RegisterSuccessResponse
+		existing, params := RegisterRandomUser(t)
+		mockUser := &mockoidc.MockUser{
+			Subject:       GenerateRandomNumber(),
+			Email:         params["email"],
+			EmailVerified: true,
+		}
+
+		for i := 0; i < 2; i++ {
+			w := DoRequest("GET", loginAs(t, mockUser, ""), "", nil)
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+			err := json.NewDecoder(w.Body).Decode(&resp)
+			require.Nil(t, err, err)
+			assert.Equal(t, existing.Data.User.UserID, resp.Data.User.UserID)
+		}
+	})
+
+	t.Run("test enforced sso blocks social login", func(t *testing.T) {
+		var resp RegisterSuccessResponse
+		var orgsResp struct {
+			Data struct {
+				Organisations []struct {
+					OrgID string `json:"orgId"`
+				} `json:"organisations"`
+			} `json:"data"`
+		}
+		domain := strings.ToLower(GenerateRandomString(10)) + ".com"
+		mockUser := &mockoidc.MockUser{
+			Subject:       GenerateRandomNumber(),
+			Email:         strings.ToLower(GenerateRandomString(10)) + "@" + domain,
+			EmailVerified: true,
+		}
+
+		w := DoRequest("GET", loginAs(t, mockUser, ""), "", nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&resp))
+
+		w = DoRequest("GET", "/api/organisations", resp.Data.AccessToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&orgsResp))
+		orgId, _ := strconv.Atoi(orgsResp.Data.Organisations[0].OrgID)
+
+		now := time.Now()
+		require.Nil(t, db.Create(&models.OrganisationDomain{OrganisationID: uint(orgId), Domain: domain, VerifiedAt: &now}).Error)
+		require.Nil(t, db.Create(&models.SAMLConfig{OrganisationID: uint(orgId), SSOEnforced: true}).Error)
+
+		w = DoRequest("GET", loginAs(t, mockUser, ""), "", nil)
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), fmt.Sprintf("/saml/organisations/%d/login", orgId))
+		assert.NotContains(t, w.Body.String(), "accessToken")
+	})
+
+	t.Run("test login fails with unverified email", func(t *testing.T) {
+		w := DoRequest("GET", loginAs(t, &mockoidc.MockUser{
+			Subject: GenerateRandomNumber(),
+			Email:   GenerateRandomEmail(),
+		}, ""), "", nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, w.Body.String())
+	})
+
+	t.Run("test state cannot be reused", func(t *testing.T) {
+		callback := loginAs(t, &mockoidc.MockUser{
+			Subject:       GenerateRandomNumber(),
+			Email:         GenerateRandomEmail(),
+			EmailVerified: true,
+		}, "")
+
+		w := DoRequest("GET", callback, "", nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = DoRequest("GET", callback, "", nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+	})
+
+	t.Run("test first login registers invited user", func(t *testing.T) {
+		var invitation struct {
+			Data struct {
+				Token string `json:"token"`
+				OrgID string `json:"orgId"`
+			} `json:"data"`
+		}
+		var resp RegisterSuccessResponse
+
+		owner, _ := RegisterRandomUser(t)
+		w := DoRequest("GET", "/api/organisations", owner.Data.AccessToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		orgId := regexp.MustCompile(`"orgId":"(\d+)"`).FindStringSubmatch(w.Body.String())[1]
+
+		w = DoRequest("POST", "/api/organisations/"+orgId+"/invitations", owner.Data.AccessToken, map[string]string{})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&invitation))
+
+		mockUser := &mockoidc.MockUser{
+			Subject:       GenerateRandomNumber(),
+			Email:         GenerateRandomEmail(),
+			EmailVerified: true,
+		}
+		w = DoRequest("GET", loginAs(t, mockUser, "inv_unknown"), "", nil)
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+
+		w = DoRequest("GET", loginAs(t, mockUser, invitation.Data.Token), "", nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&resp))
+
+		w = DoRequest("GET", "/api/organisations/"+orgId, resp.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	})
+}
+
+func TestOAuthServer(t *testing.T) {
+	var orgsResp struct {
+		Data struct {
+			Organisations []struct {
+				OrgID string `json:"orgId"`
+			} `json:"organisations"`
+		} `json:"data"`
+	}
+	var clientResp struct {
+		Data struct {
+			ClientID     string `json:"clientId"`
+			ClientSecret string `json:"clientSecret"`
+		} `json:"data"`
+	}
+	var tokenResp struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		IDToken      string `json:"id_token"`
+		Scope        string `json:"scope"`
+	}
+
+	user, _ := RegisterRandomUser(t)
+	userToken := user.Data.AccessToken
+
+	w := DoRequest("GET", "/api/organisations", userToken, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Nil(t, json.NewDecoder(w.Body).Decode(&orgsResp))
+	clientsURL := "/api/organisations/" + orgsResp.Data.Organisations[0].OrgID + "/oauth-clients"
+
+	redirectURI := "https://app.example.com/callback"
+	w = DoRequest("POST", clientsURL, userToken, map[string]interface{}{
+		"name":         "Example App",
+		"redirectUris": []string{redirectURI},
+		"scopes":       []string{"openid", "email", "profile", "offline_access", models.ScopeOrgsRead},
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	require.Nil(t, json.NewDecoder(w.Body).Decode(&clientResp))
+	clientId, clientSecret := clientResp.Data.ClientID, clientResp.Data.ClientSecret
+	require.NotEmpty(t, clientSecret)
+
+	postForm := func(path string, form url.Values, withSecret bool) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if withSecret {
+			req.SetBasicAuth(clientId, clientSecret)
+		}
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	verifier := GenerateRandomString(48)
+	sum := sha256.Sum256([]byte(verifier))
+	authorizeParams := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientId},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {"openid email offline_access " + models.ScopeOrgsRead},
+		"state":                 {"xyz"},
+		"nonce":                 {"n-0S6_WzA2Mj"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(sum[:])},
+		"code_challenge_method": {"S256"},
+	}
+
+	// authorize returns the code from the redirect the user is sent back with
+	authorize := func(t *testing.T) string {
+		var redirectResp struct {
+			Data struct {
+				ConsentRequired bool   `json:"consentRequired"`
+				RedirectTo      string `json:"redirectTo"`
+			} `json:"data"`
+		}
+
+		w := DoRequest("GET", "/oauth/authorize?"+authorizeParams.Encode(), userToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&redirectResp))
+		require.False(t, redirectResp.Data.ConsentRequired)
+
+		redirectTo, err := url.Parse(redirectResp.Data.RedirectTo)
+		require.Nil(t, err, err)
+		assert.Equal(t, "xyz", redirectTo.Query().Get("state"))
+		require.NotEmpty(t, redirectTo.Query().Get("code"))
+		return redirectTo.Query().Get("code")
+	}
+
+	t.Run("test discovery document", func(t *testing.T) {
+		w := DoRequest("GET", "/.well-known/openid-configuration", "", nil)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), `"token_endpoint"`)
+		assert.Contains(t, w.Body.String(), `"code_challenge_methods_supported":["S256"]`)
+	})
+
+	t.Run("test authorize rejects plain pkce", func(t *testing.T) {
+		for _, method := range []string{"plain", ""} {
+			params := url.Values{}
+			for key, values := range authorizeParams {
+				params[key] = values
+			}
+			params.Set("code_challenge", verifier)
+			params.Set("code_challenge_method", method)
+
+			w := DoRequest("GET", "/oauth/authorize?"+params.Encode(), userToken, nil)
+			assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			assert.Contains(t, w.Body.String(), "invalid_request", method)
+		}
+	})
+
+	t.Run("test authorize rejects unregistered redirect uri", func(t *testing.T) {
+		params := url.Values{"response_type": {"code"}, "client_id": {clientId}, "redirect_uri": {"https://evil.example.com"}}
+		w := DoRequest("GET", "/oauth/authorize?"+params.Encode(), userToken, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+	})
+
+	t.Run("test authorize requires consent first", func(t *testing.T) {
+		var redirectResp struct {
+			Data struct {
+				ConsentRequired bool `json:"consentRequired"`
+			} `json:"data"`
+		}
+
+		w := DoRequest("GET", "/oauth/authorize?"+authorizeParams.Encode(), userToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&redirectResp))
+		assert.True(t, redirectResp.Data.ConsentRequired)
+
+		w = DoRequest("POST", "/oauth/authorize?"+authorizeParams.Encode()+"&approve=false", userToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), "access_denied")
+
+		w = DoRequest("POST", "/oauth/authorize?"+authorizeParams.Encode()+"&approve=true", userToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), "code=")
+	})
+
+	t.Run("test authorization code exchange with pkce", func(t *testing.T) {
+		code := authorize(t)
+		exchange := url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {code},
+			"redirect_uri":  {redirectURI},
+			"code_verifier": {"wrong"},
+		}
+
+		w := postForm("/oauth/token", exchange, true)
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+		exchange.Set("code_verifier", verifier)
+		w = postForm("/oauth/token", exchange, true)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&tokenResp))
+		require.NotEmpty(t, tokenResp.AccessToken)
+		require.NotEmpty(t, tokenResp.RefreshToken)
+
+		// the id token verifies against the published key set
+		var jwks struct {
+			Keys []struct {
+				N string `json:"n"`
+				E string `json:"e"`
+			} `json:"keys"`
+		}
+		w = DoRequest("GET", "/oauth/jwks", "", nil)
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&jwks))
+		n, _ := base64.RawURLEncoding.DecodeString(jwks.Keys[0].N)
+		e, _ := base64.RawURLEncoding.DecodeString(jwks.Keys[0].E)
+		publicKey := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+
+		claims := jwt.MapClaims{}
+		_, err := jwt.ParseWithClaims(tokenResp.IDToken, claims, func(*jwt.Token) (interface{}, error) {
+			return publicKey, nil
+		})
+		require.Nil(t, err, err)
+		assert.Equal(t, user.Data.User.UserID, claims["sub"])
+		assert.Equal(t, clientId, claims["aud"])
+		assert.Equal(t, "n-0S6_WzA2Mj", claims["nonce"])
+		assert.Equal(t, user.Data.User.Email, claims["email"])
+
+		// codes are single use
+		w = postForm("/oauth/token", exchange, true)
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+	})
+
+	t.Run("test access token works with its scopes", func(t *testing.T) {
+		w := DoRequest("GET", "/oauth/userinfo", tokenResp.AccessToken, nil)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), user.Data.User.Email)
+		assert.Contains(t, w.Body.String(), `"email_verified":false`)
+
+		w = DoRequest("GET", "/api/organisations", tokenResp.AccessToken, nil)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = DoRequest("POST", "/api/organisations", tokenResp.AccessToken, map[string]string{"name": GenerateRandomString(8)})
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+
+		w = DoRequest("GET", "/api/tokens", tokenResp.AccessToken, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+	})
+
+	t.Run("test refresh token is rotated", func(t *testing.T) {
+		refresh := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {tokenResp.RefreshToken}}
+
+		w := postForm("/oauth/token", refresh, true)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = postForm("/oauth/token", refresh, true)
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+	})
+
+	t.Run("test introspection and revocation", func(t *testing.T) {
+		token := url.Values{"token": {tokenResp.AccessToken}}
+
+		w := postForm("/oauth/introspect", token, false)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, w.Body.String())
+
+		w = postForm("/oauth/introspect", token, true)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), `"active":true`)
+		assert.Contains(t, w.Body.String(), `"sub":"`+user.Data.User.UserID+`"`)
+
+		w = postForm("/oauth/revoke", token, true)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = postForm("/oauth/introspect", token, true)
+		assert.JSONEq(t, `{"active":false}`, w.Body.String())
+
+		w = DoRequest("GET", "/api/organisations", tokenResp.AccessToken, nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, w.Body.String())
+	})
+
+	t.Run("test client credentials grant", func(t *testing.T) {
+		w := postForm("/oauth/token", url.Values{"grant_type": {"client_credentials"}, "scope": {"openid"}}, true)
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+		w = postForm("/oauth/token", url.Values{"grant_type": {"client_credentials"}}, true)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&tokenResp))
+		assert.Equal(t, models.ScopeOrgsRead, tokenResp.Scope)
+
+		w = postForm("/oauth/introspect", url.Values{"token": {tokenResp.AccessToken}}, true)
+		assert.Contains(t, w.Body.String(), `"sub":"`+clientId+`"`)
+
+		// client tokens act for no user, so cannot call the user API
+		w = DoRequest("GET", "/api/organisations", tokenResp.AccessToken, nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, w.Body.String())
+	})
+
+	t.Run("test revoking consent revokes tokens", func(t *testing.T) {
+		var consentsResp struct {
+			Data struct {
+				Consents []struct {
+					ConsentID string `json:"consentId"`
+				} `json:"consents"`
+			} `json:"data"`
+		}
+
+		w := postForm("/oauth/token", url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {authorize(t)},
+			"redirect_uri":  {redirectURI},
+			"code_verifier": {verifier},
+		}, true)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&tokenResp))
+
+		w = DoRequest("GET", "/api/oauth/consents", userToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&consentsResp))
+		require.Len(t, consentsResp.Data.Consents, 1)
+
+		w = DoRequest("DELETE", "/api/oauth/consents/"+consentsResp.Data.Consents[0].ConsentID, userToken, nil)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = DoRequest("GET", "/oauth/userinfo", tokenResp.AccessToken, nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, w.Body.String())
+	})
+
+	t.Run("test members cannot manage clients", func(t *testing.T) {
+		member, _ := RegisterRandomUser(t)
+		w := DoRequest("POST", "/api/organisations/"+orgsResp.Data.Organisations[0].OrgID+"/users", userToken, map[string]string{"userId": member.Data.User.UserID})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = DoRequest("POST", clientsURL, member.Data.AccessToken, map[string]interface{}{
+			"name":         "Rogue App",
+			"redirectUris": []string{redirectURI},
+			"scopes":       []string{"openid"},
+		})
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+		w = DoRequest("GET", clientsURL, member.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+		w = DoRequest("DELETE", clientsURL+"/"+clientId, member.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+	})
+}
+
+// samlServiceProviders lets the test IdP look up our SP metadata.
+type samlServiceProviders map[string]*saml.EntityDescriptor
+
+func (s samlServiceProviders) GetServiceProvider(_ *http.Request, id string) (*saml.EntityDescriptor, error) {
+	if sp, ok := s[id]; ok {
+		return sp, nil
+	}
+	return nil, os.ErrNotExist
+}
+
+// NewTestIdentityProvider returns a SAML IdP signing assertions with a
+// freshly generated key.
+func NewTestIdentityProvider(t *testing.T, sps samlServiceProviders) *saml.IdentityProvider {
+	key, err := rsa.GenerateKey(crand.Reader, 2048)
+	require.Nil(t, err, err)
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(crand.Reader, &template, &template, &key.PublicKey, key)
+	require.Nil(t, err, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err, err)
+
+	metadataURL, _ := url.Parse("https://idp.example.com/metadata")
+	ssoURL, _ := url.Parse("https://idp.example.com/sso")
+	return &saml.IdentityProvider{
+		Key:                     key,
+		Certificate:             cert,
+		MetadataURL:             *metadataURL,
+		SSOURL:                  *ssoURL,
+		ServiceProviderProvider: sps,
+	}
+}
+
+// NewSAMLSession returns an IdP session sending email as an attribute, the
+// way Okta does by default.
+func NewSAMLSession(email string) *saml.Session {
+	return &saml.Session{
+		NameID:       GenerateRandomString(12),
+		NameIDFormat: string(saml.PersistentNameIDFormat),
+		CustomAttributes: []saml.Attribute{{
+			Name:       "email",
+			NameFormat: "urn:oasis:names:tc:SAML:2.0:attrname-format:basic",
+			Values:     []saml.AttributeValue{{Type: "xs:string", Value: email}},
+		}},
+	}
+}
+
+func TestSAMLLogin(t *testing.T) {
+	var orgsResp struct {
+		Data struct {
+			Organisations []struct {
+				OrgID string `json:"orgId"`
+			} `json:"organisations"`
+		} `json:"data"`
+	}
+	var loginResp RegisterSuccessResponse
+
+	t.Setenv("APP_URL", "http://localhost:8080")
+
+	owner, ownerParams := RegisterRandomUser(t)
+	w := DoRequest("GET", "/api/organisations", owner.Data.AccessToken, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Nil(t, json.NewDecoder(w.Body).Decode(&orgsResp))
+	orgId := orgsResp.Data.Organisations[0].OrgID
+	configURL := "/api/organisations/" + orgId + "/saml"
+	samlURL := "/saml/organisations/" + orgId
+
+	sps := samlServiceProviders{}
+	idp := NewTestIdentityProvider(t, sps)
+	idpMetadata, err := xml.Marshal(idp.Metadata())
+	require.Nil(t, err, err)
+
+	domain := strings.ToLower(GenerateRandomString(10)) + ".com"
+
+	// loginAs has idp answer our authentication request for session and
+	// returns the form the browser posts to the ACS
+	loginAs := func(t *testing.T, idp *saml.IdentityProvider, session *saml.Session) url.Values {
+		w := DoRequest("GET", samlURL+"/login", "", nil)
+		require.Equal(t, http.StatusFound, w.Code, w.Body.String())
+
+		authnRequest, err := saml.NewIdpAuthnRequest(idp, httptest.NewRequest("GET", w.Header().Get("Location"), nil))
+		require.Nil(t, err, err)
+		require.Nil(t, authnRequest.Validate())
+		require.Nil(t, saml.DefaultAssertionMaker{}.MakeAssertion(authnRequest, session))
+
+		form, err := authnRequest.PostBinding()
+		require.Nil(t, err, err)
+		return url.Values{"SAMLResponse": {form.SAMLResponse}, "RelayState": {form.RelayState}}
+	}
+
+	postACS := func(form url.Values) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", samlURL+"/acs", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("test organisation configures its idp", func(t *testing.T) {
+		w := DoRequest("PUT", configURL, owner.Data.AccessToken, map[string]interface{}{"idpMetadata": "<nope/>"})
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code, w.Body.String())
+
+		outsider, _ := RegisterRandomUser(t)
+		w = DoRequest("PUT", configURL, outsider.Data.AccessToken, map[string]interface{}{"idpMetadata": string(idpMetadata)})
+		assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+
+		w = DoRequest("PUT", configURL, owner.Data.AccessToken, map[string]interface{}{"idpMetadata": string(idpMetadata)})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), "https://idp.example.com/metadata")
+
+		var spMetadata saml.EntityDescriptor
+		w = DoRequest("GET", samlURL+"/metadata", "", nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, xml.Unmarshal(w.Body.Bytes(), &spMetadata))
+		sps[spMetadata.EntityID] = &spMetadata
+	})
+
+	t.Run("test first login provisions member", func(t *testing.T) {
+		// accounts are only created on the organisation's verified domains
+		email := GenerateRandomString(8) + "@" + strings.ToLower(GenerateRandomString(10)) + ".com"
+		w := postACS(loginAs(t, idp, NewSAMLSession(email)))
+		assert.Equal(t, http.StatusUnauthorized, w.Code, w.Body.String())
+
+		var count int64
+		db.Model(&models.User{}).Where("email = ?", email).Count(&count)
+		assert.Zero(t, count)
+
+		provisionedDomain := strings.ToLower(GenerateRandomString(10)) + ".com"
+		orgIdNum, _ := strconv.Atoi(orgId)
+		now := time.Now()
+		require.Nil(t, db.Create(&models.OrganisationDomain{OrganisationID: uint(orgIdNum), Domain: provisionedDomain, VerifiedAt: &now}).Error)
+
+		session := NewSAMLSession(GenerateRandomString(8) + "@" + provisionedDomain)
+		session.UserGivenName = "Ada"
+		session.UserSurname = "Lovelace"
+		form := loginAs(t, idp, session)
+
+		w = postACS(form)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&loginResp))
+		assert.Equal(t, "Ada", loginResp.Data.User.FirstName)
+
+		w = DoRequest("GET", "/api/organisations/"+orgId, loginResp.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		// the relay state is single use
+		w = postACS(form)
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+	})
+
+	t.Run("test assertion signed by another key is rejected", func(t *testing.T) {
+		forger := NewTestIdentityProvider(t, sps)
+		w := postACS(loginAs(t, forger, NewSAMLSession(GenerateRandomString(8)+"@"+domain)))
+		assert.Equal(t, http.StatusUnauthorized, w.Code, w.Body.String())
+	})
+
+	t.Run("test existing account is only linked on a verified domain", func(t *testing.T) {
+		email := GenerateRandomString(8) + "@" + domain
+		password := GenerateRandomString(8)
+		w := DoRequest("POST", "/auth/register", "", map[string]string{
+			"firstName": GenerateRandomString(10),
+			"lastName":  GenerateRandomString(10),
+			"email":     email,
+			"phone":     GenerateRandomNumber(),
+			"password":  password,
+		})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+		session := NewSAMLSession(email)
+		w = postACS(loginAs(t, idp, session))
+		assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+
+		orgIdNum, _ := strconv.Atoi(orgId)
+		now := time.Now()
+		require.Nil(t, db.Create(&models.OrganisationDomain{OrganisationID: uint(orgIdNum), Domain: domain, VerifiedAt: &now}).Error)
+
+		w = postACS(loginAs(t, idp, session))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&loginResp))
+		assert.Equal(t, email, loginResp.Data.User.Email)
+
+		t.Run("test enforced sso blocks password login", func(t *testing.T) {
+			w := DoRequest("POST", "/auth/login", "", map[string]string{"email": email, "password": password})
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+			w = DoRequest("PUT", configURL, owner.Data.AccessToken, map[string]interface{}{"idpMetadata": string(idpMetadata), "ssoEnforced": true})
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+			w = DoRequest("POST", "/auth/login", "", map[string]string{"email": email, "password": password})
+			assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+			assert.Contains(t, w.Body.String(), samlURL+"/login")
+
+			// members on other domains are unaffected
+			w = DoRequest("POST", "/auth/login", "", map[string]string{"email": ownerParams["email"], "password": ownerParams["password"]})
+			assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		})
+	})
+
+	t.Run("test members cannot manage the idp", func(t *testing.T) {
+		// their own idp could log in as any other member
+		member, _ := RegisterRandomUser(t)
+		w := DoRequest("POST", "/api/organisations/"+orgId+"/users", owner.Data.AccessToken, map[string]string{"userId": member.Data.User.UserID})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		for _, method := range []string{"GET", "PUT", "DELETE"} {
+			w := DoRequest(method, configURL, member.Data.AccessToken, map[string]interface{}{"idpMetadata": string(idpMetadata)})
+			assert.Equal(t, http.StatusForbidden, w.Code, method, w.Body.String())
+		}
+	})
+}
+
+// SCIMRequest sends a SCIM request authenticated with an organisation's SCIM
+// token and decodes the response into v.
+func SCIMRequest(t *testing.T, method, url, token string, body, v interface{}) *httptest.ResponseRecorder {
+	w := DoRequest(method, url, token, body)
+	if v != nil && w.Body.Len() > 0 {
+		require.Nil(t, json.Unmarshal(w.Body.Bytes(), v), w.Body.String())
+	}
+	return w
+}
+
+func TestSCIM(t *testing.T) {
+	var orgsResp struct {
+		Data struct {
+			Organisations []struct {
+				OrgID string `json:"orgId"`
+			} `json:"organisations"`
+		} `json:"data"`
+	}
+	var tokenResp struct {
+		Data struct {
+			Token   string `json:"token"`
+			BaseURL string `json:"baseUrl"`
+		} `json:"data"`
+	}
+	type scimError struct {
+		Schemas  []string `json:"schemas"`
+		Status   string   `json:"status"`
+		ScimType string   `json:"scimType"`
+		Detail   string   `json:"detail"`
+	}
+	type scimList struct {
+		TotalResults int                       `json:"totalResults"`
+		StartIndex   int                       `json:"startIndex"`
+		ItemsPerPage int                       `json:"itemsPerPage"`
+		Resources    []models.SCIMUserResource `json:"Resources"`
+	}
+
+	t.Setenv("APP_URL", "http://localhost:8080")
+
+	owner, ownerParams := RegisterRandomUser(t)
+	w := DoRequest("GET", "/api/organisations", owner.Data.AccessToken, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Nil(t, json.NewDecoder(w.Body).Decode(&orgsResp))
+	orgId := orgsResp.Data.Organisations[0].OrgID
+	tokensURL := "/api/organisations/" + orgId + "/scim-tokens"
+	scimURL := "/scim/v2/organisations/" + orgId
+
+	outsider, _ := RegisterRandomUser(t)
+	w = DoRequest("POST", tokensURL, outsider.Data.AccessToken, map[string]string{"name": "okta"})
+	require.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+
+	w = DoRequest("POST", tokensURL, owner.Data.AccessToken, map[string]string{"name": "okta"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	require.Nil(t, json.NewDecoder(w.Body).Decode(&tokenResp))
+	token := tokenResp.Data.Token
+	require.True(t, strings.HasPrefix(token, models.SCIMTokenPrefix))
+	assert.Equal(t, "http://localhost:8080"+scimURL, tokenResp.Data.BaseURL)
+
+	// users are only created on the organisation's verified domains
+	domain := strings.ToLower(GenerateRandomString(8)) + ".com"
+	orgIdNum, _ := strconv.Atoi(orgId)
+	now := time.Now()
+	require.Nil(t, db.Create(&models.OrganisationDomain{OrganisationID: uint(orgIdNum), Domain: domain, VerifiedAt: &now}).Error)
+	newUser := func(userName string) map[string]interface{} {
+		return map[string]interface{}{
+			"schemas":  []string{models.SCIMSchemaUser},
+			"userName": userName,
+			"name":     map[string]string{"givenName": "Test", "familyName": "User"},
+			"emails":   []map[string]interface{}{{"value": userName, "type": "work", "primary": true}},
+		}
+	}
+
+	var bjensen models.SCIMUserResource
+
+	t.Run("test only the organisation's scim tokens are accepted", func(t *testing.T) {
+		var errResp scimError
+
+		w := SCIMRequest(t, "GET", scimURL+"/Users", "", nil, &errResp)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.Equal(t, "application/scim+json", w.Header().Get("Content-Type"))
+		assert.Equal(t, []string{models.SCIMSchemaError}, errResp.Schemas)
+		assert.Equal(t, "401", errResp.Status)
+
+		w = DoRequest("GET", scimURL+"/Users", owner.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, w.Body.String())
+
+		var otherOrgsResp struct {
+			Data struct {
+				Organisations []struct {
+					OrgID string `json:"orgId"`
+				} `json:"organisations"`
+			} `json:"data"`
+		}
+		w = DoRequest("GET", "/api/organisations", outsider.Data.AccessToken, nil)
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&otherOrgsResp))
+		w = DoRequest("GET", "/scim/v2/organisations/"+otherOrgsResp.Data.Organisations[0].OrgID+"/Users", token, nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, w.Body.String())
+	})
+
+	t.Run("test discovery endpoints", func(t *testing.T) {
+		var config struct {
+			Patch  struct{ Supported bool } `json:"patch"`
+			Filter struct {
+				Supported  bool `json:"supported"`
+				MaxResults int  `json:"maxResults"`
+			} `json:"filter"`
+			Bulk struct{ Supported bool } `json:"bulk"`
+		}
+		w := SCIMRequest(t, "GET", scimURL+"/ServiceProviderConfig", token, nil, &config)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, "application/scim+json", w.Header().Get("Content-Type"))
+		assert.True(t, config.Patch.Supported)
+		assert.True(t, config.Filter.Supported)
+		assert.Equal(t, 200, config.Filter.MaxResults)
+		assert.False(t, config.Bulk.Supported)
+
+		var resourceTypes struct {
+			TotalResults int `json:"totalResults"`
+			Resources    []struct {
+				Name     string `json:"name"`
+				Endpoint string `json:"endpoint"`
+				Schema   string `json:"schema"`
+			} `json:"Resources"`
+		}
+		w = SCIMRequest(t, "GET", scimURL+"/ResourceTypes", token, nil, &resourceTypes)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, 2, resourceTypes.TotalResults)
+		assert.Equal(t, "/Users", resourceTypes.Resources[0].Endpoint)
+		assert.Equal(t, models.SCIMSchemaGroup, resourceTypes.Resources[1].Schema)
+	})
+
+	t.Run("test creating a user (RFC 7644 3.3)", func(t *testing.T) {
+		userName := "bjensen@" + domain
+		w := SCIMRequest(t, "POST", scimURL+"/Users", token, map[string]interface{}{
+			"schemas":    []string{models.SCIMSchemaUser},
+			"userName":   userName,
+			"externalId": "bjensen",
+			"name": map[string]string{
+				"formatted":  "Ms. Barbara J Jensen III",
+				"familyName": "Jensen",
+				"givenName":  "Barbara",
+			},
+		}, &bjensen)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		assert.Equal(t, "application/scim+json", w.Header().Get("Content-Type"))
+
+		assert.NotEmpty(t, bjensen.ID)
+		assert.Equal(t, userName, bjensen.UserName)
+		assert.Equal(t, "bjensen", bjensen.ExternalID)
+		assert.Equal(t, "Barbara", bjensen.Name.GivenName)
+		assert.Equal(t, userName, bjensen.PrimaryEmail())
+		require.NotNil(t, bjensen.Active)
+		assert.True(t, *bjensen.Active)
+		assert.Equal(t, "User", bjensen.Meta.ResourceType)
+		assert.Equal(t, "http://localhost:8080"+scimURL+"/Users/"+bjensen.ID, bjensen.Meta.Location)
+		assert.Equal(t, bjensen.Meta.Location, w.Header().Get("Location"))
+
+		var fetched models.SCIMUserResource
+		w = SCIMRequest(t, "GET", scimURL+"/Users/"+bjensen.ID, token, nil, &fetched)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, bjensen.UserName, fetched.UserName)
+		assert.Equal(t, "Jensen", fetched.Name.FamilyName)
+	})
+
+	t.Run("test userName and email are unique", func(t *testing.T) {
+		var errResp scimError
+
+		w := SCIMRequest(t, "POST", scimURL+"/Users", token, newUser(strings.ToUpper(bjensen.UserName)), &errResp)
+		require.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+		assert.Equal(t, []string{models.SCIMSchemaError}, errResp.Schemas)
+		assert.Equal(t, "409", errResp.Status)
+		assert.Equal(t, "uniqueness", errResp.ScimType)
+
+		w = SCIMRequest(t, "POST", scimURL+"/Users", token, newUser(ownerParams["email"]), &errResp)
+		assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+
+		w = SCIMRequest(t, "POST", scimURL+"/Users", token, map[string]interface{}{"userName": "not an email"}, &errResp)
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+		assert.Equal(t, "invalidValue", errResp.ScimType)
+	})
+
+	t.Run("test users are only created and moved on verified domains", func(t *testing.T) {
+		var errResp scimError
+
+		// the account would hold an email the organisation does not own
+		outsideEmail := GenerateRandomEmail()
+		w := SCIMRequest(t, "POST", scimURL+"/Users", token, newUser(outsideEmail), &errResp)
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+		assert.Equal(t, "invalidValue", errResp.ScimType)
+
+		var count int64
+		db.Model(&models.User{}).Where("email = ?", outsideEmail).Count(&count)
+		assert.Zero(t, count)
+
+		w = SCIMRequest(t, "PATCH", scimURL+"/Users/"+bjensen.ID, token, map[string]interface{}{
+			"schemas":    []string{models.SCIMSchemaPatchOp},
+			"Operations": []map[string]interface{}{{"op": "replace", "path": "emails[type eq \"work\"].value", "value": outsideEmail}},
+		}, &errResp)
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+		assert.Equal(t, "invalidValue", errResp.ScimType)
+	})
+
+	t.Run("test filtering users (RFC 7644 3.4.2.2)", func(t *testing.T) {
+		var list scimList
+
+		// existing members are users of the organisation too
+		w := SCIMRequest(t, "GET", scimURL+"/Users?filter="+url.QueryEscape(`userName eq "`+ownerParams["email"]+`"`), token, nil, &list)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, 1, list.TotalResults)
+		assert.Equal(t, owner.Data.User.UserID, list.Resources[0].ID)
+		assert.True(t, *list.Resources[0].Active)
+
+		list = scimList{}
+		w = SCIMRequest(t, "GET", scimURL+"/Users?filter="+url.QueryEscape(`userName Eq "`+strings.ToUpper(bjensen.UserName)+`"`), token, nil, &list)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, 1, list.TotalResults)
+		assert.Equal(t, bjensen.ID, list.Resources[0].ID)
+
+		list = scimList{}
+		filter := `name.familyName sw "jen" and (externalId pr or emails[value co "nobody"]) and not (active eq false)`
+		w = SCIMRequest(t, "GET", scimURL+"/Users?filter="+url.QueryEscape(filter), token, nil, &list)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, 1, list.TotalResults)
+		assert.Equal(t, bjensen.ID, list.Resources[0].ID)
+
+		list = scimList{}
+		filter = `urn:ietf:params:scim:schemas:core:2.0:User:userName eq "nobody@example.com"`
+		w = SCIMRequest(t, "GET", scimURL+"/Users?filter="+url.QueryEscape(filter), token, nil, &list)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, 0, list.TotalResults)
+		assert.Empty(t, list.Resources)
+
+		for _, filter := range []string{`userName eq`, `title eq "Tour Guide"`, `userName xx "a"`, `(userName eq "a"`} {
+			var errResp scimError
+			w = SCIMRequest(t, "GET", scimURL+"/Users?filter="+url.QueryEscape(filter), token, nil, &errResp)
+			assert.Equal(t, http.StatusBadRequest, w.Code, filter)
+			assert.Equal(t, "invalidFilter", errResp.ScimType, filter)
+		}
+	})
+
+	t.Run("test paginating users (RFC 7644 3.4.2.4)", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			w := DoRequest("POST", scimURL+"/Users", token, newUser(fmt.Sprintf("page%d@%s", i, domain)))
+			require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		}
+
+		var list scimList
+		w := SCIMRequest(t, "GET", scimURL+"/Users", token, nil, &list)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		total := list.TotalResults
+		assert.Equal(t, 4, total)
+		assert.Equal(t, 1, list.StartIndex)
+		assert.Len(t, list.Resources, total)
+
+		list = scimList{}
+		w = SCIMRequest(t, "GET", scimURL+"/Users?startIndex=2&count=2", token, nil, &list)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, total, list.TotalResults)
+		assert.Equal(t, 2, list.StartIndex)
+		assert.Equal(t, 2, list.ItemsPerPage)
+		assert.Equal(t, bjensen.ID, list.Resources[0].ID)
+
+		list = scimList{}
+		w = SCIMRequest(t, "GET", scimURL+"/Users?count=0", token, nil, &list)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, total, list.TotalResults)
+		assert.Empty(t, list.Resources)
+	})
+
+	t.Run("test replacing a user (RFC 7644 3.5.1)", func(t *testing.T) {
+		replacement := newUser(bjensen.UserName)
+		replacement["externalId"] = "bjensen"
+		replacement["name"] = map[string]string{"givenName": "Babs", "familyName": "Jensen"}
+		replacement["phoneNumbers"] = []map[string]string{{"value": "555-555-8377", "type": "work"}}
+
+		var replaced models.SCIMUserResource
+		w := SCIMRequest(t, "PUT", scimURL+"/Users/"+bjensen.ID, token, replacement, &replaced)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, "Babs", replaced.Name.GivenName)
+		require.Len(t, replaced.PhoneNumbers, 1)
+		assert.Equal(t, "555-555-8377", replaced.PhoneNumbers[0].Value)
+		assert.True(t, *replaced.Active)
+
+		var user models.User
+		require.Nil(t, db.First(&user, bjensen.ID).Error)
+		assert.Equal(t, "Babs", user.FirstName)
+
+		w = DoRequest("PUT", scimURL+"/Users/0", token, replacement)
+		assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+	})
+
+	t.Run("test patching a user (RFC 7644 3.5.2)", func(t *testing.T) {
+		// Okta deactivates users without a path
+		var patched models.SCIMUserResource
+		w := SCIMRequest(t, "PATCH", scimURL+"/Users/"+bjensen.ID, token, map[string]interface{}{
+			"schemas":    []string{models.SCIMSchemaPatchOp},
+			"Operations": []map[string]interface{}{{"op": "replace", "value": map[string]interface{}{"active": false}}},
+		}, &patched)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.False(t, *patched.Active)
+
+		var list scimList
+		w = SCIMRequest(t, "GET", scimURL+"/Users?filter="+url.QueryEscape(`active eq false`), token, nil, &list)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, 1, list.TotalResults)
+		assert.Equal(t, bjensen.ID, list.Resources[0].ID)
+
+		var members int64
+		db.Table("users_organisations").Where("user_id = ? AND organisation_id = ?", bjensen.ID, orgId).Count(&members)
+		assert.Zero(t, members)
+
+		// Azure AD uses paths and sends booleans as strings
+		email := "barbara@" + domain
+		patched = models.SCIMUserResource{}
+		w = SCIMRequest(t, "PATCH", scimURL+"/Users/"+bjensen.ID, token, map[string]interface{}{
+			"schemas": []string{models.SCIMSchemaPatchOp},
+			"Operations": []map[string]interface{}{
+				{"op": "Replace", "path": "active", "value": "True"},
+				{"op": "Replace", "path": `emails[type eq "work"].value`, "value": email},
+				{"op": "Add", "path": "name.familyName", "value": "Jensen-Smith"},
+			},
+		}, &patched)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.True(t, *patched.Active)
+		assert.Equal(t, email, patched.PrimaryEmail())
+		assert.Equal(t, "Jensen-Smith", patched.Name.FamilyName)
+		assert.Equal(t, "Babs", patched.Name.GivenName)
+
+		db.Table("users_organisations").Where("user_id = ? AND organisation_id = ?", bjensen.ID, orgId).Count(&members)
+		assert.Equal(t, int64(1), members)
+
+		var errResp scimError
+		w = SCIMRequest(t, "PATCH", scimURL+"/Users/"+bjensen.ID, token, map[string]interface{}{
+			"schemas":    []string{models.SCIMSchemaPatchOp},
+			"Operations": []map[string]interface{}{{"op": "replace", "path": `emails[type eq "home"].value`, "value": email}},
+		}, &errResp)
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+		assert.Equal(t, "noTarget", errResp.ScimType)
+
+		w = SCIMRequest(t, "PATCH", scimURL+"/Users/"+bjensen.ID, token, map[string]interface{}{
+			"schemas":    []string{models.SCIMSchemaPatchOp},
+			"Operations": []map[string]interface{}{{"op": "move", "path": "active"}},
+		}, &errResp)
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+	})
+
+	t.Run("test existing accounts are adopted but not managed", func(t *testing.T) {
+		existing, existingParams := RegisterRandomUser(t)
+
+		var adopted models.SCIMUserResource
+		w := SCIMRequest(t, "POST", scimURL+"/Users", token, newUser(existingParams["email"]), &adopted)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		assert.Equal(t, existing.Data.User.UserID, adopted.ID)
+		assert.Equal(t, existingParams["firstName"], adopted.Name.GivenName)
+
+		w = DoRequest("GET", "/api/organisations/"+orgId, existing.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		// the identity provider did not create the owner or this user, so it
+		// cannot change their profile
+		for _, userId := range []string{existing.Data.User.UserID, owner.Data.User.UserID} {
+			var errResp scimError
+			w = SCIMRequest(t, "PATCH", scimURL+"/Users/"+userId, token, map[string]interface{}{
+				"schemas":    []string{models.SCIMSchemaPatchOp},
+				"Operations": []map[string]interface{}{{"op": "replace", "path": "name.givenName", "value": "Mallory"}},
+			}, &errResp)
+			assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+			assert.Equal(t, "mutability", errResp.ScimType)
+		}
+
+		var user models.User
+		require.Nil(t, db.First(&user, existing.Data.User.UserID).Error)
+		assert.Equal(t, existingParams["firstName"], user.FirstName)
+
+		// the account itself stays usable after it is deprovisioned
+		w = DoRequest("DELETE", scimURL+"/Users/"+existing.Data.User.UserID, token, nil)
+		require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+		w = DoRequest("GET", "/api/organisations/"+orgId, existing.Data.AccessToken, nil)
+		assert.NotEqual(t, http.StatusOK, w.Code, w.Body.String())
+		w = DoRequest("POST", "/auth/login", "", map[string]string{"email": existingParams["email"], "password": existingParams["password"]})
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	})
+
+	t.Run("test managing groups", func(t *testing.T) {
+		var list scimList
+		w := SCIMRequest(t, "GET", scimURL+"/Users?filter="+url.QueryEscape(`userName sw "page0@"`), token, nil, &list)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, 1, list.TotalResults)
+		otherId := list.Resources[0].ID
+
+		var group models.SCIMGroupResource
+		w = SCIMRequest(t, "POST", scimURL+"/Groups", token, map[string]interface{}{
+			"schemas":     []string{models.SCIMSchemaGroup},
+			"displayName": "Tour Guides",
+			"members":     []map[string]string{{"value": bjensen.ID}},
+		}, &group)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		assert.Equal(t, "Tour Guides", group.DisplayName)
+		require.Len(t, group.Members, 1)
+		assert.Equal(t, bjensen.ID, group.Members[0].Value)
+		assert.Equal(t, group.Meta.Location, w.Header().Get("Location"))
+		groupURL := scimURL + "/Groups/" + group.ID
+
+		var errResp scimError
+		w = SCIMRequest(t, "POST", scimURL+"/Groups", token, map[string]interface{}{"displayName": "tour guides"}, &errResp)
+		assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+		assert.Equal(t, "uniqueness", errResp.ScimType)
+
+		w = SCIMRequest(t, "POST", scimURL+"/Groups", token, map[string]interface{}{
+			"displayName": "Outsiders",
+			"members":     []map[string]string{{"value": outsider.Data.User.UserID}},
+		}, &errResp)
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+		assert.Equal(t, "invalidValue", errResp.ScimType)
+
+		patchGroup := func(t *testing.T, operations ...map[string]interface{}) models.SCIMGroupResource {
+			var patched models.SCIMGroupResource
+			w := SCIMRequest(t, "PATCH", groupURL, token, map[string]interface{}{
+				"schemas":    []string{models.SCIMSchemaPatchOp},
+				"Operations": operations,
+			}, &patched)
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			return patched
+		}
+
+		patched := patchGroup(t, map[string]interface{}{
+			"op":    "add",
+			"path":  "members",
+			"value": []map[string]string{{"display": "Page Zero", "value": otherId}, {"value": bjensen.ID}},
+		})
+		assert.Len(t, patched.Members, 2)
+
+		var user models.SCIMUserResource
+		w = SCIMRequest(t, "GET", scimURL+"/Users/"+otherId, token, nil, &user)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Len(t, user.Groups, 1)
+		assert.Equal(t, group.ID, user.Groups[0].Value)
+		assert.Equal(t, "Tour Guides", user.Groups[0].Display)
+
+		patched = patchGroup(t, map[string]interface{}{"op": "remove", "path": `members[value eq "` + bjensen.ID + `"]`})
+		require.Len(t, patched.Members, 1)
+		assert.Equal(t, otherId, patched.Members[0].Value)
+
+		// Azure AD removes members by value
+		patched = patchGroup(t,
+			map[string]interface{}{"op": "Remove", "path": "members", "value": []map[string]string{{"value": otherId}}},
+			map[string]interface{}{"op": "Replace", "path": "displayName", "value": "Guides"},
+		)
+		assert.Empty(t, patched.Members)
+		assert.Equal(t, "Guides", patched.DisplayName)
+
+		var replaced models.SCIMGroupResource
+		w = SCIMRequest(t, "PUT", groupURL, token, map[string]interface{}{
+			"schemas":     []string{models.SCIMSchemaGroup},
+			"displayName": "Tour Guides",
+			"externalId":  "tour-guides",
+			"members":     []map[string]string{{"value": bjensen.ID}, {"value": otherId}},
+		}, &replaced)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, "tour-guides", replaced.ExternalID)
+		assert.Len(t, replaced.Members, 2)
+
+		var groups struct {
+			TotalResults int                        `json:"totalResults"`
+			Resources    []models.SCIMGroupResource `json:"Resources"`
+		}
+		w = SCIMRequest(t, "GET", scimURL+"/Groups?excludedAttributes=members&filter="+url.QueryEscape(`displayName eq "tour guides"`), token, nil, &groups)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Equal(t, 1, groups.TotalResults)
+		assert.Empty(t, groups.Resources[0].Members)
+
+		// deactivated users leave the organisation's groups
+		w = DoRequest("PATCH", scimURL+"/Users/"+otherId, token, map[string]interface{}{
+			"schemas":    []string{models.SCIMSchemaPatchOp},
+			"Operations": []map[string]interface{}{{"op": "replace", "path": "active", "value": false}},
+		})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var fetched models.SCIMGroupResource
+		w = SCIMRequest(t, "GET", groupURL, token, nil, &fetched)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Len(t, fetched.Members, 1)
+		assert.Equal(t, bjensen.ID, fetched.Members[0].Value)
+
+		w = DoRequest("DELETE", groupURL, token, nil)
+		assert.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+		w = DoRequest("GET", groupURL, token, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+	})
+
+	t.Run("test deleting a user (RFC 7644 3.6)", func(t *testing.T) {
+		w := DoRequest("DELETE", scimURL+"/Users/"+bjensen.ID, token, nil)
+		require.Equal(t, http.StatusNoContent, w.Code, w.Body.String())
+
+		var errResp scimError
+		w = SCIMRequest(t, "GET", scimURL+"/Users/"+bjensen.ID, token, nil, &errResp)
+		assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+		assert.Equal(t, "404", errResp.Status)
+		assert.Equal(t, []string{models.SCIMSchemaError}, errResp.Schemas)
+	})
+
+	t.Run("test revoked scim tokens are rejected", func(t *testing.T) {
+		var tokensResp struct {
+			Data struct {
+				Tokens []struct {
+					TokenID    string  `json:"tokenId"`
+					LastUsedAt *string `json:"lastUsedAt"`
+				} `json:"tokens"`
+			} `json:"data"`
+		}
+		w := DoRequest("GET", tokensURL, owner.Data.AccessToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&tokensResp))
+		require.Len(t, tokensResp.Data.Tokens, 1)
+		assert.NotNil(t, tokensResp.Data.Tokens[0].LastUsedAt)
+
+		w = DoRequest("DELETE", tokensURL+"/"+tokensResp.Data.Tokens[0].TokenID, owner.Data.AccessToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = DoRequest("GET", scimURL+"/Users", token, nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, w.Body.String())
+	})
+
+	t.Run("test members cannot manage scim tokens", func(t *testing.T) {
+		// a token would let them take over the accounts of the other members
+		member, _ := RegisterRandomUser(t)
+		w := DoRequest("POST", "/api/organisations/"+orgId+"/users", owner.Data.AccessToken, map[string]string{"userId": member.Data.User.UserID})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		for _, method := range []string{"GET", "POST"} {
+			w := DoRequest(method, tokensURL, member.Data.AccessToken, map[string]string{"name": "okta"})
+			assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+		}
+		w = DoRequest("DELETE", tokensURL+"/1", member.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+	})
+}
+
+type testEmail struct {
+	To, Subject, Body string
+}
+
+// testMailer records emails instead of sending them.
+type testMailer struct {
+	mu   sync.Mutex
+	sent []testEmail
+}
+
+func (m *testMailer) Send(to, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, testEmail{To: to, Subject: subject, Body: body})
+	return nil
+}
+
+// LastToken returns the token of the link in the last email sent to to.
+func (m *testMailer) LastToken(t *testing.T, to string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := len(m.sent) - 1; i >= 0; i-- {
+		if m.sent[i].To == to {
+			match := regexp.MustCompile(`token=(\S+)`).FindStringSubmatch(m.sent[i].Body)
+			require.NotNil(t, match, m.sent[i].Body)
+			return match[1]
+		}
+	}
+
+	require.Fail(t, "no email sent to "+to)
+	return ""
+}
+
+// testResolver serves the TXT records of domains.
+type testResolver map[string][]string
+
+func (r testResolver) LookupTXT(_ context.Context, name string) ([]string, error) {
+	records, ok := r[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+	return records, nil
+}
+
+func RegisterUserWithEmail(t *testing.T, email string) (map[string]interface{}, string) {
+	var resp struct {
+		Data map[string]interface{} `json:"data"`
+	}
+
+	w := DoRequest("POST", "/auth/register", "", map[string]string{
+		"firstName": GenerateRandomString(10),
+		"lastName":  GenerateRandomString(10),
+		"email":     email,
+		"phone":     GenerateRandomNumber(),
+		"password":  GenerateRandomString(8),
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	require.Nil(t, json.NewDecoder(w.Body).Decode(&resp))
+
+	return resp.Data, resp.Data["accessToken"].(string)
+}
+
+func TestDomains(t *testing.T) {
+	var orgsResp struct {
+		Data struct {
+			Organisations []struct {
+				OrgID string `json:"orgId"`
+			} `json:"organisations"`
+			EmailVerified bool `json:"emailVerified"`
+		} `json:"data"`
+	}
+	type domainResp struct {
+		Data struct {
+			DomainID  string `json:"domainId"`
+			Verified  bool   `json:"verified"`
+			AutoJoin  bool   `json:"autoJoin"`
+			DNSRecord struct {
+				Type  string `json:"type"`
+				Name  string `json:"name"`
+				Value string `json:"value"`
+			} `json:"dnsRecord"`
+		} `json:"data"`
+	}
+
+	t.Setenv("APP_URL", "http://localhost:8080")
+
+	owner, _ := RegisterRandomUser(t)
+	w := DoRequest("GET", "/api/organisations", owner.Data.AccessToken, nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	require.Nil(t, json.NewDecoder(w.Body).Decode(&orgsResp))
+	orgId := orgsResp.Data.Organisations[0].OrgID
+	domainsURL := "/api/organisations/" + orgId + "/domains"
+
+	other, _ := RegisterRandomUser(t)
+	w = DoRequest("GET", "/api/organisations", other.Data.AccessToken, nil)
+	require.Nil(t, json.NewDecoder(w.Body).Decode(&orgsResp))
+	otherDomainsURL := "/api/organisations/" + orgsResp.Data.Organisations[0].OrgID + "/domains"
+
+	domain := strings.ToLower(GenerateRandomString(10)) + ".com"
+	var domainURL string
+
+	t.Run("test organisation verifies its domain through dns", func(t *testing.T) {
+		w := DoRequest("POST", domainsURL, other.Data.AccessToken, map[string]interface{}{"domain": domain, "method": "dns"})
+		assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+
+		for _, params := range []map[string]interface{}{
+			{"domain": "not a domain", "method": "dns"},
+			{"domain": domain, "method": "carrier pigeon"},
+			{"domain": domain, "method": "email"},
+			{"domain": domain, "method": "email", "email": "ceo@" + domain},
+			{"domain": domain, "method": "email", "email": "admin@example.com"},
+		} {
+			w := DoRequest("POST", domainsURL, owner.Data.AccessToken, params)
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Code, w.Body.String())
+		}
+
+		var created domainResp
+		w = DoRequest("POST", domainsURL, owner.Data.AccessToken, map[string]interface{}{"domain": strings.ToUpper(domain) + ".", "method": "dns"})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&created))
+		assert.False(t, created.Data.Verified)
+		assert.Equal(t, "TXT", created.Data.DNSRecord.Type)
+		assert.Equal(t, domain, created.Data.DNSRecord.Name)
+		require.True(t, strings.HasPrefix(created.Data.DNSRecord.Value, "hng11-domain-verification="))
+		domainURL = domainsURL + "/" + created.Data.DomainID
+
+		w = DoRequest("POST", domainsURL, owner.Data.AccessToken, map[string]interface{}{"domain": domain, "method": "dns"})
+		assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+
+		// claims are not verified until the record is published
+		w = DoRequest("POST", domainURL+"/verify", owner.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+		resolver[domain] = []string{"v=spf1 -all", "hng11-domain-verification=someone-else"}
+		w = DoRequest("POST", domainURL+"/verify", owner.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+		resolver[domain] = append(resolver[domain], created.Data.DNSRecord.Value)
+		var verified domainResp
+		w = DoRequest("POST", domainURL+"/verify", owner.Data.AccessToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&verified))
+		assert.True(t, verified.Data.Verified)
+
+		// a verified domain cannot be claimed by another organisation
+		w = DoRequest("POST", otherDomainsURL, other.Data.AccessToken, map[string]interface{}{"domain": domain, "method": "dns"})
+		assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	})
+
+	t.Run("test users of the domain are offered membership", func(t *testing.T) {
+		email := strings.ToLower(GenerateRandomString(8)) + "@" + domain
+		data, token := RegisterUserWithEmail(t, email)
+		joinable, _ := data["joinableOrganisations"].([]interface{})
+		require.Len(t, joinable, 1)
+		assert.Equal(t, orgId, joinable[0].(map[string]interface{})["orgId"])
+
+		w := DoRequest("GET", "/api/organisations/"+orgId, token, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+
+		orgsResp.Data.Organisations = nil
+		w = DoRequest("GET", "/api/organisations/joinable", token, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&orgsResp))
+		require.Len(t, orgsResp.Data.Organisations, 1)
+		assert.False(t, orgsResp.Data.EmailVerified)
+
+		// anyone can register with any email, so it has to be verified first
+		w = DoRequest("POST", "/api/organisations/"+orgId+"/join", token, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+
+		w = DoRequest("GET", "/auth/verify-email?token=evt_nope", "", nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+		var verifyResp struct {
+			Data struct {
+				JoinedOrganisations []interface{} `json:"joinedOrganisations"`
+			} `json:"data"`
+		}
+		verifyURL := "/auth/verify-email?token=" + mailer.LastToken(t, email)
+		w = DoRequest("GET", verifyURL, "", nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&verifyResp))
+		assert.Empty(t, verifyResp.Data.JoinedOrganisations)
+
+		w = DoRequest("GET", verifyURL, "", nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+		w = DoRequest("POST", "/api/organisations/"+other.Data.User.UserID+"/join", token, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+
+		w = DoRequest("POST", "/api/organisations/"+orgId+"/join", token, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		w = DoRequest("GET", "/api/organisations/"+orgId, token, nil)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = DoRequest("POST", "/api/organisations/"+orgId+"/join", token, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+	})
+
+	t.Run("test users of the domain join automatically", func(t *testing.T) {
+		var updated domainResp
+		w := DoRequest("PATCH", domainURL, owner.Data.AccessToken, map[string]interface{}{"autoJoin": true})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&updated))
+		assert.True(t, updated.Data.AutoJoin)
+
+		email := strings.ToLower(GenerateRandomString(8)) + "@" + domain
+		_, token := RegisterUserWithEmail(t, email)
+		w = DoRequest("GET", "/api/organisations/"+orgId, token, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+
+		// users can ask for a new link
+		w = DoRequest("POST", "/auth/verify-email", token, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		var verifyResp struct {
+			Data struct {
+				JoinedOrganisations []struct {
+					OrgID string `json:"orgId"`
+				} `json:"joinedOrganisations"`
+			} `json:"data"`
+		}
+		w = DoRequest("GET", "/auth/verify-email?token="+mailer.LastToken(t, email), "", nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&verifyResp))
+		require.Len(t, verifyResp.Data.JoinedOrganisations, 1)
+		assert.Equal(t, orgId, verifyResp.Data.JoinedOrganisations[0].OrgID)
+
+		w = DoRequest("GET", "/api/organisations/"+orgId, token, nil)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = DoRequest("POST", "/auth/verify-email", token, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), "already verified")
+	})
+
+	t.Run("test organisation verifies its domain through email", func(t *testing.T) {
+		emailDomain := strings.ToLower(GenerateRandomString(10)) + ".org"
+		adminEmail := "postmaster@" + emailDomain
+
+		var created domainResp
+		w := DoRequest("POST", otherDomainsURL, other.Data.AccessToken, map[string]interface{}{"domain": emailDomain, "method": "email", "email": adminEmail})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&created))
+		assert.False(t, created.Data.Verified)
+		firstToken := mailer.LastToken(t, adminEmail)
+
+		// asking again replaces the link
+		w = DoRequest("POST", otherDomainsURL+"/"+created.Data.DomainID+"/verify", other.Data.AccessToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		token := mailer.LastToken(t, adminEmail)
+		require.NotEqual(t, firstToken, token)
+
+		w = DoRequest("GET", "/domains/verify?token="+firstToken, "", nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+		var verified domainResp
+		w = DoRequest("GET", "/domains/verify?token="+token, "", nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&verified))
+		assert.True(t, verified.Data.Verified)
+
+		w = DoRequest("GET", "/domains/verify?token="+token, "", nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+		w = DoRequest("DELETE", otherDomainsURL+"/"+created.Data.DomainID, owner.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+		w = DoRequest("DELETE", otherDomainsURL+"/"+created.Data.DomainID, other.Data.AccessToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		// once released, the domain can be claimed by someone else
+		w = DoRequest("POST", domainsURL, owner.Data.AccessToken, map[string]interface{}{"domain": emailDomain, "method": "dns"})
+		assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	})
+
+	t.Run("test a domain is only verified by one organisation", func(t *testing.T) {
+		sharedDomain := strings.ToLower(GenerateRandomString(10)) + ".net"
+
+		// unverified claims do not conflict, so both organisations can
+		// be waiting on a link at the same time
+		var tokens []string
+		for _, claim := range []struct{ url, accessToken, mailbox string }{
+			{domainsURL, owner.Data.AccessToken, "admin"},
+			{otherDomainsURL, other.Data.AccessToken, "webmaster"},
+		} {
+			adminEmail := claim.mailbox + "@" + sharedDomain
+			w := DoRequest("POST", claim.url, claim.accessToken, map[string]interface{}{"domain": sharedDomain, "method": "email", "email": adminEmail})
+			require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+			tokens = append(tokens, mailer.LastToken(t, adminEmail))
+		}
+
+		w := DoRequest("GET", "/domains/verify?token="+tokens[0], "", nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		w = DoRequest("GET", "/domains/verify?token="+tokens[1], "", nil)
+		assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+
+		var count int64
+		require.Nil(t, db.Model(&models.OrganisationDomain{}).Where("domain = ? AND verified_at IS NOT NULL", sharedDomain).Count(&count).Error)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("test other users are not offered membership", func(t *testing.T) {
+		data, token := RegisterUserWithEmail(t, GenerateRandomEmail())
+		assert.NotContains(t, data, "joinableOrganisations")
+
+		w := DoRequest("POST", "/api/organisations/"+orgId+"/join", token, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+	})
+
+	t.Run("test members cannot manage domains", func(t *testing.T) {
+		member, _ := RegisterRandomUser(t)
+		w := DoRequest("POST", "/api/organisations/"+orgId+"/users", owner.Data.AccessToken, map[string]string{"userId": member.Data.User.UserID})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = DoRequest("POST", domainsURL, member.Data.AccessToken, map[string]interface{}{"domain": "rogue-" + domain, "method": "dns"})
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+		w = DoRequest("GET", domainsURL, member.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+		for _, method := range []string{"PATCH", "DELETE"} {
+			w := DoRequest(method, domainsURL+"/1", member.Data.AccessToken, map[string]interface{}{"autoJoin": true})
+			assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+		}
+		w = DoRequest("POST", domainsURL+"/1/verify", member.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+	})
+}
+
+func TestRegistration(t *testing.T) {
+	type invitationResp struct {
+		Data struct {
+			InvitationID string `json:"invitationId"`
+			Token        string `json:"token"`
+		} `json:"data"`
+	}
+	type errorsResp struct {
+		Errors []models.InputError `json:"errors"`
+	}
+
+	register := func(email, invitationToken string) *httptest.ResponseRecorder {
+		return DoRequest("POST", "/auth/register", "", map[string]string{
+			"firstName":       GenerateRandomString(10),
+			"lastName":        GenerateRandomString(10),
+			"email":           email,
+			"phone":           GenerateRandomNumber(),
+			"password":        GenerateRandomString(8),
+			"invitationToken": invitationToken,
+		})
+	}
+	organisations := func(token string) []string {
+		var orgsResp struct {
+			Data struct {
+				Organisations []struct {
+					OrgID string `json:"orgId"`
+				} `json:"organisations"`
+			} `json:"data"`
+		}
+		w := DoRequest("GET", "/api/organisations", token, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&orgsResp))
+
+		orgIds := []string{}
+		for _, org := range orgsResp.Data.Organisations {
+			orgIds = append(orgIds, org.OrgID)
+		}
+		return orgIds
+	}
+	accessToken := func(w *httptest.ResponseRecorder) string {
+		var resp RegisterSuccessResponse
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&resp))
+		return resp.Data.AccessToken
+	}
+	assertInvalidField := func(w *httptest.ResponseRecorder, field string) {
+		var resp errorsResp
+		require.Equal(t, http.StatusUnprocessableEntity, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&resp))
+		require.Len(t, resp.Errors, 1)
+		assert.Equal(t, field, resp.Errors[0].Field)
+	}
+
+	owner, _ := RegisterRandomUser(t)
+	orgId := organisations(owner.Data.AccessToken)[0]
+	invitationsURL := "/api/organisations/" + orgId + "/invitations"
+
+	invite := func(email string) invitationResp {
+		var resp invitationResp
+		w := DoRequest("POST", invitationsURL, owner.Data.AccessToken, map[string]string{"email": email})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&resp))
+		require.True(t, strings.HasPrefix(resp.Data.Token, models.InvitationTokenPrefix))
+		return resp
+	}
+
+	t.Run("test users get a personal organisation by default", func(t *testing.T) {
+		user, _ := RegisterRandomUser(t)
+		assert.Len(t, organisations(user.Data.AccessToken), 1)
+	})
+
+	t.Run("test invited users join the organisation instead", func(t *testing.T) {
+		invitation := invite("")
+
+		token := accessToken(register(GenerateRandomEmail(), invitation.Data.Token))
+		assert.Equal(t, []string{orgId}, organisations(token))
+
+		// an invitation can only be used once
+		assertInvalidField(register(GenerateRandomEmail(), invitation.Data.Token), "invitationToken")
+		assertInvalidField(register(GenerateRandomEmail(), "inv_unknown"), "invitationToken")
+	})
+
+	t.Run("test invitations to an email", func(t *testing.T) {
+		email := GenerateRandomEmail()
+		invitation := invite(email)
+
+		mailer.mu.Lock()
+		sent := mailer.sent[len(mailer.sent)-1]
+		mailer.mu.Unlock()
+		assert.Equal(t, email, sent.To)
+		assert.Contains(t, sent.Body, invitation.Data.Token)
+
+		w := DoRequest("POST", invitationsURL, owner.Data.AccessToken, map[string]string{"email": "not an email"})
+		assertInvalidField(w, "email")
+
+		// a rejected registration is rolled back
+		other := GenerateRandomEmail()
+		assertInvalidField(register(other, invitation.Data.Token), "invitationToken")
+		var count int64
+		db.Model(&models.User{}).Where("email = ?", other).Count(&count)
+		assert.Zero(t, count)
+
+		token := accessToken(register(strings.ToUpper(email), invitation.Data.Token))
+		assert.Equal(t, []string{orgId}, organisations(token))
+	})
+
+	t.Run("test existing users accept invitations", func(t *testing.T) {
+		invitation := invite("")
+		user, _ := RegisterRandomUser(t)
+
+		w := DoRequest("POST", "/api/invitations/accept", user.Data.AccessToken, map[string]string{})
+		assertInvalidField(w, "token")
+
+		w = DoRequest("POST", "/api/invitations/accept", user.Data.AccessToken, map[string]string{"token": invitation.Data.Token})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, organisations(user.Data.AccessToken), orgId)
+
+		w = DoRequest("POST", "/api/invitations/accept", user.Data.AccessToken, map[string]string{"token": invitation.Data.Token})
+		assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+	})
+
+	t.Run("test revoked invitations", func(t *testing.T) {
+		var list struct {
+			Data struct {
+				Invitations []struct {
+					InvitationID string `json:"invitationId"`
+				} `json:"invitations"`
+			} `json:"data"`
+		}
+
+		invitation := invite("")
+		other, _ := RegisterRandomUser(t)
+
+		w := DoRequest("GET", invitationsURL, other.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+		w = DoRequest("DELETE", invitationsURL+"/"+invitation.Data.InvitationID, other.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+
+		w = DoRequest("GET", invitationsURL, owner.Data.AccessToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&list))
+		assert.Equal(t, invitation.Data.InvitationID, list.Data.Invitations[len(list.Data.Invitations)-1].InvitationID)
+
+		w = DoRequest("DELETE", invitationsURL+"/"+invitation.Data.InvitationID, owner.Data.AccessToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		assertInvalidField(register(GenerateRandomEmail(), invitation.Data.Token), "invitationToken")
+	})
+
+	t.Run("test members cannot manage invitations", func(t *testing.T) {
+		invitation := invite("")
+		member, _ := RegisterRandomUser(t)
+		w := DoRequest("POST", "/api/invitations/accept", member.Data.AccessToken, map[string]string{"token": invitation.Data.Token})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = DoRequest("POST", invitationsURL, member.Data.AccessToken, map[string]string{})
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+		w = DoRequest("GET", invitationsURL, member.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+		w = DoRequest("DELETE", invitationsURL+"/"+invitation.Data.InvitationID, member.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+	})
+
+	t.Run("test other default organisations", func(t *testing.T) {
+		defaultHooks := registration.Hooks
+		t.Cleanup(func() { registration.Hooks = defaultHooks })
+
+		_, err := controllers.NewRegistrationPipeline("team")
+		assert.NotNil(t, err)
+
+		none, err := controllers.NewRegistrationPipeline(controllers.RegistrationOrganisationNone)
+		require.Nil(t, err)
+		registration.Hooks = none.Hooks
+		token := accessToken(register(GenerateRandomEmail(), ""))
+		assert.Empty(t, organisations(token))
+
+		invitationOnly, err := controllers.NewRegistrationPipeline(controllers.RegistrationOrganisationInvitation)
+		require.Nil(t, err)
+		registration.Hooks = invitationOnly.Hooks
+		assertInvalidField(register(GenerateRandomEmail(), ""), "invitationToken")
+		token = accessToken(register(GenerateRandomEmail(), invite("").Data.Token))
+		assert.Equal(t, []string{orgId}, organisations(token))
+	})
+
+	t.Run("test deployment hooks run in the registration transaction", func(t *testing.T) {
+		defaultHooks := registration.Hooks
+		t.Cleanup(func() { registration.Hooks = defaultHooks })
+
+		// seeds a second organisation, or fails after the user was created
+		pipeline, err := controllers.NewRegistrationPipeline("", func(tx *gorm.DB, r *controllers.Registration) error {
+			if r.User.LastName == "Fail" {
+				return fmt.Errorf("failing hook")
+			}
+			org := models.Organisation{Name: "Sandbox", CreatedByID: r.User.ID}
+			if err := tx.Create(&org).Error; err != nil {
+				return err
+			}
+			return tx.Model(&org).Association("Users").Append(&r.User)
+		})
+		require.Nil(t, err)
+		registration.Hooks = pipeline.Hooks
+
+		user, _ := RegisterRandomUser(t)
+		assert.Len(t, organisations(user.Data.AccessToken), 2)
+
+		email := GenerateRandomEmail()
+		w := DoRequest("POST", "/auth/register", "", map[string]string{
+			"firstName": GenerateRandomString(10),
+			"lastName":  "Fail",
+			"email":     email,
+			"phone":     GenerateRandomNumber(),
+			"password":  GenerateRandomString(8),
+		})
+		assert.Equal(t, http.StatusInternalServerError, w.Code, w.Body.String())
+
+		var count int64
+		db.Model(&models.User{}).Where("email = ?", email).Count(&count)
+		assert.Zero(t, count)
+	})
+}
+
+func TestOrganisationPagination(t *testing.T) {
+	type pageResp struct {
+		Data struct {
+			Organisations []struct {
+				OrgID string `json:"orgId"`
+				Name  string `json:"name"`
+			} `json:"organisations"`
+			Pagination utils.PageInfo `json:"pagination"`
+		} `json:"data"`
+	}
+
+	// a known name sorts the personal organisation last
+	var user RegisterSuccessResponse
+	w := DoRequest("POST", "/auth/register", "", map[string]string{
+		"firstName": "Zed",
+		"lastName":  GenerateRandomString(10),
+		"email":     GenerateRandomEmail(),
+		"phone":     GenerateRandomNumber(),
+		"password":  GenerateRandomString(8),
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	require.Nil(t, json.NewDecoder(w.Body).Decode(&user))
+	token := user.Data.AccessToken
+	names := []string{"Zed's Organisation"}
+	for _, name := range []string{"delta", "Alpha", "Gamma", "beta", "Epsilon"} {
+		w := DoRequest("POST", "/api/organisations", token, map[string]string{"name": name})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		names = append(names, name)
+	}
+
+	getPage := func(t *testing.T, query string) pageResp {
+		var resp pageResp
+		w := DoRequest("GET", "/api/organisations?"+query, token, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&resp))
+		return resp
+	}
+	pageNames := func(resp pageResp) []string {
+		res := []string{}
+		for _, org := range resp.Data.Organisations {
+			res = append(res, org.Name)
+		}
+		return res
+	}
+
+	t.Run("test cursors walk through all organisations", func(t *testing.T) {
+		var walked []string
+		var pages []pageResp
+
+		page := getPage(t, "limit=4")
+		assert.Empty(t, page.Data.Pagination.PrevCursor)
+		for {
+			assert.Equal(t, int64(6), page.Data.Pagination.Total)
+			assert.Equal(t, "createdAt", page.Data.Pagination.Sort)
+			pages = append(pages, page)
+			walked = append(walked, pageNames(page)...)
+			if page.Data.Pagination.NextCursor == "" {
+				break
+			}
+			page = getPage(t, "limit=4&cursor="+page.Data.Pagination.NextCursor)
+		}
+		assert.Equal(t, names, walked)
+		require.Len(t, pages, 2)
+		assert.Len(t, pages[1].Data.Organisations, 2)
+
+		prev := getPage(t, "limit=4&cursor="+pages[1].Data.Pagination.PrevCursor)
+		assert.Equal(t, pageNames(pages[0]), pageNames(prev))
+		assert.Empty(t, prev.Data.Pagination.PrevCursor)
+		assert.NotEmpty(t, prev.Data.Pagination.NextCursor)
+
+		assert.Len(t, getPage(t, "").Data.Organisations, 6)
+	})
+
+	t.Run("test sorting by name", func(t *testing.T) {
+		page := getPage(t, "sort=name&limit=2&q=t")
+		assert.Equal(t, []string{"beta", "delta"}, pageNames(page))
+		page = getPage(t, "limit=2&q=t&cursor="+page.Data.Pagination.NextCursor)
+		assert.Equal(t, []string{"Zed's Organisation"}, pageNames(page))
+		assert.Empty(t, page.Data.Pagination.NextCursor)
+
+		page = getPage(t, "sort=-name&limit=1&prefix=e")
+		assert.Equal(t, []string{"Epsilon"}, pageNames(page))
+		assert.Empty(t, page.Data.Pagination.NextCursor)
+
+		page = getPage(t, "sort=-name&limit=2&q=l")
+		assert.Equal(t, []string{"Epsilon", "delta"}, pageNames(page))
+		page = getPage(t, "sort=-name&limit=2&q=l&cursor="+page.Data.Pagination.NextCursor)
+		assert.Equal(t, []string{"Alpha"}, pageNames(page))
+
+		// a cursor only continues the order it was created with
+		w := DoRequest("GET", "/api/organisations?sort=name&cursor="+page.Data.Pagination.PrevCursor, token, nil)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code, w.Body.String())
+	})
+
+	t.Run("test filtering by name", func(t *testing.T) {
+		assert.Equal(t, []string{"delta", "beta"}, pageNames(getPage(t, "q=TA")))
+		assert.Equal(t, []string{"Alpha"}, pageNames(getPage(t, "prefix=al")))
+		assert.Empty(t, pageNames(getPage(t, "q=%25")))
+		assert.Empty(t, pageNames(getPage(t, "prefix=_lpha")))
+
+		page := getPage(t, "prefix=a&limit=1")
+		assert.Equal(t, int64(1), page.Data.Pagination.Total)
+		assert.Empty(t, page.Data.Pagination.NextCursor)
+	})
+
+	t.Run("test invalid parameters", func(t *testing.T) {
+		for _, query := range []string{"sort=email", "cursor=invalid", "cursor=e30"} {
+			w := DoRequest("GET", "/api/organisations?"+query, token, nil)
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Code, query)
+		}
+	})
+
+	t.Run("test stale cursors are rejected", func(t *testing.T) {
+		var created struct {
+			Data struct {
+				OrgID string `json:"orgId"`
+			} `json:"data"`
+		}
+		w := DoRequest("POST", "/api/organisations", token, map[string]string{"name": "Omega"})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&created))
+
+		page := getPage(t, "sort=-createdAt&limit=1")
+		require.Equal(t, []string{"Omega"}, pageNames(page))
+		cursor := page.Data.Pagination.NextCursor
+
+		// a cursor row deleted between pages must not end the listing silently
+		require.Nil(t, db.Exec("DELETE FROM users_organisations WHERE organisation_id = ?", created.Data.OrgID).Error)
+		require.Nil(t, db.Unscoped().Delete(&models.Organisation{}, created.Data.OrgID).Error)
+		w = DoRequest("GET", "/api/organisations?cursor="+cursor, token, nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+	})
+}
+
+func TestSearch(t *testing.T) {
+	type searchResp struct {
+		Data struct {
+			Organisations []struct {
+				OrgID string `json:"orgId"`
+				Name  string `json:"name"`
+			} `json:"organisations"`
+			Users []struct {
+				UserID string `json:"userId"`
+			} `json:"users"`
+		} `json:"data"`
+	}
+
+	search := func(t *testing.T, token, query string) searchResp {
+		var resp searchResp
+		w := DoRequest("GET", "/api/search?"+query, token, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&resp))
+		return resp
+	}
+	createOrg := func(t *testing.T, token, name string) string {
+		var resp struct {
+			Data struct {
+				OrgID string `json:"orgId"`
+			} `json:"data"`
+		}
+		w := DoRequest("POST", "/api/organisations", token, map[string]string{"name": name})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&resp))
+		return resp.Data.OrgID
+	}
+
+	term := strings.ToLower(GenerateRandomString(12))
+	owner, _ := RegisterRandomUser(t)
+	colleague, colleagueParams := RegisterRandomUser(t)
+	stranger, strangerParams := RegisterRandomUser(t)
+
+	orgId := createOrg(t, owner.Data.AccessToken, "Other "+term+" labs")
+	createOrg(t, owner.Data.AccessToken, term+" works")
+	createOrg(t, stranger.Data.AccessToken, term+" hidden")
+
+	w := DoRequest("POST", "/api/organisations/"+orgId+"/users", owner.Data.AccessToken, map[string]string{"userId": colleague.Data.User.UserID})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	t.Run("test organisations are found by partial name", func(t *testing.T) {
+		resp := search(t, owner.Data.AccessToken, "q="+strings.ToUpper(term[:8]))
+		require.Len(t, resp.Data.Organisations, 2)
+
+		// the name starting with the query ranks first
+		assert.Equal(t, term+" works", resp.Data.Organisations[0].Name)
+		assert.Equal(t, orgId, resp.Data.Organisations[1].OrgID)
+
+		resp = search(t, colleague.Data.AccessToken, "q="+term)
+		require.Len(t, resp.Data.Organisations, 1)
+		assert.Equal(t, orgId, resp.Data.Organisations[0].OrgID)
+
+		resp = search(t, owner.Data.AccessToken, "q="+term+"&limit=1")
+		assert.Len(t, resp.Data.Organisations, 1)
+	})
+
+	t.Run("test users are found among colleagues", func(t *testing.T) {
+		resp := search(t, owner.Data.AccessToken, "q="+url.QueryEscape(colleagueParams["email"]))
+		require.Len(t, resp.Data.Users, 1)
+		assert.Equal(t, colleague.Data.User.UserID, resp.Data.Users[0].UserID)
+
+		resp = search(t, owner.Data.AccessToken, "q="+colleagueParams["lastName"][:6])
+		require.Len(t, resp.Data.Users, 1)
+		assert.Equal(t, colleague.Data.User.UserID, resp.Data.Users[0].UserID)
+
+		resp = search(t, owner.Data.AccessToken, "q="+url.QueryEscape(strangerParams["email"]))
+		assert.Empty(t, resp.Data.Users)
+	})
+
+	t.Run("test users need the users:read scope", func(t *testing.T) {
+		var tokenResp struct {
+			Data struct {
+				Token string `json:"token"`
+			} `json:"data"`
+		}
+		w := DoRequest("POST", "/api/tokens", owner.Data.AccessToken, map[string]interface{}{
+			"name":          "search",
+			"scopes":        []string{"orgs:read"},
+			"expiresInDays": 1,
+		})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&tokenResp))
+
+		resp := search(t, tokenResp.Data.Token, "q="+url.QueryEscape(colleagueParams["email"]))
+		assert.Empty(t, resp.Data.Users)
+		resp = search(t, tokenResp.Data.Token, "q="+term)
+		assert.Len(t, resp.Data.Organisations, 2)
+	})
+
+	t.Run("test invalid queries", func(t *testing.T) {
+		for _, query := range []string{"", "q=", "q=a&limit=100", "q=a&limit=x"} {
+			w := DoRequest("GET", "/api/search?"+query, owner.Data.AccessToken, nil)
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Code, query)
+		}
+
+		w := DoRequest("GET", "/api/search?q=a", "", nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code, w.Body.String())
+
+		assert.Empty(t, search(t, owner.Data.AccessToken, "q=%25%25").Data.Organisations)
+	})
+}
+
+func TestTeams(t *testing.T) {
+	type teamResp struct {
+		Data struct {
+			TeamID   string  `json:"teamId"`
+			ParentID *string `json:"parentId"`
+			Name     string  `json:"name"`
+			Role     string  `json:"role"`
+		} `json:"data"`
+	}
+	type teamsResp struct {
+		Data struct {
+			Teams []struct {
+				Name string `json:"name"`
+			} `json:"teams"`
+		} `json:"data"`
+	}
+
+	owner, _ := RegisterRandomUser(t)
+	member, _ := RegisterRandomUser(t)
+	colleague, _ := RegisterRandomUser(t)
+	stranger, _ := RegisterRandomUser(t)
+
+	w := DoRequest("POST", "/api/organisations", owner.Data.AccessToken, map[string]string{"name": "Teams"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	orgId := regexp.MustCompile(`"orgId":"(\d+)"`).FindStringSubmatch(w.Body.String())[1]
+	orgURL := "/api/organisations/" + orgId
+	for _, user := range []RegisterSuccessResponse{member, colleague} {
+		w := DoRequest("POST", orgURL+"/users", owner.Data.AccessToken, map[string]string{"userId": user.Data.User.UserID})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+
+	createTeam := func(t *testing.T, params map[string]string) teamResp {
+		var resp teamResp
+		w := DoRequest("POST", orgURL+"/teams", owner.Data.AccessToken, params)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&resp))
+		return resp
+	}
+	teamNames := func(t *testing.T, url string) []string {
+		var resp teamsResp
+		w := DoRequest("GET", url, member.Data.AccessToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&resp))
+
+		names := []string{}
+		for _, team := range resp.Data.Teams {
+			names = append(names, team.Name)
+		}
+		return names
+	}
+	// only admins can manage invitations
+	canManage := func(user RegisterSuccessResponse) bool {
+		w := DoRequest("GET", orgURL+"/invitations", user.Data.AccessToken, nil)
+		return w.Code == http.StatusOK
+	}
+
+	engineering := createTeam(t, map[string]string{"name": "Engineering"})
+	assert.Equal(t, models.RoleMember, engineering.Data.Role)
+	assert.Nil(t, engineering.Data.ParentID)
+	platform := createTeam(t, map[string]string{"name": "Platform", "parentId": engineering.Data.TeamID})
+	require.NotNil(t, platform.Data.ParentID)
+	assert.Equal(t, engineering.Data.TeamID, *platform.Data.ParentID)
+	leads := createTeam(t, map[string]string{"name": "Leads", "role": models.RoleAdmin})
+	engineeringURL := orgURL + "/teams/" + engineering.Data.TeamID
+	platformURL := orgURL + "/teams/" + platform.Data.TeamID
+	leadsURL := orgURL + "/teams/" + leads.Data.TeamID
+
+	t.Run("test members see teams but cannot manage them", func(t *testing.T) {
+		assert.Equal(t, []string{"Engineering", "Platform", "Leads"}, teamNames(t, orgURL+"/teams"))
+
+		w := DoRequest("GET", platformURL, member.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = DoRequest("POST", orgURL+"/teams", member.Data.AccessToken, map[string]string{"name": "Rogue"})
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+		w = DoRequest("POST", leadsURL+"/members", member.Data.AccessToken, map[string]string{"userId": member.Data.User.UserID})
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+		assert.False(t, canManage(member))
+
+		// any member can still add users
+		newcomer, _ := RegisterRandomUser(t)
+		w = DoRequest("POST", orgURL+"/users", member.Data.AccessToken, map[string]string{"userId": newcomer.Data.User.UserID})
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = DoRequest("GET", orgURL+"/teams", stranger.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+		w = DoRequest("GET", platformURL, stranger.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+	})
+
+	t.Run("test teams are made of members of the organisation", func(t *testing.T) {
+		for _, userId := range []string{stranger.Data.User.UserID, "0", "x"} {
+			w := DoRequest("POST", platformURL+"/members", owner.Data.AccessToken, map[string]string{"userId": userId})
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Code, w.Body.String())
+		}
+
+		w := DoRequest("POST", platformURL+"/members", owner.Data.AccessToken, map[string]string{"userId": colleague.Data.User.UserID})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		w = DoRequest("GET", platformURL+"/members", member.Data.AccessToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), colleague.Data.User.Email)
+
+		// sub-team members are effective members of the teams above
+		assert.Equal(t, []string{"Engineering", "Platform"}, teamNames(t, orgURL+"/users/"+colleague.Data.User.UserID+"/teams"))
+		assert.Empty(t, teamNames(t, orgURL+"/users/"+member.Data.User.UserID+"/teams"))
+
+		w = DoRequest("GET", orgURL+"/users/"+stranger.Data.User.UserID+"/teams", member.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+	})
+
+	t.Run("test team roles flow to their members", func(t *testing.T) {
+		w := DoRequest("POST", leadsURL+"/members", owner.Data.AccessToken, map[string]string{"userId": member.Data.User.UserID})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.True(t, canManage(member))
+
+		w = DoRequest("POST", orgURL+"/teams", member.Data.AccessToken, map[string]string{"name": "Delegated"})
+		assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+		w = DoRequest("DELETE", leadsURL+"/members/"+member.Data.User.UserID, owner.Data.AccessToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		w = DoRequest("DELETE", leadsURL+"/members/"+member.Data.User.UserID, owner.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+		assert.False(t, canManage(member))
+
+		// the role of a team reaches the members of its sub-teams
+		assert.False(t, canManage(colleague))
+		w = DoRequest("PATCH", engineeringURL, owner.Data.AccessToken, map[string]string{"role": models.RoleAdmin})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.True(t, canManage(colleague))
+
+		w = DoRequest("PATCH", engineeringURL, owner.Data.AccessToken, map[string]string{"role": "owner"})
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code, w.Body.String())
+	})
+
+	t.Run("test teams form a tree", func(t *testing.T) {
+		for _, parentId := range []string{engineering.Data.TeamID, platform.Data.TeamID, "0", "x"} {
+			w := DoRequest("PATCH", engineeringURL, owner.Data.AccessToken, map[string]string{"parentId": parentId})
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Code, parentId)
+		}
+
+		w := DoRequest("DELETE", engineeringURL, owner.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+
+		var moved teamResp
+		w = DoRequest("PATCH", platformURL, owner.Data.AccessToken, map[string]string{"parentId": "", "name": "Infrastructure"})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&moved))
+		assert.Nil(t, moved.Data.ParentID)
+		assert.Equal(t, "Infrastructure", moved.Data.Name)
+
+		// out of Engineering, its members lose the role it grants
+		assert.Equal(t, []string{"Infrastructure"}, teamNames(t, orgURL+"/users/"+colleague.Data.User.UserID+"/teams"))
+		assert.False(t, canManage(colleague))
+
+		w = DoRequest("DELETE", engineeringURL, owner.Data.AccessToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		w = DoRequest("GET", engineeringURL, owner.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+	})
+}
+
+func TestAuditLog(t *testing.T) {
+	type auditResp struct {
+		Data struct {
+			Events []struct {
+				EventID    string                 `json:"eventId"`
+				ActorType  string                 `json:"actorType"`
+				ActorID    *string                `json:"actorId"`
+				Action     string                 `json:"action"`
+				TargetType string                 `json:"targetType"`
+				TargetID   string                 `json:"targetId"`
+				Metadata   map[string]interface{} `json:"metadata"`
+				IP         string                 `json:"ip"`
+				UserAgent  string                 `json:"userAgent"`
+			} `json:"events"`
+			Pagination utils.PageInfo `json:"pagination"`
+		} `json:"data"`
+	}
+
+	owner, _ := RegisterRandomUser(t)
+	member, _ := RegisterRandomUser(t)
+	stranger, _ := RegisterRandomUser(t)
+
+	w := DoRequest("POST", "/api/organisations", owner.Data.AccessToken, map[string]string{"name": "Audited"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	orgId := regexp.MustCompile(`"orgId":"(\d+)"`).FindStringSubmatch(w.Body.String())[1]
+	orgURL := "/api/organisations/" + orgId
+
+	// the user agent and IP of the request are recorded
+	buf, _ := json.Marshal(map[string]string{"userId": member.Data.User.UserID})
+	req, _ := http.NewRequest("POST", orgURL+"/users", bytes.NewReader(buf))
+	req.Header.Set("Authorization", "Bearer "+owner.Data.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "audit-test/1.0")
+	req.RemoteAddr = "203.0.113.7:41000"
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = DoRequest("POST", orgURL+"/teams", owner.Data.AccessToken, map[string]string{"name": "Auditors"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	teamId := regexp.MustCompile(`"teamId":"(\d+)"`).FindStringSubmatch(w.Body.String())[1]
+	w = DoRequest("POST", orgURL+"/invitations", owner.Data.AccessToken, map[string]string{})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	auditLog := func(t *testing.T, query string) auditResp {
+		var resp auditResp
+		w := DoRequest("GET", orgURL+"/audit-log?"+query, owner.Data.AccessToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&resp))
+		return resp
+	}
+
+	t.Run("test mutations are recorded newest first", func(t *testing.T) {
+		resp := auditLog(t, "")
+		actions := []string{}
+		for _, event := range resp.Data.Events {
+			actions = append(actions, event.Action)
+		}
+		assert.Equal(t, []string{models.AuditInvitationCreated, models.AuditTeamCreated, models.AuditMemberAdded, models.AuditOrganisationCreated}, actions)
+
+		added := resp.Data.Events[2]
+		assert.Equal(t, models.AuditActorUser, added.ActorType)
+		require.NotNil(t, added.ActorID)
+		assert.Equal(t, owner.Data.User.UserID, *added.ActorID)
+		assert.Equal(t, models.AuditTargetUser, added.TargetType)
+		assert.Equal(t, member.Data.User.UserID, added.TargetID)
+		assert.Equal(t, "audit-test/1.0", added.UserAgent)
+		assert.Equal(t, "203.0.113.7", added.IP)
+
+		assert.Equal(t, teamId, resp.Data.Events[1].TargetID)
+		assert.Equal(t, "Auditors", resp.Data.Events[1].Metadata["name"])
+	})
+
+	t.Run("test filters and pagination", func(t *testing.T) {
+		resp := auditLog(t, "action="+models.AuditTeamCreated)
+		require.Len(t, resp.Data.Events, 1)
+		assert.Equal(t, teamId, resp.Data.Events[0].TargetID)
+
+		resp = auditLog(t, "targetType=user&targetId="+member.Data.User.UserID)
+		require.Len(t, resp.Data.Events, 1)
+		assert.Equal(t, models.AuditMemberAdded, resp.Data.Events[0].Action)
+
+		assert.Len(t, auditLog(t, "actorId="+owner.Data.User.UserID).Data.Events, 4)
+		assert.Empty(t, auditLog(t, "actorId="+member.Data.User.UserID).Data.Events)
+		assert.Empty(t, auditLog(t, "since="+url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339))).Data.Events)
+		assert.Len(t, auditLog(t, "until="+url.QueryEscape(time.Now().Add(time.Hour).Format(time.RFC3339))).Data.Events, 4)
+
+		first := auditLog(t, "limit=3&sort=createdAt")
+		require.Len(t, first.Data.Events, 3)
+		assert.Equal(t, models.AuditOrganisationCreated, first.Data.Events[0].Action)
+		require.NotEmpty(t, first.Data.Pagination.NextCursor)
+		next := auditLog(t, "limit=3&cursor="+first.Data.Pagination.NextCursor)
+		require.Len(t, next.Data.Events, 1)
+		assert.Equal(t, models.AuditInvitationCreated, next.Data.Events[0].Action)
+
+		for _, query := range []string{"actorId=x", "since=yesterday", "sort=action"} {
+			w := DoRequest("GET", orgURL+"/audit-log?"+query, owner.Data.AccessToken, nil)
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Code, query)
+		}
+	})
+
+	t.Run("test only admins read the audit log", func(t *testing.T) {
+		for _, path := range []string{"/audit-log", "/audit-log/export"} {
+			w := DoRequest("GET", orgURL+path, member.Data.AccessToken, nil)
+			assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+			w = DoRequest("GET", orgURL+path, stranger.Data.AccessToken, nil)
+			assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("test export", func(t *testing.T) {
+		w := DoRequest("GET", orgURL+"/audit-log/export", owner.Data.AccessToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Header().Get("Content-Disposition"), "audit-log-"+orgId+".csv")
+		records, err := csv.NewReader(w.Body).ReadAll()
+		require.Nil(t, err)
+		require.Len(t, records, 5)
+		assert.Equal(t, "eventId", records[0][0])
+		assert.Equal(t, models.AuditOrganisationCreated, records[1][5])
+
+		w = DoRequest("GET", orgURL+"/audit-log/export?format=jsonl&action="+models.AuditTeamCreated, owner.Data.AccessToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+		require.Len(t, lines, 1)
+		var event map[string]interface{}
+		require.Nil(t, json.Unmarshal([]byte(lines[0]), &event))
+		assert.Equal(t, teamId, event["targetId"])
+
+		w = DoRequest("GET", orgURL+"/audit-log/export?format=xml", owner.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusUnprocessableEntity, w.Code, w.Body.String())
+	})
+
+	t.Run("test events cannot be changed", func(t *testing.T) {
+		var event models.AuditEvent
+		require.Nil(t, db.Where("organisation_id = ?", orgId).First(&event).Error)
+
+		assert.ErrorIs(t, db.Model(&event).Update("action", "forged").Error, models.ErrAuditEventImmutable)
+		assert.ErrorIs(t, db.Delete(&event).Error, models.ErrAuditEventImmutable)
+		assert.Len(t, auditLog(t, "").Data.Events, 4)
+	})
+}
+
+func TestAuditChain(t *testing.T) {
+	type verifyResp struct {
+		Data utils.AuditVerification `json:"data"`
+	}
+
+	owner, _ := RegisterRandomUser(t)
+	member, _ := RegisterRandomUser(t)
+
+	w := DoRequest("POST", "/api/organisations", owner.Data.AccessToken, map[string]string{"name": "Chained"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	orgId := regexp.MustCompile(`"orgId":"(\d+)"`).FindStringSubmatch(w.Body.String())[1]
+	orgURL := "/api/organisations/" + orgId
+	for _, name := range []string{"One", "Two", "Three"} {
+		w := DoRequest("POST", orgURL+"/teams", owner.Data.AccessToken, map[string]string{"name": name})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	}
+
+	verify := func(t *testing.T) utils.AuditVerification {
+		var resp verifyResp
+		w := DoRequest("GET", orgURL+"/audit-log/verify", owner.Data.AccessToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&resp))
+		return resp.Data
+	}
+	events := func(t *testing.T) []models.AuditEvent {
+		var events []models.AuditEvent
+		require.Nil(t, db.Where("organisation_id = ?", orgId).Order("sequence").Find(&events).Error)
+		return events
+	}
+	verifyAuditCLI := func(t *testing.T) (int, string) {
+		var out bytes.Buffer
+		code := verifyAudit(db, []string{orgId}, &out)
+		return code, out.String()
+	}
+
+	t.Run("test events are chained", func(t *testing.T) {
+		chain := events(t)
+		require.Len(t, chain, 4)
+		assert.Empty(t, chain[0].PrevHash)
+		for i, event := range chain {
+			assert.Equal(t, uint64(i+1), event.Sequence)
+			assert.Equal(t, event.ComputeHash(), event.Hash)
+			if i > 0 {
+				assert.Equal(t, chain[i-1].Hash, event.PrevHash)
+			}
+		}
+
+		verification := verify(t)
+		assert.True(t, verification.Valid)
+		assert.Equal(t, uint64(4), verification.Events)
+		assert.Nil(t, verification.BrokenAt)
+
+		code, out := verifyAuditCLI(t)
+		assert.Equal(t, 0, code, out)
+		assert.Contains(t, out, "ok, 4 event(s)")
+
+		w := DoRequest("POST", orgURL+"/users", owner.Data.AccessToken, map[string]string{"userId": member.Data.User.UserID})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		w = DoRequest("GET", orgURL+"/audit-log/verify", member.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+	})
+
+	t.Run("test checkpoints are signed once per change", func(t *testing.T) {
+		_, err := utils.CreateAuditCheckpoints(db)
+		require.Nil(t, err)
+		_, err = utils.CreateAuditCheckpoints(db)
+		require.Nil(t, err)
+
+		var checkpoints []models.AuditCheckpoint
+		require.Nil(t, db.Where("organisation_id = ?", orgId).Find(&checkpoints).Error)
+		require.Len(t, checkpoints, 1)
+		assert.Equal(t, uint64(5), checkpoints[0].Sequence)
+		assert.Equal(t, events(t)[4].Hash, checkpoints[0].Hash)
+
+		assert.ErrorIs(t, db.Model(&checkpoints[0]).Update("sequence", 1).Error, models.ErrAuditEventImmutable)
+		assert.Equal(t, 1, verify(t).Checkpoints)
+	})
+
+	t.Run("test tampering is reported at the first broken link", func(t *testing.T) {
+		chain := events(t)
+
+		// bypasses the model, as someone with database access could
+		require.Nil(t, db.Exec("UPDATE audit_events SET target_id = ? WHERE id = ?", "forged", chain[2].ID).Error)
+		verification := verify(t)
+		assert.False(t, verification.Valid)
+		require.NotNil(t, verification.BrokenAt)
+		assert.Equal(t, uint64(3), verification.BrokenAt.Sequence)
+		assert.Equal(t, fmt.Sprintf("%d", chain[2].ID), verification.BrokenAt.EventID)
+
+		code, out := verifyAuditCLI(t)
+		assert.Equal(t, 1, code, out)
+		assert.Contains(t, out, "BROKEN at event 3")
+
+		// rehashing the forged event breaks the link to the next one
+		forged := chain[2]
+		forged.TargetID = "forged"
+		require.Nil(t, db.Exec("UPDATE audit_events SET hash = ? WHERE id = ?", forged.ComputeHash(), forged.ID).Error)
+		verification = verify(t)
+		require.NotNil(t, verification.BrokenAt)
+		assert.Equal(t, uint64(4), verification.BrokenAt.Sequence)
+
+		require.Nil(t, db.Exec("UPDATE audit_events SET target_id = ?, hash = ? WHERE id = ?", chain[2].TargetID, chain[2].Hash, chain[2].ID).Error)
+		assert.True(t, verify(t).Valid)
+	})
+
+	t.Run("test removed events are reported", func(t *testing.T) {
+		chain := events(t)
+
+		// the last event is only missed thanks to the checkpoint
+		require.Nil(t, db.Exec("DELETE FROM audit_events WHERE id = ?", chain[4].ID).Error)
+		verification := verify(t)
+		require.NotNil(t, verification.BrokenAt)
+		assert.Equal(t, uint64(5), verification.BrokenAt.Sequence)
+		assert.NotEmpty(t, verification.BrokenAt.CheckpointID)
+
+		require.Nil(t, db.Exec("DELETE FROM audit_events WHERE id = ?", chain[1].ID).Error)
+		verification = verify(t)
+		require.NotNil(t, verification.BrokenAt)
+		assert.Equal(t, uint64(2), verification.BrokenAt.Sequence)
+		assert.Equal(t, "event is missing", verification.BrokenAt.Reason)
+	})
+}
+
+func TestWebhooks(t *testing.T) {
+	type webhookResp struct {
+		Data struct {
+			WebhookID string   `json:"webhookId"`
+			Events    []string `json:"events"`
+			Active    bool     `json:"active"`
+			Secret    string   `json:"secret"`
+		} `json:"data"`
+	}
+	type deliveryResp struct {
+		DeliveryID     string  `json:"deliveryId"`
+		EventID        string  `json:"eventId"`
+		Event          string  `json:"event"`
+		Status         string  `json:"status"`
+		Attempts       int     `json:"attempts"`
+		ResponseStatus int     `json:"responseStatus"`
+		NextAttemptAt  *string `json:"nextAttemptAt"`
+		ReplayOf       *string `json:"replayOf"`
+	}
+	type received struct {
+		Header http.Header
+		Body   []byte
+	}
+
+	var mu sync.Mutex
+	var requests []received
+	status := http.StatusOK
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		requests = append(requests, received{Header: r.Header, Body: body})
+		w.WriteHeader(status)
+	}))
+	defer receiver.Close()
+
+	respondWith := func(code int) {
+		mu.Lock()
+		defer mu.Unlock()
+		status = code
+	}
+	lastRequest := func(t *testing.T) received {
+		mu.Lock()
+		defer mu.Unlock()
+		require.NotEmpty(t, requests)
+		return requests[len(requests)-1]
+	}
+
+	relay := utils.OutboxRelay{DB: db, Sinks: []utils.OutboxSink{utils.WebhookSink{DB: db}}}
+	sender := utils.WebhookSender{DB: db, Client: utils.NewWebhookClient(true)}
+	deliver := func(t *testing.T) int {
+		for {
+			n, err := relay.RelayDue(context.Background())
+			require.Nil(t, err)
+			if n == 0 {
+				break
+			}
+		}
+		n, err := sender.DeliverDue(context.Background())
+		require.Nil(t, err)
+		return n
+	}
+
+	owner, _ := RegisterRandomUser(t)
+	member, _ := RegisterRandomUser(t)
+	w := DoRequest("POST", "/api/organisations", owner.Data.AccessToken, map[string]string{"name": "Hooked"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	orgId := regexp.MustCompile(`"orgId":"(\d+)"`).FindStringSubmatch(w.Body.String())[1]
+	orgURL := "/api/organisations/" + orgId
+
+	var webhook webhookResp
+	w = DoRequest("POST", orgURL+"/webhooks", owner.Data.AccessToken, map[string]interface{}{
+		"url":    receiver.URL + "/hooks",
+		"events": []string{models.WebhookMemberAdded, models.WebhookUserRegistered},
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	require.Nil(t, json.NewDecoder(w.Body).Decode(&webhook))
+	require.True(t, strings.HasPrefix(webhook.Data.Secret, models.WebhookSecretPrefix))
+	assert.True(t, webhook.Data.Active)
+	webhookURL := orgURL + "/webhooks/" + webhook.Data.WebhookID
+
+	deliveries := func(t *testing.T, query string) []deliveryResp {
+		var resp struct {
+			Data struct {
+				Deliveries []deliveryResp `json:"deliveries"`
+			} `json:"data"`
+		}
+		w := DoRequest("GET", webhookURL+"/deliveries?"+query, owner.Data.AccessToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&resp))
+		return resp.Data.Deliveries
+	}
+
+	t.Run("test validation", func(t *testing.T) {
+		for _, params := range []map[string]interface{}{
+			{"url": "ftp://example.com", "events": []string{models.WebhookMemberAdded}},
+			{"url": "https://example.com", "events": []string{"user.deleted"}},
+			{"url": "https://example.com", "events": []string{}},
+		} {
+			w := DoRequest("POST", orgURL+"/webhooks", owner.Data.AccessToken, params)
+			assert.Equal(t, http.StatusUnprocessableEntity, w.Code, w.Body.String())
+		}
+
+		// the secret is only shown when the webhook is created
+		w := DoRequest("GET", webhookURL, owner.Data.AccessToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.NotContains(t, w.Body.String(), webhook.Data.Secret)
+	})
+
+	t.Run("test signed deliveries of membership events", func(t *testing.T) {
+		w := DoRequest("POST", orgURL+"/users", owner.Data.AccessToken, map[string]string{"userId": member.Data.User.UserID})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, 1, deliver(t))
+
+		req := lastRequest(t)
+		assert.Equal(t, models.WebhookMemberAdded, req.Header.Get("X-Webhook-Event"))
+		signature := req.Header.Get("X-Webhook-Signature")
+		timestamp, err := strconv.ParseInt(regexp.MustCompile(`^t=(\d+),`).FindStringSubmatch(signature)[1], 10, 64)
+		require.Nil(t, err)
+		assert.Equal(t, utils.SignWebhook(webhook.Data.Secret, timestamp, req.Body), signature)
+
+		var payload struct {
+			ID    string `json:"id"`
+			Event string `json:"event"`
+			OrgID string `json:"orgId"`
+			Data  struct {
+				User struct {
+					UserID string `json:"userId"`
+				} `json:"user"`
+			} `json:"data"`
+		}
+		require.Nil(t, json.Unmarshal(req.Body, &payload))
+		assert.Equal(t, req.Header.Get("X-Webhook-Event-Id"), payload.ID)
+		assert.Equal(t, orgId, payload.OrgID)
+		assert.Equal(t, member.Data.User.UserID, payload.Data.User.UserID)
+
+		sent := deliveries(t, "")
+		require.Len(t, sent, 1)
+		assert.Equal(t, models.WebhookDeliverySucceeded, sent[0].Status)
+		assert.Equal(t, http.StatusOK, sent[0].ResponseStatus)
+
+		// nothing is due anymore
+		assert.Equal(t, 0, deliver(t))
+	})
+
+	t.Run("test registrations through invitations are delivered", func(t *testing.T) {
+		var invitation struct {
+			Data struct {
+				Token string `json:"token"`
+			} `json:"data"`
+		}
+		w := DoRequest("POST", orgURL+"/invitations", owner.Data.AccessToken, map[string]string{})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&invitation))
+
+		email := GenerateRandomEmail()
+		w = DoRequest("POST", "/auth/register", "", map[string]string{
+			"firstName":       GenerateRandomString(10),
+			"lastName":        GenerateRandomString(10),
+			"email":           email,
+			"phone":           GenerateRandomNumber(),
+			"password":        GenerateRandomString(8),
+			"invitationToken": invitation.Data.Token,
+		})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		deliver(t)
+
+		events := []string{}
+		for _, delivery := range deliveries(t, "") {
+			events = append(events, delivery.Event)
+		}
+		assert.Contains(t, events, models.WebhookUserRegistered)
+		assert.Contains(t, string(lastRequest(t).Body), email)
+	})
+
+	t.Run("test failed deliveries are retried with backoff", func(t *testing.T) {
+		respondWith(http.StatusInternalServerError)
+		defer respondWith(http.StatusOK)
+
+		// organisation.created is not subscribed to
+		w := DoRequest("POST", "/api/organisations", owner.Data.AccessToken, map[string]string{"name": "Unhooked"})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		assert.Equal(t, 0, deliver(t))
+
+		user, _ := RegisterRandomUser(t)
+		w = DoRequest("POST", orgURL+"/users", owner.Data.AccessToken, map[string]string{"userId": user.Data.User.UserID})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Equal(t, 1, deliver(t))
+
+		failing := deliveries(t, "status="+models.WebhookDeliveryPending)
+		require.Len(t, failing, 1)
+		assert.Equal(t, 1, failing[0].Attempts)
+		assert.Equal(t, http.StatusInternalServerError, failing[0].ResponseStatus)
+		require.NotNil(t, failing[0].NextAttemptAt)
+		nextAttemptAt, err := time.Parse(time.RFC3339, *failing[0].NextAttemptAt)
+		require.Nil(t, err)
+		assert.True(t, nextAttemptAt.After(time.Now()))
+		assert.Equal(t, 0, deliver(t))
+
+		for i := 1; i < models.WebhookMaxAttempts; i++ {
+			require.Nil(t, db.Model(&models.WebhookDelivery{}).Where("id = ?", failing[0].DeliveryID).Update("next_attempt_at", time.Now().Add(-time.Second)).Error)
+			assert.Equal(t, 1, deliver(t))
+		}
+
+		failed := deliveries(t, "status="+models.WebhookDeliveryFailed)
+		require.Len(t, failed, 1)
+		assert.Equal(t, models.WebhookMaxAttempts, failed[0].Attempts)
+		assert.Nil(t, failed[0].NextAttemptAt)
+	})
+
+	t.Run("test deliveries are replayed", func(t *testing.T) {
+		failed := deliveries(t, "status="+models.WebhookDeliveryFailed)[0]
+
+		var replay struct {
+			Data deliveryResp `json:"data"`
+		}
+		w := DoRequest("POST", webhookURL+"/deliveries/"+failed.DeliveryID+"/replay", owner.Data.AccessToken, nil)
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&replay))
+		require.NotNil(t, replay.Data.ReplayOf)
+		assert.Equal(t, failed.DeliveryID, *replay.Data.ReplayOf)
+		assert.Equal(t, failed.EventID, replay.Data.EventID)
+
+		assert.Equal(t, 1, deliver(t))
+		assert.Equal(t, failed.EventID, lastRequest(t).Header.Get("X-Webhook-Event-Id"))
+		w = DoRequest("GET", webhookURL+"/deliveries/"+replay.Data.DeliveryID, owner.Data.AccessToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), `"status":"succeeded"`)
+	})
+
+	t.Run("test disabled webhooks are not delivered to", func(t *testing.T) {
+		w := DoRequest("PATCH", webhookURL, owner.Data.AccessToken, map[string]interface{}{"active": false})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), `"active":false`)
+
+		count := len(deliveries(t, "limit=100"))
+		user, _ := RegisterRandomUser(t)
+		w = DoRequest("POST", orgURL+"/users", owner.Data.AccessToken, map[string]string{"userId": user.Data.User.UserID})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Len(t, deliveries(t, "limit=100"), count)
+	})
+
+	t.Run("test only admins manage webhooks", func(t *testing.T) {
+		stranger, _ := RegisterRandomUser(t)
+
+		for _, path := range []string{"/webhooks", "/webhooks/" + webhook.Data.WebhookID, "/webhooks/" + webhook.Data.WebhookID + "/deliveries"} {
+			w := DoRequest("GET", orgURL+path, member.Data.AccessToken, nil)
+			assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+			w = DoRequest("GET", orgURL+path, stranger.Data.AccessToken, nil)
+			assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+		}
+
+		w := DoRequest("DELETE", webhookURL, member.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusForbidden, w.Code, w.Body.String())
+		w = DoRequest("DELETE", webhookURL, owner.Data.AccessToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		w = DoRequest("GET", webhookURL, owner.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+	})
+}
+
+func TestOutbox(t *testing.T) {
+	type published struct {
+		Key     string
+		EventID string
+		Event   string
+		Payload string
+	}
+
+	var mu sync.Mutex
+	var natsMessages, kafkaRecords, busEvents []published
+	record := func(to *[]published, p published) {
+		mu.Lock()
+		defer mu.Unlock()
+		*to = append(*to, p)
+	}
+	// only what was published for events of the organisation, by event
+	forOrg := func(from *[]published, orgId string) map[string][]published {
+		mu.Lock()
+		defer mu.Unlock()
+		res := map[string][]published{}
+		for _, p := range *from {
+			var payload struct {
+				OrgID string `json:"orgId"`
+			}
+			json.Unmarshal([]byte(p.Payload), &payload)
+			if payload.OrgID == orgId {
+				res[p.EventID] = append(res[p.EventID], p)
+			}
+		}
+		return res
+	}
+
+	// a stand-in NATS server, taking published messages
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				conn.Write([]byte("INFO {\"headers\":true}\r\n"))
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					fields := strings.Fields(line)
+					switch {
+					case len(fields) == 1 && fields[0] == "PING":
+						conn.Write([]byte("PONG\r\n"))
+					case len(fields) == 4 && fields[0] == "HPUB":
+						headerLen, _ := strconv.Atoi(fields[2])
+						totalLen, _ := strconv.Atoi(fields[3])
+						buf := make([]byte, totalLen+2)
+						if _, err := io.ReadFull(r, buf); err != nil {
+							return
+						}
+						msgId := regexp.MustCompile(`Nats-Msg-Id: (\S+)`).FindStringSubmatch(string(buf[:headerLen]))
+						require.Len(t, msgId, 2)
+						record(&natsMessages, published{Key: fields[1], EventID: msgId[1], Payload: string(buf[headerLen:totalLen])})
+					}
+				}
+			}(conn)
+		}
+	}()
+
+	// a stand-in Kafka REST proxy
+	kafkaStatus := http.StatusOK
+	kafka := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		status := kafkaStatus
+		mu.Unlock()
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+
+		assert.Equal(t, "/topics/org-events", r.URL.Path)
+		assert.Equal(t, "application/vnd.kafka.json.v2+json", r.Header.Get("Content-Type"))
+		var body struct {
+			Records []struct {
+				Key   string          `json:"key"`
+				Value json.RawMessage `json:"value"`
+			} `json:"records"`
+		}
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&body))
+		for _, rec := range body.Records {
+			var payload struct {
+				ID    string `json:"id"`
+				Event string `json:"event"`
+			}
+			require.Nil(t, json.Unmarshal(rec.Value, &payload))
+			record(&kafkaRecords, published{Key: rec.Key, EventID: payload.ID, Event: payload.Event, Payload: string(rec.Value)})
+		}
+		w.Header().Set("Content-Type", "application/vnd.kafka.v2+json")
+		w.Write([]byte(`{"offsets":[{"partition":0,"offset":1,"error_code":null,"error":null}]}`))
+	}))
+	defer kafka.Close()
+	kafkaRespondWith := func(code int) {
+		mu.Lock()
+		defer mu.Unlock()
+		kafkaStatus = code
+	}
+
+	bus := utils.NewEventBus()
+	unsubscribe := bus.Subscribe(func(event models.OutboxEvent) {
+		record(&busEvents, published{Key: fmt.Sprintf("%d", event.OrganisationID), EventID: event.EventID, Event: event.Event, Payload: event.Payload})
+	})
+	defer unsubscribe()
+
+	nats := utils.NewNATSSink("nats://"+listener.Addr().String(), "events")
+	defer nats.Close()
+	relay := utils.NewOutboxRelay(db, utils.WebhookSink{DB: db}, bus, nats, utils.NewKafkaRESTSink(kafka.URL, "org-events"))
+	relayAll := func(t *testing.T) {
+		for {
+			n, err := relay.RelayDue(context.Background())
+			require.Nil(t, err)
+			if n == 0 {
+				return
+			}
+		}
+	}
+
+	owner, _ := RegisterRandomUser(t)
+	member, _ := RegisterRandomUser(t)
+	w := DoRequest("POST", "/api/organisations", owner.Data.AccessToken, map[string]string{"name": "Outboxed"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	orgId := regexp.MustCompile(`"orgId":"(\d+)"`).FindStringSubmatch(w.Body.String())[1]
+	orgURL := "/api/organisations/" + orgId
+
+	outbox := func(t *testing.T) []models.OutboxEvent {
+		var events []models.OutboxEvent
+		require.Nil(t, db.Where("organisation_id = ?", orgId).Order("id").Find(&events).Error)
+		return events
+	}
+
+	t.Run("test events are written with the change", func(t *testing.T) {
+		w := DoRequest("POST", orgURL+"/users", owner.Data.AccessToken, map[string]string{"userId": member.Data.User.UserID})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+		events := outbox(t)
+		require.Len(t, events, 2)
+		assert.Equal(t, models.WebhookOrganisationCreated, events[0].Event)
+		assert.Equal(t, models.WebhookMemberAdded, events[1].Event)
+		for _, event := range events {
+			assert.True(t, strings.HasPrefix(event.EventID, models.OutboxEventPrefix))
+			assert.Nil(t, event.PublishedAt)
+			assert.Contains(t, event.Payload, `"id":"`+event.EventID+`"`)
+		}
+	})
+
+	t.Run("test events are published to every sink", func(t *testing.T) {
+		relayAll(t)
+
+		events := outbox(t)
+		for _, event := range events {
+			assert.NotNil(t, event.PublishedAt)
+			assert.Equal(t, 1, event.Attempts)
+		}
+
+		for name, sink := range map[string]*[]published{"nats": &natsMessages, "kafka": &kafkaRecords, "bus": &busEvents} {
+			got := forOrg(sink, orgId)
+			assert.Len(t, got, 2, name)
+			for _, event := range events {
+				require.Len(t, got[event.EventID], 1, name)
+				assert.JSONEq(t, event.Payload, got[event.EventID][0].Payload, name)
+			}
+		}
+		assert.Equal(t, "events."+models.WebhookMemberAdded, forOrg(&natsMessages, orgId)[events[1].EventID][0].Key)
+		assert.Equal(t, orgId, forOrg(&kafkaRecords, orgId)[events[1].EventID][0].Key)
+
+		relayAll(t)
+		assert.Len(t, forOrg(&busEvents, orgId), 2)
+	})
+
+	t.Run("test events failing a sink are published again", func(t *testing.T) {
+		kafkaRespondWith(http.StatusServiceUnavailable)
+		defer kafkaRespondWith(http.StatusOK)
+
+		user, _ := RegisterRandomUser(t)
+		w := DoRequest("POST", orgURL+"/users", owner.Data.AccessToken, map[string]string{"userId": user.Data.User.UserID})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		relayAll(t)
+
+		event := outbox(t)[2]
+		assert.Nil(t, event.PublishedAt)
+		assert.Equal(t, 1, event.Attempts)
+		assert.Contains(t, event.LastError, "kafka")
+		require.NotNil(t, event.NextAttemptAt)
+		assert.True(t, event.NextAttemptAt.After(time.Now()))
+		assert.Empty(t, forOrg(&kafkaRecords, orgId)[event.EventID])
+
+		kafkaRespondWith(http.StatusOK)
+		require.Nil(t, db.Model(&event).Update("next_attempt_at", time.Now().Add(-time.Second)).Error)
+		relayAll(t)
+
+		event = outbox(t)[2]
+		assert.NotNil(t, event.PublishedAt)
+		assert.Empty(t, event.LastError)
+		assert.Len(t, forOrg(&kafkaRecords, orgId)[event.EventID], 1)
+		// at least once: the other sinks got it twice, with the same ID
+		assert.Len(t, forOrg(&busEvents, orgId)[event.EventID], 2)
+		assert.Len(t, forOrg(&natsMessages, orgId)[event.EventID], 2)
+	})
+
+	t.Run("test webhooks are queued once per event", func(t *testing.T) {
+		event := outbox(t)[1]
+		webhook := models.Webhook{OrganisationID: event.OrganisationID, URL: "https://example.com/hooks", Events: event.Event, Active: true}
+		require.Nil(t, db.Create(&webhook).Error)
+
+		sink := utils.WebhookSink{DB: db}
+		require.Nil(t, sink.Publish(context.Background(), event))
+		require.Nil(t, sink.Publish(context.Background(), event))
+
+		var deliveries []models.WebhookDelivery
+		require.Nil(t, db.Where("webhook_id = ?", webhook.ID).Find(&deliveries).Error)
+		require.Len(t, deliveries, 1)
+		assert.Equal(t, event.EventID, deliveries[0].EventID)
+		assert.Equal(t, event.Payload, deliveries[0].Payload)
+	})
+}
+
+func TestJobs(t *testing.T) {
+	type greeting struct {
+		Name string `json:"name"`
+	}
+
+	var mu sync.Mutex
+	greeted := []string{}
+	failures := 2
+	runner := utils.NewJobRunner(db, 1)
+	runner.Handle("test.greet", utils.JobFunc(func(ctx context.Context, payload greeting) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			return fmt.Errorf("not now")
+		}
+		greeted = append(greeted, payload.Name)
+		return nil
+	}))
+	runner.Handle("test.panic", func(ctx context.Context, job models.Job) error {
+		panic("oops")
+	})
+	runner.Handle(models.JobSendEmail, utils.SendEmailJob(mailer))
+	runner.Handle(models.JobExpireInvitations, utils.ExpireInvitationsJob(db))
+
+	runAll := func(t *testing.T) int {
+		ran := 0
+		for {
+			ok, err := runner.RunNext(context.Background())
+			require.Nil(t, err)
+			if !ok {
+				return ran
+			}
+			ran++
+		}
+	}
+	findJob := func(t *testing.T, id uint) models.Job {
+		var job models.Job
+		require.Nil(t, db.First(&job, id).Error)
+		return job
+	}
+	makeDue := func(t *testing.T, id uint) {
+		require.Nil(t, db.Model(&models.Job{}).Where("id = ?", id).Update("run_at", time.Now().Add(-time.Second)).Error)
+	}
+
+	t.Run("test schedules", func(t *testing.T) {
+		from := time.Date(2024, time.June, 1, 10, 7, 30, 0, time.UTC) // a Saturday
+		for spec, next := range map[string]time.Time{
+			"*/15 9-17 * * 1-5": time.Date(2024, time.June, 3, 9, 0, 0, 0, time.UTC),
+			"0 12 1 * 1":        time.Date(2024, time.June, 1, 12, 0, 0, 0, time.UTC),
+			"0 0 29 2 *":        time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC),
+			"5,10 3 * * 7":      time.Date(2024, time.June, 2, 3, 5, 0, 0, time.UTC),
+			"0 0 31 * *":        time.Date(2024, time.July, 31, 0, 0, 0, 0, time.UTC),
+			"@weekly":           time.Date(2024, time.June, 2, 0, 0, 0, 0, time.UTC),
+			"@every 10m":        time.Date(2024, time.June, 1, 10, 10, 0, 0, time.UTC),
+			"@every 1h30m":      time.Date(2024, time.June, 1, 10, 30, 0, 0, time.UTC),
+		} {
+			schedule, err := utils.ParseSchedule(spec)
+			require.Nil(t, err, spec)
+			assert.Equal(t, next, schedule.Next(from), spec)
+		}
+
+		for _, spec := range []string{"60 * * * *", "* * *", "*/0 * * * *", "5-1 * * * *", "0 0 * * mon", "@every 500ms", "@yearly"} {
+			_, err := utils.ParseSchedule(spec)
+			assert.NotNil(t, err, spec)
+		}
+	})
+
+	t.Run("test failed jobs are retried with backoff", func(t *testing.T) {
+		job, err := utils.EnqueueJob(db, "test.greet", greeting{Name: "Ada"}, utils.JobOptions{})
+		require.Nil(t, err)
+
+		assert.Equal(t, 1, runAll(t))
+		job = findJob(t, job.ID)
+		assert.Equal(t, models.JobQueued, job.Status)
+		assert.Equal(t, 1, job.Attempts)
+		assert.Equal(t, "not now", job.LastError)
+		assert.True(t, job.RunAt.After(time.Now()))
+		assert.Equal(t, 0, runAll(t))
+
+		for i := 0; i < 2; i++ {
+			makeDue(t, job.ID)
+			assert.Equal(t, 1, runAll(t))
+		}
+		job = findJob(t, job.ID)
+		assert.Equal(t, models.JobSucceeded, job.Status)
+		assert.Equal(t, 3, job.Attempts)
+		assert.NotNil(t, job.FinishedAt)
+		assert.Empty(t, job.LastError)
+		assert.Equal(t, []string{"Ada"}, greeted)
+
+		// jobs are only claimed by runners handling their kind
+		other, err := utils.EnqueueJob(db, "test.unhandled", nil, utils.JobOptions{})
+		require.Nil(t, err)
+		assert.Equal(t, 0, runAll(t))
+		assert.Equal(t, models.JobQueued, findJob(t, other.ID).Status)
+		require.Nil(t, db.Delete(&other).Error)
+	})
+
+	t.Run("test jobs failing every attempt are dead until requeued", func(t *testing.T) {
+		job, err := utils.EnqueueJob(db, "test.panic", nil, utils.JobOptions{MaxAttempts: 2})
+		require.Nil(t, err)
+
+		assert.Equal(t, 1, runAll(t))
+		makeDue(t, job.ID)
+		assert.Equal(t, 1, runAll(t))
+		job = findJob(t, job.ID)
+		assert.Equal(t, models.JobDead, job.Status)
+		assert.Equal(t, "panic: oops", job.LastError)
+		makeDue(t, job.ID)
+		assert.Equal(t, 0, runAll(t))
+
+		var out bytes.Buffer
+		assert.Equal(t, 0, requeueJobs(db, []string{fmt.Sprintf("%d", job.ID)}, &out))
+		assert.Equal(t, "requeued 1 job(s)\n", out.String())
+		job = findJob(t, job.ID)
+		assert.Equal(t, models.JobQueued, job.Status)
+		assert.Equal(t, 0, job.Attempts)
+		require.Nil(t, db.Delete(&job).Error)
+	})
+
+	t.Run("test scheduled jobs are queued once per time", func(t *testing.T) {
+		other := utils.NewJobRunner(db, 1)
+		for _, r := range []*utils.JobRunner{runner, other} {
+			require.Nil(t, r.Schedule("@every 1m", "test.greet", greeting{Name: "Grace"}))
+		}
+		assert.NotNil(t, runner.Schedule("every minute", "test.greet", nil))
+
+		// a minute ago, for the job to be due
+		now := time.Now().Add(-2 * time.Minute)
+		for _, r := range []*utils.JobRunner{runner, other} {
+			n, err := r.EnqueueScheduled(now)
+			require.Nil(t, err)
+			assert.Equal(t, 0, n)
+		}
+		n, err := runner.EnqueueScheduled(now.Add(time.Minute))
+		require.Nil(t, err)
+		assert.Equal(t, 1, n)
+		n, err = other.EnqueueScheduled(now.Add(time.Minute))
+		require.Nil(t, err)
+		assert.Equal(t, 0, n)
+
+		assert.Equal(t, 1, runAll(t))
+		assert.Equal(t, []string{"Ada", "Grace"}, greeted)
+	})
+
+	t.Run("test queued emails", func(t *testing.T) {
+		email := GenerateRandomEmail()
+		require.Nil(t, utils.NewQueuedMailer(db).Send(email, "Hello", "token=abc"))
+		assert.Equal(t, 1, runAll(t))
+		assert.Equal(t, "abc", mailer.LastToken(t, email))
+	})
+
+	t.Run("test expired invitations are deleted", func(t *testing.T) {
+		owner, _ := RegisterRandomUser(t)
+		var orgId uint
+		require.Nil(t, db.Table("users_organisations").Where("user_id = ?", owner.Data.User.UserID).Pluck("organisation_id", &orgId).Error)
+		for i := 0; i < 2; i++ {
+			w := DoRequest("POST", fmt.Sprintf("/api/organisations/%d/invitations", orgId), owner.Data.AccessToken, map[string]string{})
+			require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		}
+
+		var invitations []models.Invitation
+		require.Nil(t, db.Where("organisation_id = ?", orgId).Order("id").Find(&invitations).Error)
+		require.Len(t, invitations, 2)
+		require.Nil(t, db.Model(&invitations[0]).Update("expires_at", time.Now().Add(-time.Minute)).Error)
+
+		_, err := utils.EnqueueJob(db, models.JobExpireInvitations, nil, utils.JobOptions{})
+		require.Nil(t, err)
+		assert.Equal(t, 1, runAll(t))
+
+		var left []models.Invitation
+		require.Nil(t, db.Where("organisation_id = ?", orgId).Find(&left).Error)
+		require.Len(t, left, 1)
+		assert.Equal(t, invitations[1].ID, left[0].ID)
+	})
+
+	t.Run("test deleted organisations are purged after retention", func(t *testing.T) {
+		owner, _ := RegisterRandomUser(t)
+		member, _ := RegisterRandomUser(t)
+		createOrg := func(name string) string {
+			w := DoRequest("POST", "/api/organisations", owner.Data.AccessToken, map[string]string{"name": name})
+			require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+			orgId := regexp.MustCompile(`"orgId":"(\d+)"`).FindStringSubmatch(w.Body.String())[1]
+			orgURL := "/api/organisations/" + orgId
+			w = DoRequest("POST", orgURL+"/users", owner.Data.AccessToken, map[string]string{"userId": member.Data.User.UserID})
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+			w = DoRequest("POST", orgURL+"/teams", owner.Data.AccessToken, map[string]string{"name": "Team"})
+			require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+			w = DoRequest("POST", orgURL+"/invitations", owner.Data.AccessToken, map[string]string{})
+			require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+			w = DoRequest("POST", orgURL+"/service-accounts", owner.Data.AccessToken, map[string]string{"name": "Robot", "role": "member"})
+			require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+			w = DoRequest("POST", orgURL+"/webhooks", owner.Data.AccessToken, map[string]interface{}{"url": "https://example.com/hooks", "events": []string{models.WebhookMemberAdded}})
+			require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+			return orgId
+		}
+		expired, recent := createOrg("Expired"), createOrg("Recent")
+		require.Nil(t, db.Delete(&models.Organisation{}, expired).Error)
+		require.Nil(t, db.Delete(&models.Organisation{}, recent).Error)
+		require.Nil(t, db.Unscoped().Model(&models.Organisation{}).Where("id = ?", expired).Update("deleted_at", time.Now().Add(-31*24*time.Hour)).Error)
+
+		n, err := utils.PurgeOrganisations(db, time.Now().Add(-30*24*time.Hour))
+		require.Nil(t, err)
+		assert.Equal(t, 1, n)
+
+		count := func(table, orgId string) int64 {
+			var n int64
+			require.Nil(t, db.Table(table).Where("organisation_id = ?", orgId).Count(&n).Error)
+			return n
+		}
+		for _, table := range []string{"organisations", "users_organisations", "teams", "invitations", "service_accounts", "webhooks"} {
+			column := "organisation_id"
+			if table == "organisations" {
+				column = "id"
+			}
+			var n int64
+			require.Nil(t, db.Table(table).Where(column+" = ?", expired).Count(&n).Error)
+			assert.Zero(t, n, table)
+			require.Nil(t, db.Table(table).Where(column+" = ?", recent).Count(&n).Error)
+			assert.NotZero(t, n, table)
+		}
+		assert.NotZero(t, count("audit_events", expired))
+	})
+}
+
+func TestNotifications(t *testing.T) {
+	type notificationsResp struct {
+		Data struct {
+			Notifications []struct {
+				NotificationID string `json:"notificationId"`
+				Event          string `json:"event"`
+				OrgID          string `json:"orgId"`
+				Title          string `json:"title"`
+				Body           string `json:"body"`
+				Read           bool   `json:"read"`
+			} `json:"notifications"`
+			UnreadCount int64 `json:"unreadCount"`
+		} `json:"data"`
+	}
+
+	relay := utils.NewOutboxRelay(db, utils.NotificationSink{DB: db})
+	relayAll := func(t *testing.T) {
+		for {
+			n, err := relay.RelayDue(context.Background())
+			require.Nil(t, err)
+			if n == 0 {
+				return
+			}
+		}
+	}
+	runner := utils.NewJobRunner(db, 1)
+	runner.Handle(models.JobSendEmail, utils.SendEmailJob(mailer))
+	// the emails sent to the address, other tests leave events behind
+	sendEmails := func(t *testing.T, to string) []testEmail {
+		mailer.mu.Lock()
+		before := len(mailer.sent)
+		mailer.mu.Unlock()
+		for {
+			ok, err := runner.RunNext(context.Background())
+			require.Nil(t, err)
+			if !ok {
+				break
+			}
+		}
+		mailer.mu.Lock()
+		defer mailer.mu.Unlock()
+		return slices.DeleteFunc(slices.Clone(mailer.sent[before:]), func(email testEmail) bool {
+			return email.To != to
+		})
+	}
+	notifications := func(t *testing.T, token, query string) notificationsResp {
+		var resp notificationsResp
+		w := DoRequest("GET", "/api/notifications?"+query, token, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&resp))
+		return resp
+	}
+
+	owner, _ := RegisterRandomUser(t)
+	member, _ := RegisterRandomUser(t)
+	createOrg := func(name string) string {
+		w := DoRequest("POST", "/api/organisations", owner.Data.AccessToken, map[string]string{"name": name})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		return regexp.MustCompile(`"orgId":"(\d+)"`).FindStringSubmatch(w.Body.String())[1]
+	}
+	addMember := func(orgId string) {
+		w := DoRequest("POST", "/api/organisations/"+orgId+"/users", owner.Data.AccessToken, map[string]string{"userId": member.Data.User.UserID})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	}
+	orgId := createOrg("Notifying")
+
+	t.Run("test members are told they were added", func(t *testing.T) {
+		addMember(orgId)
+		relayAll(t)
+
+		resp := notifications(t, member.Data.AccessToken, "")
+		require.Len(t, resp.Data.Notifications, 1)
+		assert.Equal(t, int64(1), resp.Data.UnreadCount)
+		notification := resp.Data.Notifications[0]
+		assert.Equal(t, models.WebhookMemberAdded, notification.Event)
+		assert.Equal(t, orgId, notification.OrgID)
+		assert.Equal(t, "You were added to Notifying", notification.Title)
+		assert.Equal(t, owner.Data.User.FirstName+" "+owner.Data.User.LastName+" added you to Notifying.", notification.Body)
+		assert.False(t, notification.Read)
+
+		sent := sendEmails(t, member.Data.User.Email)
+		require.Len(t, sent, 1)
+		assert.Equal(t, "You were added to Notifying", sent[0].Subject)
+
+		// nobody is told of what they did themselves
+		assert.Empty(t, notifications(t, owner.Data.AccessToken, "").Data.Notifications)
+	})
+
+	t.Run("test events published again are notified once", func(t *testing.T) {
+		require.Nil(t, db.Model(&models.OutboxEvent{}).Where("organisation_id = ?", orgId).Updates(map[string]interface{}{
+			"published_at":    nil,
+			"next_attempt_at": time.Now().Add(-time.Second),
+		}).Error)
+		relayAll(t)
+
+		assert.Len(t, notifications(t, member.Data.AccessToken, "").Data.Notifications, 1)
+		assert.Empty(t, sendEmails(t, member.Data.User.Email))
+	})
+
+	t.Run("test owners are told of registrations", func(t *testing.T) {
+		var invitation struct {
+			Data struct {
+				Token string `json:"token"`
+			} `json:"data"`
+		}
+		w := DoRequest("POST", "/api/organisations/"+orgId+"/invitations", owner.Data.AccessToken, map[string]string{})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&invitation))
+		w = DoRequest("POST", "/auth/register", "", map[string]string{
+			"firstName":       "Grace",
+			"lastName":        "Hopper",
+			"email":           GenerateRandomEmail(),
+			"phone":           GenerateRandomNumber(),
+			"password":        GenerateRandomString(8),
+			"invitationToken": invitation.Data.Token,
+		})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		relayAll(t)
+
+		resp := notifications(t, owner.Data.AccessToken, "")
+		require.Len(t, resp.Data.Notifications, 1)
+		assert.Equal(t, models.WebhookUserRegistered, resp.Data.Notifications[0].Event)
+		assert.Equal(t, "Grace Hopper joined Notifying", resp.Data.Notifications[0].Title)
+		// not by email by default
+		assert.Empty(t, sendEmails(t, owner.Data.User.Email))
+	})
+
+	t.Run("test preferences", func(t *testing.T) {
+		var prefs struct {
+			Data struct {
+				Preferences []struct {
+					Event string `json:"event"`
+					InApp bool   `json:"inApp"`
+					Email bool   `json:"email"`
+				} `json:"preferences"`
+			} `json:"data"`
+		}
+		w := DoRequest("GET", "/api/notifications/preferences", member.Data.AccessToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&prefs))
+		require.Len(t, prefs.Data.Preferences, len(models.NotificationEvents))
+		for _, pref := range prefs.Data.Preferences {
+			assert.True(t, pref.InApp, pref.Event)
+			assert.Equal(t, pref.Event == models.WebhookMemberAdded || pref.Event == models.WebhookMemberRemoved, pref.Email, pref.Event)
+		}
+
+		w = DoRequest("PUT", "/api/notifications/preferences/"+models.WebhookMemberAdded, member.Data.AccessToken, map[string]bool{"email": false})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), `"email":false,"event":"member.added","inApp":true`)
+		w = DoRequest("PUT", "/api/notifications/preferences/user.deleted", member.Data.AccessToken, map[string]bool{"email": false})
+		assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+
+		addMember(createOrg("Quiet"))
+		relayAll(t)
+		assert.Len(t, notifications(t, member.Data.AccessToken, "").Data.Notifications, 2)
+		assert.Empty(t, sendEmails(t, member.Data.User.Email))
+
+		w = DoRequest("PUT", "/api/notifications/preferences/"+models.WebhookMemberAdded, member.Data.AccessToken, map[string]bool{"inApp": false})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), `"email":false,"event":"member.added","inApp":false`)
+
+		addMember(createOrg("Silent"))
+		relayAll(t)
+		assert.Len(t, notifications(t, member.Data.AccessToken, "").Data.Notifications, 2)
+	})
+
+	t.Run("test notifications are marked read", func(t *testing.T) {
+		resp := notifications(t, member.Data.AccessToken, "")
+		require.Len(t, resp.Data.Notifications, 2)
+		assert.Equal(t, int64(2), resp.Data.UnreadCount)
+		first := resp.Data.Notifications[1].NotificationID
+
+		w := DoRequest("POST", "/api/notifications/"+first+"/read", owner.Data.AccessToken, nil)
+		assert.Equal(t, http.StatusNotFound, w.Code, w.Body.String())
+		w = DoRequest("POST", "/api/notifications/"+first+"/read", member.Data.AccessToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), `"read":true`)
+
+		resp = notifications(t, member.Data.AccessToken, "unread=true")
+		require.Len(t, resp.Data.Notifications, 1)
+		assert.NotEqual(t, first, resp.Data.Notifications[0].NotificationID)
+		assert.Equal(t, int64(1), resp.Data.UnreadCount)
+
+		w = DoRequest("POST", "/api/notifications/read-all", member.Data.AccessToken, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.Contains(t, w.Body.String(), "marked 1 notification(s) as read")
+		resp = notifications(t, member.Data.AccessToken, "unread=true")
+		assert.Empty(t, resp.Data.Notifications)
+		assert.Zero(t, resp.Data.UnreadCount)
+	})
+}
+
+func TestEventStream(t *testing.T) {
+	type streamEvent struct {
+		ID    string
+		Event string
+		Data  struct {
+			ID    string `json:"id"`
+			Event string `json:"event"`
+			OrgID string `json:"orgId"`
+		}
+	}
+
+	server := httptest.NewServer(router)
+	// after the streams are closed
+	t.Cleanup(server.Close)
+
+	relay := utils.NewOutboxRelay(db, bus)
+	relayAll := func(t *testing.T) {
+		for {
+			n, err := relay.RelayDue(context.Background())
+			require.Nil(t, err)
+			if n == 0 {
+				return
+			}
+		}
+	}
+	// openStream returns the next event of a stream, closed with the test
+	openStream := func(t *testing.T, token, lastEventId string) func() streamEvent {
+		req, err := http.NewRequest("GET", server.URL+"/api/events/stream", nil)
+		require.Nil(t, err)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if lastEventId != "" {
+			req.Header.Set("Last-Event-ID", lastEventId)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		require.Nil(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+		events := make(chan streamEvent)
+		go func() {
+			defer close(events)
+			var event streamEvent
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				line := scanner.Text()
+				switch {
+				case strings.HasPrefix(line, "id: "):
+					event.ID = strings.TrimPrefix(line, "id: ")
+				case strings.HasPrefix(line, "event: "):
+					event.Event = strings.TrimPrefix(line, "event: ")
+				case strings.HasPrefix(line, "data: "):
+					json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event.Data)
+				case line == "" && event.ID != "":
+					events <- event
+					event = streamEvent{}
+				}
+			}
+		}()
+
+		return func() streamEvent {
+			select {
+			case event, ok := <-events:
+				require.True(t, ok, "stream closed")
+				return event
+			case <-time.After(5 * time.Second):
+				require.FailNow(t, "no event streamed")
+				return streamEvent{}
+			}
+		}
+	}
+	createOrg := func(t *testing.T, token, name string) string {
+		w := DoRequest("POST", "/api/organisations", token, map[string]string{"name": name})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		return regexp.MustCompile(`"orgId":"(\d+)"`).FindStringSubmatch(w.Body.String())[1]
+	}
+
+	owner, _ := RegisterRandomUser(t)
+	member, _ := RegisterRandomUser(t)
+	outsider, _ := RegisterRandomUser(t)
+	relayAll(t)
+
+	t.Run("test streaming requires a session", func(t *testing.T) {
+		w := DoRequest("GET", "/api/events/stream", "", nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+
+	ownerNext := openStream(t, owner.Data.AccessToken, "")
+	memberNext := openStream(t, member.Data.AccessToken, "")
+	outsiderNext := openStream(t, outsider.Data.AccessToken, "")
+
+	orgId := createOrg(t, owner.Data.AccessToken, "Streaming")
+	relayAll(t)
+	created := ownerNext()
+
+	t.Run("test members are sent the events of their organisations", func(t *testing.T) {
+		assert.Equal(t, models.WebhookOrganisationCreated, created.Event)
+		assert.Equal(t, created.ID, created.Data.ID)
+		assert.Equal(t, orgId, created.Data.OrgID)
+
+		w := DoRequest("POST", "/api/organisations/"+orgId+"/users", owner.Data.AccessToken, map[string]string{"userId": member.Data.User.UserID})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		relayAll(t)
+
+		added := memberNext()
+		assert.Equal(t, models.WebhookMemberAdded, added.Event)
+		assert.Equal(t, orgId, added.Data.OrgID)
+		assert.Equal(t, added, ownerNext())
+	})
+
+	t.Run("test users are not sent the events of other organisations", func(t *testing.T) {
+		otherId := createOrg(t, outsider.Data.AccessToken, "Elsewhere")
+		relayAll(t)
+
+		event := outsiderNext()
+		assert.Equal(t, models.WebhookOrganisationCreated, event.Event)
+		assert.Equal(t, otherId, event.Data.OrgID)
+	})
+
+	t.Run("test streams resume after the last event", func(t *testing.T) {
+		next := openStream(t, owner.Data.AccessToken, created.ID)
+
+		event := next()
+		assert.Equal(t, models.WebhookMemberAdded, event.Event)
+		assert.Equal(t, orgId, event.Data.OrgID)
+
+		laterId := createOrg(t, owner.Data.AccessToken, "Later")
+		relayAll(t)
+		event = next()
+		assert.Equal(t, models.WebhookOrganisationCreated, event.Event)
+		assert.Equal(t, laterId, event.Data.OrgID)
+	})
+}
+
+func TestAPIVersions(t *testing.T) {
+	user, _ := RegisterRandomUser(t)
+	token := user.Data.AccessToken
+	userURL := "/api/users/" + user.Data.User.UserID
+
+	t.Run("should serve v1", func(t *testing.T) {
+		w := DoRequest("GET", "/v1"+userURL, token, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), user.Data.User.Email)
+		assert.Empty(t, w.Header().Get("Deprecation"))
+		assert.Empty(t, w.Header().Get("Sunset"))
+	})
+
+	t.Run("should serve v1 at the deprecated unversioned paths", func(t *testing.T) {
+		w := DoRequest("GET", userURL, token, nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), user.Data.User.Email)
+		assert.Equal(t, fmt.Sprintf("@%d", unversionedDeprecatedAt.Unix()), w.Header().Get("Deprecation"))
+		assert.Equal(t, unversionedSunset.Format(http.TimeFormat), w.Header().Get("Sunset"))
+		assert.Equal(t, `</v1`+userURL+`>; rel="successor-version"`, w.Header().Get("Link"))
+
+		// errors are marked too
+		w = DoRequest("GET", userURL, "", nil)
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+		assert.NotEmpty(t, w.Header().Get("Deprecation"))
+	})
+
+	t.Run("should alias every v1 route", func(t *testing.T) {
+		routes := map[string]bool{}
+		for _, route := range router.Routes() {
+			routes[route.Method+" "+route.Path] = true
+		}
+
+		for _, route := range router.Routes() {
+			if path, ok := strings.CutPrefix(route.Path, "/v1"); ok {
+				assert.True(t, routes[route.Method+" "+path], "%s %s has no alias", route.Method, route.Path)
+			}
+			for _, prefix := range []string{"/auth/", "/api/", "/admin/", "/domains/"} {
+				if strings.HasPrefix(route.Path, prefix) {
+					assert.True(t, routes[route.Method+" /v1"+route.Path], "%s %s is not in v1", route.Method, route.Path)
+				}
+			}
+		}
+	})
+
+	t.Run("should serve the handlers of each version", func(t *testing.T) {
+		userController := controllers.UserController{DB: db, Mailer: mailer, Registration: registration}
+		organisationController := controllers.OrganisationController{DB: db}
+
+		versions := utils.NewVersionedRouter()
+		v1 := versions.Version("v1")
+		v1.Group("/api", middlewares.Auth(db)).
+			GET("/users/:id", userController.GetUserById).
+			GET("/organisations", organisationController.GetAll)
+		v2 := versions.Version("v2")
+		v2.Group("/api", middlewares.Auth(db)).
+			GET("/users/:id", func(c *gin.Context) {
+				principal, _ := middlewares.GetPrincipal(c)
+				c.JSON(http.StatusOK, gin.H{
+					"id":   principal.User.ID,
+					"name": principal.User.FirstName + " " + principal.User.LastName,
+				})
+			})
+
+		engine := gin.New()
+		versions.Mount(engine)
+
+		serve := func(url string) *httptest.ResponseRecorder {
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", url, nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			engine.ServeHTTP(w, req)
+			return w
+		}
+
+		w := serve("/v1" + userURL)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"userId":"`+user.Data.User.UserID+`"`)
+
+		w = serve("/v2" + userURL)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, fmt.Sprintf(`{"id":%s,"name":"%s %s"}`, user.Data.User.UserID, user.Data.User.FirstName, user.Data.User.LastName), w.Body.String())
+
+		// v2 serves the routes it did not change with the handlers of v1
+		w = serve("/v2/api/organisations")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), `"pagination"`)
+	})
+}
+
+func TestClient(t *testing.T) {
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	ctx := context.Background()
+
+	email := GenerateRandomEmail()
+	registration, err := client.New(server.URL).Register(ctx, client.RegisterParams{
+		FirstName: GenerateRandomString(8),
+		LastName:  GenerateRandomString(8),
+		Email:     email,
+		Password:  "password",
+		Phone:     GenerateRandomNumber(),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, email, registration.User.Email)
+	assert.NotEmpty(t, registration.AccessToken)
+
+	t.Run("should call the API as the user", func(t *testing.T) {
+		c := client.New(server.URL, client.WithCredentials(email, "password"))
+
+		user, err := c.GetUser(ctx, registration.User.ID)
+		require.NoError(t, err)
+		assert.Equal(t, registration.User, *user)
+
+		org, err := c.CreateOrganisation(ctx, client.CreateOrganisationParams{Name: "Client Organisation", Description: "created by the client"})
+		require.NoError(t, err)
+		assert.Equal(t, "Client Organisation", org.Name)
+
+		got, err := c.GetOrganisation(ctx, org.ID)
+		require.NoError(t, err)
+		assert.Equal(t, org, got)
+
+		page, err := c.ListOrganisations(ctx, client.ListOrganisationsParams{Query: "client organisation", Limit: 1, Sort: "name"})
+		require.NoError(t, err)
+		assert.Equal(t, []client.Organisation{*org}, page.Organisations)
+		assert.Equal(t, client.Pagination{Total: 1, Limit: 1, Sort: "name"}, page.Pagination)
+
+		other, err := client.New(server.URL).Register(ctx, client.RegisterParams{
+			FirstName: "Other",
+			LastName:  "User",
+			Email:     GenerateRandomEmail(),
+			Password:  "password",
+			Phone:     GenerateRandomNumber(),
+		})
+		require.NoError(t, err)
+		require.NoError(t, c.AddUserToOrganisation(ctx, org.ID, other.User.ID))
+
+		otherOrg, err := client.New(server.URL, client.WithToken(other.AccessToken)).GetOrganisation(ctx, org.ID)
+		require.NoError(t, err)
+		assert.Equal(t, org, otherOrg)
+
+		joinable, err := c.ListJoinableOrganisations(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, joinable.Organisations)
+		assert.False(t, joinable.EmailVerified)
+	})
+
+	t.Run("should return typed errors", func(t *testing.T) {
+		c := client.New(server.URL, client.WithToken(registration.AccessToken))
+
+		_, err := c.GetOrganisation(ctx, "999999")
+		assert.ErrorIs(t, err, client.ErrNotFound)
+		var apiErr *client.Error
+		require.ErrorAs(t, err, &apiErr)
+		assert.Equal(t, "Not Found", apiErr.Status)
+		assert.Equal(t, "organisation not found", apiErr.Message)
+
+		_, err = c.CreateOrganisation(ctx, client.CreateOrganisationParams{})
+		var validationErr *client.ValidationError
+		require.ErrorAs(t, err, &validationErr)
+		assert.Equal(t, "name", validationErr.Errors[0].Field)
+
+		_, err = client.New(server.URL, client.WithToken("invalid")).GetUser(ctx, registration.User.ID)
+		assert.ErrorIs(t, err, client.ErrUnauthorized)
+		require.ErrorAs(t, err, &apiErr)
+		assert.Equal(t, "invalid token", apiErr.Message)
+
+		_, err = client.New(server.URL).Login(ctx, client.LoginParams{Email: email, Password: "wrong"})
+		assert.ErrorIs(t, err, client.ErrUnauthorized)
+	})
+
+	t.Run("should log in again when the token expires", func(t *testing.T) {
+		var mu sync.Mutex
+		logins, uses := 0, 0
+		expiring := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			if r.URL.Path == "/v1/auth/login" {
+				logins, uses = logins+1, 0
+			}
+			// every token expires after its first use
+			reject := false
+			if r.Header.Get("Authorization") != "" {
+				uses++
+				reject = uses > 1
+			}
+			mu.Unlock()
+
+			if reject {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`{"error":"expired token"}`))
+				return
+			}
+			router.ServeHTTP(w, r)
+		}))
+		t.Cleanup(expiring.Close)
+
+		c := client.New(expiring.URL, client.WithCredentials(email, "password"))
+		for i := 0; i < 2; i++ {
+			user, err := c.GetUser(ctx, registration.User.ID)
+			require.NoError(t, err)
+			assert.Equal(t, email, user.Email)
+		}
+		assert.Equal(t, 2, logins)
+
+		// non-idempotent requests are sent again too, the API not having
+		// handled them
+		_, err := c.CreateOrganisation(ctx, client.CreateOrganisationParams{Name: "Refreshed"})
+		require.NoError(t, err)
+		assert.Equal(t, 3, logins)
+	})
+
+	t.Run("should go on with a changed password", func(t *testing.T) {
+		email := GenerateRandomEmail()
+		_, err := client.New(server.URL).Register(ctx, client.RegisterParams{
+			FirstName: "Changing",
+			LastName:  "Password",
+			Email:     email,
+			Password:  "password",
+			Phone:     GenerateRandomNumber(),
+		})
+		require.NoError(t, err)
+
+		c := client.New(server.URL, client.WithCredentials(email, "password"))
+		_, err = c.ListOrganisations(ctx, client.ListOrganisationsParams{})
+		require.NoError(t, err)
+
+		session, err := c.ChangePassword(ctx, client.ChangePasswordParams{Email: email, Password: "password", NewPassword: "new password"})
+		require.NoError(t, err)
+		assert.Equal(t, email, session.User.Email)
+
+		_, err = c.ListOrganisations(ctx, client.ListOrganisationsParams{})
+		require.NoError(t, err)
+	})
+
+	t.Run("should retry idempotent requests", func(t *testing.T) {
+		var mu sync.Mutex
+		attempts := map[string]int{}
+		flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			attempts[r.Method]++
+			n := attempts[r.Method]
+			mu.Unlock()
+
+			if n <= 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			router.ServeHTTP(w, r)
+		}))
+		t.Cleanup(flaky.Close)
+
+		c := client.New(flaky.URL, client.WithToken(registration.AccessToken), client.WithRetries(2, time.Millisecond))
+		_, err := c.GetUser(ctx, registration.User.ID)
+		require.NoError(t, err)
+		assert.Equal(t, 3, attempts["GET"])
+
+		_, err = c.CreateOrganisation(ctx, client.CreateOrganisationParams{Name: "Not Retried"})
+		var apiErr *client.Error
+		require.ErrorAs(t, err, &apiErr)
+		assert.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+		assert.Equal(t, 1, attempts["POST"])
+	})
+
+	t.Run("should stop when the context is done", func(t *testing.T) {
+		unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		t.Cleanup(unavailable.Close)
+
+		ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+
+		c := client.New(unavailable.URL, client.WithToken(registration.AccessToken), client.WithRetries(10, time.Second))
+		_, err := c.GetUser(ctx, registration.User.ID)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	})
+}
+
+// openAPISpec is the part of the OpenAPI document responses are checked
+// against.
+type openAPISpec struct {
+	Paths      map[string]map[string]openAPIOperation `json:"paths"`
+	Components struct {
+		Schemas   map[string]map[string]interface{} `json:"schemas"`
+		Responses map[string]openAPIResponse        `json:"responses"`
+	} `json:"components"`
+}
+
+type openAPIOperation struct {
+	Responses map[string]openAPIResponse `json:"responses"`
+}
+
+type openAPIResponse struct {
+	Ref     string `json:"$ref"`
+	Content map[string]struct {
+		Schema map[string]interface{} `json:"schema"`
+	} `json:"content"`
+}
+
+var (
+	spec = func() openAPISpec {
+		var spec openAPISpec
+		if err := json.Unmarshal(docs.OpenAPI, &spec); err != nil {
+			log.Fatal("error parsing OpenAPI document:", err)
+		}
+		return spec
+	}()
+
+	ginPathParam = regexp.MustCompile(`:(\w+)`)
+
+	specViolationsMu sync.Mutex
+	specViolations   []string
+)
+
+type graphqlResponse struct {
+	Data   json.RawMessage `json:"data"`
+	Errors []struct {
+		Message    string `json:"message"`
+		Extensions struct {
+			StatusCode int                 `json:"statusCode"`
+			Errors     []models.InputError `json:"errors"`
+		} `json:"extensions"`
+	} `json:"errors"`
+}
+
+func GraphQLRequest(t *testing.T, handler http.Handler, token, query string, variables map[string]interface{}, data interface{}) graphqlResponse {
+	body, _ := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/graphql", bytes.NewBuffer(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	handler.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var resp graphqlResponse
+	require.Nil(t, json.NewDecoder(w.Body).Decode(&resp))
+	if data != nil && len(resp.Data) > 0 {
+		require.Nil(t, json.Unmarshal(resp.Data, data))
+	}
+	return resp
+}
+
+// countingLogger counts the SQL statements that mention a table.
+type countingLogger struct {
+	logger.Interface
+	table string
+	count atomic.Int64
+}
+
+func (l *countingLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if sql, _ := fc(); strings.Contains(sql, l.table) {
+		l.count.Add(1)
+	}
+}
+
+func TestGraphQL(t *testing.T) {
+	type organisation struct {
+		OrgID   string `json:"orgId"`
+		Name    string `json:"name"`
+		Members []struct {
+			UserID        string `json:"userId"`
+			Email         string `json:"email"`
+			Organisations []struct {
+				OrgID string `json:"orgId"`
+			} `json:"organisations"`
+		} `json:"members"`
+	}
+
+	owner, _ := RegisterRandomUser(t)
+	member, _ := RegisterRandomUser(t)
+	newcomer, _ := RegisterRandomUser(t)
+	stranger, _ := RegisterRandomUser(t)
+	ownerToken := owner.Data.AccessToken
+
+	var orgs struct {
+		Organisations struct {
+			Organisations []organisation `json:"organisations"`
+		} `json:"organisations"`
+	}
+	resp := GraphQLRequest(t, router, ownerToken, `{ organisations { organisations { orgId name } } }`, nil, &orgs)
+	require.Empty(t, resp.Errors)
+	require.Len(t, orgs.Organisations.Organisations, 1)
+	orgId := orgs.Organisations.Organisations[0].OrgID
+
+	t.Run("should require a token and a query", func(t *testing.T) {
+		w := DoRequest("POST", "/graphql", "", map[string]string{"query": "{ me { userId } }"})
+		assert.Equal(t, http.StatusUnauthorized, w.Code, w.Body.String())
+
+		w = DoRequest("POST", "/graphql", ownerToken, map[string]string{})
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+		resp := GraphQLRequest(t, router, ownerToken, `{ nope }`, nil, nil)
+		assert.NotEmpty(t, resp.Errors)
+	})
+
+	t.Run("should add users to organisations the caller belongs to", func(t *testing.T) {
+		mutation := `mutation ($orgId: ID!, $userId: ID!) {
+			addUserToOrganisation(orgId: $orgId, userId: $userId) { user { userId } organisation { orgId } }
+		}`
+
+		resp := GraphQLRequest(t, router, member.Data.AccessToken, mutation, map[string]interface{}{"orgId": orgId, "userId": stranger.Data.User.UserID}, nil)
+		require.Len(t, resp.Errors, 1)
+		assert.Equal(t, http.StatusUnauthorized, resp.Errors[0].Extensions.StatusCode)
+
+		resp = GraphQLRequest(t, router, ownerToken, mutation, map[string]interface{}{"orgId": orgId, "userId": "0"}, nil)
+		require.Len(t, resp.Errors, 1)
+		assert.Equal(t, http.StatusNotFound, resp.Errors[0].Extensions.StatusCode)
+		assert.Equal(t, "user not found", resp.Errors[0].Message)
+
+		var added struct {
+			AddUserToOrganisation struct {
+				User         struct{ UserID string } `json:"user"`
+				Organisation struct{ OrgID string }  `json:"organisation"`
+			} `json:"addUserToOrganisation"`
+		}
+		resp = GraphQLRequest(t, router, ownerToken, mutation, map[string]interface{}{"orgId": orgId, "userId": member.Data.User.UserID}, &added)
+		require.Empty(t, resp.Errors)
+		assert.Equal(t, member.Data.User.UserID, added.AddUserToOrganisation.User.UserID)
+		assert.Equal(t, orgId, added.AddUserToOrganisation.Organisation.OrgID)
+
+		var audits int64
+		db.Model(&models.AuditEvent{}).
+			Where("organisation_id = ? AND action = ? AND target_id = ?", orgId, models.AuditMemberAdded, member.Data.User.UserID).
+			Count(&audits)
+		assert.EqualValues(t, 1, audits)
+
+		// any member can add users, whatever their role
+		resp = GraphQLRequest(t, router, member.Data.AccessToken, mutation, map[string]interface{}{"orgId": orgId, "userId": newcomer.Data.User.UserID}, nil)
+		assert.Empty(t, resp.Errors)
+	})
+
+	t.Run("should resolve nested users and organisations the caller can see", func(t *testing.T) {
+		var me struct {
+			Me struct {
+				UserID        string         `json:"userId"`
+				Email         string         `json:"email"`
+				Organisations []organisation `json:"organisations"`
+			} `json:"me"`
+		}
+		resp := GraphQLRequest(t, router, ownerToken, `{
+			me { userId email organisations { orgId name members { userId email organisations { orgId } } } }
+		}`, nil, &me)
+		require.Empty(t, resp.Errors)
+		assert.Equal(t, owner.Data.User.UserID, me.Me.UserID)
+		require.Len(t, me.Me.Organisations, 1)
+
+		members := map[string][]string{}
+		for _, user := range me.Me.Organisations[0].Members {
+			for _, org := range user.Organisations {
+				members[user.Email] = append(members[user.Email], org.OrgID)
+			}
+		}
+		// the member's own organisation is not the owner's to see
+		assert.Equal(t, map[string][]string{
+			owner.Data.User.Email:    {orgId},
+			member.Data.User.Email:   {orgId},
+			newcomer.Data.User.Email: {orgId},
+		}, members)
+
+		var other struct {
+			User *struct {
+				Email         string                   `json:"email"`
+				Organisations []struct{ OrgID string } `json:"organisations"`
+			} `json:"user"`
+			Organisation *organisation `json:"organisation"`
+		}
+		resp = GraphQLRequest(t, router, stranger.Data.AccessToken, `query ($userId: ID!, $orgId: ID!) {
+			user(userId: $userId) { email organisations { orgId } }
+			organisation(orgId: $orgId) { orgId }
+		}`, map[string]interface{}{"userId": owner.Data.User.UserID, "orgId": orgId}, &other)
+		require.Empty(t, resp.Errors)
+		require.NotNil(t, other.User)
+		assert.Equal(t, owner.Data.User.Email, other.User.Email)
+		assert.Empty(t, other.User.Organisations)
+		assert.Nil(t, other.Organisation)
+	})
+
+	t.Run("should create organisations", func(t *testing.T) {
+		mutation := `mutation ($name: String!) { createOrganisation(name: $name, description: "made over graphql") { orgId name members { userId } } }`
+
+		resp := GraphQLRequest(t, router, ownerToken, mutation, map[string]interface{}{"name": ""}, nil)
+		require.Len(t, resp.Errors, 1)
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.Errors[0].Extensions.StatusCode)
+		require.Len(t, resp.Errors[0].Extensions.Errors, 1)
+		assert.Equal(t, "name", resp.Errors[0].Extensions.Errors[0].Field)
+
+		var created struct {
+			CreateOrganisation organisation `json:"createOrganisation"`
+		}
+		resp = GraphQLRequest(t, router, ownerToken, mutation, map[string]interface{}{"name": "GraphQL"}, &created)
+		require.Empty(t, resp.Errors)
+		assert.Equal(t, "GraphQL", created.CreateOrganisation.Name)
+		require.Len(t, created.CreateOrganisation.Members, 1)
+		assert.Equal(t, owner.Data.User.UserID, created.CreateOrganisation.Members[0].UserID)
+
+		var page struct {
+			Organisations struct {
+				Organisations []organisation `json:"organisations"`
+				Pagination    struct {
+					Total      int     `json:"total"`
+					NextCursor *string `json:"nextCursor"`
+				} `json:"pagination"`
+			} `json:"organisations"`
+		}
+		resp = GraphQLRequest(t, router, ownerToken, `{ organisations(limit: 1, sort: "-createdAt") { organisations { name } pagination { total nextCursor } } }`, nil, &page)
+		require.Empty(t, resp.Errors)
+		assert.Equal(t, 2, page.Organisations.Pagination.Total)
+		require.Len(t, page.Organisations.Organisations, 1)
+		assert.Equal(t, "GraphQL", page.Organisations.Organisations[0].Name)
+		assert.NotNil(t, page.Organisations.Pagination.NextCursor)
+
+		resp = GraphQLRequest(t, router, ownerToken, `{ organisations(sort: "size") { pagination { total } } }`, nil, nil)
+		require.Len(t, resp.Errors, 1)
+		assert.Equal(t, http.StatusUnprocessableEntity, resp.Errors[0].Extensions.StatusCode)
+	})
+
+	t.Run("should check the scopes of tokens", func(t *testing.T) {
+		var tokenResp struct {
+			Data struct {
+				Token string `json:"token"`
+			} `json:"data"`
+		}
+		w := DoRequest("POST", "/api/tokens", ownerToken, map[string]interface{}{"name": "graphql", "scopes": []string{"orgs:read"}, "expiresInDays": 1})
+		require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&tokenResp))
+		pat := tokenResp.Data.Token
+
+		resp := GraphQLRequest(t, router, pat, `{ organisations { organisations { orgId } } }`, nil, nil)
+		assert.Empty(t, resp.Errors)
+
+		for _, query := range []string{
+			`{ me { userId } }`,
+			`{ organisations { organisations { members { userId } } } }`,
+			`{ joinableOrganisations { orgId } }`,
+			`mutation { createOrganisation(name: "nope") { orgId } }`,
+			`mutation { joinOrganisation(orgId: "1") { user { userId } } }`,
+		} {
+			resp := GraphQLRequest(t, router, pat, query, nil, nil)
+			require.NotEmpty(t, resp.Errors, query)
+			assert.Equal(t, http.StatusForbidden, resp.Errors[0].Extensions.StatusCode, query)
+		}
+	})
+
+	t.Run("should batch the membership lookups of each level of a query", func(t *testing.T) {
+		user, _ := RegisterRandomUser(t)
+		token := user.Data.AccessToken
+		query := `{ me { organisations { members { organisations { members { userId } } } } } }`
+
+		counter := &countingLogger{Interface: logger.Discard, table: "users_organisations"}
+		handler := gin.New()
+		handler.POST("/graphql", middlewares.Auth(db), (&controllers.GraphQLController{DB: db.Session(&gorm.Session{Logger: counter})}).Serve)
+
+		statements := func(t *testing.T) int64 {
+			counter.count.Store(0)
+			resp := GraphQLRequest(t, handler, token, query, nil, nil)
+			require.Empty(t, resp.Errors)
+			return counter.count.Load()
+		}
+
+		// each organisation has a member of its own, so each level of the
+		// query has users or organisations the levels above did not load
+		addOrganisation := func(t *testing.T) {
+			var created struct {
+				CreateOrganisation struct{ OrgID string } `json:"createOrganisation"`
+			}
+			resp := GraphQLRequest(t, router, token, `mutation { createOrganisation(name: "batch") { orgId } }`, nil, &created)
+			require.Empty(t, resp.Errors)
+			other, _ := RegisterRandomUser(t)
+			w := DoRequest("POST", "/api/organisations/"+created.CreateOrganisation.OrgID+"/users", token, map[string]string{"userId": other.Data.User.UserID})
+			require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		}
+
+		addOrganisation(t)
+		few := statements(t)
+		for i := 0; i < 5; i++ {
+			addOrganisation(t)
+		}
+
+		assert.Equal(t, few, statements(t))
+		assert.LessOrEqual(t, few, int64(3))
+	})
+}
+
+func TestGRPC(t *testing.T) {
+	lis := bufconn.Listen(1 << 20)
+	server, _ := newGRPCServer(db)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	identity := identitypb.NewIdentityClient(conn)
+
+	as := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	}
+	var orgsResp struct {
+		Data struct {
+			Organisations []struct {
+				OrgID string `json:"orgId"`
+			} `json:"organisations"`
+		} `json:"data"`
+	}
+	firstOrganisation := func(t *testing.T, token string) string {
+		w := DoRequest("GET", "/api/organisations", token, nil)
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		require.Nil(t, json.NewDecoder(w.Body).Decode(&orgsResp))
+		return orgsResp.Data.Organisations[0].OrgID
+	}
+
+	owner, _ := RegisterRandomUser(t)
+	member, _ := RegisterRandomUser(t)
+	stranger, _ := RegisterRandomUser(t)
+	orgId := firstOrganisation(t, owner.Data.AccessToken)
+	memberOrgId := firstOrganisation(t, member.Data.AccessToken)
+	w := DoRequest("POST", "/api/organisations/"+orgId+"/users", owner.Data.AccessToken, map[string]string{"userId": member.Data.User.UserID})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var accountResp struct {
+		Data struct {
+			ServiceAccountID string `json:"serviceAccountId"`
+		} `json:"data"`
+	}
+	w = DoRequest("POST", "/api/organisations/"+orgId+"/service-accounts", owner.Data.AccessToken, map[string]string{"name": "billing", "role": "member"})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	require.Nil(t, json.NewDecoder(w.Body).Decode(&accountResp))
+	var keyResp struct {
+		Data struct {
+			Key string `json:"key"`
+		} `json:"data"`
+	}
+	w = DoRequest("POST", "/api/organisations/"+orgId+"/service-accounts/"+accountResp.Data.ServiceAccountID+"/keys", owner.Data.AccessToken, nil)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	require.Nil(t, json.NewDecoder(w.Body).Decode(&keyResp))
+	apiKey := keyResp.Data.Key
+
+	var tokenResp struct {
+		Data struct {
+			Token string `json:"token"`
+		} `json:"data"`
+	}
+	w = DoRequest("POST", "/api/tokens", owner.Data.AccessToken, map[string]interface{}{"name": "grpc", "scopes": []string{"orgs:read"}, "expiresInDays": 1})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	require.Nil(t, json.NewDecoder(w.Body).Decode(&tokenResp))
+	pat := tokenResp.Data.Token
+
+	t.Run("should serve health checks to anyone", func(t *testing.T) {
+		health := healthpb.NewHealthClient(conn)
+		for _, service := range []string{"", identitypb.Identity_ServiceDesc.ServiceName} {
+			resp, err := health.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+			require.NoError(t, err)
+			assert.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.Status)
+		}
+	})
+
+	t.Run("should authenticate callers like the HTTP API", func(t *testing.T) {
+		req := &identitypb.GetUserRequest{UserId: owner.Data.User.UserID}
+
+		_, err := identity.GetUser(context.Background(), req)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+		_, err = identity.GetUser(as("not a token"), req)
+		assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+		for _, token := range []string{owner.Data.AccessToken, apiKey} {
+			_, err = identity.GetUser(as(token), req)
+			assert.NoError(t, err)
+		}
+
+		_, err = identity.GetUser(as(pat), req)
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+		assert.Contains(t, status.Convert(err).Message(), "users:read")
+	})
+
+	t.Run("should verify tokens for service accounts", func(t *testing.T) {
+		_, err := identity.VerifyToken(as(owner.Data.AccessToken), &identitypb.VerifyTokenRequest{Token: pat})
+		assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+		resp, err := identity.VerifyToken(as(apiKey), &identitypb.VerifyTokenRequest{Token: owner.Data.AccessToken})
+		require.NoError(t, err)
+		assert.True(t, resp.Active)
+		assert.True(t, resp.Session)
+		assert.Empty(t, resp.Scopes)
+		assert.Equal(t, owner.Data.User.Email, resp.GetUser().GetEmail())
+
+		resp, err = identity.VerifyToken(as(apiKey), &identitypb.VerifyTokenRequest{Token: pat})
+		require.NoError(t, err)
+		assert.True(t, resp.Active)
+		assert.False(t, resp.Session)
+		assert.Equal(t, []string{"orgs:read"}, resp.Scopes)
+		assert.Equal(t, owner.Data.User.UserID, resp.GetUser().GetUserId())
+
+		resp, err = identity.VerifyToken(as(apiKey), &identitypb.VerifyTokenRequest{Token: apiKey})
+		require.NoError(t, err)
+		assert.True(t, resp.Active)
+		assert.Equal(t, accountResp.Data.ServiceAccountID, resp.GetServiceAccount().GetServiceAccountId())
+		assert.Equal(t, orgId, resp.GetServiceAccount().GetOrgId())
+
+		resp, err = identity.VerifyToken(as(apiKey), &identitypb.VerifyTokenRequest{Token: "pat_revoked"})
+		require.NoError(t, err)
+		assert.False(t, resp.Active)
+		assert.NotEmpty(t, resp.Error)
+		assert.Nil(t, resp.Principal)
+	})
+
+	t.Run("should only show service accounts the members of their organisation", func(t *testing.T) {
+		user, err := identity.GetUser(as(apiKey), &identitypb.GetUserRequest{UserId: member.Data.User.UserID})
+		require.NoError(t, err)
+		assert.Equal(t, member.Data.User.Email, user.Email)
+
+		_, err = identity.GetUser(as(apiKey), &identitypb.GetUserRequest{UserId: stranger.Data.User.UserID})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+		_, err = identity.GetUser(as(owner.Data.AccessToken), &identitypb.GetUserRequest{UserId: "0"})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+
+	t.Run("should list the organisations of users the caller can see", func(t *testing.T) {
+		orgIds := func(resp *identitypb.ListUserOrganisationsResponse) []string {
+			var ids []string
+			for _, org := range resp.Organisations {
+				ids = append(ids, org.OrgId)
+			}
+			return ids
+		}
+
+		resp, err := identity.ListUserOrganisations(as(member.Data.AccessToken), &identitypb.ListUserOrganisationsRequest{UserId: member.Data.User.UserID, PageSize: 1})
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, resp.Total)
+		require.NotEmpty(t, resp.NextPageToken)
+		first := orgIds(resp)
+		resp, err = identity.ListUserOrganisations(as(member.Data.AccessToken), &identitypb.ListUserOrganisationsRequest{UserId: member.Data.User.UserID, PageSize: 1, PageToken: resp.NextPageToken})
+		require.NoError(t, err)
+		assert.Empty(t, resp.NextPageToken)
+		assert.ElementsMatch(t, []string{orgId, memberOrgId}, append(first, orgIds(resp)...))
+
+		// only the organisations the caller is a member of as well
+		for _, token := range []string{owner.Data.AccessToken, pat, apiKey} {
+			resp, err := identity.ListUserOrganisations(as(token), &identitypb.ListUserOrganisationsRequest{UserId: member.Data.User.UserID})
+			require.NoError(t, err)
+			assert.Equal(t, []string{orgId}, orgIds(resp))
+		}
+
+		_, err = identity.ListUserOrganisations(as(owner.Data.AccessToken), &identitypb.ListUserOrganisationsRequest{UserId: member.Data.User.UserID, PageToken: "nope"})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("should check memberships of the caller's organisations", func(t *testing.T) {
+		testCases := []struct {
+			userId string
+			member bool
+			role   string
+		}{
+			{owner.Data.User.UserID, true, models.RoleAdmin},
+			{member.Data.User.UserID, true, models.RoleMember},
+			{stranger.Data.User.UserID, false, ""},
+		}
+		for _, testCase := range testCases {
+			for _, token := range []string{owner.Data.AccessToken, apiKey} {
+				resp, err := identity.CheckMembership(as(token), &identitypb.CheckMembershipRequest{UserId: testCase.userId, OrgId: orgId})
+				require.NoError(t, err)
+				assert.Equal(t, testCase.member, resp.Member)
+				assert.Equal(t, testCase.role, resp.Role)
+			}
+		}
+
+		_, err := identity.CheckMembership(as(owner.Data.AccessToken), &identitypb.CheckMembershipRequest{UserId: member.Data.User.UserID, OrgId: memberOrgId})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+		_, err = identity.CheckMembership(as(owner.Data.AccessToken), &identitypb.CheckMembershipRequest{UserId: "0", OrgId: orgId})
+		assert.Equal(t, codes.NotFound, status.Code(err))
+	})
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+
+	for _, violation := range specViolations {
+		fmt.Println("response does not match the OpenAPI document:", violation)
+	}
+	if code == 0 && len(specViolations) > 0 {
+		code = 1
+	}
+	os.Exit(code)
+}
+
+// teeWriter keeps a copy of what is written to the response.
+type teeWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *teeWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *teeWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+// validateResponses records the responses that are not described by the
+// operation of their route in the OpenAPI document, for TestMain to fail on.
+func validateResponses(c *gin.Context) {
+	writer := &teeWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+	c.Next()
+
+	if c.FullPath() == "" {
+		return
+	}
+	path := specPath(c.FullPath())
+	method := strings.ToLower(c.Request.Method)
+	where := fmt.Sprintf("%s %s %d", c.Request.Method, path, writer.Status())
+	if err := checkResponse(path, method, writer.Status(), writer.Header().Get("Content-Type"), writer.body.Bytes()); err != nil {
+		specViolationsMu.Lock()
+		specViolations = append(specViolations, where+": "+err.Error())
+		specViolationsMu.Unlock()
+	}
+}
+
+// specPath returns the path of the OpenAPI document describing a route, the
+// unversioned aliases being described by the routes of v1.
+func specPath(route string) string {
+	path := ginPathParam.ReplaceAllString(route, "{$1}")
+	if _, ok := spec.Paths[path]; !ok {
+		if _, ok := spec.Paths["/v1"+path]; ok {
+			return "/v1" + path
+		}
+	}
+	return path
+}
+
+func checkResponse(path, method string, status int, contentType string, body []byte) error {
+	operation, ok := spec.Paths[path][method]
+	if !ok {
+		return fmt.Errorf("no operation")
+	}
+
+	response, ok := operation.Responses[strconv.Itoa(status)]
+	if !ok {
+		if response, ok = operation.Responses["default"]; !ok {
+			return fmt.Errorf("undocumented status")
+		}
+	}
+	if response.Ref != "" {
+		response = spec.Components.Responses[strings.TrimPrefix(response.Ref, "#/components/responses/")]
+	}
+
+	if len(response.Content) == 0 {
+		if len(body) > 0 {
+			return fmt.Errorf("undocumented body %q", body)
+		}
+		return nil
+	}
+
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	media, ok := response.Content[mediaType]
+	if !ok {
+		return fmt.Errorf("undocumented content type %q", contentType)
+	}
+	if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+		return nil
+	}
+
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return err
+	}
+	return validateSchema(media.Schema, value, "$")
+}
+
+// validateSchema checks value against the subset of JSON Schema the OpenAPI
+// document uses. Objects with properties are taken to have no others, so
+// fields missing from the document are caught.
+func validateSchema(schema map[string]interface{}, value interface{}, at string) error {
+	if err := validateSubschema(schema, value, at); err != nil {
+		return err
+	}
+
+	if object, ok := value.(map[string]interface{}); ok {
+		properties, closed := schemaProperties(schema)
+		for name := range object {
+			if closed && !properties[name] {
+				return fmt.Errorf("%s: undocumented property %s", at, name)
+			}
+		}
+	}
+	return nil
+}
+
+// validateSubschema is validateSchema without rejecting the properties it
+// does not know, for the schemas of an allOf.
+func validateSubschema(schema map[string]interface{}, value interface{}, at string) error {
+	schema = resolveSchema(schema)
+
+	if types, ok := schema["type"]; ok {
+		matched := false
+		switch types := types.(type) {
+		case string:
+			matched = isJSONType(value, types)
+		case []interface{}:
+			for _, t := range types {
+				matched = matched || isJSONType(value, t.(string))
+			}
+		}
+		if !matched {
+			return fmt.Errorf("%s: %v is not of type %v", at, value, types)
+		}
+	}
+
+	if constant, ok := schema["const"]; ok && constant != value {
+		return fmt.Errorf("%s: %v is not %v", at, value, constant)
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok && !slices.Contains(enum, value) {
+		return fmt.Errorf("%s: %v is not one of %v", at, value, enum)
+	}
+
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			if err := validateSubschema(sub.(map[string]interface{}), value, at); err != nil {
+				return err
+			}
+		}
+	}
+	if one, ok := schema["oneOf"].([]interface{}); ok {
+		matches := 0
+		for _, sub := range one {
+			if validateSchema(sub.(map[string]interface{}), value, at) == nil {
+				matches++
+			}
+		}
+		if matches != 1 {
+			return fmt.Errorf("%s: %v matches %d schemas of oneOf", at, value, matches)
+		}
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		required, _ := schema["required"].([]interface{})
+		for _, name := range required {
+			if _, ok := value[name.(string)]; !ok {
+				return fmt.Errorf("%s: missing %s", at, name)
+			}
+		}
+		for name, v := range value {
+			if property, ok := properties[name].(map[string]interface{}); ok {
+				if err := validateSchema(property, v, at+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, v := range value {
+				if err := validateSchema(items, v, fmt.Sprintf("%s[%d]", at, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func resolveSchema(schema map[string]interface{}) map[string]interface{} {
+	for {
+		ref, ok := schema["$ref"].(string)
+		if !ok {
+			return schema
+		}
+		schema = spec.Components.Schemas[strings.TrimPrefix(ref, "#/components/schemas/")]
+	}
+}
+
+// schemaProperties returns the properties declared by the schema and its
+// allOf, and whether it declares any.
+func schemaProperties(schema map[string]interface{}) (map[string]bool, bool) {
+	schema = resolveSchema(schema)
+	properties := map[string]bool{}
+	declared := false
+
+	if own, ok := schema["properties"].(map[string]interface{}); ok {
+		declared = true
+		for name := range own {
+			properties[name] = true
+		}
+	}
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			inherited, ok := schemaProperties(sub.(map[string]interface{}))
+			declared = declared || ok
+			for name := range inherited {
+				properties[name] = true
+			}
+		}
+	}
+	return properties, declared
+}
+
+func isJSONType(value interface{}, t string) bool {
+	switch value := value.(type) {
+	case nil:
+		return t == "null"
+	case bool:
+		return t == "boolean"
+	case string:
+		return t == "string"
+	case float64:
+		return t == "number" || (t == "integer" && value == float64(int64(value)))
+	case []interface{}:
+		return t == "array"
+	case map[string]interface{}:
+		return t == "object"
+	}
+	return false
+}
+
+func TestOpenAPI(t *testing.T) {
+	t.Run("should describe every route", func(t *testing.T) {
+		routes := map[string]bool{}
+		for _, route := range router.Routes() {
+			path := specPath(route.Path)
+			routes[route.Method+" "+path] = true
+			assert.Contains(t, spec.Paths[path], strings.ToLower(route.Method), "%s %s is not in the OpenAPI document", route.Method, route.Path)
+		}
+
+		for path, operations := range spec.Paths {
+			for method := range operations {
+				assert.True(t, routes[strings.ToUpper(method)+" "+path], "%s %s is not a route", strings.ToUpper(method), path)
+			}
+		}
+	})
+
+	t.Run("should resolve every reference", func(t *testing.T) {
+		var document interface{}
+		require.NoError(t, json.Unmarshal(docs.OpenAPI, &document))
+
+		refs := regexp.MustCompile(`"\$ref":\s*"#/components/(\w+)/(\w+)"`).FindAllSubmatch(docs.OpenAPI, -1)
+		require.NotEmpty(t, refs)
+		components := document.(map[string]interface{})["components"].(map[string]interface{})
+		for _, ref := range refs {
+			section, _ := components[string(ref[1])].(map[string]interface{})
+			assert.Contains(t, section, string(ref[2]))
+		}
+	})
+
+	t.Run("should serve the document", func(t *testing.T) {
+		w := DoRequest("GET", "/openapi.json", "", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.JSONEq(t, string(docs.OpenAPI), w.Body.String())
+	})
+
+	t.Run("should serve the documentation", func(t *testing.T) {
+		w := DoRequest("GET", "/docs", "", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Header().Get("Content-Type"), "text/html")
+		assert.Contains(t, w.Body.String(), `spec-url="/openapi.json"`)
+	})
+
+	t.Run("should reject responses not matching their schema", func(t *testing.T) {
+		body := []byte(`{"status":"success","message":"","data":{"userId":1}}`)
+		assert.Error(t, checkResponse("/v1/api/users/{id}", "get", http.StatusOK, "application/json", body))
+
+		body = []byte(`{"status":"success","message":"","data":{"userId":"1","firstName":"","lastName":"","email":"","phone":"","password":""}}`)
+		assert.Error(t, checkResponse("/v1/api/users/{id}", "get", http.StatusOK, "application/json", body))
+
+		body = []byte(`{"status":"success","message":"","data":{"userId":"1","firstName":"","lastName":"","email":"","phone":""}}`)
+		assert.NoError(t, checkResponse("/v1/api/users/{id}", "get", http.StatusOK, "application/json", body))
+	})
+}
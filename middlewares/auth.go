@@ -1,13 +1,22 @@
 package middlewares
 
 import (
+	"errors"
 	"net/http"
+	"strings"
+	"time"
 
+	"github.com/codelikesuraj/hng11-task-two/models"
 	"github.com/codelikesuraj/hng11-task-two/utils"
 	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
 )
 
-func Auth() gin.HandlerFunc {
+// Auth authenticates the bearer token once per request, as a JWT, personal
+// access token, service account API key or OAuth access token issued to a
+// client app, and stores the resulting Principal in the context. Handlers
+// read it back with GetPrincipal.
+func Auth(db *gorm.DB) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		tokenString, err := utils.GetJWTFromRequest(c)
 		if err != nil {
@@ -17,13 +26,179 @@ func Auth() gin.HandlerFunc {
 			return
 		}
 
-		if err := utils.CheckJWT(tokenString); err != nil {
+		principal, err := Authenticate(db, tokenString)
+		if err != nil {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
 				"error": err.Error(),
 			})
 			return
 		}
 
+		c.Set(PrincipalKey, principal)
+		c.Next()
+	}
+}
+
+// Authenticate returns the caller a bearer token authenticates, for Auth and
+// the other servers authenticating callers the same way.
+func Authenticate(db *gorm.DB, tokenString string) (Principal, error) {
+	switch {
+	case strings.HasPrefix(tokenString, models.PersonalAccessTokenPrefix):
+		return authenticatePersonalAccessToken(db, tokenString)
+	case strings.HasPrefix(tokenString, models.APIKeyPrefix):
+		return authenticateAPIKey(db, tokenString)
+	case strings.HasPrefix(tokenString, models.OAuthAccessTokenPrefix):
+		return authenticateOAuthToken(db, tokenString)
+	default:
+		return authenticateJWT(db, tokenString)
+	}
+}
+
+func authenticateJWT(db *gorm.DB, tokenString string) (Principal, error) {
+	claims, err := utils.ParseJWT(tokenString)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	userId, err := utils.GetUserIDFromClaims(claims)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	user, err := findActiveUser(db, userId)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	// revoking a user's sessions bumps their session version, tokens issued
+	// before the sv claim was added count as version 0
+	sv, _ := claims["sv"].(float64)
+	if uint(sv) != user.SessionVersion {
+		return Principal{}, errors.New("session has been revoked")
+	}
+
+	return Principal{User: &user}, nil
+}
+
+func authenticatePersonalAccessToken(db *gorm.DB, tokenString string) (Principal, error) {
+	var pat models.PersonalAccessToken
+	result := db.Where("token_hash = ?", utils.HashToken(tokenString)).Limit(1).Find(&pat)
+	if result.Error != nil || result.RowsAffected < 1 {
+		return Principal{}, errors.New("invalid token")
+	}
+
+	if pat.HasExpired() {
+		return Principal{}, errors.New("expired token")
+	}
+
+	user, err := findActiveUser(db, pat.UserID)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	db.Model(&pat).UpdateColumn("last_used_at", time.Now())
+
+	return Principal{User: &user, Scopes: pat.ScopeList()}, nil
+}
+
+func authenticateAPIKey(db *gorm.DB, tokenString string) (Principal, error) {
+	var key models.APIKey
+	result := db.Where("key_hash = ?", utils.HashToken(tokenString)).Preload("ServiceAccount").Limit(1).Find(&key)
+	if result.Error != nil || result.RowsAffected < 1 || key.ServiceAccount.ID == 0 {
+		return Principal{}, errors.New("invalid token")
+	}
+
+	if !key.IsActive() {
+		return Principal{}, errors.New("revoked or expired token")
+	}
+
+	db.Model(&key).UpdateColumn("last_used_at", time.Now())
+
+	return Principal{
+		ServiceAccount: &key.ServiceAccount,
+		Scopes:         models.ScopesForRole(key.ServiceAccount.Role),
+	}, nil
+}
+
+// authenticateOAuthToken accepts access tokens a client app obtained on behalf
+// of a user. Tokens from the client credentials grant have no user and are
+// only meant for the client's own resource servers, via introspection.
+func authenticateOAuthToken(db *gorm.DB, tokenString string) (Principal, error) {
+	var token models.OAuthToken
+	result := db.Where("token_hash = ? AND type = ?", utils.HashToken(tokenString), models.OAuthTokenTypeAccess).Limit(1).Find(&token)
+	if result.Error != nil || result.RowsAffected < 1 || token.UserID == nil {
+		return Principal{}, errors.New("invalid token")
+	}
+
+	if !token.IsActive() {
+		return Principal{}, errors.New("revoked or expired token")
+	}
+
+	user, err := findActiveUser(db, *token.UserID)
+	if err != nil {
+		return Principal{}, err
+	}
+
+	return Principal{User: &user, Scopes: token.ScopeList()}, nil
+}
+
+// findActiveUser loads the user a token was issued to, rejecting users who
+// have since been deleted or disabled.
+func findActiveUser(db *gorm.DB, userId uint) (models.User, error) {
+	var user models.User
+	result := db.Limit(1).Find(&user, userId)
+	if result.Error != nil || result.RowsAffected < 1 {
+		return user, errors.New("user not found")
+	}
+
+	if user.IsDisabled() {
+		return user, errors.New("account is disabled")
+	}
+
+	return user, nil
+}
+
+// RequireScope rejects personal access tokens and service accounts that were
+// not granted scope.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if principal, _ := GetPrincipal(c); !principal.HasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "token is missing the " + scope + " scope",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireSession rejects personal access tokens and service accounts, for
+// routes that should only be reachable by a user who logged in with their
+// password.
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if principal, _ := GetPrincipal(c); !principal.IsSession() {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "tokens and API keys cannot access this route",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireAdmin rejects anyone who is not a platform administrator.
+func RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if principal, _ := GetPrincipal(c); principal.User == nil || !principal.User.IsAdmin {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "admin access required",
+			})
+			return
+		}
+
 		c.Next()
 	}
 }
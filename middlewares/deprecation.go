@@ -0,0 +1,26 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Deprecated marks the responses of deprecated routes with when they were
+// deprecated and when they will be removed, in the Deprecation (RFC 9745) and
+// Sunset (RFC 8594) headers, and links to the route replacing them, at the
+// same path under successorPrefix.
+func Deprecated(since, sunset time.Time, successorPrefix string) gin.HandlerFunc {
+	deprecation := fmt.Sprintf("@%d", since.Unix())
+	sunsetDate := sunset.UTC().Format(http.TimeFormat)
+
+	return func(c *gin.Context) {
+		c.Header("Deprecation", deprecation)
+		c.Header("Sunset", sunsetDate)
+		c.Header("Link", fmt.Sprintf(`<%s%s>; rel="successor-version"`, successorPrefix, c.Request.URL.Path))
+
+		c.Next()
+	}
+}
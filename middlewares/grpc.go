@@ -0,0 +1,97 @@
+package middlewares
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+type principalContextKey struct{}
+
+// GRPCAuth authenticates the bearer token in the authorization metadata of
+// every call, as Auth does for HTTP requests, and stores the resulting
+// Principal in the context of the call. Handlers read it back with
+// PrincipalFromContext. The methods of the public services, such as health
+// checking, are served to anyone.
+func GRPCAuth(db *gorm.DB, public ...string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if isPublicMethod(info.FullMethod, public) {
+			return handler(ctx, req)
+		}
+
+		principal, err := authenticateGRPC(ctx, db)
+		if err != nil {
+			return nil, err
+		}
+
+		return handler(context.WithValue(ctx, principalContextKey{}, principal), req)
+	}
+}
+
+// GRPCStreamAuth is GRPCAuth for streaming calls.
+func GRPCStreamAuth(db *gorm.DB, public ...string) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if isPublicMethod(info.FullMethod, public) {
+			return handler(srv, ss)
+		}
+
+		principal, err := authenticateGRPC(ss.Context(), db)
+		if err != nil {
+			return err
+		}
+
+		return handler(srv, &principalServerStream{
+			ServerStream: ss,
+			ctx:          context.WithValue(ss.Context(), principalContextKey{}, principal),
+		})
+	}
+}
+
+// PrincipalFromContext returns the principal stored by GRPCAuth.
+func PrincipalFromContext(ctx context.Context) (Principal, bool) {
+	p, ok := ctx.Value(principalContextKey{}).(Principal)
+	return p, ok
+}
+
+func authenticateGRPC(ctx context.Context, db *gorm.DB) (Principal, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	if len(values) != 1 {
+		return Principal{}, status.Error(codes.Unauthenticated, "authorization metadata is missing")
+	}
+
+	tokenString, ok := strings.CutPrefix(values[0], "Bearer ")
+	if !ok || tokenString == "" {
+		return Principal{}, status.Error(codes.Unauthenticated, "authorization metadata is missing")
+	}
+
+	principal, err := Authenticate(db, tokenString)
+	if err != nil {
+		return Principal{}, status.Error(codes.Unauthenticated, err.Error())
+	}
+
+	return principal, nil
+}
+
+// isPublicMethod reports whether the method, as /<service>/<method>, is one
+// of the public services.
+func isPublicMethod(fullMethod string, public []string) bool {
+	service, _, _ := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	return slices.Contains(public, service)
+}
+
+// principalServerStream is a stream with the context holding its principal.
+type principalServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *principalServerStream) Context() context.Context {
+	return s.ctx
+}
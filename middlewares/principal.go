@@ -0,0 +1,45 @@
+package middlewares
+
+import (
+	"slices"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/gin-gonic/gin"
+)
+
+const PrincipalKey = "principal"
+
+// Principal is the caller authenticated by Auth. Exactly one of User and
+// ServiceAccount is set.
+type Principal struct {
+	User           *models.User
+	ServiceAccount *models.ServiceAccount
+	// Scopes limits personal access tokens and service accounts. It is nil
+	// for JWT sessions, which are not restricted by scope.
+	Scopes []string
+}
+
+func (p Principal) IsServiceAccount() bool {
+	return p.ServiceAccount != nil
+}
+
+// IsSession reports whether the principal is a user who logged in with their
+// password, as opposed to a token or API key.
+func (p Principal) IsSession() bool {
+	return p.User != nil && p.Scopes == nil
+}
+
+func (p Principal) HasScope(scope string) bool {
+	return p.Scopes == nil || slices.Contains(p.Scopes, scope)
+}
+
+// GetPrincipal returns the principal stored by Auth.
+func GetPrincipal(c *gin.Context) (Principal, bool) {
+	v, ok := c.Get(PrincipalKey)
+	if !ok {
+		return Principal{}, false
+	}
+
+	p, ok := v.(Principal)
+	return p, ok
+}
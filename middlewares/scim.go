@@ -0,0 +1,55 @@
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/codelikesuraj/hng11-task-two/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	SCIMOrganisationKey = "scimOrganisation"
+	SCIMTokenKey        = "scimToken"
+)
+
+// SCIMAuth authenticates an identity provider with a SCIM token of the :orgId
+// organisation, and stores the organisation and token IDs in the context. Failures are
+// reported as SCIM errors, which is what identity providers expect.
+func SCIMAuth(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		tokenString, err := utils.GetJWTFromRequest(c)
+		if err != nil || !strings.HasPrefix(tokenString, models.SCIMTokenPrefix) {
+			abortSCIMUnauthorized(c)
+			return
+		}
+
+		orgId, _ := strconv.Atoi(c.Param("orgId"))
+
+		var token models.SCIMToken
+		result := db.Where("token_hash = ? AND organisation_id = ?", utils.HashToken(tokenString), orgId).Limit(1).Find(&token)
+		if result.Error != nil || result.RowsAffected < 1 {
+			abortSCIMUnauthorized(c)
+			return
+		}
+
+		db.Model(&token).UpdateColumn("last_used_at", time.Now())
+
+		c.Set(SCIMOrganisationKey, token.OrganisationID)
+		c.Set(SCIMTokenKey, token.ID)
+		c.Next()
+	}
+}
+
+func abortSCIMUnauthorized(c *gin.Context) {
+	c.Header("Content-Type", "application/scim+json")
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+		"schemas": []string{models.SCIMSchemaError},
+		"status":  strconv.Itoa(http.StatusUnauthorized),
+		"detail":  "invalid SCIM token",
+	})
+}
@@ -0,0 +1,245 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Actions recorded in the audit log.
+const (
+	AuditOrganisationCreated = "organisation.created"
+
+	AuditMemberAdded   = "member.added"
+	AuditMemberUpdated = "member.updated"
+	AuditMemberRemoved = "member.removed"
+
+	AuditInvitationCreated  = "invitation.created"
+	AuditInvitationRevoked  = "invitation.revoked"
+	AuditInvitationAccepted = "invitation.accepted"
+
+	AuditTeamCreated       = "team.created"
+	AuditTeamUpdated       = "team.updated"
+	AuditTeamDeleted       = "team.deleted"
+	AuditTeamMemberAdded   = "team.member_added"
+	AuditTeamMemberRemoved = "team.member_removed"
+
+	AuditDomainCreated  = "domain.created"
+	AuditDomainUpdated  = "domain.updated"
+	AuditDomainVerified = "domain.verified"
+	AuditDomainDeleted  = "domain.deleted"
+
+	AuditSAMLConfigUpdated = "saml_config.updated"
+	AuditSAMLConfigDeleted = "saml_config.deleted"
+
+	AuditSCIMTokenCreated = "scim_token.created"
+	AuditSCIMTokenDeleted = "scim_token.deleted"
+	AuditSCIMGroupCreated = "scim_group.created"
+	AuditSCIMGroupUpdated = "scim_group.updated"
+	AuditSCIMGroupDeleted = "scim_group.deleted"
+
+	AuditServiceAccountCreated = "service_account.created"
+	AuditServiceAccountDeleted = "service_account.deleted"
+	AuditAPIKeyCreated         = "api_key.created"
+	AuditAPIKeyRotated         = "api_key.rotated"
+	AuditAPIKeyRevoked         = "api_key.revoked"
+
+	AuditOAuthClientCreated = "oauth_client.created"
+	AuditOAuthClientDeleted = "oauth_client.deleted"
+
+	AuditWebhookCreated          = "webhook.created"
+	AuditWebhookUpdated          = "webhook.updated"
+	AuditWebhookDeleted          = "webhook.deleted"
+	AuditWebhookDeliveryReplayed = "webhook.delivery_replayed"
+)
+
+// Who performed an audited action.
+const (
+	AuditActorUser           = "user"
+	AuditActorServiceAccount = "service_account"
+	AuditActorSCIMToken      = "scim_token"
+	// AuditActorAnonymous acts through a link, like the one verifying a
+	// domain by email.
+	AuditActorAnonymous = "anonymous"
+)
+
+// What an audited action was performed on.
+const (
+	AuditTargetOrganisation   = "organisation"
+	AuditTargetUser           = "user"
+	AuditTargetInvitation     = "invitation"
+	AuditTargetTeam           = "team"
+	AuditTargetDomain         = "domain"
+	AuditTargetSAMLConfig     = "saml_config"
+	AuditTargetSCIMToken      = "scim_token"
+	AuditTargetSCIMGroup      = "scim_group"
+	AuditTargetServiceAccount = "service_account"
+	AuditTargetAPIKey         = "api_key"
+	AuditTargetOAuthClient    = "oauth_client"
+	AuditTargetWebhook        = "webhook"
+)
+
+// auditChainLock namespaces the advisory locks serialising the chains.
+const auditChainLock = 0x617564
+
+var ErrAuditEventImmutable = errors.New("audit events cannot be changed")
+
+// AuditEvent records a mutation of an organisation. Events are only ever
+// appended, changing or deleting one fails.
+//
+// The events of an organisation form a hash chain: each is numbered by
+// Sequence, from 1, and its Hash covers its content and the Hash of the event
+// before it, PrevHash. Altering, removing or reordering an event breaks every
+// link after it.
+type AuditEvent struct {
+	ID             uint      `gorm:"primarykey"`
+	CreatedAt      time.Time `gorm:"index"`
+	OrganisationID uint      `gorm:"index;index:idx_audit_events_chain,priority:1"`
+	Sequence       uint64    `gorm:"index:idx_audit_events_chain,priority:2"`
+	PrevHash       string
+	Hash           string
+	ActorType      string
+	ActorID        *uint
+	Action         string `gorm:"index"`
+	TargetType     string
+	TargetID       string
+	Metadata       map[string]interface{} `gorm:"serializer:json"`
+	IP             string
+	UserAgent      string
+}
+
+// BeforeCreate appends the event to the chain of its organisation.
+func (e *AuditEvent) BeforeCreate(tx *gorm.DB) error {
+	db := tx.Session(&gorm.Session{NewDB: true})
+
+	// Create runs in a transaction, which holds the lock until the event is
+	// in, so concurrent events cannot both follow the same one
+	if db.Dialector.Name() == "postgres" {
+		if err := db.Exec("SELECT pg_advisory_xact_lock(?, ?)", auditChainLock, e.OrganisationID).Error; err != nil {
+			return err
+		}
+	}
+
+	var last AuditEvent
+	err := db.Where("organisation_id = ?", e.OrganisationID).Order("sequence DESC").Limit(1).Find(&last).Error
+	if err != nil {
+		return err
+	}
+
+	// hashed as stored, databases keep no more than microseconds
+	if e.CreatedAt.IsZero() {
+		e.CreatedAt = time.Now()
+	}
+	e.CreatedAt = e.CreatedAt.UTC().Truncate(time.Microsecond)
+
+	e.Sequence = last.Sequence + 1
+	e.PrevHash = last.Hash
+	e.Hash = e.ComputeHash()
+	return nil
+}
+
+func (e *AuditEvent) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAuditEventImmutable
+}
+
+func (e *AuditEvent) BeforeDelete(tx *gorm.DB) error {
+	return ErrAuditEventImmutable
+}
+
+// ComputeHash returns the hex SHA-256 of PrevHash followed by the content of
+// the event.
+func (e *AuditEvent) ComputeHash() string {
+	content, _ := json.Marshal([]interface{}{
+		e.OrganisationID,
+		e.Sequence,
+		e.CreatedAt.UTC().Format(time.RFC3339Nano),
+		e.ActorType,
+		e.ActorID,
+		e.Action,
+		e.TargetType,
+		e.TargetID,
+		e.Metadata,
+		e.IP,
+		e.UserAgent,
+	})
+
+	sum := sha256.Sum256(append([]byte(e.PrevHash), content...))
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditCheckpoint vouches for the chain of an organisation up to the event
+// numbered Sequence, whose Hash it records, with the Signature of the server.
+// Removing events from the end of a chain breaks no link, only a checkpoint
+// past its new end gives it away.
+type AuditCheckpoint struct {
+	ID             uint `gorm:"primarykey"`
+	CreatedAt      time.Time
+	OrganisationID uint `gorm:"index"`
+	Sequence       uint64
+	Hash           string
+	Signature      string
+}
+
+func (c *AuditCheckpoint) BeforeUpdate(tx *gorm.DB) error {
+	return ErrAuditEventImmutable
+}
+
+func (c *AuditCheckpoint) BeforeDelete(tx *gorm.DB) error {
+	return ErrAuditEventImmutable
+}
+
+// AuditEventFilterParams narrows the audit log down, all set fields have to
+// match.
+type AuditEventFilterParams struct {
+	Action     string `form:"action" json:"action" validate:"omitempty,max=64"`
+	ActorID    string `form:"actorId" json:"actorId" validate:"omitempty,number"`
+	TargetType string `form:"targetType" json:"targetType" validate:"omitempty,max=64"`
+	TargetID   string `form:"targetId" json:"targetId" validate:"omitempty,max=64"`
+	Since      string `form:"since" json:"since" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	Until      string `form:"until" json:"until" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+}
+
+func AuditEventsResponse(events []AuditEvent) []map[string]interface{} {
+	res := []map[string]interface{}{}
+
+	for _, event := range events {
+		res = append(res, AuditEventResponse(event))
+	}
+
+	return res
+}
+
+func AuditEventResponse(event AuditEvent) map[string]interface{} {
+	var actorId *string
+	if event.ActorID != nil {
+		id := fmt.Sprintf("%d", *event.ActorID)
+		actorId = &id
+	}
+
+	metadata := event.Metadata
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+
+	return map[string]interface{}{
+		"eventId":    fmt.Sprintf("%d", event.ID),
+		"orgId":      fmt.Sprintf("%d", event.OrganisationID),
+		"sequence":   event.Sequence,
+		"actorType":  event.ActorType,
+		"actorId":    actorId,
+		"action":     event.Action,
+		"targetType": event.TargetType,
+		"targetId":   event.TargetID,
+		"metadata":   metadata,
+		"ip":         event.IP,
+		"userAgent":  event.UserAgent,
+		"createdAt":  event.CreatedAt.Format(time.RFC3339),
+		"prevHash":   event.PrevHash,
+		"hash":       event.Hash,
+	}
+}
@@ -0,0 +1,23 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	EmailVerificationTokenPrefix = "evt_"
+	EmailVerificationTTL         = 24 * time.Hour
+)
+
+// EmailVerification is a link emailed to a user to prove they own Email. It
+// no longer verifies anything once the user changed their email.
+type EmailVerification struct {
+	gorm.Model
+	UserID    uint
+	User      User
+	Email     string
+	TokenHash string `gorm:"uniqueIndex"`
+	ExpiresAt time.Time
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	InvitationTokenPrefix = "inv_"
+	InvitationTTL         = 7 * 24 * time.Hour
+)
+
+// Invitation lets whoever holds its token join an organisation, once. An
+// invitation with an Email can only be accepted by a user with that email.
+type Invitation struct {
+	gorm.Model
+	OrganisationID uint
+	Organisation   Organisation
+	Email          string
+	TokenHash      string `gorm:"uniqueIndex"`
+	InvitedByID    uint
+	ExpiresAt      time.Time
+	AcceptedAt     *time.Time
+	AcceptedByID   *uint
+}
+
+type InvitationCreateParams struct {
+	Email string `json:"email" validate:"omitempty,email"`
+}
+
+type InvitationAcceptParams struct {
+	Token string `json:"token" validate:"required"`
+}
+
+func (i Invitation) HasExpired() bool {
+	return time.Now().After(i.ExpiresAt)
+}
+
+func (i Invitation) IsAccepted() bool {
+	return i.AcceptedAt != nil
+}
+
+// IsFor reports whether a user with the email may accept the invitation.
+func (i Invitation) IsFor(email string) bool {
+	return i.Email == "" || strings.EqualFold(i.Email, email)
+}
+
+func InvitationsResponse(invitations []Invitation) []map[string]interface{} {
+	res := []map[string]interface{}{}
+
+	for _, invitation := range invitations {
+		res = append(res, InvitationResponse(invitation))
+	}
+
+	return res
+}
+
+func InvitationResponse(invitation Invitation) map[string]interface{} {
+	return map[string]interface{}{
+		"invitationId": fmt.Sprintf("%d", invitation.ID),
+		"orgId":        fmt.Sprintf("%d", invitation.OrganisationID),
+		"email":        invitation.Email,
+		"expiresAt":    invitation.ExpiresAt.Format(time.RFC3339),
+		"acceptedAt":   formatTime(invitation.AcceptedAt),
+		"createdAt":    invitation.CreatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// The status of a job. Dead jobs failed every attempt and stay in the table
+// until they are requeued by hand.
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobDead      = "dead"
+)
+
+// Kinds of jobs.
+const (
+	JobSendEmail           = "email.send"
+	JobPurgeOrganisations  = "organisations.purge"
+	JobExpireInvitations   = "invitations.expire"
+	JobCheckpointAuditLogs = "audit.checkpoint"
+	JobPruneJobs           = "jobs.prune"
+)
+
+const JobDefaultMaxAttempts = 10
+
+// Job is work for the background workers. Payload is the JSON its handler
+// is given; a job with a UniqueKey is only queued once for it.
+type Job struct {
+	ID          uint `gorm:"primarykey"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Kind        string `gorm:"index"`
+	Payload     string
+	Status      string    `gorm:"index:idx_jobs_due,priority:1"`
+	RunAt       time.Time `gorm:"index:idx_jobs_due,priority:2"`
+	Attempts    int
+	MaxAttempts int
+	LockedAt    *time.Time
+	LockedBy    string
+	LastError   string
+	FinishedAt  *time.Time
+	UniqueKey   *string `gorm:"uniqueIndex"`
+}
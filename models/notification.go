@@ -0,0 +1,123 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// The channels notifications are sent on.
+const (
+	NotificationInApp = "inApp"
+	NotificationEmail = "email"
+)
+
+// NotificationEvents are the events users are notified of, the events
+// published through the outbox.
+var NotificationEvents = WebhookEvents
+
+// Notification tells a user about an event, in the app. A user is notified of
+// an event once.
+type Notification struct {
+	ID             uint      `gorm:"primarykey"`
+	CreatedAt      time.Time `gorm:"index"`
+	UserID         uint      `gorm:"uniqueIndex:idx_notifications_user_event"`
+	EventID        string    `gorm:"uniqueIndex:idx_notifications_user_event"`
+	Event          string
+	OrganisationID uint
+	Title          string
+	Body           string
+	ReadAt         *time.Time
+}
+
+// NotificationPreference is whether a user is notified of an event in the
+// app and by email. Users without one get the defaults of
+// DefaultNotificationPreference.
+type NotificationPreference struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	UserID    uint   `gorm:"uniqueIndex:idx_notification_preferences_user_event"`
+	Event     string `gorm:"uniqueIndex:idx_notification_preferences_user_event"`
+	InApp     bool
+	Email     bool
+}
+
+// DefaultNotificationPreference notifies users of every event in the app,
+// and by email of being added to or removed from an organisation.
+func DefaultNotificationPreference(userId uint, event string) NotificationPreference {
+	return NotificationPreference{
+		UserID: userId,
+		Event:  event,
+		InApp:  true,
+		Email:  event == WebhookMemberAdded || event == WebhookMemberRemoved,
+	}
+}
+
+func (p NotificationPreference) Allows(channel string) bool {
+	switch channel {
+	case NotificationInApp:
+		return p.InApp
+	case NotificationEmail:
+		return p.Email
+	}
+	return false
+}
+
+func IsNotificationEvent(event string) bool {
+	return slices.Contains(NotificationEvents, event)
+}
+
+// NotificationPreferenceParams changes the channels that are set.
+type NotificationPreferenceParams struct {
+	InApp *bool `json:"inApp"`
+	Email *bool `json:"email"`
+}
+
+func NotificationsResponse(notifications []Notification) []map[string]interface{} {
+	res := []map[string]interface{}{}
+
+	for _, notification := range notifications {
+		res = append(res, NotificationResponse(notification))
+	}
+
+	return res
+}
+
+func NotificationResponse(notification Notification) map[string]interface{} {
+	var readAt *string
+	if notification.ReadAt != nil {
+		s := notification.ReadAt.Format(time.RFC3339)
+		readAt = &s
+	}
+
+	return map[string]interface{}{
+		"notificationId": fmt.Sprintf("%d", notification.ID),
+		"event":          notification.Event,
+		"eventId":        notification.EventID,
+		"orgId":          fmt.Sprintf("%d", notification.OrganisationID),
+		"title":          notification.Title,
+		"body":           notification.Body,
+		"read":           notification.ReadAt != nil,
+		"readAt":         readAt,
+		"createdAt":      notification.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func NotificationPreferencesResponse(preferences []NotificationPreference) []map[string]interface{} {
+	res := []map[string]interface{}{}
+
+	for _, preference := range preferences {
+		res = append(res, NotificationPreferenceResponse(preference))
+	}
+
+	return res
+}
+
+func NotificationPreferenceResponse(preference NotificationPreference) map[string]interface{} {
+	return map[string]interface{}{
+		"event": preference.Event,
+		"inApp": preference.InApp,
+		"email": preference.Email,
+	}
+}
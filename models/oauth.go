@@ -0,0 +1,155 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	OAuthClientIDPrefix       = "oac_"
+	OAuthClientSecretPrefix   = "ocs_"
+	OAuthAccessTokenPrefix    = "oat_"
+	OAuthRefreshTokenPrefix   = "ort_"
+	OAuthAuthorizationCodeTTL = 10 * time.Minute
+	OAuthAccessTokenTTL       = time.Hour
+	OAuthRefreshTokenTTL      = 30 * 24 * time.Hour
+)
+
+const (
+	OAuthTokenTypeAccess  = "access_token"
+	OAuthTokenTypeRefresh = "refresh_token"
+)
+
+// OAuthScopes are the scopes client apps can request: the OpenID Connect
+// scopes plus the API scopes also granted to personal access tokens.
+var OAuthScopes = []string{"openid", "profile", "email", "offline_access", ScopeOrgsRead, ScopeOrgsWrite, ScopeUsersRead}
+
+// OAuthClient is an app registered by an organisation to use this service as
+// its identity provider. Public clients have no secret and must use PKCE.
+type OAuthClient struct {
+	gorm.Model
+	OrganisationID uint
+	Organisation   Organisation
+	Name           string
+	ClientID       string `gorm:"uniqueIndex"`
+	SecretHash     string
+	RedirectURIs   string
+	Scopes         string
+	CreatedByID    uint
+}
+
+type OAuthClientCreateParams struct {
+	Name         string   `json:"name" validate:"required,min=1,max=64"`
+	RedirectURIs []string `json:"redirectUris" validate:"required,min=1,dive,url"`
+	Scopes       []string `json:"scopes" validate:"required,min=1,dive,oneof=openid profile email offline_access orgs:read orgs:write users:read"`
+	Public       bool     `json:"public"`
+}
+
+type OAuthAuthorizationCode struct {
+	gorm.Model
+	CodeHash            string `gorm:"uniqueIndex"`
+	ClientID            uint
+	UserID              uint
+	RedirectURI         string
+	Scopes              string
+	Nonce               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	ExpiresAt           time.Time
+	UsedAt              *time.Time
+}
+
+// OAuthConsent records the scopes a user has allowed a client to access.
+type OAuthConsent struct {
+	gorm.Model
+	UserID   uint `gorm:"uniqueIndex:idx_oauth_consents_user_client"`
+	ClientID uint `gorm:"uniqueIndex:idx_oauth_consents_user_client"`
+	Client   OAuthClient
+	Scopes   string
+}
+
+// OAuthToken is an access or refresh token issued to a client, either on
+// behalf of a user or, for the client credentials grant, of the client itself.
+type OAuthToken struct {
+	gorm.Model
+	TokenHash string `gorm:"uniqueIndex"`
+	Type      string
+	ClientID  uint
+	Client    OAuthClient
+	UserID    *uint
+	Scopes    string
+	ExpiresAt time.Time
+	RevokedAt *time.Time
+}
+
+func (c OAuthClient) IsPublic() bool {
+	return c.SecretHash == ""
+}
+
+func (c OAuthClient) RedirectURIList() []string {
+	return strings.Fields(c.RedirectURIs)
+}
+
+func (c OAuthClient) ScopeList() []string {
+	return strings.Fields(c.Scopes)
+}
+
+func (c OAuthConsent) Covers(scopes []string) bool {
+	granted := strings.Fields(c.Scopes)
+	for _, scope := range scopes {
+		if !slices.Contains(granted, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t OAuthToken) IsActive() bool {
+	return t.RevokedAt == nil && time.Now().Before(t.ExpiresAt)
+}
+
+func (t OAuthToken) ScopeList() []string {
+	return strings.Fields(t.Scopes)
+}
+
+func OAuthClientsResponse(clients []OAuthClient) []map[string]interface{} {
+	res := []map[string]interface{}{}
+
+	for _, client := range clients {
+		res = append(res, OAuthClientResponse(client))
+	}
+
+	return res
+}
+
+func OAuthClientResponse(client OAuthClient) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           fmt.Sprintf("%d", client.ID),
+		"orgId":        fmt.Sprintf("%d", client.OrganisationID),
+		"name":         client.Name,
+		"clientId":     client.ClientID,
+		"public":       client.IsPublic(),
+		"redirectUris": client.RedirectURIList(),
+		"scopes":       client.ScopeList(),
+	}
+}
+
+func OAuthConsentsResponse(consents []OAuthConsent) []map[string]interface{} {
+	res := []map[string]interface{}{}
+
+	for _, consent := range consents {
+		res = append(res, map[string]interface{}{
+			"consentId":  fmt.Sprintf("%d", consent.ID),
+			"clientId":   consent.Client.ClientID,
+			"clientName": consent.Client.Name,
+			"scopes":     strings.Fields(consent.Scopes),
+			"grantedAt":  consent.UpdatedAt.Format(time.RFC3339),
+		})
+	}
+
+	return res
+}
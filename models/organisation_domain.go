@@ -0,0 +1,96 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	DomainVerificationDNS   = "dns"
+	DomainVerificationEmail = "email"
+
+	// DomainVerificationTokenPrefix prefixes the tokens emailed to a
+	// domain's admin mailbox.
+	DomainVerificationTokenPrefix = "dvt_"
+	DomainVerificationEmailTTL    = 24 * time.Hour
+)
+
+// OrganisationDomain is an email domain claimed by an Organisation. Only a
+// verified domain is trusted to identify the organisation's users.
+//
+// A domain is verified either through a DNS TXT record holding
+// VerificationToken, or by following the link emailed to VerificationEmail,
+// an admin mailbox of the domain. Users with a verified email on a verified
+// domain are offered membership, or given it if AutoJoin is set. A domain
+// can only be verified by one organisation at a time.
+type OrganisationDomain struct {
+	gorm.Model
+	OrganisationID      uint   `gorm:"uniqueIndex:idx_organisation_domains_org_domain"`
+	Domain              string `gorm:"uniqueIndex:idx_organisation_domains_org_domain;index:idx_organisation_domains_verified_domain,unique,where:verified_at IS NOT NULL AND deleted_at IS NULL"`
+	VerifiedAt          *time.Time
+	VerificationMethod  string
+	VerificationToken   string
+	VerificationEmail   string
+	EmailTokenHash      string `gorm:"index"`
+	EmailTokenExpiresAt *time.Time
+	AutoJoin            bool
+}
+
+type OrganisationDomainCreateParams struct {
+	Domain   string `json:"domain" validate:"required,fqdn,max=253"`
+	Method   string `json:"method" validate:"required,oneof=dns email"`
+	Email    string `json:"email" validate:"required_if=Method email,omitempty,email"`
+	AutoJoin bool   `json:"autoJoin"`
+}
+
+type OrganisationDomainUpdateParams struct {
+	AutoJoin *bool `json:"autoJoin" validate:"required"`
+}
+
+func (d OrganisationDomain) IsVerified() bool {
+	return d.VerifiedAt != nil
+}
+
+// VerificationRecord is the value of the TXT record that proves the
+// organisation controls the domain.
+func (d OrganisationDomain) VerificationRecord() string {
+	return "hng11-domain-verification=" + d.VerificationToken
+}
+
+func OrganisationDomainsResponse(domains []OrganisationDomain) []map[string]interface{} {
+	res := []map[string]interface{}{}
+
+	for _, domain := range domains {
+		res = append(res, OrganisationDomainResponse(domain))
+	}
+
+	return res
+}
+
+func OrganisationDomainResponse(domain OrganisationDomain) map[string]interface{} {
+	res := map[string]interface{}{
+		"domainId":   fmt.Sprintf("%d", domain.ID),
+		"orgId":      fmt.Sprintf("%d", domain.OrganisationID),
+		"domain":     domain.Domain,
+		"method":     domain.VerificationMethod,
+		"verified":   domain.IsVerified(),
+		"verifiedAt": formatTime(domain.VerifiedAt),
+		"autoJoin":   domain.AutoJoin,
+		"createdAt":  domain.CreatedAt.Format(time.RFC3339),
+	}
+
+	switch domain.VerificationMethod {
+	case DomainVerificationDNS:
+		res["dnsRecord"] = map[string]string{
+			"type":  "TXT",
+			"name":  domain.Domain,
+			"value": domain.VerificationRecord(),
+		}
+	case DomainVerificationEmail:
+		res["email"] = domain.VerificationEmail
+	}
+
+	return res
+}
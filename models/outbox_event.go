@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+const OutboxEventPrefix = "evt_"
+
+// OutboxEvent is an event written in the transaction of the change it is
+// about, so that it exists if and only if the change does. The outbox relay
+// publishes it to the sinks afterwards, at least once: sinks and their
+// consumers tell repeats apart by EventID.
+type OutboxEvent struct {
+	ID             uint `gorm:"primarykey"`
+	CreatedAt      time.Time
+	EventID        string `gorm:"uniqueIndex"`
+	OrganisationID uint   `gorm:"index"`
+	Event          string
+	// Payload is the JSON sent to the sinks, see controllers.publishEvent.
+	Payload       string
+	PublishedAt   *time.Time
+	Attempts      int
+	NextAttemptAt *time.Time `gorm:"index"`
+	LastError     string
+}
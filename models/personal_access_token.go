@@ -0,0 +1,66 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const PersonalAccessTokenPrefix = "pat_"
+
+const (
+	ScopeOrgsRead  = "orgs:read"
+	ScopeOrgsWrite = "orgs:write"
+	ScopeUsersRead = "users:read"
+)
+
+type PersonalAccessToken struct {
+	gorm.Model
+	UserID     uint
+	User       User
+	Name       string
+	TokenHash  string `gorm:"uniqueIndex"`
+	Scopes     string
+	ExpiresAt  time.Time
+	LastUsedAt *time.Time
+}
+
+type PersonalAccessTokenCreateParams struct {
+	Name          string   `json:"name" validate:"required,min=1,max=64"`
+	Scopes        []string `json:"scopes" validate:"required,min=1,dive,oneof=orgs:read orgs:write users:read"`
+	ExpiresInDays int      `json:"expiresInDays" validate:"required,min=1,max=365"`
+}
+
+func (t PersonalAccessToken) ScopeList() []string {
+	if t.Scopes == "" {
+		return []string{}
+	}
+	return strings.Split(t.Scopes, " ")
+}
+
+func (t PersonalAccessToken) HasExpired() bool {
+	return time.Now().After(t.ExpiresAt)
+}
+
+func PersonalAccessTokensResponse(tokens []PersonalAccessToken) []map[string]interface{} {
+	res := []map[string]interface{}{}
+
+	for _, token := range tokens {
+		res = append(res, PersonalAccessTokenResponse(token))
+	}
+
+	return res
+}
+
+func PersonalAccessTokenResponse(token PersonalAccessToken) map[string]interface{} {
+	return map[string]interface{}{
+		"tokenId":    fmt.Sprintf("%d", token.ID),
+		"name":       token.Name,
+		"scopes":     token.ScopeList(),
+		"expiresAt":  token.ExpiresAt.Format(time.RFC3339),
+		"lastUsedAt": formatTime(token.LastUsedAt),
+		"createdAt":  token.CreatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const SAMLRequestTTL = 10 * time.Minute
+
+// SAMLConfig connects an Organisation to its own SAML identity provider.
+// When SSOEnforced is set, members whose email domain the organisation has
+// verified can no longer log in with a password.
+type SAMLConfig struct {
+	gorm.Model
+	OrganisationID uint `gorm:"uniqueIndex"`
+	Organisation   Organisation
+	IDPEntityID    string
+	IDPMetadata    string
+	SSOEnforced    bool
+}
+
+type SAMLConfigParams struct {
+	IDPMetadata string `json:"idpMetadata" validate:"required"`
+	SSOEnforced bool   `json:"ssoEnforced"`
+}
+
+// SAMLRequest tracks an authentication request sent to an organisation's IdP
+// until the response comes back to the ACS with the same relay state.
+type SAMLRequest struct {
+	gorm.Model
+	RelayState     string `gorm:"uniqueIndex"`
+	RequestID      string
+	OrganisationID uint
+	ExpiresAt      time.Time
+}
+
+func SAMLConfigResponse(config SAMLConfig) map[string]interface{} {
+	return map[string]interface{}{
+		"orgId":       fmt.Sprintf("%d", config.OrganisationID),
+		"idpEntityId": config.IDPEntityID,
+		"ssoEnforced": config.SSOEnforced,
+		"updatedAt":   config.UpdatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,160 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	SCIMTokenPrefix = "scim_"
+
+	SCIMSchemaUser         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SCIMSchemaGroup        = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SCIMSchemaListResponse = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SCIMSchemaPatchOp      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SCIMSchemaError        = "urn:ietf:params:scim:api:messages:2.0:Error"
+
+	SCIMSchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SCIMSchemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+)
+
+// SCIMToken authenticates an organisation's identity provider to the SCIM
+// endpoints of that organisation.
+type SCIMToken struct {
+	gorm.Model
+	OrganisationID uint
+	Organisation   Organisation
+	Name           string
+	TokenHash      string `gorm:"uniqueIndex"`
+	CreatedByID    uint
+	LastUsedAt     *time.Time
+}
+
+type SCIMTokenCreateParams struct {
+	Name string `json:"name" validate:"required,min=1,max=64"`
+}
+
+// SCIMUser holds what an organisation's identity provider knows about a user
+// that has no place on User. Members are active, a SCIMUser without a
+// membership is a user the identity provider deactivated. Managed is set for
+// users the identity provider created, the only ones whose profile it may
+// change.
+type SCIMUser struct {
+	gorm.Model
+	OrganisationID uint `gorm:"uniqueIndex:idx_scim_users_org_user"`
+	UserID         uint `gorm:"uniqueIndex:idx_scim_users_org_user"`
+	User           User
+	ExternalID     string
+	UserName       string
+	Managed        bool
+}
+
+// SCIMGroup is a group pushed by an organisation's identity provider. Its
+// members are always members of the organisation.
+type SCIMGroup struct {
+	gorm.Model
+	OrganisationID uint
+	DisplayName    string
+	ExternalID     string
+	Members        []User `gorm:"many2many:scim_group_members"`
+}
+
+type SCIMMeta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created"`
+	LastModified string `json:"lastModified"`
+	Location     string `json:"location"`
+}
+
+type SCIMName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type SCIMMultiValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// SCIMUserResource is the SCIM representation of a User (RFC 7643 4.1).
+type SCIMUserResource struct {
+	Schemas      []string         `json:"schemas"`
+	ID           string           `json:"id,omitempty"`
+	ExternalID   string           `json:"externalId,omitempty"`
+	UserName     string           `json:"userName"`
+	Name         *SCIMName        `json:"name,omitempty"`
+	DisplayName  string           `json:"displayName,omitempty"`
+	Emails       []SCIMMultiValue `json:"emails,omitempty"`
+	PhoneNumbers []SCIMMultiValue `json:"phoneNumbers,omitempty"`
+	Active       *bool            `json:"active,omitempty"`
+	Password     string           `json:"password,omitempty"`
+	Groups       []SCIMMultiValue `json:"groups,omitempty"`
+	Meta         *SCIMMeta        `json:"meta,omitempty"`
+}
+
+// SCIMGroupResource is the SCIM representation of a SCIMGroup (RFC 7643 4.2).
+type SCIMGroupResource struct {
+	Schemas     []string         `json:"schemas"`
+	ID          string           `json:"id,omitempty"`
+	ExternalID  string           `json:"externalId,omitempty"`
+	DisplayName string           `json:"displayName"`
+	Members     []SCIMMultiValue `json:"members,omitempty"`
+	Meta        *SCIMMeta        `json:"meta,omitempty"`
+}
+
+type SCIMPatchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+type SCIMPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []SCIMPatchOperation `json:"Operations"`
+}
+
+type SCIMListResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int64         `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+// PrimaryEmail returns the primary email, or the first one.
+func (r SCIMUserResource) PrimaryEmail() string {
+	for _, email := range r.Emails {
+		if email.Primary {
+			return email.Value
+		}
+	}
+	if len(r.Emails) > 0 {
+		return r.Emails[0].Value
+	}
+	return ""
+}
+
+func SCIMTokensResponse(tokens []SCIMToken) []map[string]interface{} {
+	res := []map[string]interface{}{}
+
+	for _, token := range tokens {
+		res = append(res, SCIMTokenResponse(token))
+	}
+
+	return res
+}
+
+func SCIMTokenResponse(token SCIMToken) map[string]interface{} {
+	return map[string]interface{}{
+		"tokenId":    fmt.Sprintf("%d", token.ID),
+		"orgId":      fmt.Sprintf("%d", token.OrganisationID),
+		"name":       token.Name,
+		"lastUsedAt": formatTime(token.LastUsedAt),
+		"createdAt":  token.CreatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,6 @@
+package models
+
+type SearchParams struct {
+	Q     string `form:"q" json:"q" validate:"required,max=100"`
+	Limit int    `form:"limit" json:"limit" validate:"omitempty,min=1,max=50"`
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const APIKeyPrefix = "sak_"
+
+// Roles a service account can hold in its organisation.
+const (
+	RoleMember = "member"
+	RoleAdmin  = "admin"
+)
+
+type ServiceAccount struct {
+	gorm.Model
+	OrganisationID uint
+	Organisation   Organisation
+	Name           string
+	Role           string
+	CreatedByID    uint
+	CreatedBy      User `gorm:"foreignKey:CreatedByID"`
+	APIKeys        []APIKey
+}
+
+type APIKey struct {
+	gorm.Model
+	ServiceAccountID uint
+	ServiceAccount   ServiceAccount
+	KeyHash          string `gorm:"uniqueIndex"`
+	ExpiresAt        *time.Time
+	RevokedAt        *time.Time
+	LastUsedAt       *time.Time
+}
+
+type ServiceAccountCreateParams struct {
+	Name string `json:"name" validate:"required,min=1,max=64"`
+	Role string `json:"role" validate:"required,oneof=member admin"`
+}
+
+type APIKeyCreateParams struct {
+	ExpiresInDays int `json:"expiresInDays" validate:"omitempty,min=1,max=365"`
+}
+
+// ScopesForRole maps a service account role onto the scopes checked by the
+// API routes.
+func ScopesForRole(role string) []string {
+	switch role {
+	case RoleAdmin:
+		return []string{ScopeOrgsRead, ScopeOrgsWrite, ScopeUsersRead}
+	case RoleMember:
+		return []string{ScopeOrgsRead, ScopeUsersRead}
+	default:
+		return []string{}
+	}
+}
+
+func (k APIKey) IsActive() bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || time.Now().Before(*k.ExpiresAt)
+}
+
+func ServiceAccountsResponse(accounts []ServiceAccount) []map[string]interface{} {
+	res := []map[string]interface{}{}
+
+	for _, account := range accounts {
+		res = append(res, ServiceAccountResponse(account))
+	}
+
+	return res
+}
+
+func ServiceAccountResponse(account ServiceAccount) map[string]interface{} {
+	return map[string]interface{}{
+		"serviceAccountId": fmt.Sprintf("%d", account.ID),
+		"orgId":            fmt.Sprintf("%d", account.OrganisationID),
+		"name":             account.Name,
+		"role":             account.Role,
+		"keys":             APIKeysResponse(account.APIKeys),
+	}
+}
+
+func APIKeysResponse(keys []APIKey) []map[string]interface{} {
+	res := []map[string]interface{}{}
+
+	for _, key := range keys {
+		res = append(res, APIKeyResponse(key))
+	}
+
+	return res
+}
+
+func APIKeyResponse(key APIKey) map[string]interface{} {
+	return map[string]interface{}{
+		"keyId":      fmt.Sprintf("%d", key.ID),
+		"active":     key.IsActive(),
+		"expiresAt":  formatTime(key.ExpiresAt),
+		"revokedAt":  formatTime(key.RevokedAt),
+		"lastUsedAt": formatTime(key.LastUsedAt),
+		"createdAt":  key.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func formatTime(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.Format(time.RFC3339)
+}
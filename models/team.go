@@ -0,0 +1,65 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Team is a group of members of an Organisation, optionally nested under a
+// parent team. A team grants its Role in the organisation to its members and
+// to the members of the teams nested under it.
+type Team struct {
+	gorm.Model
+	OrganisationID uint
+	ParentID       *uint
+	Name           string
+	Description    string
+	Role           string
+	Users          []User `gorm:"many2many:team_members"`
+}
+
+type TeamCreateParams struct {
+	Name        string `json:"name" validate:"required,min=1,max=64"`
+	Description string `json:"description" validate:"omitempty,max=255"`
+	ParentID    string `json:"parentId"`
+	Role        string `json:"role" validate:"omitempty,oneof=member admin"`
+}
+
+// TeamUpdateParams changes the fields that are set. An empty ParentID moves
+// the team to the top of the tree.
+type TeamUpdateParams struct {
+	Name        *string `json:"name" validate:"omitnil,min=1,max=64"`
+	Description *string `json:"description" validate:"omitnil,max=255"`
+	ParentID    *string `json:"parentId"`
+	Role        *string `json:"role" validate:"omitnil,oneof=member admin"`
+}
+
+func TeamsResponse(teams []Team) []map[string]interface{} {
+	res := []map[string]interface{}{}
+
+	for _, team := range teams {
+		res = append(res, TeamResponse(team))
+	}
+
+	return res
+}
+
+func TeamResponse(team Team) map[string]interface{} {
+	var parentId *string
+	if team.ParentID != nil {
+		id := fmt.Sprintf("%d", *team.ParentID)
+		parentId = &id
+	}
+
+	return map[string]interface{}{
+		"teamId":      fmt.Sprintf("%d", team.ID),
+		"orgId":       fmt.Sprintf("%d", team.OrganisationID),
+		"parentId":    parentId,
+		"name":        team.Name,
+		"description": team.Description,
+		"role":        team.Role,
+		"createdAt":   team.CreatedAt.Format(time.RFC3339),
+	}
+}
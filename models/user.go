@@ -2,27 +2,34 @@ package models
 
 import (
 	"fmt"
+	"time"
 
 	"gorm.io/gorm"
 )
 
 type User struct {
 	gorm.Model
-	FirstName            string
-	LastName             string
-	Email                string `gorm:"unique"`
-	Password             string `json:"-"`
-	Phone                string
-	CreatedOrganisations []Organisation `gorm:"foreignKey:CreatedByID"`
-	Organisations        []Organisation `gorm:"many2many:users_organisations"`
+	FirstName             string
+	LastName              string
+	Email                 string `gorm:"unique"`
+	Password              string `json:"-"`
+	Phone                 string
+	EmailVerifiedAt       *time.Time
+	IsAdmin               bool
+	DisabledAt            *time.Time
+	PasswordResetRequired bool
+	SessionVersion        uint
+	CreatedOrganisations  []Organisation `gorm:"foreignKey:CreatedByID"`
+	Organisations         []Organisation `gorm:"many2many:users_organisations"`
 }
 
 type UserRegisterParams struct {
-	FirstName string `json:"firstName" validate:"required,min=1,max=64"`
-	LastName  string `json:"lastName" validate:"required,min=1,max=64"`
-	Email     string `json:"email" validate:"required,email"`
-	Password  string `json:"password" validate:"required,min=1,max=64"`
-	Phone     string `json:"phone" validate:"required,min=1"`
+	FirstName       string `json:"firstName" validate:"required,min=1,max=64"`
+	LastName        string `json:"lastName" validate:"required,min=1,max=64"`
+	Email           string `json:"email" validate:"required,email"`
+	Password        string `json:"password" validate:"required,min=1,max=64"`
+	Phone           string `json:"phone" validate:"required,min=1"`
+	InvitationToken string `json:"invitationToken"`
 }
 
 type UserLoginParams struct {
@@ -30,6 +37,20 @@ type UserLoginParams struct {
 	Password string `json:"password" validate:"required,min=1,max=64"`
 }
 
+type UserChangePasswordParams struct {
+	Email       string `json:"email" validate:"required,email"`
+	Password    string `json:"password" validate:"required,min=1,max=64"`
+	NewPassword string `json:"newPassword" validate:"required,min=1,max=64,nefield=Password"`
+}
+
+func (u User) IsDisabled() bool {
+	return u.DisabledAt != nil
+}
+
+func (u User) IsEmailVerified() bool {
+	return u.EmailVerifiedAt != nil
+}
+
 func UserResponse(user User) map[string]string {
 	return map[string]string{
 		"userId":    fmt.Sprintf("%d", user.ID),
@@ -39,3 +60,29 @@ func UserResponse(user User) map[string]string {
 		"phone":     user.Phone,
 	}
 }
+
+func AdminUsersResponse(users []User) []map[string]interface{} {
+	res := []map[string]interface{}{}
+
+	for _, user := range users {
+		res = append(res, AdminUserResponse(user))
+	}
+
+	return res
+}
+
+// AdminUserResponse extends UserResponse with the account state shown to
+// platform administrators.
+func AdminUserResponse(user User) map[string]interface{} {
+	res := map[string]interface{}{
+		"isAdmin":               user.IsAdmin,
+		"disabledAt":            formatTime(user.DisabledAt),
+		"passwordResetRequired": user.PasswordResetRequired,
+		"createdAt":             user.CreatedAt.Format(time.RFC3339),
+	}
+	for k, v := range UserResponse(user) {
+		res[k] = v
+	}
+
+	return res
+}
@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UserIdentity links a User to their account at a social login provider.
+type UserIdentity struct {
+	gorm.Model
+	UserID   uint
+	User     User
+	Provider string `gorm:"uniqueIndex:idx_user_identities_provider_subject"`
+	Subject  string `gorm:"uniqueIndex:idx_user_identities_provider_subject"`
+	Email    string
+}
+
+// OIDCLoginState holds the state, nonce and PKCE verifier of a social login
+// between the redirect to the provider and the callback, along with the
+// invitation a new user registers with.
+type OIDCLoginState struct {
+	gorm.Model
+	State           string `gorm:"uniqueIndex"`
+	Provider        string
+	Nonce           string
+	CodeVerifier    string
+	InvitationToken string
+	ExpiresAt       time.Time
+}
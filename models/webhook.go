@@ -0,0 +1,159 @@
+package models
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	WebhookSecretPrefix = "whsec_"
+	// WebhookMaxAttempts is how many times a delivery is attempted before it
+	// is given up on.
+	WebhookMaxAttempts = 8
+)
+
+// Events published through the outbox, which webhooks subscribe to. Apart
+// from user.registered they are named after the audit action they are
+// published for.
+const (
+	WebhookUserRegistered      = "user.registered"
+	WebhookOrganisationCreated = AuditOrganisationCreated
+	WebhookMemberAdded         = AuditMemberAdded
+	WebhookMemberUpdated       = AuditMemberUpdated
+	WebhookMemberRemoved       = AuditMemberRemoved
+)
+
+var WebhookEvents = []string{WebhookUserRegistered, WebhookOrganisationCreated, WebhookMemberAdded, WebhookMemberUpdated, WebhookMemberRemoved}
+
+// The status of a webhook delivery.
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// Webhook is an endpoint of an organisation notified of the Events it
+// subscribes to, with requests signed with its Secret.
+type Webhook struct {
+	gorm.Model
+	OrganisationID uint `gorm:"index"`
+	URL            string
+	Description    string
+	// Events is space separated.
+	Events      string
+	Secret      string
+	Active      bool
+	CreatedByID *uint
+}
+
+func (w Webhook) EventList() []string {
+	return strings.Fields(w.Events)
+}
+
+func (w Webhook) SubscribesTo(event string) bool {
+	return slices.Contains(w.EventList(), event)
+}
+
+// WebhookDelivery is one event sent, or to be sent, to a webhook. Replaying a
+// delivery sends its event again as a new delivery, with the same EventID.
+type WebhookDelivery struct {
+	ID             uint `gorm:"primarykey"`
+	CreatedAt      time.Time
+	UpdatedAt      time.Time
+	WebhookID      uint `gorm:"index"`
+	Webhook        Webhook
+	EventID        string `gorm:"index"`
+	Event          string
+	Payload        string
+	Status         string `gorm:"index"`
+	Attempts       int
+	NextAttemptAt  *time.Time `gorm:"index"`
+	LastAttemptAt  *time.Time
+	ResponseStatus int
+	ResponseBody   string
+	Error          string
+	ReplayOfID     *uint
+}
+
+type WebhookCreateParams struct {
+	URL         string   `json:"url" validate:"required,http_url,max=2048"`
+	Description string   `json:"description" validate:"omitempty,max=255"`
+	Events      []string `json:"events" validate:"required,min=1,dive,oneof=user.registered organisation.created member.added member.updated member.removed"`
+}
+
+// WebhookUpdateParams changes the fields that are set.
+type WebhookUpdateParams struct {
+	URL         *string   `json:"url" validate:"omitnil,http_url,max=2048"`
+	Description *string   `json:"description" validate:"omitnil,max=255"`
+	Events      *[]string `json:"events" validate:"omitnil,min=1,dive,oneof=user.registered organisation.created member.added member.updated member.removed"`
+	Active      *bool     `json:"active"`
+}
+
+func WebhooksResponse(webhooks []Webhook) []map[string]interface{} {
+	res := []map[string]interface{}{}
+
+	for _, webhook := range webhooks {
+		res = append(res, WebhookResponse(webhook))
+	}
+
+	return res
+}
+
+func WebhookResponse(webhook Webhook) map[string]interface{} {
+	return map[string]interface{}{
+		"webhookId":   fmt.Sprintf("%d", webhook.ID),
+		"orgId":       fmt.Sprintf("%d", webhook.OrganisationID),
+		"url":         webhook.URL,
+		"description": webhook.Description,
+		"events":      webhook.EventList(),
+		"active":      webhook.Active,
+		"createdAt":   webhook.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+func WebhookDeliveriesResponse(deliveries []WebhookDelivery) []map[string]interface{} {
+	res := []map[string]interface{}{}
+
+	for _, delivery := range deliveries {
+		res = append(res, WebhookDeliveryResponse(delivery))
+	}
+
+	return res
+}
+
+func WebhookDeliveryResponse(delivery WebhookDelivery) map[string]interface{} {
+	formatTime := func(t *time.Time) *string {
+		if t == nil {
+			return nil
+		}
+		s := t.Format(time.RFC3339)
+		return &s
+	}
+
+	var replayOf *string
+	if delivery.ReplayOfID != nil {
+		id := fmt.Sprintf("%d", *delivery.ReplayOfID)
+		replayOf = &id
+	}
+
+	return map[string]interface{}{
+		"deliveryId":     fmt.Sprintf("%d", delivery.ID),
+		"webhookId":      fmt.Sprintf("%d", delivery.WebhookID),
+		"eventId":        delivery.EventID,
+		"event":          delivery.Event,
+		"payload":        delivery.Payload,
+		"status":         delivery.Status,
+		"attempts":       delivery.Attempts,
+		"nextAttemptAt":  formatTime(delivery.NextAttemptAt),
+		"lastAttemptAt":  formatTime(delivery.LastAttemptAt),
+		"responseStatus": delivery.ResponseStatus,
+		"responseBody":   delivery.ResponseBody,
+		"error":          delivery.Error,
+		"replayOf":       replayOf,
+		"createdAt":      delivery.CreatedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/utils"
+	"gorm.io/gorm"
+)
+
+const defaultOutboxPollInterval = time.Second
+
+// relayOutbox starts publishing outbox events in the background, looking for
+// due ones every OUTBOX_POLL_INTERVAL, until ctx is done. Events go to
+// webhooks, notifications and the event streams, and to NATS and Kafka when
+// NATS_URL and KAFKA_REST_URL are set. Event streams are served from bus,
+// or from the bus of every instance with an EVENT_HUB of postgres, see
+// listenForEvents.
+func relayOutbox(ctx context.Context, wg *sync.WaitGroup, db *gorm.DB, bus *utils.EventBus) {
+	interval := defaultOutboxPollInterval
+	if v := os.Getenv("OUTBOX_POLL_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatal("invalid OUTBOX_POLL_INTERVAL:", v)
+		}
+		interval = d
+	}
+
+	sinks := []utils.OutboxSink{utils.WebhookSink{DB: db}, utils.NotificationSink{DB: db}}
+	if eventHub() == "postgres" {
+		sinks = append(sinks, utils.PostgresNotifySink{DB: db})
+	} else {
+		sinks = append(sinks, bus)
+	}
+	if v := os.Getenv("NATS_URL"); v != "" {
+		prefix := os.Getenv("NATS_SUBJECT_PREFIX")
+		if prefix == "" {
+			prefix = "events"
+		}
+		sinks = append(sinks, utils.NewNATSSink(v, prefix))
+	}
+	if v := os.Getenv("KAFKA_REST_URL"); v != "" {
+		topic := os.Getenv("KAFKA_TOPIC")
+		if topic == "" {
+			topic = "events"
+		}
+		sinks = append(sinks, utils.NewKafkaRESTSink(v, topic))
+	}
+
+	relay := utils.NewOutboxRelay(db, sinks...)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		relay.Run(ctx, interval)
+	}()
+}
+
+// listenForEvents publishes the events announced through Postgres to bus when
+// EVENT_HUB is postgres, so the event streams of every instance get them
+// whichever instance relays them. With the default, memory, streams only get
+// the events relayed by their own instance, which suits a single instance
+// running the worker.
+func listenForEvents(ctx context.Context, db *gorm.DB, bus *utils.EventBus) {
+	if eventHub() == "postgres" {
+		go utils.ListenForEvents(ctx, os.Getenv("PG_URL"), db, bus)
+	}
+}
+
+func eventHub() string {
+	switch v := os.Getenv("EVENT_HUB"); v {
+	case "", "memory":
+		return "memory"
+	case "postgres":
+		return v
+	default:
+		log.Fatal("invalid EVENT_HUB:", v)
+		return ""
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/controllers"
+	"github.com/codelikesuraj/hng11-task-two/middlewares"
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/codelikesuraj/hng11-task-two/utils"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// when the unversioned routes were deprecated in favour of /v1, and when
+// they will be removed
+var (
+	unversionedDeprecatedAt = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+	unversionedSunset       = time.Date(2027, time.April, 30, 0, 0, 0, 0, time.UTC)
+)
+
+// routeDependencies are what the controllers are built from, so that the
+// tests register the same routes as main with their own mailer and resolver
+type routeDependencies struct {
+	DB              *gorm.DB
+	Mailer          utils.Mailer
+	Registration    *controllers.RegistrationPipeline
+	SocialProviders map[string]utils.SocialProvider
+	Resolver        utils.TXTResolver
+	Bus             *utils.EventBus
+}
+
+// registerRoutes registers every HTTP route of the API on router
+func registerRoutes(router *gin.Engine, deps routeDependencies) {
+	OrganisationController := controllers.NewOrganisationController(deps.DB)
+	UserController := controllers.NewUserController(deps.DB, deps.Mailer, deps.Registration)
+	TokenController := controllers.NewTokenController(deps.DB)
+	ServiceAccountController := controllers.NewServiceAccountController(deps.DB)
+	AdminController := controllers.NewAdminController(deps.DB)
+	OIDCController := controllers.NewOIDCController(deps.DB, deps.SocialProviders, deps.Registration)
+	OAuthController := controllers.NewOAuthController(deps.DB)
+	SAMLController := controllers.NewSAMLController(deps.DB)
+	SCIMController := controllers.NewSCIMController(deps.DB)
+	DomainController := controllers.NewDomainController(deps.DB, deps.Resolver, deps.Mailer)
+	InvitationController := controllers.NewInvitationController(deps.DB, deps.Mailer)
+	SearchController := controllers.NewSearchController(deps.DB)
+	TeamController := controllers.NewTeamController(deps.DB)
+	AuditController := controllers.NewAuditController(deps.DB)
+	WebhookController := controllers.NewWebhookController(deps.DB)
+	NotificationController := controllers.NewNotificationController(deps.DB)
+	EventController := controllers.NewEventController(deps.DB, deps.Bus)
+	GraphQLController := controllers.NewGraphQLController(deps.DB)
+
+	router.GET("/", controllers.Home)
+	router.GET("/openapi.json", controllers.OpenAPI)
+	router.GET("/docs", controllers.Docs)
+	router.GET("/.well-known/openid-configuration", OAuthController.Discovery)
+	router.Group("/oauth").
+		GET("/jwks", OAuthController.JWKS).
+		POST("/token", OAuthController.Token).
+		POST("/introspect", OAuthController.Introspect).
+		POST("/revoke", OAuthController.Revoke)
+	router.Group("/oauth", middlewares.Auth(deps.DB)).
+		GET("/authorize", middlewares.RequireSession(), OAuthController.Authorize).
+		POST("/authorize", middlewares.RequireSession(), OAuthController.Consent).
+		GET("/userinfo", OAuthController.UserInfo)
+	router.Group("/saml/organisations/:orgId").
+		GET("/metadata", SAMLController.Metadata).
+		GET("/login", SAMLController.Login).
+		POST("/acs", SAMLController.ACS)
+	router.Group("/scim/v2/organisations/:orgId", middlewares.SCIMAuth(deps.DB)).
+		GET("/ServiceProviderConfig", SCIMController.ServiceProviderConfig).
+		GET("/ResourceTypes", SCIMController.ResourceTypes).
+		GET("/Users", SCIMController.GetUsers).
+		POST("/Users", SCIMController.CreateUser).
+		GET("/Users/:id", SCIMController.GetUser).
+		PUT("/Users/:id", SCIMController.ReplaceUser).
+		PATCH("/Users/:id", SCIMController.PatchUser).
+		DELETE("/Users/:id", SCIMController.DeleteUser).
+		GET("/Groups", SCIMController.GetGroups).
+		POST("/Groups", SCIMController.CreateGroup).
+		GET("/Groups/:id", SCIMController.GetGroup).
+		PUT("/Groups/:id", SCIMController.ReplaceGroup).
+		PATCH("/Groups/:id", SCIMController.PatchGroup).
+		DELETE("/Groups/:id", SCIMController.DeleteGroup)
+	// the GraphQL schema evolves by adding fields rather than by version
+	router.POST("/graphql", middlewares.Auth(deps.DB), GraphQLController.Serve)
+
+	// the routes of each version are served under /<version>, and those of v1
+	// at their unversioned paths too for the clients from before versioning
+	versions := utils.NewVersionedRouter()
+	v1 := versions.Version("v1")
+	v1.Group("/auth").
+		POST("/register", UserController.RegisterUser).
+		POST("/login", UserController.LoginUser).
+		POST("/change-password", UserController.ChangePassword).
+		GET("/oidc/:provider/login", OIDCController.Login).
+		GET("/oidc/:provider/callback", OIDCController.Callback).
+		GET("/verify-email", UserController.VerifyEmail)
+	v1.Group("/auth", middlewares.Auth(deps.DB), middlewares.RequireSession()).
+		POST("/verify-email", UserController.SendEmailVerification)
+	v1.Group("/domains").GET("/verify", DomainController.VerifyEmail)
+	v1.Group("/api", middlewares.Auth(deps.DB)).
+		GET("/users/:id", middlewares.RequireScope(models.ScopeUsersRead), UserController.GetUserById).
+		GET("/organisations/:orgId", middlewares.RequireScope(models.ScopeOrgsRead), OrganisationController.GetOrganisationById).
+		GET("/organisations", middlewares.RequireScope(models.ScopeOrgsRead), OrganisationController.GetAll).
+		GET("/search", middlewares.RequireScope(models.ScopeOrgsRead), SearchController.Search).
+		GET("/organisations/joinable", middlewares.RequireSession(), OrganisationController.GetJoinable).
+		POST("/organisations/:orgId/join", middlewares.RequireSession(), OrganisationController.Join).
+		POST("/organisations", middlewares.RequireScope(models.ScopeOrgsWrite), OrganisationController.Create).
+		POST("/organisations/:orgId/users", middlewares.RequireScope(models.ScopeOrgsWrite), OrganisationController.AddUser).
+		GET("/organisations/:orgId/users/:userId/teams", middlewares.RequireScope(models.ScopeOrgsRead), TeamController.GetUserTeams).
+		GET("/organisations/:orgId/teams", middlewares.RequireScope(models.ScopeOrgsRead), TeamController.GetAll).
+		POST("/organisations/:orgId/teams", middlewares.RequireScope(models.ScopeOrgsWrite), TeamController.Create).
+		GET("/organisations/:orgId/teams/:teamId", middlewares.RequireScope(models.ScopeOrgsRead), TeamController.Get).
+		PATCH("/organisations/:orgId/teams/:teamId", middlewares.RequireScope(models.ScopeOrgsWrite), TeamController.Update).
+		DELETE("/organisations/:orgId/teams/:teamId", middlewares.RequireScope(models.ScopeOrgsWrite), TeamController.Delete).
+		GET("/organisations/:orgId/teams/:teamId/members", middlewares.RequireScope(models.ScopeOrgsRead), TeamController.GetMembers).
+		POST("/organisations/:orgId/teams/:teamId/members", middlewares.RequireScope(models.ScopeOrgsWrite), TeamController.AddMember).
+		DELETE("/organisations/:orgId/teams/:teamId/members/:userId", middlewares.RequireScope(models.ScopeOrgsWrite), TeamController.RemoveMember).
+		GET("/organisations/:orgId/audit-log", middlewares.RequireScope(models.ScopeOrgsRead), AuditController.GetAll).
+		GET("/organisations/:orgId/audit-log/export", middlewares.RequireScope(models.ScopeOrgsRead), AuditController.Export).
+		GET("/organisations/:orgId/audit-log/verify", middlewares.RequireScope(models.ScopeOrgsRead), AuditController.Verify).
+		GET("/organisations/:orgId/webhooks", middlewares.RequireScope(models.ScopeOrgsRead), WebhookController.GetAll).
+		POST("/organisations/:orgId/webhooks", middlewares.RequireScope(models.ScopeOrgsWrite), WebhookController.Create).
+		GET("/organisations/:orgId/webhooks/:webhookId", middlewares.RequireScope(models.ScopeOrgsRead), WebhookController.Get).
+		PATCH("/organisations/:orgId/webhooks/:webhookId", middlewares.RequireScope(models.ScopeOrgsWrite), WebhookController.Update).
+		DELETE("/organisations/:orgId/webhooks/:webhookId", middlewares.RequireScope(models.ScopeOrgsWrite), WebhookController.Delete).
+		GET("/organisations/:orgId/webhooks/:webhookId/deliveries", middlewares.RequireScope(models.ScopeOrgsRead), WebhookController.GetDeliveries).
+		GET("/organisations/:orgId/webhooks/:webhookId/deliveries/:deliveryId", middlewares.RequireScope(models.ScopeOrgsRead), WebhookController.GetDelivery).
+		POST("/organisations/:orgId/webhooks/:webhookId/deliveries/:deliveryId/replay", middlewares.RequireScope(models.ScopeOrgsWrite), WebhookController.Replay).
+		GET("/tokens", middlewares.RequireSession(), TokenController.GetAll).
+		POST("/tokens", middlewares.RequireSession(), TokenController.Create).
+		DELETE("/tokens/:tokenId", middlewares.RequireSession(), TokenController.Delete).
+		GET("/organisations/:orgId/service-accounts", middlewares.RequireSession(), ServiceAccountController.GetAll).
+		POST("/organisations/:orgId/service-accounts", middlewares.RequireSession(), ServiceAccountController.Create).
+		DELETE("/organisations/:orgId/service-accounts/:accountId", middlewares.RequireSession(), ServiceAccountController.Delete).
+		POST("/organisations/:orgId/service-accounts/:accountId/keys", middlewares.RequireSession(), ServiceAccountController.CreateKey).
+		POST("/organisations/:orgId/service-accounts/:accountId/keys/:keyId/rotate", middlewares.RequireSession(), ServiceAccountController.RotateKey).
+		DELETE("/organisations/:orgId/service-accounts/:accountId/keys/:keyId", middlewares.RequireSession(), ServiceAccountController.RevokeKey).
+		GET("/organisations/:orgId/oauth-clients", middlewares.RequireSession(), OAuthController.GetClients).
+		POST("/organisations/:orgId/oauth-clients", middlewares.RequireSession(), OAuthController.CreateClient).
+		DELETE("/organisations/:orgId/oauth-clients/:clientId", middlewares.RequireSession(), OAuthController.DeleteClient).
+		GET("/notifications", middlewares.RequireSession(), NotificationController.GetAll).
+		POST("/notifications/read-all", middlewares.RequireSession(), NotificationController.MarkAllRead).
+		POST("/notifications/:notificationId/read", middlewares.RequireSession(), NotificationController.MarkRead).
+		GET("/notifications/preferences", middlewares.RequireSession(), NotificationController.GetPreferences).
+		PUT("/notifications/preferences/:event", middlewares.RequireSession(), NotificationController.UpdatePreference).
+		GET("/events/stream", middlewares.RequireSession(), EventController.Stream).
+		GET("/oauth/consents", middlewares.RequireSession(), OAuthController.GetConsents).
+		DELETE("/oauth/consents/:consentId", middlewares.RequireSession(), OAuthController.RevokeConsent).
+		GET("/organisations/:orgId/saml", middlewares.RequireSession(), SAMLController.GetConfig).
+		PUT("/organisations/:orgId/saml", middlewares.RequireSession(), SAMLController.UpdateConfig).
+		DELETE("/organisations/:orgId/saml", middlewares.RequireSession(), SAMLController.DeleteConfig).
+		GET("/organisations/:orgId/scim-tokens", middlewares.RequireSession(), SCIMController.GetTokens).
+		POST("/organisations/:orgId/scim-tokens", middlewares.RequireSession(), SCIMController.CreateToken).
+		DELETE("/organisations/:orgId/scim-tokens/:tokenId", middlewares.RequireSession(), SCIMController.DeleteToken).
+		GET("/organisations/:orgId/domains", middlewares.RequireSession(), DomainController.GetAll).
+		POST("/organisations/:orgId/domains", middlewares.RequireSession(), DomainController.Create).
+		PATCH("/organisations/:orgId/domains/:domainId", middlewares.RequireSession(), DomainController.Update).
+		DELETE("/organisations/:orgId/domains/:domainId", middlewares.RequireSession(), DomainController.Delete).
+		POST("/organisations/:orgId/domains/:domainId/verify", middlewares.RequireSession(), DomainController.Verify).
+		GET("/organisations/:orgId/invitations", middlewares.RequireSession(), InvitationController.GetAll).
+		POST("/organisations/:orgId/invitations", middlewares.RequireSession(), InvitationController.Create).
+		DELETE("/organisations/:orgId/invitations/:invitationId", middlewares.RequireSession(), InvitationController.Delete).
+		POST("/invitations/accept", middlewares.RequireSession(), InvitationController.Accept)
+	v1.Group("/admin", middlewares.Auth(deps.DB), middlewares.RequireSession(), middlewares.RequireAdmin()).
+		GET("/users", AdminController.GetUsers).
+		GET("/users/:id", AdminController.GetUser).
+		GET("/users/:id/organisations", AdminController.GetUserOrganisations).
+		POST("/users/:id/disable", AdminController.DisableUser).
+		POST("/users/:id/enable", AdminController.EnableUser).
+		POST("/users/:id/force-password-reset", AdminController.ForcePasswordReset)
+	versions.Mount(router)
+	v1.Register(router.Group("/", middlewares.Deprecated(unversionedDeprecatedAt, unversionedSunset, "/v1")))
+}
@@ -0,0 +1,211 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"gorm.io/gorm"
+)
+
+const auditVerifyBatchSize = 500
+
+var auditTriggers = []string{
+	`CREATE OR REPLACE FUNCTION audit_events_immutable() RETURNS trigger AS $$
+BEGIN
+	RAISE EXCEPTION 'audit events cannot be changed';
+END;
+$$ LANGUAGE plpgsql`,
+	"DROP TRIGGER IF EXISTS audit_events_immutable ON audit_events",
+	"CREATE TRIGGER audit_events_immutable BEFORE UPDATE OR DELETE ON audit_events FOR EACH ROW EXECUTE FUNCTION audit_events_immutable()",
+	"DROP TRIGGER IF EXISTS audit_events_immutable_truncate ON audit_events",
+	"CREATE TRIGGER audit_events_immutable_truncate BEFORE TRUNCATE ON audit_events FOR EACH STATEMENT EXECUTE FUNCTION audit_events_immutable()",
+	"DROP TRIGGER IF EXISTS audit_checkpoints_immutable ON audit_checkpoints",
+	"CREATE TRIGGER audit_checkpoints_immutable BEFORE UPDATE OR DELETE ON audit_checkpoints FOR EACH ROW EXECUTE FUNCTION audit_events_immutable()",
+	"DROP TRIGGER IF EXISTS audit_checkpoints_immutable_truncate ON audit_checkpoints",
+	"CREATE TRIGGER audit_checkpoints_immutable_truncate BEFORE TRUNCATE ON audit_checkpoints FOR EACH STATEMENT EXECUTE FUNCTION audit_events_immutable()",
+}
+
+// CreateAuditTriggers has Postgres refuse to change, delete or truncate
+// audit events and checkpoints, even outside of the models. Elsewhere only
+// the models refuse to.
+func CreateAuditTriggers(db *gorm.DB) error {
+	if db.Dialector.Name() != "postgres" {
+		return nil
+	}
+
+	for _, sql := range auditTriggers {
+		if err := db.Exec(sql).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// auditCheckpointKey returns the key checkpoints are signed with, from
+// AUDIT_CHECKPOINT_KEY or else JWT_SECRET.
+func auditCheckpointKey() []byte {
+	if key := os.Getenv("AUDIT_CHECKPOINT_KEY"); key != "" {
+		return []byte(key)
+	}
+	return []byte(os.Getenv("JWT_SECRET"))
+}
+
+// SignAuditCheckpoint returns the hex HMAC-SHA256 of the chain of the
+// organisation up to the event numbered sequence.
+func SignAuditCheckpoint(orgId uint, sequence uint64, hash string) string {
+	mac := hmac.New(sha256.New, auditCheckpointKey())
+	fmt.Fprintf(mac, "%d:%d:%s", orgId, sequence, hash)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func verifyAuditCheckpoint(checkpoint models.AuditCheckpoint) bool {
+	expected := SignAuditCheckpoint(checkpoint.OrganisationID, checkpoint.Sequence, checkpoint.Hash)
+	return hmac.Equal([]byte(expected), []byte(checkpoint.Signature))
+}
+
+// CreateAuditCheckpoints signs a checkpoint at the end of the chain of every
+// organisation with events since its last checkpoint, returning how many it
+// signed.
+func CreateAuditCheckpoints(db *gorm.DB) (int, error) {
+	var heads []struct {
+		OrganisationID uint
+		Sequence       uint64
+	}
+	err := db.Model(&models.AuditEvent{}).
+		Select("organisation_id, MAX(sequence) AS sequence").
+		Where("sequence > 0").
+		Group("organisation_id").
+		Having("MAX(sequence) > (?)", db.Model(&models.AuditCheckpoint{}).
+			Select("COALESCE(MAX(audit_checkpoints.sequence), 0)").
+			Where("audit_checkpoints.organisation_id = audit_events.organisation_id")).
+		Scan(&heads).Error
+	if err != nil {
+		return 0, err
+	}
+
+	for i, head := range heads {
+		var event models.AuditEvent
+		err := db.Where("organisation_id = ? AND sequence = ?", head.OrganisationID, head.Sequence).First(&event).Error
+		if err != nil {
+			return i, err
+		}
+
+		err = db.Create(&models.AuditCheckpoint{
+			OrganisationID: event.OrganisationID,
+			Sequence:       event.Sequence,
+			Hash:           event.Hash,
+			Signature:      SignAuditCheckpoint(event.OrganisationID, event.Sequence, event.Hash),
+		}).Error
+		if err != nil {
+			return i, err
+		}
+	}
+
+	return len(heads), nil
+}
+
+// AuditVerification is the outcome of walking the chain of an organisation.
+// BrokenAt is set unless the chain is intact.
+type AuditVerification struct {
+	Valid       bool        `json:"valid"`
+	Events      uint64      `json:"events"`
+	Checkpoints int         `json:"checkpoints"`
+	BrokenAt    *AuditBreak `json:"brokenAt,omitempty"`
+}
+
+// AuditBreak is the first broken link of a chain, at the event numbered
+// Sequence. EventID is empty when that event is missing.
+type AuditBreak struct {
+	Sequence     uint64 `json:"sequence"`
+	EventID      string `json:"eventId,omitempty"`
+	CheckpointID string `json:"checkpointId,omitempty"`
+	Reason       string `json:"reason"`
+}
+
+// VerifyAuditChain re-walks the chain of the organisation, recomputing the
+// hash of every event and checking it against the next event and against
+// the signed checkpoints. Events recorded before events were chained have no
+// sequence and are skipped.
+func VerifyAuditChain(db *gorm.DB, orgId uint) (AuditVerification, error) {
+	var verification AuditVerification
+
+	var checkpoints []models.AuditCheckpoint
+	if err := db.Where("organisation_id = ?", orgId).Order("sequence, id").Find(&checkpoints).Error; err != nil {
+		return verification, err
+	}
+	verification.Checkpoints = len(checkpoints)
+
+	broken := func(sequence uint64, event *models.AuditEvent, checkpoint *models.AuditCheckpoint, reason string) (AuditVerification, error) {
+		verification.BrokenAt = &AuditBreak{Sequence: sequence, Reason: reason}
+		if event != nil {
+			verification.BrokenAt.EventID = fmt.Sprintf("%d", event.ID)
+		}
+		if checkpoint != nil {
+			verification.BrokenAt.CheckpointID = fmt.Sprintf("%d", checkpoint.ID)
+		}
+		return verification, nil
+	}
+
+	for i := range checkpoints {
+		if !verifyAuditCheckpoint(checkpoints[i]) {
+			return broken(checkpoints[i].Sequence, nil, &checkpoints[i], "checkpoint signature is invalid")
+		}
+	}
+
+	prevHash := ""
+	next := 0 // the first checkpoint not reached yet
+	for {
+		var events []models.AuditEvent
+		err := db.Where("organisation_id = ? AND sequence > ?", orgId, verification.Events).
+			Order("sequence, id").
+			Limit(auditVerifyBatchSize).
+			Find(&events).Error
+		if err != nil {
+			return verification, err
+		}
+
+		for i := range events {
+			event := &events[i]
+			sequence := verification.Events + 1
+
+			if event.Sequence < sequence {
+				return broken(event.Sequence, event, nil, "event is duplicated")
+			}
+			if event.Sequence > sequence {
+				return broken(sequence, nil, nil, "event is missing")
+			}
+			if event.PrevHash != prevHash {
+				return broken(sequence, event, nil, "previous hash does not match the event before")
+			}
+			if event.ComputeHash() != event.Hash {
+				return broken(sequence, event, nil, "hash does not match the content of the event")
+			}
+
+			for ; next < len(checkpoints) && checkpoints[next].Sequence == sequence; next++ {
+				if checkpoints[next].Hash != event.Hash {
+					return broken(sequence, event, &checkpoints[next], "event differs from the checkpoint")
+				}
+			}
+
+			prevHash = event.Hash
+			verification.Events = sequence
+		}
+
+		if len(events) < auditVerifyBatchSize {
+			break
+		}
+	}
+
+	// any checkpoint left is past the end of the chain
+	if next < len(checkpoints) {
+		return broken(verification.Events+1, nil, &checkpoints[next], "event is missing")
+	}
+
+	verification.Valid = true
+	return verification, nil
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"strings"
+)
+
+// DomainAdminMailboxes are the mailboxes of a domain a verification email
+// can be sent to, the ones reserved for its administrators by RFC 2142.
+var DomainAdminMailboxes = []string{"admin", "administrator", "hostmaster", "postmaster", "webmaster"}
+
+// TXTResolver looks up DNS TXT records. It is satisfied by net.Resolver, and
+// replaced in tests.
+type TXTResolver interface {
+	LookupTXT(ctx context.Context, name string) ([]string, error)
+}
+
+// HasTXTRecord reports whether domain has a TXT record with the value record.
+func HasTXTRecord(ctx context.Context, resolver TXTResolver, domain, record string) (bool, error) {
+	records, err := resolver.LookupTXT(ctx, domain)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return slices.Contains(records, record), nil
+}
+
+// GetEmailDomain returns the lower case domain of email.
+func GetEmailDomain(email string) string {
+	_, domain, _ := strings.Cut(strings.ToLower(email), "@")
+	return domain
+}
+
+// IsDomainAdminEmail reports whether email is one of the admin mailboxes of
+// domain.
+func IsDomainAdminEmail(email, domain string) bool {
+	mailbox, emailDomain, _ := strings.Cut(strings.ToLower(email), "@")
+	return emailDomain == strings.ToLower(domain) && slices.Contains(DomainAdminMailboxes, mailbox)
+}
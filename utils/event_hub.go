@@ -0,0 +1,143 @@
+package utils
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/jackc/pgx/v5"
+	"gorm.io/gorm"
+)
+
+// EventsChannel is the Postgres channel outbox events are announced on to
+// the EventBus of every instance.
+const EventsChannel = "outbox_events"
+
+const eventListenRetry = 5 * time.Second
+
+// EventSubscription buffers the events published to an EventBus for a reader
+// that may fall behind. A subscription falling more than its buffer behind is
+// dropped rather than holding up the bus: Events is closed and Lagged
+// reports true.
+type EventSubscription struct {
+	events      chan models.OutboxEvent
+	unsubscribe func()
+
+	mu     sync.Mutex
+	closed bool
+	lagged bool
+}
+
+// SubscribeBuffered subscribes to the events published from now on, keeping
+// up to size of them until they are read.
+func (b *EventBus) SubscribeBuffered(size int) *EventSubscription {
+	s := &EventSubscription{events: make(chan models.OutboxEvent, size)}
+	s.unsubscribe = b.Subscribe(s.publish)
+	return s
+}
+
+func (s *EventSubscription) publish(event models.OutboxEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	select {
+	case s.events <- event:
+	default:
+		s.lagged = true
+		s.closed = true
+		close(s.events)
+		s.unsubscribe()
+	}
+}
+
+// Events is closed once the subscription is closed or dropped.
+func (s *EventSubscription) Events() <-chan models.OutboxEvent {
+	return s.events
+}
+
+// Lagged reports whether the subscription was dropped for falling behind.
+func (s *EventSubscription) Lagged() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lagged
+}
+
+func (s *EventSubscription) Close() {
+	s.unsubscribe()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.events)
+	}
+}
+
+// PostgresNotifySink announces outbox events on EventsChannel, for
+// ListenForEvents to publish them to the EventBus of every instance. Only
+// the EventID is sent, notifications being limited in size.
+type PostgresNotifySink struct {
+	DB *gorm.DB
+}
+
+func (s PostgresNotifySink) Name() string {
+	return "postgres"
+}
+
+func (s PostgresNotifySink) Publish(ctx context.Context, event models.OutboxEvent) error {
+	return s.DB.WithContext(ctx).Exec("SELECT pg_notify(?, ?)", EventsChannel, event.EventID).Error
+}
+
+// ListenForEvents publishes the outbox events announced on EventsChannel to
+// bus until ctx is done, listening on a connection of its own to the
+// database at dsn and reconnecting whenever it is lost. Events announced
+// while it is reconnecting are missed.
+func ListenForEvents(ctx context.Context, dsn string, db *gorm.DB, bus *EventBus) {
+	for {
+		if err := listenForEvents(ctx, dsn, db, bus); err != nil && ctx.Err() == nil {
+			log.Println("error listening for events:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(eventListenRetry):
+		}
+	}
+}
+
+func listenForEvents(ctx context.Context, dsn string, db *gorm.DB, bus *EventBus) error {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return err
+	}
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{EventsChannel}.Sanitize()); err != nil {
+		return err
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			return err
+		}
+
+		var event models.OutboxEvent
+		result := db.WithContext(ctx).Where("event_id = ?", notification.Payload).Limit(1).Find(&event)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected < 1 {
+			continue
+		}
+
+		bus.Publish(ctx, event)
+	}
+}
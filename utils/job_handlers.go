@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"context"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"gorm.io/gorm"
+)
+
+type EmailJob struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+// QueuedMailer sends emails from a job, retried until the SMTP server takes
+// them, instead of while the request waits.
+type QueuedMailer struct {
+	DB *gorm.DB
+}
+
+func NewQueuedMailer(db *gorm.DB) *QueuedMailer {
+	return &QueuedMailer{DB: db}
+}
+
+func (m *QueuedMailer) Send(to, subject, body string) error {
+	_, err := EnqueueJob(m.DB, models.JobSendEmail, EmailJob{To: to, Subject: subject, Body: body}, JobOptions{})
+	return err
+}
+
+// SendEmailJob sends the emails queued by a QueuedMailer with mailer.
+func SendEmailJob(mailer Mailer) JobHandler {
+	return JobFunc(func(ctx context.Context, email EmailJob) error {
+		return mailer.Send(email.To, email.Subject, email.Body)
+	})
+}
+
+// PurgeOrganisationsJob purges the organisations deleted more than retention
+// ago.
+func PurgeOrganisationsJob(db *gorm.DB, retention time.Duration) JobHandler {
+	return func(ctx context.Context, job models.Job) error {
+		_, err := PurgeOrganisations(db.WithContext(ctx), time.Now().Add(-retention))
+		return err
+	}
+}
+
+// PurgeOrganisations deletes the organisations soft deleted before the time
+// for good, with their members, teams, invitations and everything else they
+// own, returning how many it deleted. Audit logs are kept, they cannot be
+// deleted.
+func PurgeOrganisations(db *gorm.DB, before time.Time) (int, error) {
+	var orgIds []uint
+	err := db.Unscoped().Model(&models.Organisation{}).
+		Where("deleted_at IS NOT NULL AND deleted_at < ?", before).
+		Pluck("id", &orgIds).Error
+	if err != nil {
+		return 0, err
+	}
+
+	for _, orgId := range orgIds {
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return purgeOrganisation(tx, orgId)
+		}); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(orgIds), nil
+}
+
+func purgeOrganisation(tx *gorm.DB, orgId uint) error {
+	tx = tx.Unscoped().Session(&gorm.Session{})
+
+	clients := tx.Model(&models.OAuthClient{}).Select("id").Where("organisation_id = ?", orgId)
+	serviceAccounts := tx.Model(&models.ServiceAccount{}).Select("id").Where("organisation_id = ?", orgId)
+	teams := tx.Model(&models.Team{}).Select("id").Where("organisation_id = ?", orgId)
+	scimGroups := tx.Model(&models.SCIMGroup{}).Select("id").Where("organisation_id = ?", orgId)
+	webhooks := tx.Model(&models.Webhook{}).Select("id").Where("organisation_id = ?", orgId)
+	del := func(model interface{}, query string, args ...interface{}) func() error {
+		return func() error { return tx.Where(query, args...).Delete(model).Error }
+	}
+	exec := func(sql string, args ...interface{}) func() error {
+		return func() error { return tx.Exec(sql, args...).Error }
+	}
+
+	// dependents first, for the foreign keys
+	steps := []func() error{
+		del(&models.OAuthToken{}, "client_id IN (?)", clients),
+		del(&models.OAuthAuthorizationCode{}, "client_id IN (?)", clients),
+		del(&models.OAuthConsent{}, "client_id IN (?)", clients),
+		del(&models.OAuthClient{}, "organisation_id = ?", orgId),
+		del(&models.APIKey{}, "service_account_id IN (?)", serviceAccounts),
+		del(&models.ServiceAccount{}, "organisation_id = ?", orgId),
+		exec("DELETE FROM team_members WHERE team_id IN (?)", teams),
+		del(&models.Team{}, "organisation_id = ?", orgId),
+		exec("DELETE FROM scim_group_members WHERE scim_group_id IN (?)", scimGroups),
+		del(&models.SCIMGroup{}, "organisation_id = ?", orgId),
+		del(&models.SCIMUser{}, "organisation_id = ?", orgId),
+		del(&models.SCIMToken{}, "organisation_id = ?", orgId),
+		del(&models.SAMLRequest{}, "organisation_id = ?", orgId),
+		del(&models.SAMLConfig{}, "organisation_id = ?", orgId),
+		del(&models.OrganisationDomain{}, "organisation_id = ?", orgId),
+		del(&models.Invitation{}, "organisation_id = ?", orgId),
+		del(&models.WebhookDelivery{}, "webhook_id IN (?)", webhooks),
+		del(&models.Webhook{}, "organisation_id = ?", orgId),
+		del(&models.OutboxEvent{}, "organisation_id = ?", orgId),
+		exec("DELETE FROM users_organisations WHERE organisation_id = ?", orgId),
+		del(&models.Organisation{}, "id = ?", orgId),
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ExpireInvitationsJob deletes the invitations that expired unaccepted.
+func ExpireInvitationsJob(db *gorm.DB) JobHandler {
+	return func(ctx context.Context, job models.Job) error {
+		return db.WithContext(ctx).
+			Where("accepted_at IS NULL AND expires_at < ?", time.Now()).
+			Delete(&models.Invitation{}).Error
+	}
+}
+
+// CheckpointAuditLogsJob signs a checkpoint of every audit log that changed.
+func CheckpointAuditLogsJob(db *gorm.DB) JobHandler {
+	return func(ctx context.Context, job models.Job) error {
+		_, err := CreateAuditCheckpoints(db.WithContext(ctx))
+		return err
+	}
+}
+
+// PruneJobsJob deletes the jobs that succeeded more than retention ago. Dead
+// jobs are kept until requeued.
+func PruneJobsJob(db *gorm.DB, retention time.Duration) JobHandler {
+	return func(ctx context.Context, job models.Job) error {
+		return db.WithContext(ctx).
+			Where("status = ? AND finished_at < ?", models.JobSucceeded, time.Now().Add(-retention)).
+			Delete(&models.Job{}).Error
+	}
+}
@@ -0,0 +1,327 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const jobMaxBackoff = time.Hour
+
+var (
+	// JobBackoff is how long after its first failed attempt a job is run
+	// again, doubling after every further failure.
+	JobBackoff = 10 * time.Second
+	// JobTimeout is how long a job may run. A job still running after it,
+	// its worker gone, is run again.
+	JobTimeout = 15 * time.Minute
+)
+
+// JobHandler does the work of jobs of a kind. A job whose handler returns an
+// error, or panics, is retried, so handlers should be safe to run again.
+type JobHandler func(ctx context.Context, job models.Job) error
+
+// JobFunc returns the handler of jobs whose payload is a T, decoding it for
+// fn.
+func JobFunc[T any](fn func(ctx context.Context, payload T) error) JobHandler {
+	return func(ctx context.Context, job models.Job) error {
+		var payload T
+		if err := json.Unmarshal([]byte(job.Payload), &payload); err != nil {
+			return fmt.Errorf("invalid payload: %w", err)
+		}
+		return fn(ctx, payload)
+	}
+}
+
+type JobOptions struct {
+	// RunAt is when the job is run at the earliest, now if zero.
+	RunAt time.Time
+	// MaxAttempts is how many times the job is attempted before it is dead,
+	// models.JobDefaultMaxAttempts if zero.
+	MaxAttempts int
+	// UniqueKey, if set, keeps the job from being queued twice.
+	UniqueKey string
+}
+
+// EnqueueJob queues a job of the kind with the payload encoded as JSON.
+// Queued with db in a transaction, the job only runs if the transaction
+// commits. A job whose UniqueKey was queued already is not queued again,
+// and the returned job has no ID.
+func EnqueueJob(db *gorm.DB, kind string, payload interface{}, opts JobOptions) (models.Job, error) {
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return models.Job{}, err
+	}
+
+	job := models.Job{
+		Kind:        kind,
+		Payload:     string(b),
+		Status:      models.JobQueued,
+		RunAt:       opts.RunAt,
+		MaxAttempts: opts.MaxAttempts,
+	}
+	if job.RunAt.IsZero() {
+		job.RunAt = time.Now()
+	}
+	if job.MaxAttempts < 1 {
+		job.MaxAttempts = models.JobDefaultMaxAttempts
+	}
+	if opts.UniqueKey != "" {
+		job.UniqueKey = &opts.UniqueKey
+	}
+
+	err = db.Clauses(clause.OnConflict{DoNothing: true}).Create(&job).Error
+	return job, err
+}
+
+// RequeueJobs queues the dead jobs with the IDs, or all of them, again, for
+// as many attempts as before. It returns how many it requeued.
+func RequeueJobs(db *gorm.DB, ids ...uint) (int64, error) {
+	query := db.Model(&models.Job{}).Where("status = ?", models.JobDead)
+	if len(ids) > 0 {
+		query = query.Where("id IN ?", ids)
+	}
+
+	result := query.Updates(map[string]interface{}{
+		"status":      models.JobQueued,
+		"run_at":      time.Now(),
+		"attempts":    0,
+		"finished_at": nil,
+	})
+	return result.RowsAffected, result.Error
+}
+
+// JobRunner runs queued jobs with the handlers of their kind, and queues
+// scheduled jobs when they are due. Any number of runners, in any number of
+// processes, can share the queue: jobs are claimed with SELECT ... FOR
+// UPDATE SKIP LOCKED and scheduled jobs are unique per time.
+type JobRunner struct {
+	DB *gorm.DB
+	// Name identifies the runner in the jobs it claims.
+	Name string
+	// Concurrency is how many jobs the runner runs at once.
+	Concurrency int
+
+	handlers  map[string]JobHandler
+	kinds     []string
+	mu        sync.Mutex
+	schedules []*scheduledJob
+}
+
+type scheduledJob struct {
+	schedule Schedule
+	kind     string
+	payload  interface{}
+	next     time.Time
+}
+
+func NewJobRunner(db *gorm.DB, concurrency int) *JobRunner {
+	host, _ := os.Hostname()
+	return &JobRunner{
+		DB:          db,
+		Name:        fmt.Sprintf("%s:%d", host, os.Getpid()),
+		Concurrency: max(concurrency, 1),
+		handlers:    map[string]JobHandler{},
+	}
+}
+
+// Handle runs jobs of the kind with handler. Runners only claim jobs of the
+// kinds they handle.
+func (r *JobRunner) Handle(kind string, handler JobHandler) {
+	if _, ok := r.handlers[kind]; !ok {
+		r.kinds = append(r.kinds, kind)
+	}
+	r.handlers[kind] = handler
+}
+
+// Schedule queues a job of the kind with the payload at every time of the
+// schedule, see ParseSchedule.
+func (r *JobRunner) Schedule(spec, kind string, payload interface{}) error {
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.schedules = append(r.schedules, &scheduledJob{schedule: schedule, kind: kind, payload: payload})
+	return nil
+}
+
+// Run runs jobs until ctx is done, looking for queued ones every interval
+// when there are none.
+func (r *JobRunner) Run(ctx context.Context, interval time.Duration) {
+	var wg sync.WaitGroup
+
+	for i := 0; i < r.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				ran, err := r.RunNext(ctx)
+				if err != nil {
+					log.Println("error running job:", err)
+				}
+				if ran && err == nil {
+					continue
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(interval):
+				}
+			}
+		}()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := r.EnqueueScheduled(time.Now()); err != nil {
+			log.Println("error queueing scheduled jobs:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// EnqueueScheduled queues the scheduled jobs whose time came by now,
+// returning how many it queued. A time is queued once, whichever runner
+// gets to it, and a job is queued only once for times missed while no
+// runner ran.
+func (r *JobRunner) EnqueueScheduled(now time.Time) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	queued := 0
+	for _, s := range r.schedules {
+		if s.next.IsZero() {
+			s.next = s.schedule.Next(now)
+			continue
+		}
+		if now.Before(s.next) {
+			continue
+		}
+
+		job, err := EnqueueJob(r.DB, s.kind, s.payload, JobOptions{
+			RunAt:     s.next,
+			UniqueKey: s.kind + "@" + s.next.UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			return queued, err
+		}
+		if job.ID != 0 {
+			queued++
+		}
+		s.next = s.schedule.Next(now)
+	}
+
+	return queued, nil
+}
+
+// RunNext claims the next job that is due and runs it, reporting whether
+// there was one.
+func (r *JobRunner) RunNext(ctx context.Context) (bool, error) {
+	job, ok, err := r.claim()
+	if err != nil || !ok {
+		return false, err
+	}
+
+	runErr := r.run(ctx, job)
+
+	now := time.Now()
+	values := map[string]interface{}{"locked_at": nil, "locked_by": ""}
+	switch {
+	case runErr == nil:
+		values["status"] = models.JobSucceeded
+		values["finished_at"] = now
+		values["last_error"] = ""
+	case job.Attempts >= job.MaxAttempts:
+		values["status"] = models.JobDead
+		values["finished_at"] = now
+		values["last_error"] = runErr.Error()
+	default:
+		values["status"] = models.JobQueued
+		values["run_at"] = now.Add(retryDelay(JobBackoff, jobMaxBackoff, job.Attempts))
+		values["last_error"] = runErr.Error()
+	}
+
+	// the job may have timed out and been claimed again meanwhile
+	err = r.DB.Model(&models.Job{}).
+		Where("id = ? AND status = ? AND locked_by = ? AND attempts = ?", job.ID, models.JobRunning, r.Name, job.Attempts).
+		Updates(values).Error
+	return true, err
+}
+
+func (r *JobRunner) claim() (models.Job, bool, error) {
+	var job models.Job
+	if len(r.kinds) == 0 {
+		return job, false, nil
+	}
+
+	claimed := false
+	err := r.DB.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		result := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("kind IN ?", r.kinds).
+			Where("(status = ? AND run_at <= ?) OR (status = ? AND locked_at < ?)", models.JobQueued, now, models.JobRunning, now.Add(-JobTimeout)).
+			Order("run_at, id").
+			Limit(1).
+			Find(&job)
+		if result.Error != nil || result.RowsAffected < 1 {
+			return result.Error
+		}
+
+		// without row locks, as in SQLite, another runner may have claimed
+		// the job since
+		result = tx.Model(&models.Job{}).
+			Where("id = ? AND status = ? AND attempts = ?", job.ID, job.Status, job.Attempts).
+			Updates(map[string]interface{}{
+				"status":    models.JobRunning,
+				"attempts":  job.Attempts + 1,
+				"locked_at": now,
+				"locked_by": r.Name,
+			})
+		if result.Error != nil || result.RowsAffected < 1 {
+			return result.Error
+		}
+
+		job.Status = models.JobRunning
+		job.Attempts++
+		job.LockedAt = &now
+		job.LockedBy = r.Name
+		claimed = true
+		return nil
+	})
+
+	return job, claimed && err == nil, err
+}
+
+func (r *JobRunner) run(ctx context.Context, job models.Job) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, JobTimeout)
+	defer cancel()
+
+	defer func() {
+		if v := recover(); v != nil {
+			log.Printf("job %d (%s) panicked: %v\n%s", job.ID, job.Kind, v, debug.Stack())
+			err = fmt.Errorf("panic: %v", v)
+		}
+	}()
+
+	return r.handlers[job.Kind](ctx, job)
+}
@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+)
+
+// Mailer sends plain text emails.
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// NewMailer returns a mailer sending through the SMTP server configured by
+// SMTP_HOST, SMTP_PORT, SMTP_USERNAME and SMTP_PASSWORD, from MAIL_FROM.
+// Without SMTP_HOST emails are only logged, which is enough in development.
+// Their bodies hold tokens, so they are only logged with MAIL_LOG_BODIES.
+func NewMailer() Mailer {
+	host := os.Getenv("SMTP_HOST")
+	if host == "" {
+		log.Println("SMTP_HOST is not set, emails will be logged instead of sent")
+		return logMailer{bodies: os.Getenv("MAIL_LOG_BODIES") == "true"}
+	}
+
+	port := os.Getenv("SMTP_PORT")
+	if port == "" {
+		port = "587"
+	}
+
+	var auth smtp.Auth
+	if username := os.Getenv("SMTP_USERNAME"); username != "" {
+		auth = smtp.PlainAuth("", username, os.Getenv("SMTP_PASSWORD"), host)
+	}
+
+	return &smtpMailer{
+		addr: net.JoinHostPort(host, port),
+		auth: auth,
+		from: os.Getenv("MAIL_FROM"),
+	}
+}
+
+type smtpMailer struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+func (m *smtpMailer) Send(to, subject, body string) error {
+	// the addresses end up in headers, so they must not be able to add any
+	if strings.ContainsAny(to+subject, "\r\n") {
+		return fmt.Errorf("invalid email header")
+	}
+
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n%s\r\n", m.from, to, subject, body)
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{to}, []byte(msg))
+}
+
+type logMailer struct {
+	bodies bool
+}
+
+func (m logMailer) Send(to, subject, body string) error {
+	if !m.bodies {
+		log.Printf("email to %s: %s", to, subject)
+		return nil
+	}
+	log.Printf("email to %s: %s\n%s", to, subject, body)
+	return nil
+}
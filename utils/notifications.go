@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NotificationSink notifies users of the events they are concerned with, as
+// their preferences allow: members of being added to, updated in or removed
+// from an organisation, and the creator of an organisation of it being
+// created and of users registering into it. Users are not notified of what
+// they did themselves. Emails are sent by the email job.
+type NotificationSink struct {
+	DB *gorm.DB
+}
+
+func (s NotificationSink) Name() string {
+	return "notifications"
+}
+
+// the part of the payload of outbox events notifications are made of
+type notificationPayload struct {
+	Data struct {
+		Actor *struct {
+			Type string  `json:"type"`
+			ID   *string `json:"id"`
+		} `json:"actor"`
+		Target *struct {
+			Type string `json:"type"`
+			ID   string `json:"id"`
+		} `json:"target"`
+		User *struct {
+			UserID    string `json:"userId"`
+			FirstName string `json:"firstName"`
+			LastName  string `json:"lastName"`
+		} `json:"user"`
+	} `json:"data"`
+}
+
+func (s NotificationSink) Publish(ctx context.Context, event models.OutboxEvent) error {
+	if !models.IsNotificationEvent(event.Event) {
+		return nil
+	}
+
+	var payload notificationPayload
+	if err := json.Unmarshal([]byte(event.Payload), &payload); err != nil {
+		return err
+	}
+
+	db := s.DB.WithContext(ctx)
+
+	var org models.Organisation
+	result := db.Unscoped().Limit(1).Find(&org, event.OrganisationID)
+	if result.Error != nil || result.RowsAffected < 1 {
+		// purged since
+		return result.Error
+	}
+
+	var recipient string
+	var title, body string
+	actor := s.actorName(db, payload)
+	switch event.Event {
+	case models.WebhookMemberAdded, models.WebhookMemberUpdated, models.WebhookMemberRemoved:
+		if payload.Data.Target == nil || payload.Data.Target.Type != models.AuditTargetUser {
+			return nil
+		}
+		recipient = payload.Data.Target.ID
+		switch event.Event {
+		case models.WebhookMemberAdded:
+			title, body = "You were added to "+org.Name, actor+" added you to "+org.Name+"."
+		case models.WebhookMemberUpdated:
+			title, body = "Your membership of "+org.Name+" was updated", actor+" updated your membership of "+org.Name+"."
+		default:
+			title, body = "You were removed from "+org.Name, actor+" removed you from "+org.Name+"."
+		}
+	case models.WebhookOrganisationCreated:
+		recipient = strconv.FormatUint(uint64(org.CreatedByID), 10)
+		title, body = org.Name+" was created", actor+" created "+org.Name+"."
+	case models.WebhookUserRegistered:
+		if payload.Data.User == nil || payload.Data.User.UserID == strconv.FormatUint(uint64(org.CreatedByID), 10) {
+			return nil
+		}
+		recipient = strconv.FormatUint(uint64(org.CreatedByID), 10)
+		name := payload.Data.User.FirstName + " " + payload.Data.User.LastName
+		title, body = name+" joined "+org.Name, name+" registered and joined "+org.Name+"."
+	}
+
+	if actor := payload.Data.Actor; actor != nil && actor.Type == models.AuditActorUser && actor.ID != nil && *actor.ID == recipient {
+		return nil
+	}
+
+	var user models.User
+	result = db.Limit(1).Find(&user, recipient)
+	if result.Error != nil || result.RowsAffected < 1 {
+		return result.Error
+	}
+
+	preference := models.DefaultNotificationPreference(user.ID, event.Event)
+	if err := db.Where("user_id = ? AND event = ?", user.ID, event.Event).Limit(1).Find(&preference).Error; err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if preference.Allows(models.NotificationInApp) {
+			// published again, the event was notified already
+			err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.Notification{
+				UserID:         user.ID,
+				EventID:        event.EventID,
+				Event:          event.Event,
+				OrganisationID: org.ID,
+				Title:          title,
+				Body:           body,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		if preference.Allows(models.NotificationEmail) {
+			_, err := EnqueueJob(tx, models.JobSendEmail, EmailJob{To: user.Email, Subject: title, Body: body}, JobOptions{
+				UniqueKey: "notification:" + event.EventID + ":" + strconv.FormatUint(uint64(user.ID), 10),
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// actorName is who did what the event is about, as told to users.
+func (s NotificationSink) actorName(db *gorm.DB, payload notificationPayload) string {
+	actor := payload.Data.Actor
+	if actor == nil {
+		return "Someone"
+	}
+
+	switch actor.Type {
+	case models.AuditActorUser:
+		var user models.User
+		if actor.ID != nil && db.Limit(1).Find(&user, *actor.ID).RowsAffected > 0 {
+			return user.FirstName + " " + user.LastName
+		}
+	case models.AuditActorServiceAccount:
+		return "A service account"
+	case models.AuditActorSCIMToken:
+		return "Your identity provider"
+	}
+	return "Someone"
+}
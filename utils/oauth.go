@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"log"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	oauthKeyOnce sync.Once
+	oauthKey     *rsa.PrivateKey
+	oauthKeyErr  error
+)
+
+// GetIssuer returns the issuer identifier advertised in OIDC discovery and
+// set on ID tokens.
+func GetIssuer() string {
+	return strings.TrimSuffix(os.Getenv("APP_URL"), "/")
+}
+
+// GetOAuthSigningKey returns the RSA key ID tokens are signed with, read from
+// the PEM in OAUTH_SIGNING_KEY. Without one a key is generated at startup,
+// so ID tokens stop verifying when the server restarts.
+func GetOAuthSigningKey() (*rsa.PrivateKey, error) {
+	oauthKeyOnce.Do(func() {
+		keyPEM := os.Getenv("OAUTH_SIGNING_KEY")
+		if keyPEM == "" {
+			log.Println("OAUTH_SIGNING_KEY is not set, generating a temporary ID token signing key")
+			oauthKey, oauthKeyErr = rsa.GenerateKey(rand.Reader, 2048)
+			return
+		}
+
+		oauthKey, oauthKeyErr = parseRSAPrivateKey("OAUTH_SIGNING_KEY", keyPEM)
+	})
+
+	return oauthKey, oauthKeyErr
+}
+
+// parseRSAPrivateKey decodes a PKCS1 or PKCS8 PEM encoded RSA key read from
+// the env variable name.
+func parseRSAPrivateKey(name, keyPEM string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(keyPEM))
+	if block == nil {
+		return nil, errors.New(name + " is not a PEM encoded key")
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New(name + " is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+func oauthKeyID(key *rsa.PrivateKey) string {
+	sum := sha256.Sum256(key.PublicKey.N.Bytes())
+	return base64.RawURLEncoding.EncodeToString(sum[:8])
+}
+
+func SignIDToken(claims jwt.MapClaims) (string, error) {
+	key, err := GetOAuthSigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = oauthKeyID(key)
+	return token.SignedString(key)
+}
+
+// GetOAuthJWKS returns the JSON Web Key Set clients verify ID tokens with.
+func GetOAuthJWKS() (map[string]interface{}, error) {
+	key, err := GetOAuthSigningKey()
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"kid": oauthKeyID(key),
+			"n":   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes()),
+		}},
+	}, nil
+}
+
+// VerifyCodeChallenge checks a PKCE code verifier against the challenge sent
+// with the authorization request (RFC 7636).
+func VerifyCodeChallenge(challenge, method, verifier string) bool {
+	if challenge == "" || verifier == "" {
+		return false
+	}
+
+	expected := verifier
+	if method == "S256" {
+		sum := sha256.Sum256([]byte(verifier))
+		expected = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
@@ -0,0 +1,261 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+)
+
+type OIDCProviderConfig struct {
+	Name string
+	// Type is "oidc" for any OpenID Connect provider, such as Google, or
+	// "github" for GitHub, which only supports plain OAuth2.
+	Type         string
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+}
+
+// SocialIdentity is what a provider tells us about the user who signed in.
+type SocialIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	FirstName     string
+	LastName      string
+}
+
+// SocialProvider runs the authorization code + PKCE flow against a provider.
+type SocialProvider interface {
+	AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error)
+	Exchange(ctx context.Context, code, nonce, codeVerifier string) (SocialIdentity, error)
+}
+
+// GetOIDCProviderConfigs reads the providers named in OIDC_PROVIDERS, e.g.
+// "google,github", each configured through OIDC_<NAME>_TYPE, _ISSUER,
+// _CLIENT_ID and _CLIENT_SECRET. Callbacks are served from APP_URL.
+func GetOIDCProviderConfigs() []OIDCProviderConfig {
+	configs := []OIDCProviderConfig{}
+
+	for _, name := range strings.Split(os.Getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		providerType := os.Getenv(prefix + "TYPE")
+		if providerType == "" {
+			providerType = "oidc"
+		}
+
+		configs = append(configs, OIDCProviderConfig{
+			Name:         name,
+			Type:         providerType,
+			Issuer:       os.Getenv(prefix + "ISSUER"),
+			ClientID:     os.Getenv(prefix + "CLIENT_ID"),
+			ClientSecret: os.Getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  strings.TrimSuffix(os.Getenv("APP_URL"), "/") + "/v1/auth/oidc/" + name + "/callback",
+		})
+	}
+
+	return configs
+}
+
+func NewSocialProviders(configs []OIDCProviderConfig) (map[string]SocialProvider, error) {
+	providers := map[string]SocialProvider{}
+
+	for _, config := range configs {
+		switch config.Type {
+		case "oidc":
+			providers[config.Name] = &oidcProvider{config: config}
+		case "github":
+			providers[config.Name] = &githubProvider{config: config}
+		default:
+			return nil, fmt.Errorf("unknown type %q for provider %s", config.Type, config.Name)
+		}
+	}
+
+	return providers, nil
+}
+
+type oidcProvider struct {
+	config OIDCProviderConfig
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+// discover fetches the provider's discovery document on first use, so the
+// server can start while a provider is unreachable.
+func (p *oidcProvider) discover(ctx context.Context) (*oidc.Provider, oauth2.Config, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.provider == nil {
+		provider, err := oidc.NewProvider(ctx, p.config.Issuer)
+		if err != nil {
+			return nil, oauth2.Config{}, err
+		}
+		p.provider = provider
+	}
+
+	scopes := p.config.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+
+	return p.provider, oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		RedirectURL:  p.config.RedirectURL,
+		Endpoint:     p.provider.Endpoint(),
+		Scopes:       scopes,
+	}, nil
+}
+
+func (p *oidcProvider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	_, config, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	return config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(codeVerifier)), nil
+}
+
+func (p *oidcProvider) Exchange(ctx context.Context, code, nonce, codeVerifier string) (SocialIdentity, error) {
+	provider, config, err := p.discover(ctx)
+	if err != nil {
+		return SocialIdentity{}, err
+	}
+
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(codeVerifier))
+	if err != nil {
+		return SocialIdentity{}, err
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return SocialIdentity{}, errors.New("missing id_token")
+	}
+
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: p.config.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return SocialIdentity{}, err
+	}
+	if idToken.Nonce != nonce {
+		return SocialIdentity{}, errors.New("invalid nonce")
+	}
+
+	var claims struct {
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+		GivenName         string `json:"given_name"`
+		FamilyName        string `json:"family_name"`
+		PreferredUsername string `json:"preferred_username"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return SocialIdentity{}, err
+	}
+
+	identity := SocialIdentity{
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		FirstName:     claims.GivenName,
+		LastName:      claims.FamilyName,
+	}
+	if identity.FirstName == "" {
+		identity.FirstName = claims.PreferredUsername
+	}
+
+	return identity, nil
+}
+
+type githubProvider struct {
+	config OIDCProviderConfig
+}
+
+func (p *githubProvider) oauth2Config() oauth2.Config {
+	return oauth2.Config{
+		ClientID:     p.config.ClientID,
+		ClientSecret: p.config.ClientSecret,
+		RedirectURL:  p.config.RedirectURL,
+		Endpoint:     github.Endpoint,
+		Scopes:       []string{"read:user", "user:email"},
+	}
+}
+
+// AuthCodeURL ignores nonce, GitHub does not issue ID tokens to bind it to.
+func (p *githubProvider) AuthCodeURL(ctx context.Context, state, nonce, codeVerifier string) (string, error) {
+	config := p.oauth2Config()
+	return config.AuthCodeURL(state, oauth2.S256ChallengeOption(codeVerifier)), nil
+}
+
+func (p *githubProvider) Exchange(ctx context.Context, code, nonce, codeVerifier string) (SocialIdentity, error) {
+	config := p.oauth2Config()
+
+	token, err := config.Exchange(ctx, code, oauth2.VerifierOption(codeVerifier))
+	if err != nil {
+		return SocialIdentity{}, err
+	}
+	client := config.Client(ctx, token)
+
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := getJSON(client, "https://api.github.com/user", &user); err != nil {
+		return SocialIdentity{}, err
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(client, "https://api.github.com/user/emails", &emails); err != nil {
+		return SocialIdentity{}, err
+	}
+
+	identity := SocialIdentity{Subject: fmt.Sprintf("%d", user.ID), FirstName: user.Login}
+	if firstName, lastName, ok := strings.Cut(user.Name, " "); ok {
+		identity.FirstName, identity.LastName = firstName, lastName
+	} else if user.Name != "" {
+		identity.FirstName = user.Name
+	}
+	for _, email := range emails {
+		if email.Primary {
+			identity.Email = email.Email
+			identity.EmailVerified = email.Verified
+		}
+	}
+
+	return identity, nil
+}
+
+func getJSON(client *http.Client, url string, v interface{}) error {
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
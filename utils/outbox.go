@@ -0,0 +1,215 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"gorm.io/gorm"
+)
+
+const (
+	outboxBatchSize  = 100
+	outboxLease      = time.Minute
+	outboxMaxBackoff = time.Hour
+)
+
+// OutboxBackoff is how long after its first failed publication an outbox
+// event is published again, doubling after every further failure.
+var OutboxBackoff = 5 * time.Second
+
+// OutboxSink is somewhere outbox events are published to. Events may be
+// published more than once, Publish should be idempotent for an EventID or
+// leave telling repeats apart to consumers.
+type OutboxSink interface {
+	Name() string
+	Publish(ctx context.Context, event models.OutboxEvent) error
+}
+
+// OutboxRelay publishes the outbox events that are due to its sinks.
+type OutboxRelay struct {
+	DB    *gorm.DB
+	Sinks []OutboxSink
+}
+
+func NewOutboxRelay(db *gorm.DB, sinks ...OutboxSink) *OutboxRelay {
+	return &OutboxRelay{DB: db, Sinks: sinks}
+}
+
+// Run relays what is due every interval until ctx is done.
+func (r *OutboxRelay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := r.RelayDue(ctx); err != nil {
+			log.Println("error relaying outbox events:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RelayDue publishes the events that are due, oldest first, returning how
+// many it published. An event is only marked published once every sink has
+// taken it; if any fails it is published to all of them again later, with
+// exponential backoff, for as long as it takes.
+func (r *OutboxRelay) RelayDue(ctx context.Context) (int, error) {
+	var events []models.OutboxEvent
+	err := r.DB.Where("published_at IS NULL AND next_attempt_at <= ?", time.Now()).
+		Order("id").
+		Limit(outboxBatchSize).
+		Find(&events).Error
+	if err != nil {
+		return 0, err
+	}
+
+	published := 0
+	for _, event := range events {
+		// other relays may have picked the same events, only the one pushing
+		// the next attempt back while it is still due gets to publish it
+		now := time.Now()
+		result := r.DB.Model(&event).
+			Where("published_at IS NULL AND next_attempt_at <= ?", now).
+			UpdateColumn("next_attempt_at", now.Add(outboxLease))
+		if result.Error != nil {
+			return published, result.Error
+		}
+		if result.RowsAffected < 1 {
+			continue
+		}
+
+		values := map[string]interface{}{"attempts": event.Attempts + 1}
+		if err := r.publish(ctx, event); err != nil {
+			values["last_error"] = err.Error()
+			values["next_attempt_at"] = time.Now().Add(retryDelay(OutboxBackoff, outboxMaxBackoff, event.Attempts+1))
+		} else {
+			values["last_error"] = ""
+			values["published_at"] = time.Now()
+			values["next_attempt_at"] = nil
+			published++
+		}
+
+		if err := r.DB.Model(&event).Updates(values).Error; err != nil {
+			return published, err
+		}
+	}
+
+	return published, nil
+}
+
+func (r *OutboxRelay) publish(ctx context.Context, event models.OutboxEvent) error {
+	var errs []error
+	for _, sink := range r.Sinks {
+		if err := sink.Publish(ctx, event); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sink.Name(), err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WebhookSink queues a delivery of every event for each active webhook of its
+// organisation subscribing to it, which the WebhookSender then sends. An
+// event is only queued once for a webhook, however often it is published.
+type WebhookSink struct {
+	DB *gorm.DB
+}
+
+func (s WebhookSink) Name() string {
+	return "webhooks"
+}
+
+func (s WebhookSink) Publish(ctx context.Context, event models.OutboxEvent) error {
+	return s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var webhooks []models.Webhook
+		if err := tx.Where("organisation_id = ? AND active = ?", event.OrganisationID, true).Find(&webhooks).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for _, webhook := range webhooks {
+			if !webhook.SubscribesTo(event.Event) {
+				continue
+			}
+
+			var queued int64
+			err := tx.Model(&models.WebhookDelivery{}).
+				Where("webhook_id = ? AND event_id = ? AND replay_of_id IS NULL", webhook.ID, event.EventID).
+				Count(&queued).Error
+			if err != nil {
+				return err
+			}
+			if queued > 0 {
+				continue
+			}
+
+			err = tx.Create(&models.WebhookDelivery{
+				WebhookID:     webhook.ID,
+				EventID:       event.EventID,
+				Event:         event.Event,
+				Payload:       event.Payload,
+				Status:        models.WebhookDeliveryPending,
+				NextAttemptAt: &now,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// EventBus hands outbox events to the handlers subscribed to it within the
+// process.
+type EventBus struct {
+	mu       sync.RWMutex
+	handlers []*func(models.OutboxEvent)
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Subscribe calls handler with every event published from now on, until
+// the returned function is called. Handlers are called in turn and should
+// not block.
+func (b *EventBus) Subscribe(handler func(models.OutboxEvent)) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	h := &handler
+	b.handlers = append(b.handlers, h)
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.handlers = slices.DeleteFunc(b.handlers, func(other *func(models.OutboxEvent)) bool {
+			return other == h
+		})
+	}
+}
+
+func (b *EventBus) Name() string {
+	return "bus"
+}
+
+func (b *EventBus) Publish(_ context.Context, event models.OutboxEvent) error {
+	b.mu.RLock()
+	handlers := slices.Clone(b.handlers)
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		(*handler)(event)
+	}
+	return nil
+}
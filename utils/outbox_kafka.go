@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+)
+
+// KafkaRESTSink produces events to Topic through the v2 API of a Kafka REST
+// proxy at URL. Events are keyed by organisation, keeping the events of one
+// organisation in a partition, and consumers deduplicate on the id of the
+// payload, the EventID.
+type KafkaRESTSink struct {
+	URL    string
+	Topic  string
+	Client *http.Client
+}
+
+func NewKafkaRESTSink(url, topic string) *KafkaRESTSink {
+	return &KafkaRESTSink{URL: url, Topic: topic, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (s *KafkaRESTSink) Name() string {
+	return "kafka"
+}
+
+func (s *KafkaRESTSink) Publish(ctx context.Context, event models.OutboxEvent) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"records": []map[string]interface{}{{
+			"key":   fmt.Sprintf("%d", event.OrganisationID),
+			"value": json.RawMessage(event.Payload),
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	endpoint := strings.TrimSuffix(s.URL, "/") + "/topics/" + url.PathEscape(s.Topic)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	b, _ := io.ReadAll(io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("Kafka REST proxy responded with %d: %s", res.StatusCode, strings.TrimSpace(string(b)))
+	}
+
+	// records can fail on their own, with the request succeeding
+	var produced struct {
+		Offsets []struct {
+			ErrorCode *int   `json:"error_code"`
+			Error     string `json:"error"`
+		} `json:"offsets"`
+	}
+	if err := json.Unmarshal(b, &produced); err != nil {
+		return err
+	}
+	for _, offset := range produced.Offsets {
+		if offset.ErrorCode != nil {
+			return fmt.Errorf("Kafka REST proxy failed to produce the event: %s", offset.Error)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+)
+
+const natsTimeout = 10 * time.Second
+
+// NATSSink publishes events to NATS on "<Prefix>.<event>", with the EventID
+// as the Nats-Msg-Id header JetStream streams deduplicate on. It speaks the
+// client protocol of NATS over a plain connection to URL, of the form
+// nats://[user[:pass]@]host[:port], and waits for the server to answer a PING
+// after every event, so that events only count as published once the server
+// has them.
+type NATSSink struct {
+	URL    string
+	Prefix string
+
+	mu   sync.Mutex
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func NewNATSSink(url, prefix string) *NATSSink {
+	return &NATSSink{URL: url, Prefix: prefix}
+}
+
+func (s *NATSSink) Name() string {
+	return "nats"
+}
+
+func (s *NATSSink) Publish(ctx context.Context, event models.OutboxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		if err := s.connect(ctx); err != nil {
+			return err
+		}
+	}
+
+	if err := s.publish(ctx, event); err != nil {
+		// the connection is in an unknown state, the next event gets a new one
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// Close closes the connection to the server, if any.
+func (s *NATSSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+func (s *NATSSink) connect(ctx context.Context) error {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "nats" {
+		return fmt.Errorf("unsupported NATS URL scheme %q", u.Scheme)
+	}
+	address := u.Host
+	if u.Port() == "" {
+		address = net.JoinHostPort(u.Hostname(), "4222")
+	}
+
+	dialer := net.Dialer{Timeout: natsTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(deadline(ctx, natsTimeout))
+	r := bufio.NewReader(conn)
+
+	line, err := r.ReadString('\n')
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if !strings.HasPrefix(line, "INFO ") {
+		conn.Close()
+		return fmt.Errorf("unexpected NATS greeting %q", strings.TrimSpace(line))
+	}
+
+	options := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"headers":  true,
+		"name":     "hng11-task-two",
+		"lang":     "go",
+	}
+	if u.User != nil {
+		if pass, ok := u.User.Password(); ok {
+			options["user"] = u.User.Username()
+			options["pass"] = pass
+		} else {
+			options["auth_token"] = u.User.Username()
+		}
+	}
+	b, _ := json.Marshal(options)
+	if _, err := fmt.Fprintf(conn, "CONNECT %s\r\n", b); err != nil {
+		conn.Close()
+		return err
+	}
+
+	s.conn, s.r = conn, r
+	return nil
+}
+
+func (s *NATSSink) publish(ctx context.Context, event models.OutboxEvent) error {
+	s.conn.SetDeadline(deadline(ctx, natsTimeout))
+
+	headers := "NATS/1.0\r\nNats-Msg-Id: " + event.EventID + "\r\n\r\n"
+	subject := s.Prefix + "." + event.Event
+	_, err := fmt.Fprintf(s.conn, "HPUB %s %d %d\r\n%s%s\r\nPING\r\n", subject, len(headers), len(headers)+len(event.Payload), headers, event.Payload)
+	if err != nil {
+		return err
+	}
+
+	for {
+		line, err := s.r.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		line = strings.TrimSpace(line)
+
+		switch {
+		case line == "PONG":
+			return nil
+		case line == "PING":
+			if _, err := s.conn.Write([]byte("PONG\r\n")); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New("NATS: " + strings.TrimSpace(strings.TrimPrefix(line, "-ERR")))
+		}
+	}
+}
+
+// deadline is the deadline of ctx, or timeout from now if it has none.
+func deadline(ctx context.Context, timeout time.Duration) time.Time {
+	if d, ok := ctx.Deadline(); ok {
+		return d
+	}
+	return time.Now().Add(timeout)
+}
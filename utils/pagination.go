@@ -0,0 +1,196 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// ErrStaleCursor is returned by Paginate for a cursor whose row has been
+// deleted since, the listing has to be started again.
+var ErrStaleCursor = errors.New("cursor is no longer valid, start the listing again")
+
+// Pagination is the page of a listing requested through the limit, sort and
+// cursor query parameters, see ParsePagination.
+type Pagination struct {
+	Limit  int
+	Sort   string
+	table  string
+	column string
+	desc   bool
+	cursor *pageCursor
+}
+
+// pageCursor points at the first or last row of a page, the next page
+// starts after it, or ends before it if Before is set.
+type pageCursor struct {
+	ID     uint   `json:"id"`
+	Sort   string `json:"sort"`
+	Before bool   `json:"before,omitempty"`
+}
+
+// PageInfo describes a page. The cursors are empty at either end of the
+// listing, Total counts the rows of the whole listing.
+type PageInfo struct {
+	Total      int64  `json:"total"`
+	Limit      int    `json:"limit"`
+	Sort       string `json:"sort"`
+	NextCursor string `json:"nextCursor,omitempty"`
+	PrevCursor string `json:"prevCursor,omitempty"`
+}
+
+// ParsePagination reads the page requested from the query. sorts maps the
+// sort keys of the listing to the columns of table they sort by, prefixing a
+// key with "-" sorts in descending order. Rows sorting equal are sorted by
+// ID, which keeps cursors stable. A cursor continues the listing in the
+// order it was created with, so the sort may be left out alongside it.
+func ParsePagination(c *gin.Context, table string, sorts map[string]string, defaultSort string) (Pagination, []models.InputError) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	return NewPagination(limit, c.Query("sort"), c.Query("cursor"), table, sorts, defaultSort)
+}
+
+// NewPagination is ParsePagination for a page requested other than through
+// the query.
+func NewPagination(limit int, sort, cursor string, table string, sorts map[string]string, defaultSort string) (Pagination, []models.InputError) {
+	p := Pagination{table: table}
+
+	p.Limit = limit
+	if p.Limit < 1 || p.Limit > MaxPageSize {
+		p.Limit = DefaultPageSize
+	}
+
+	p.Sort = sort
+
+	if raw := cursor; raw != "" {
+		p.cursor = decodePageCursor(raw)
+		if p.cursor == nil || (p.Sort != "" && p.Sort != p.cursor.Sort) {
+			return p, []models.InputError{{Field: "cursor", Message: "invalid cursor"}}
+		}
+		p.Sort = p.cursor.Sort
+	}
+
+	if p.Sort == "" {
+		p.Sort = defaultSort
+	}
+
+	key, desc := strings.CutPrefix(p.Sort, "-")
+	column, ok := sorts[key]
+	if !ok {
+		keys := make([]string, 0, len(sorts))
+		for key := range sorts {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys)
+		return p, []models.InputError{{Field: "sort", Message: "must be one of: " + strings.Join(keys, " ") + ", optionally prefixed with -"}}
+	}
+	p.column, p.desc = column, desc
+
+	return p, nil
+}
+
+// Paginate loads the page of the rows query selects from the table given to
+// ParsePagination. T must have an ID field, as all models embedding
+// gorm.Model do. It returns ErrStaleCursor if the row of the cursor is gone.
+func Paginate[T any](query *gorm.DB, p Pagination) ([]T, PageInfo, error) {
+	info := PageInfo{Limit: p.Limit, Sort: p.Sort}
+	var rows []T
+
+	query = query.Session(&gorm.Session{})
+	if err := query.Count(&info.Total).Error; err != nil {
+		return nil, info, err
+	}
+
+	// a page before the cursor is loaded backwards, then put back in order
+	backwards := p.cursor != nil && p.cursor.Before
+	desc := p.desc != backwards
+	direction, op := "ASC", ">"
+	if desc {
+		direction, op = "DESC", "<"
+	}
+
+	id := p.table + ".id"
+	page := query
+	if p.cursor != nil {
+		// without the row the comparisons below are against NULL, which
+		// would end the listing early rather than fail
+		var exists int64
+		err := query.Session(&gorm.Session{NewDB: true}).Table(p.table).Where("id = ?", p.cursor.ID).Count(&exists).Error
+		if err != nil {
+			return nil, info, err
+		}
+		if exists == 0 {
+			return nil, info, ErrStaleCursor
+		}
+
+		// compares with the cursor row as it is now, whatever it was when the
+		// cursor was created
+		value := fmt.Sprintf("(SELECT %s FROM %s WHERE %s = ?)", p.column, p.table, id)
+		page = page.Where(fmt.Sprintf("(%s %s %s OR (%s = %s AND %s %s ?))", p.column, op, value, p.column, value, id, op),
+			p.cursor.ID, p.cursor.ID, p.cursor.ID)
+	}
+
+	err := page.Order(fmt.Sprintf("%s %s, %s %s", p.column, direction, id, direction)).Limit(p.Limit + 1).Find(&rows).Error
+	if err != nil {
+		return nil, info, err
+	}
+
+	more := len(rows) > p.Limit
+	if more {
+		rows = rows[:p.Limit]
+	}
+	if backwards {
+		slices.Reverse(rows)
+	}
+
+	hasNext, hasPrev := more, p.cursor != nil
+	if backwards {
+		hasNext, hasPrev = true, more
+	}
+	if len(rows) > 0 {
+		if hasNext {
+			info.NextCursor = encodePageCursor(pageCursor{ID: rowID(rows[len(rows)-1]), Sort: p.Sort})
+		}
+		if hasPrev {
+			info.PrevCursor = encodePageCursor(pageCursor{ID: rowID(rows[0]), Sort: p.Sort, Before: true})
+		}
+	}
+
+	return rows, info, nil
+}
+
+func encodePageCursor(cursor pageCursor) string {
+	b, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodePageCursor(raw string) *pageCursor {
+	b, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil
+	}
+
+	var cursor pageCursor
+	if err := json.Unmarshal(b, &cursor); err != nil || cursor.ID == 0 {
+		return nil
+	}
+
+	return &cursor
+}
+
+func rowID(row interface{}) uint {
+	return uint(reflect.Indirect(reflect.ValueOf(row)).FieldByName("ID").Uint())
+}
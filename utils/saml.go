@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/crewjam/saml"
+)
+
+var (
+	samlKeyOnce sync.Once
+	samlKey     *rsa.PrivateKey
+	samlCert    *x509.Certificate
+	samlKeyErr  error
+)
+
+// SAMLAttributeNames lists the attribute names IdPs commonly use for each
+// user field, as friendly names, OIDs and the claim URIs used by Azure AD.
+var SAMLAttributeNames = map[string][]string{
+	"email": {
+		"email", "mail", "emailaddress", "urn:oid:0.9.2342.19200300.100.1.3",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress",
+	},
+	"firstName": {
+		"firstName", "givenName", "given_name", "urn:oid:2.5.4.42",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/givenname",
+	},
+	"lastName": {
+		"lastName", "sn", "surname", "family_name", "urn:oid:2.5.4.4",
+		"http://schemas.xmlsoap.org/ws/2005/05/identity/claims/surname",
+	},
+}
+
+// GetSAMLServiceProviderURL returns the base URL of the SAML endpoints we
+// serve for an organisation.
+func GetSAMLServiceProviderURL(orgId uint) string {
+	return fmt.Sprintf("%s/saml/organisations/%d", GetIssuer(), orgId)
+}
+
+// GetSAMLKeyPair returns the key and certificate we sign authentication
+// requests and decrypt assertions with, read from the PEMs in SAML_SP_KEY
+// and SAML_SP_CERT. Without them a self-signed pair is generated at startup,
+// so IdPs have to reload our metadata after every restart.
+func GetSAMLKeyPair() (*rsa.PrivateKey, *x509.Certificate, error) {
+	samlKeyOnce.Do(func() {
+		keyPEM, certPEM := os.Getenv("SAML_SP_KEY"), os.Getenv("SAML_SP_CERT")
+		if keyPEM == "" || certPEM == "" {
+			log.Println("SAML_SP_KEY or SAML_SP_CERT is not set, generating a temporary SAML key pair")
+			samlKey, samlCert, samlKeyErr = generateSelfSignedCertificate()
+			return
+		}
+
+		samlKey, samlKeyErr = parseRSAPrivateKey("SAML_SP_KEY", keyPEM)
+		if samlKeyErr != nil {
+			return
+		}
+
+		block, _ := pem.Decode([]byte(certPEM))
+		if block == nil {
+			samlKeyErr = errors.New("SAML_SP_CERT is not a PEM encoded certificate")
+			return
+		}
+		samlCert, samlKeyErr = x509.ParseCertificate(block.Bytes)
+	})
+
+	return samlKey, samlCert, samlKeyErr
+}
+
+func generateSelfSignedCertificate() (*rsa.PrivateKey, *x509.Certificate, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: "hng11-task-two"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	return key, cert, err
+}
+
+// ParseSAMLIDPMetadata parses an IdP's metadata XML, as uploaded by an
+// organisation, checking it describes a single sign-on service.
+func ParseSAMLIDPMetadata(metadata string) (*saml.EntityDescriptor, error) {
+	var descriptor saml.EntityDescriptor
+	if err := xml.Unmarshal([]byte(metadata), &descriptor); err != nil {
+		return nil, errors.New("invalid IdP metadata")
+	}
+
+	if descriptor.EntityID == "" || len(descriptor.IDPSSODescriptors) == 0 {
+		return nil, errors.New("IdP metadata does not describe an identity provider")
+	}
+
+	return &descriptor, nil
+}
+
+// NewSAMLServiceProvider returns the service provider an organisation's
+// members log in to their IdP with.
+func NewSAMLServiceProvider(orgId uint, idpMetadata string) (*saml.ServiceProvider, error) {
+	key, cert, err := GetSAMLKeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	descriptor, err := ParseSAMLIDPMetadata(idpMetadata)
+	if err != nil {
+		return nil, err
+	}
+
+	baseURL := GetSAMLServiceProviderURL(orgId)
+	metadataURL, err := url.Parse(baseURL + "/metadata")
+	if err != nil {
+		return nil, err
+	}
+	acsURL, err := url.Parse(baseURL + "/acs")
+	if err != nil {
+		return nil, err
+	}
+
+	return &saml.ServiceProvider{
+		EntityID:          metadataURL.String(),
+		Key:               key,
+		Certificate:       cert,
+		MetadataURL:       *metadataURL,
+		AcsURL:            *acsURL,
+		IDPMetadata:       descriptor,
+		AuthnNameIDFormat: saml.UnspecifiedNameIDFormat,
+	}, nil
+}
+
+// GetSAMLAttribute returns the first value of the attribute for a user field,
+// as named in SAMLAttributeNames.
+func GetSAMLAttribute(assertion *saml.Assertion, field string) string {
+	for _, statement := range assertion.AttributeStatements {
+		for _, attribute := range statement.Attributes {
+			for _, name := range SAMLAttributeNames[field] {
+				if (strings.EqualFold(attribute.Name, name) || strings.EqualFold(attribute.FriendlyName, name)) && len(attribute.Values) > 0 {
+					return attribute.Values[0].Value
+				}
+			}
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,637 @@
+package utils
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+)
+
+// GetSCIMBaseURL returns the base URL of the SCIM endpoints we serve for an
+// organisation.
+func GetSCIMBaseURL(orgId uint) string {
+	return fmt.Sprintf("%s/scim/v2/organisations/%d", GetIssuer(), orgId)
+}
+
+// SCIMError is a request error reported with one of the scimType values of
+// RFC 7644 3.12.
+type SCIMError struct {
+	Type   string
+	Detail string
+}
+
+func (e *SCIMError) Error() string {
+	return e.Detail
+}
+
+// SCIMFilter is a parsed SCIM filter expression (RFC 7644 3.4.2.2). Op is
+// "and", "or" or "not" with Children, or an attribute operator comparing
+// Attr with Value.
+type SCIMFilter struct {
+	Op       string
+	Attr     string
+	Value    interface{}
+	Children []*SCIMFilter
+}
+
+// SCIMAttribute maps a filterable attribute onto a SQL expression. Type is
+// one of "string" (the default), "boolean", "integer" or "dateTime".
+type SCIMAttribute struct {
+	Column string
+	Type   string
+}
+
+var scimComparisonOps = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true,
+}
+
+type scimToken struct {
+	text   string
+	quoted bool
+}
+
+type scimFilterParser struct {
+	tokens []scimToken
+	pos    int
+}
+
+// ParseSCIMFilter parses the filter query parameter.
+func ParseSCIMFilter(filter string) (*SCIMFilter, error) {
+	tokens, err := tokenizeSCIMFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &scimFilterParser{tokens: tokens}
+	f, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, invalidFilter("unexpected %q", p.tokens[p.pos].text)
+	}
+
+	return f, nil
+}
+
+func invalidFilter(format string, args ...interface{}) error {
+	return &SCIMError{Type: "invalidFilter", Detail: fmt.Sprintf(format, args...)}
+}
+
+func tokenizeSCIMFilter(filter string) ([]scimToken, error) {
+	var tokens []scimToken
+
+	for i := 0; i < len(filter); {
+		switch ch := filter[i]; {
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			i++
+		case strings.ContainsRune("()[]", rune(ch)):
+			tokens = append(tokens, scimToken{text: string(ch)})
+			i++
+		case ch == '"':
+			// values are JSON strings
+			end := i + 1
+			for ; end < len(filter) && filter[end] != '"'; end++ {
+				if filter[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(filter) {
+				return nil, invalidFilter("unterminated string")
+			}
+			value, err := strconv.Unquote(filter[i : end+1])
+			if err != nil {
+				return nil, invalidFilter("invalid string %s", filter[i:end+1])
+			}
+			tokens = append(tokens, scimToken{text: value, quoted: true})
+			i = end + 1
+		default:
+			end := i
+			for end < len(filter) && !strings.ContainsRune(" \t\n()[]\"", rune(filter[end])) {
+				end++
+			}
+			tokens = append(tokens, scimToken{text: filter[i:end]})
+			i = end
+		}
+	}
+
+	return tokens, nil
+}
+
+func (p *scimFilterParser) peek() (scimToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return scimToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *scimFilterParser) isKeyword(keyword string) bool {
+	tok, ok := p.peek()
+	return ok && !tok.quoted && strings.EqualFold(tok.text, keyword)
+}
+
+func (p *scimFilterParser) expect(text string) error {
+	if tok, ok := p.peek(); !ok || tok.quoted || tok.text != text {
+		return invalidFilter("expected %q", text)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *scimFilterParser) parseOr() (*SCIMFilter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &SCIMFilter{Op: "or", Children: []*SCIMFilter{left, right}}
+	}
+
+	return left, nil
+}
+
+func (p *scimFilterParser) parseAnd() (*SCIMFilter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isKeyword("and") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &SCIMFilter{Op: "and", Children: []*SCIMFilter{left, right}}
+	}
+
+	return left, nil
+}
+
+func (p *scimFilterParser) parseUnary() (*SCIMFilter, error) {
+	if p.isKeyword("not") {
+		p.pos++
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return &SCIMFilter{Op: "not", Children: []*SCIMFilter{f}}, p.expect(")")
+	}
+
+	if p.isKeyword("(") {
+		p.pos++
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		return f, p.expect(")")
+	}
+
+	return p.parseAttrExpr()
+}
+
+func (p *scimFilterParser) parseAttrExpr() (*SCIMFilter, error) {
+	tok, ok := p.peek()
+	if !ok || tok.quoted || strings.ContainsAny(tok.text, "()[]") {
+		return nil, invalidFilter("expected an attribute")
+	}
+	p.pos++
+	attr := normalizeSCIMAttr(tok.text)
+
+	// a value path, e.g. emails[type eq "work"], filters on sub-attributes
+	if p.isKeyword("[") {
+		p.pos++
+		f, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		f.prefixAttr(attr)
+		return f, p.expect("]")
+	}
+
+	tok, ok = p.peek()
+	if !ok || tok.quoted {
+		return nil, invalidFilter("expected an operator after %s", attr)
+	}
+	p.pos++
+	op := strings.ToLower(tok.text)
+
+	if op == "pr" {
+		return &SCIMFilter{Op: op, Attr: attr}, nil
+	}
+	if !scimComparisonOps[op] {
+		return nil, invalidFilter("unknown operator %q", tok.text)
+	}
+
+	tok, ok = p.peek()
+	if !ok {
+		return nil, invalidFilter("expected a value after %s %s", attr, op)
+	}
+	p.pos++
+
+	var value interface{}
+	switch {
+	case tok.quoted:
+		value = tok.text
+	case tok.text == "true" || tok.text == "false":
+		value = tok.text == "true"
+	case tok.text == "null":
+		value = nil
+	default:
+		n, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return nil, invalidFilter("invalid value %q", tok.text)
+		}
+		value = n
+	}
+
+	return &SCIMFilter{Op: op, Attr: attr, Value: value}, nil
+}
+
+func (f *SCIMFilter) prefixAttr(prefix string) {
+	if f.Attr != "" {
+		f.Attr = prefix + "." + f.Attr
+	}
+	for _, child := range f.Children {
+		child.prefixAttr(prefix)
+	}
+}
+
+// normalizeSCIMAttr strips the schema URN attributes may be qualified with.
+func normalizeSCIMAttr(attr string) string {
+	if strings.HasPrefix(strings.ToLower(attr), "urn:") {
+		return attr[strings.LastIndex(attr, ":")+1:]
+	}
+	return attr
+}
+
+// SQL translates the filter into a WHERE clause. attributes is keyed by the
+// lower case attribute path. String comparisons are case insensitive.
+func (f *SCIMFilter) SQL(attributes map[string]SCIMAttribute) (string, []interface{}, error) {
+	switch f.Op {
+	case "and", "or":
+		left, leftArgs, err := f.Children[0].SQL(attributes)
+		if err != nil {
+			return "", nil, err
+		}
+		right, rightArgs, err := f.Children[1].SQL(attributes)
+		if err != nil {
+			return "", nil, err
+		}
+		return "(" + left + " " + strings.ToUpper(f.Op) + " " + right + ")", append(leftArgs, rightArgs...), nil
+	case "not":
+		sql, args, err := f.Children[0].SQL(attributes)
+		if err != nil {
+			return "", nil, err
+		}
+		return "NOT (" + sql + ")", args, nil
+	}
+
+	attribute, ok := attributes[strings.ToLower(f.Attr)]
+	if !ok {
+		return "", nil, invalidFilter("filtering on %s is not supported", f.Attr)
+	}
+	col := attribute.Column
+
+	if f.Op == "pr" {
+		if attribute.Type == "" || attribute.Type == "string" {
+			return "(" + col + " IS NOT NULL AND " + col + " <> '')", nil, nil
+		}
+		return col + " IS NOT NULL", nil, nil
+	}
+
+	if f.Value == nil {
+		switch f.Op {
+		case "eq":
+			return col + " IS NULL", nil, nil
+		case "ne":
+			return col + " IS NOT NULL", nil, nil
+		}
+		return "", nil, invalidFilter("null can only be compared with eq or ne")
+	}
+
+	sqlOps := map[string]string{"eq": "=", "ne": "<>", "gt": ">", "ge": ">=", "lt": "<", "le": "<="}
+
+	switch attribute.Type {
+	case "boolean":
+		value, ok := f.Value.(bool)
+		if !ok || (f.Op != "eq" && f.Op != "ne") {
+			return "", nil, invalidFilter("%s can only be compared with true or false", f.Attr)
+		}
+		return col + " " + sqlOps[f.Op] + " ?", []interface{}{value}, nil
+	case "integer":
+		var value int64
+		switch v := f.Value.(type) {
+		case float64:
+			value = int64(v)
+		case string:
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				// ids are opaque to clients, an id we never issued matches nothing
+				return "1 = 0", nil, nil
+			}
+			value = n
+		}
+		if sqlOps[f.Op] == "" {
+			return "", nil, invalidFilter("%s cannot be compared with %s", f.Attr, f.Op)
+		}
+		return col + " " + sqlOps[f.Op] + " ?", []interface{}{value}, nil
+	case "dateTime":
+		s, _ := f.Value.(string)
+		value, err := time.Parse(time.RFC3339, s)
+		if err != nil || sqlOps[f.Op] == "" {
+			return "", nil, invalidFilter("%s must be compared with a dateTime", f.Attr)
+		}
+		return col + " " + sqlOps[f.Op] + " ?", []interface{}{value}, nil
+	}
+
+	value, ok := f.Value.(string)
+	if !ok {
+		return "", nil, invalidFilter("%s must be compared with a string", f.Attr)
+	}
+	value = strings.ToLower(value)
+	escaped := EscapeLike(value)
+
+	switch f.Op {
+	case "co":
+		return "LOWER(" + col + `) LIKE ? ESCAPE '\'`, []interface{}{"%" + escaped + "%"}, nil
+	case "sw":
+		return "LOWER(" + col + `) LIKE ? ESCAPE '\'`, []interface{}{escaped + "%"}, nil
+	case "ew":
+		return "LOWER(" + col + `) LIKE ? ESCAPE '\'`, []interface{}{"%" + escaped}, nil
+	}
+	return "LOWER(" + col + ") " + sqlOps[f.Op] + " ?", []interface{}{value}, nil
+}
+
+// Matches evaluates the filter against a value of a multi-valued attribute,
+// as selected by a PATCH path like members[value eq "2819c223"].
+func (f *SCIMFilter) Matches(value map[string]interface{}) bool {
+	switch f.Op {
+	case "and":
+		return f.Children[0].Matches(value) && f.Children[1].Matches(value)
+	case "or":
+		return f.Children[0].Matches(value) || f.Children[1].Matches(value)
+	case "not":
+		return !f.Children[0].Matches(value)
+	}
+
+	actual, ok := value[FindSCIMKey(value, f.Attr)]
+	if f.Op == "pr" {
+		return ok && actual != nil && actual != ""
+	}
+
+	switch expected := f.Value.(type) {
+	case nil:
+		return (f.Op == "eq") == (actual == nil)
+	case bool:
+		return (f.Op == "eq") == (actual == expected)
+	case float64:
+		n, _ := actual.(float64)
+		return compareSCIMValues(f.Op, n-expected)
+	case string:
+		s, ok := actual.(string)
+		if !ok {
+			return f.Op == "ne"
+		}
+		s, expected = strings.ToLower(s), strings.ToLower(expected)
+		switch f.Op {
+		case "co":
+			return strings.Contains(s, expected)
+		case "sw":
+			return strings.HasPrefix(s, expected)
+		case "ew":
+			return strings.HasSuffix(s, expected)
+		}
+		return compareSCIMValues(f.Op, float64(strings.Compare(s, expected)))
+	}
+
+	return false
+}
+
+func compareSCIMValues(op string, diff float64) bool {
+	switch op {
+	case "eq":
+		return diff == 0
+	case "ne":
+		return diff != 0
+	case "gt":
+		return diff > 0
+	case "ge":
+		return diff >= 0
+	case "lt":
+		return diff < 0
+	case "le":
+		return diff <= 0
+	}
+	return false
+}
+
+// FindSCIMKey returns the key of m matching the case insensitive attribute
+// name attr, or attr itself if there is none.
+func FindSCIMKey(m map[string]interface{}, attr string) string {
+	for key := range m {
+		if strings.EqualFold(key, attr) {
+			return key
+		}
+	}
+	return attr
+}
+
+// ApplySCIMPatch applies PATCH operations (RFC 7644 3.5.2) to the JSON
+// representation of a resource.
+func ApplySCIMPatch(resource map[string]interface{}, operations []models.SCIMPatchOperation) error {
+	for _, operation := range operations {
+		op := strings.ToLower(operation.Op)
+		if op != "add" && op != "remove" && op != "replace" {
+			return &SCIMError{Type: "invalidSyntax", Detail: fmt.Sprintf("unknown operation %q", operation.Op)}
+		}
+
+		if operation.Path != "" {
+			if err := applySCIMPatchPath(resource, op, operation.Path, operation.Value); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// without a path the value holds the attributes to change
+		if op == "remove" {
+			return &SCIMError{Type: "noTarget", Detail: "remove operations require a path"}
+		}
+		values, ok := operation.Value.(map[string]interface{})
+		if !ok {
+			return &SCIMError{Type: "invalidValue", Detail: "operations without a path require an object value"}
+		}
+		for path, value := range values {
+			if err := applySCIMPatchPath(resource, op, path, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func applySCIMPatchPath(resource map[string]interface{}, op, path string, value interface{}) error {
+	path = normalizeSCIMAttr(strings.TrimSpace(path))
+
+	attr, rest, filtered := strings.Cut(path, "[")
+	if !filtered {
+		container := resource
+		if parent, sub, ok := strings.Cut(path, "."); ok {
+			key := FindSCIMKey(resource, parent)
+			child, ok := resource[key].(map[string]interface{})
+			if !ok {
+				if op == "remove" {
+					return nil
+				}
+				child = map[string]interface{}{}
+				resource[key] = child
+			}
+			container, path = child, sub
+		}
+		setSCIMValue(container, op, FindSCIMKey(container, path), value)
+		return nil
+	}
+
+	filterText, sub, ok := strings.Cut(rest, "]")
+	if !ok {
+		return &SCIMError{Type: "invalidPath", Detail: fmt.Sprintf("invalid path %q", path)}
+	}
+	sub = strings.TrimPrefix(sub, ".")
+
+	filter, err := ParseSCIMFilter(filterText)
+	if err != nil {
+		return &SCIMError{Type: "invalidPath", Detail: err.Error()}
+	}
+
+	key := FindSCIMKey(resource, attr)
+	values, _ := resource[key].([]interface{})
+
+	var kept []interface{}
+	matched := false
+	for _, v := range values {
+		element, ok := v.(map[string]interface{})
+		if !ok || !filter.Matches(element) {
+			kept = append(kept, v)
+			continue
+		}
+		matched = true
+
+		switch {
+		case op == "remove" && sub == "":
+			continue
+		case sub == "":
+			if fields, ok := value.(map[string]interface{}); ok {
+				for k, v := range fields {
+					element[FindSCIMKey(element, k)] = v
+				}
+			}
+		default:
+			setSCIMValue(element, op, FindSCIMKey(element, sub), value)
+		}
+		kept = append(kept, element)
+	}
+
+	if !matched {
+		switch {
+		case op == "replace":
+			return &SCIMError{Type: "noTarget", Detail: fmt.Sprintf("%s matched no values", path)}
+		case op == "add" && sub != "" && filter.Op == "eq":
+			// e.g. adding emails[type eq "work"].value creates the work email
+			kept = append(kept, map[string]interface{}{filter.Attr[len(attr)+1:]: filter.Value, sub: value})
+		}
+	}
+
+	if len(kept) == 0 {
+		delete(resource, key)
+	} else {
+		resource[key] = kept
+	}
+	return nil
+}
+
+func setSCIMValue(container map[string]interface{}, op, key string, value interface{}) {
+	if op == "remove" {
+		existing, ok := container[key].([]interface{})
+		removed, hasValues := value.([]interface{})
+		if !ok || !hasValues {
+			delete(container, key)
+			return
+		}
+
+		// Azure AD removes group members by value instead of with a filter
+		var kept []interface{}
+		for _, e := range existing {
+			if !containsSCIMValue(removed, e) {
+				kept = append(kept, e)
+			}
+		}
+		if len(kept) == 0 {
+			delete(container, key)
+		} else {
+			container[key] = kept
+		}
+		return
+	}
+
+	switch existing := container[key].(type) {
+	case []interface{}:
+		if op == "replace" {
+			break
+		}
+		// adding to a multi-valued attribute appends the new values
+		newValues, ok := value.([]interface{})
+		if !ok {
+			newValues = []interface{}{value}
+		}
+		for _, v := range newValues {
+			if !containsSCIMValue(existing, v) {
+				existing = append(existing, v)
+			}
+		}
+		container[key] = existing
+		return
+	case map[string]interface{}:
+		// complex attributes are merged, sub-attribute by sub-attribute
+		if fields, ok := value.(map[string]interface{}); ok {
+			for k, v := range fields {
+				existing[FindSCIMKey(existing, k)] = v
+			}
+			return
+		}
+	}
+
+	container[key] = value
+}
+
+// containsSCIMValue reports whether values holds v, comparing the value
+// sub-attribute of complex values.
+func containsSCIMValue(values []interface{}, v interface{}) bool {
+	element, isComplex := v.(map[string]interface{})
+
+	for _, candidate := range values {
+		if reflect.DeepEqual(candidate, v) {
+			return true
+		}
+		if other, ok := candidate.(map[string]interface{}); ok && isComplex {
+			if value, ok := other["value"]; ok && value == element[FindSCIMKey(element, "value")] {
+				return true
+			}
+		}
+	}
+
+	return false
+}
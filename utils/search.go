@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"strings"
+	"unicode"
+
+	"gorm.io/gorm"
+)
+
+// The text users and organisations are searched by. The indexes created by
+// CreateSearchIndexes are on these very expressions, or Postgres would not
+// use them.
+const (
+	UserSearchDocument         = "first_name || ' ' || last_name || ' ' || email"
+	OrganisationSearchDocument = "name || ' ' || description"
+)
+
+var searchIndexes = []string{
+	"CREATE EXTENSION IF NOT EXISTS pg_trgm",
+	"CREATE INDEX IF NOT EXISTS idx_users_search_tsv ON users USING gin (to_tsvector('simple', " + UserSearchDocument + "))",
+	"CREATE INDEX IF NOT EXISTS idx_users_search_trgm ON users USING gin (LOWER(" + UserSearchDocument + ") gin_trgm_ops)",
+	"CREATE INDEX IF NOT EXISTS idx_organisations_search_tsv ON organisations USING gin (to_tsvector('simple', " + OrganisationSearchDocument + "))",
+	"CREATE INDEX IF NOT EXISTS idx_organisations_search_trgm ON organisations USING gin (LOWER(" + OrganisationSearchDocument + ") gin_trgm_ops)",
+}
+
+// CreateSearchIndexes creates the full-text and trigram indexes search uses
+// on Postgres. Other databases are searched without indexes.
+func CreateSearchIndexes(db *gorm.DB) error {
+	if db.Dialector.Name() != "postgres" {
+		return nil
+	}
+
+	for _, sql := range searchIndexes {
+		if err := db.Exec(sql).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// SearchScope narrows a query to the rows whose document matches q and
+// selects their relevance as search_rank, ordering by it, highest first, then
+// by ID. On Postgres the words of q match the beginnings of words of the
+// document, q matches anywhere in it or, allowing for typos, resembles one of
+// its words. Other databases match every word of q anywhere in the document.
+func SearchScope(document, q string) func(*gorm.DB) *gorm.DB {
+	q = strings.ToLower(strings.TrimSpace(q))
+	like := "%" + EscapeLike(q) + "%"
+
+	return func(db *gorm.DB) *gorm.DB {
+		if db.Dialector.Name() == "postgres" {
+			vector := "to_tsvector('simple', " + document + ")"
+			text := "LOWER(" + document + ")"
+
+			// to_tsquery syntax is kept out by only passing letters and digits
+			words := strings.FieldsFunc(q, func(r rune) bool {
+				return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+			})
+			for i, word := range words {
+				words[i] = word + ":*"
+			}
+			tsquery := strings.Join(words, " & ")
+
+			if tsquery == "" {
+				return db.
+					Select("*, word_similarity(?, "+text+") AS search_rank", q).
+					Where(text+` LIKE ? ESCAPE '\'`, like).
+					Order("search_rank DESC, id")
+			}
+
+			return db.
+				Select("*, ts_rank("+vector+", to_tsquery('simple', ?)) + word_similarity(?, "+text+") AS search_rank", tsquery, q).
+				Where("("+vector+" @@ to_tsquery('simple', ?) OR "+text+` LIKE ? ESCAPE '\' OR ? <% `+text+")", tsquery, like, q).
+				Order("search_rank DESC, id")
+		}
+
+		text := "LOWER(" + document + ")"
+		for _, word := range strings.Fields(q) {
+			db = db.Where(text+` LIKE ? ESCAPE '\'`, "%"+EscapeLike(word)+"%")
+		}
+
+		// the document starting with q ranks above a word of it starting
+		// with q, which ranks above q anywhere else
+		prefix := EscapeLike(q) + "%"
+		return db.
+			Select("*, CASE WHEN "+text+` LIKE ? ESCAPE '\' THEN 3 WHEN `+text+` LIKE ? ESCAPE '\' THEN 2 ELSE 1 END AS search_rank`, prefix, "% "+prefix).
+			Order("search_rank DESC, id")
+	}
+}
@@ -1,6 +1,9 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"os"
 	"reflect"
@@ -39,6 +42,8 @@ func GetValidationMessage(fe validator.FieldError) string {
 		return "must not be more than " + fe.Param() + " characters"
 	case "len":
 		return "field must be exactly " + fe.Param() + " characters"
+	case "oneof":
+		return "must be one of: " + fe.Param()
 	default:
 		return fe.Error()
 	}
@@ -63,6 +68,11 @@ func GetJSONTagValue(v interface{}, fieldName string) string {
 		return ""
 	}
 
+	// strip the index from slice fields such as "Scopes[0]"
+	if i := strings.Index(fieldName, "["); i > -1 {
+		fieldName = fieldName[:i]
+	}
+
 	field, ok := t.FieldByName(fieldName)
 	if !ok {
 		return ""
@@ -75,11 +85,13 @@ func GenerateJWT(user models.User) (string, error) {
 	return jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"id":   user.ID,
 		"user": models.UserResponse(user),
+		"sv":   user.SessionVersion,
 		"exp":  time.Now().Add(time.Hour).Unix(),
 	}).SignedString([]byte(os.Getenv("JWT_SECRET")))
 }
 
-func CheckJWT(tokenString string) error {
+// ParseJWT checks the signature and expiry of a token and returns its claims.
+func ParseJWT(tokenString string) (jwt.MapClaims, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		// check signing method
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -87,36 +99,37 @@ func CheckJWT(tokenString string) error {
 		}
 		return []byte(os.Getenv("JWT_SECRET")), nil
 	})
+
+	// check expiry
+	var ve *jwt.ValidationError
+	if errors.As(err, &ve) && ve.Errors&jwt.ValidationErrorExpired != 0 {
+		return nil, errors.New("expired token")
+	}
 	if err != nil {
-		return errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
 
 	// check token validity
 	claims, ok := token.Claims.(jwt.MapClaims)
 	if !ok || !token.Valid {
-		return errors.New("invalid token")
+		return nil, errors.New("invalid token")
 	}
 
-	// check expiry
-	if float64(time.Now().Unix()) > claims["exp"].(float64) {
-		return errors.New("expired token")
+	// tokens without an expiry are never valid
+	if _, ok := claims["exp"].(float64); !ok {
+		return nil, errors.New("invalid token")
 	}
 
-	return nil
+	return claims, nil
 }
 
-func GetUserFromJWT(tokenString string) (map[string]interface{}, error) {
-	err := CheckJWT(tokenString)
-	if err != nil {
-		return map[string]interface{}{}, err
+func GetUserIDFromClaims(claims jwt.MapClaims) (uint, error) {
+	id, ok := claims["id"].(float64)
+	if !ok || id < 1 {
+		return 0, errors.New("invalid token")
 	}
 
-	token, _ := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(os.Getenv("JWT_SECRET")), nil
-	})
-
-	claims, _ := token.Claims.(jwt.MapClaims)
-	return claims["user"].(map[string]interface{}), nil
+	return uint(id), nil
 }
 
 func GetJWTFromRequest(c *gin.Context) (string, error) {
@@ -133,3 +146,26 @@ func GetJWTFromRequest(c *gin.Context) (string, error) {
 
 	return authToken[1], nil
 }
+
+// GenerateToken returns a random opaque token with the given prefix, e.g. "pat_".
+func GenerateToken(prefix string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(b), nil
+}
+
+// HashToken returns the hex-encoded SHA-256 digest used to store and look up opaque tokens.
+// Only the digest is stored, so a token is only ever seen in the response
+// that creates it and cannot be shown again.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// EscapeLike escapes the wildcards of a LIKE pattern matched with
+// ESCAPE '\'.
+func EscapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"net/http"
+	"path"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VersionedRouter collects the routes of every version of the API. A version
+// serves the routes of the one before it, so it only registers the handlers
+// of the routes that changed in it.
+type VersionedRouter struct {
+	versions []*APIVersion
+}
+
+// APIVersion is the routes of a version of the API.
+type APIVersion struct {
+	Name   string
+	routes []versionedRoute
+}
+
+type versionedRoute struct {
+	method   string
+	path     string
+	handlers gin.HandlersChain
+}
+
+func NewVersionedRouter() *VersionedRouter {
+	return &VersionedRouter{}
+}
+
+// Version adds a version, starting with the routes of the last one.
+func (vr *VersionedRouter) Version(name string) *APIVersion {
+	v := &APIVersion{Name: name}
+	if len(vr.versions) > 0 {
+		v.routes = append(v.routes, vr.versions[len(vr.versions)-1].routes...)
+	}
+	vr.versions = append(vr.versions, v)
+	return v
+}
+
+// Mount registers the routes of every version under /<name>.
+func (vr *VersionedRouter) Mount(router gin.IRouter) {
+	for _, v := range vr.versions {
+		v.Register(router.Group("/" + v.Name))
+	}
+}
+
+// Register registers the routes of the version on router.
+func (v *APIVersion) Register(router gin.IRoutes) {
+	for _, route := range v.routes {
+		router.Handle(route.method, route.path, route.handlers...)
+	}
+}
+
+// Group returns a group of routes of the version under relativePath, whose
+// handlers run after these.
+func (v *APIVersion) Group(relativePath string, handlers ...gin.HandlerFunc) *VersionGroup {
+	return &VersionGroup{version: v, basePath: relativePath, handlers: handlers}
+}
+
+// VersionGroup registers routes of a version like a gin.RouterGroup.
+type VersionGroup struct {
+	version  *APIVersion
+	basePath string
+	handlers gin.HandlersChain
+}
+
+// Handle registers a route of the version, replacing the handlers the
+// version got from the one before it if there are any.
+func (g *VersionGroup) Handle(method, relativePath string, handlers ...gin.HandlerFunc) *VersionGroup {
+	route := versionedRoute{
+		method:   method,
+		path:     joinPaths(g.basePath, relativePath),
+		handlers: append(append(gin.HandlersChain{}, g.handlers...), handlers...),
+	}
+
+	for i, existing := range g.version.routes {
+		if existing.method == route.method && existing.path == route.path {
+			g.version.routes[i] = route
+			return g
+		}
+	}
+	g.version.routes = append(g.version.routes, route)
+	return g
+}
+
+func (g *VersionGroup) GET(relativePath string, handlers ...gin.HandlerFunc) *VersionGroup {
+	return g.Handle(http.MethodGet, relativePath, handlers...)
+}
+
+func (g *VersionGroup) POST(relativePath string, handlers ...gin.HandlerFunc) *VersionGroup {
+	return g.Handle(http.MethodPost, relativePath, handlers...)
+}
+
+func (g *VersionGroup) PUT(relativePath string, handlers ...gin.HandlerFunc) *VersionGroup {
+	return g.Handle(http.MethodPut, relativePath, handlers...)
+}
+
+func (g *VersionGroup) PATCH(relativePath string, handlers ...gin.HandlerFunc) *VersionGroup {
+	return g.Handle(http.MethodPatch, relativePath, handlers...)
+}
+
+func (g *VersionGroup) DELETE(relativePath string, handlers ...gin.HandlerFunc) *VersionGroup {
+	return g.Handle(http.MethodDelete, relativePath, handlers...)
+}
+
+// joinPaths joins paths like gin does, keeping a trailing slash.
+func joinPaths(basePath, relativePath string) string {
+	if relativePath == "" {
+		return basePath
+	}
+	joined := path.Join(basePath, relativePath)
+	if relativePath[len(relativePath)-1] == '/' && joined[len(joined)-1] != '/' {
+		return joined + "/"
+	}
+	return joined
+}
@@ -0,0 +1,226 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"gorm.io/gorm"
+)
+
+const (
+	webhookTimeout        = 10 * time.Second
+	webhookBatchSize      = 50
+	webhookMaxBackoff     = 6 * time.Hour
+	webhookMaxResponseLen = 1024
+)
+
+// WebhookBackoff is how long after its first failed attempt a delivery is
+// attempted again, doubling after every further failure.
+var WebhookBackoff = 30 * time.Second
+
+var errPrivateAddress = errors.New("webhook address is not public")
+
+// NewWebhookClient returns the client webhooks are delivered with. Unless
+// allowPrivate is set it refuses to connect to loopback, private and link
+// local addresses, which keeps webhooks from reaching into our own network
+// whatever their URL resolves to.
+func NewWebhookClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+				return errPrivateAddress
+			}
+			return nil
+		}
+	}
+
+	return &http.Client{
+		Timeout:   webhookTimeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext},
+		// a redirect would get around the check of the URL
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// SignWebhook returns the X-Webhook-Signature header of a delivery of body at
+// timestamp: "t=<timestamp>,v1=<hex HMAC-SHA256 of "<timestamp>.<body>">".
+// Receivers should recompute it with the secret of the webhook and reject old
+// timestamps.
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// WebhookSender delivers the pending webhook deliveries that are due.
+type WebhookSender struct {
+	DB     *gorm.DB
+	Client *http.Client
+}
+
+func NewWebhookSender(db *gorm.DB, client *http.Client) *WebhookSender {
+	return &WebhookSender{DB: db, Client: client}
+}
+
+// Run delivers what is due every interval until ctx is done.
+func (s *WebhookSender) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.DeliverDue(ctx); err != nil {
+			log.Println("error delivering webhooks:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverDue attempts the deliveries that are due, returning how many it
+// attempted. Every attempt is recorded on its delivery; a failed one is
+// retried later, with exponential backoff, until WebhookMaxAttempts.
+func (s *WebhookSender) DeliverDue(ctx context.Context) (int, error) {
+	var deliveries []models.WebhookDelivery
+	err := s.DB.Preload("Webhook").
+		Where("status = ? AND next_attempt_at <= ?", models.WebhookDeliveryPending, time.Now()).
+		Order("next_attempt_at, id").
+		Limit(webhookBatchSize).
+		Find(&deliveries).Error
+	if err != nil {
+		return 0, err
+	}
+
+	attempted := 0
+	for _, delivery := range deliveries {
+		// other senders may have picked the same deliveries, only the one
+		// pushing the next attempt back while it is still due gets to attempt
+		// it
+		now := time.Now()
+		result := s.DB.Model(&delivery).
+			Where("status = ? AND next_attempt_at <= ?", models.WebhookDeliveryPending, now).
+			UpdateColumn("next_attempt_at", now.Add(2*webhookTimeout))
+		if result.Error != nil {
+			return attempted, result.Error
+		}
+		if result.RowsAffected < 1 {
+			continue
+		}
+
+		if err := s.attempt(ctx, delivery); err != nil {
+			return attempted, err
+		}
+		attempted++
+	}
+
+	return attempted, nil
+}
+
+func (s *WebhookSender) attempt(ctx context.Context, delivery models.WebhookDelivery) error {
+	now := time.Now()
+	values := map[string]interface{}{
+		"attempts":        delivery.Attempts + 1,
+		"last_attempt_at": now,
+		"response_status": 0,
+		"response_body":   "",
+		"error":           "",
+	}
+
+	status, body, err := s.send(ctx, delivery)
+	values["response_status"] = status
+	values["response_body"] = body
+
+	switch {
+	case delivery.Webhook.ID == 0:
+		values["status"] = models.WebhookDeliveryFailed
+		values["next_attempt_at"] = nil
+		values["error"] = "webhook was deleted"
+	case !delivery.Webhook.Active:
+		values["status"] = models.WebhookDeliveryFailed
+		values["next_attempt_at"] = nil
+		values["error"] = "webhook is disabled"
+	case err == nil && status >= 200 && status < 300:
+		values["status"] = models.WebhookDeliverySucceeded
+		values["next_attempt_at"] = nil
+	default:
+		if err != nil {
+			values["error"] = err.Error()
+		} else {
+			values["error"] = "endpoint responded with " + strconv.Itoa(status)
+		}
+
+		if delivery.Attempts+1 >= models.WebhookMaxAttempts {
+			values["status"] = models.WebhookDeliveryFailed
+			values["next_attempt_at"] = nil
+		} else {
+			values["next_attempt_at"] = now.Add(retryDelay(WebhookBackoff, webhookMaxBackoff, delivery.Attempts+1))
+		}
+	}
+
+	return s.DB.Model(&delivery).Updates(values).Error
+}
+
+// send posts the payload of the delivery to its webhook, returning the status
+// and the start of the body of the response.
+func (s *WebhookSender) send(ctx context.Context, delivery models.WebhookDelivery) (int, string, error) {
+	if delivery.Webhook.ID == 0 || !delivery.Webhook.Active {
+		return 0, "", nil
+	}
+
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "hng11-task-two-webhooks/1.0")
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Event-Id", delivery.EventID)
+	req.Header.Set("X-Webhook-Delivery", strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set("X-Webhook-Signature", SignWebhook(delivery.Webhook.Secret, time.Now().Unix(), body))
+
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer res.Body.Close()
+
+	b, _ := io.ReadAll(io.LimitReader(res.Body, webhookMaxResponseLen))
+	return res.StatusCode, string(b), nil
+}
+
+// retryDelay is the wait before the attempt after the given number of failed
+// ones, base after the first and doubling after every further one, up to max.
+func retryDelay(base, max time.Duration, failed int) time.Duration {
+	delay := base
+	for i := 1; i < failed && delay < max; i++ {
+		delay *= 2
+	}
+	return min(delay, max)
+}
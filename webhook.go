@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/utils"
+	"gorm.io/gorm"
+)
+
+const defaultWebhookPollInterval = 5 * time.Second
+
+// sendWebhooks starts delivering pending webhook deliveries in the
+// background, looking for due ones every WEBHOOK_POLL_INTERVAL, until ctx
+// is done, adding itself to wg.
+func sendWebhooks(ctx context.Context, wg *sync.WaitGroup, db *gorm.DB) {
+	interval := defaultWebhookPollInterval
+	if v := os.Getenv("WEBHOOK_POLL_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatal("invalid WEBHOOK_POLL_INTERVAL:", v)
+		}
+		interval = d
+	}
+
+	client := utils.NewWebhookClient(os.Getenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS") == "true")
+	sender := utils.NewWebhookSender(db, client)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		sender.Run(ctx, interval)
+	}()
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/codelikesuraj/hng11-task-two/models"
+	"github.com/codelikesuraj/hng11-task-two/utils"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultJobPollInterval       = time.Second
+	defaultWorkerConcurrency     = 4
+	defaultOrganisationRetention = 30 * 24 * time.Hour
+	jobRetention                 = 7 * 24 * time.Hour
+)
+
+// startWorker starts the background work until ctx is done: the jobs, the
+// delivery of webhooks and the outbox relay. It runs in the server unless
+// RUN_WORKER is false, and on its own with the worker command. wg is done
+// once the jobs running when ctx is done have finished.
+func startWorker(ctx context.Context, wg *sync.WaitGroup, db *gorm.DB, mailer utils.Mailer, bus *utils.EventBus) {
+	interval := defaultJobPollInterval
+	if v := os.Getenv("JOB_POLL_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			log.Fatal("invalid JOB_POLL_INTERVAL:", v)
+		}
+		interval = d
+	}
+
+	runner := newJobRunner(db, mailer)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		runner.Run(ctx, interval)
+	}()
+	sendWebhooks(ctx, wg, db)
+	relayOutbox(ctx, wg, db, bus)
+}
+
+// newJobRunner returns the runner of the jobs of every kind, running
+// WORKER_CONCURRENCY of them at once, with emails sent by mailer. It queues
+// the maintenance jobs on their schedule: audit checkpoints, see
+// scheduleAuditCheckpoints; purging organisations deleted more than
+// ORGANISATION_RETENTION ago daily; deleting expired invitations hourly and
+// jobs that succeeded more than a week ago daily.
+func newJobRunner(db *gorm.DB, mailer utils.Mailer) *utils.JobRunner {
+	concurrency := defaultWorkerConcurrency
+	if v := os.Getenv("WORKER_CONCURRENCY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatal("invalid WORKER_CONCURRENCY:", v)
+		}
+		concurrency = n
+	}
+
+	retention := defaultOrganisationRetention
+	if v := os.Getenv("ORGANISATION_RETENTION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Fatal("invalid ORGANISATION_RETENTION:", v)
+		}
+		retention = d
+	}
+
+	runner := utils.NewJobRunner(db, concurrency)
+	runner.Handle(models.JobSendEmail, utils.SendEmailJob(mailer))
+	runner.Handle(models.JobPurgeOrganisations, utils.PurgeOrganisationsJob(db, retention))
+	runner.Handle(models.JobExpireInvitations, utils.ExpireInvitationsJob(db))
+	runner.Handle(models.JobPruneJobs, utils.PruneJobsJob(db, jobRetention))
+
+	scheduleAuditCheckpoints(db, runner)
+	for spec, kind := range map[string]string{
+		"0 3 * * *":  models.JobPurgeOrganisations,
+		"@hourly":    models.JobExpireInvitations,
+		"30 3 * * *": models.JobPruneJobs,
+	} {
+		if err := runner.Schedule(spec, kind, nil); err != nil {
+			log.Fatal("error scheduling jobs:", err)
+		}
+	}
+
+	return runner
+}
+
+// requeueJobs implements the requeue-jobs command, which queues the dead
+// jobs whose IDs are given, or all of them, again. It returns the exit code.
+func requeueJobs(db *gorm.DB, args []string, out io.Writer) int {
+	var ids []uint
+	for _, arg := range args {
+		id, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil || id < 1 {
+			fmt.Fprintf(out, "invalid job ID %q\n", arg)
+			return 2
+		}
+		ids = append(ids, uint(id))
+	}
+
+	n, err := utils.RequeueJobs(db, ids...)
+	if err != nil {
+		fmt.Fprintln(out, "error requeueing jobs:", err)
+		return 1
+	}
+
+	fmt.Fprintf(out, "requeued %d job(s)\n", n)
+	return 0
+}